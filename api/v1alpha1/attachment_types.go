@@ -0,0 +1,54 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ControlPlaneReference refers to the XDSControlPlane a resource attaches to
+type ControlPlaneReference struct {
+	// +kubebuilder:validation:Required
+	// Name is the name of the XDSControlPlane
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	// Namespace is the namespace of the XDSControlPlane, defaults to the
+	// namespace of the resource. The control plane must allow the namespace
+	// of the resource in spec.allowedNamespaces.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// AttachmentSpec selects the control plane an xDS resource is served by
+type AttachmentSpec struct {
+	// +kubebuilder:validation:Optional
+	// ControlPlaneRef attaches the resource to an XDSControlPlane
+	// Resources without a reference attach to the control planes whose
+	// spec.resourceSelector matches their labels
+	ControlPlaneRef *ControlPlaneReference `json:"controlPlaneRef,omitempty"`
+}
+
+// AttachmentStatus reports the outcome of the attachment of a resource to a control plane
+type AttachmentStatus struct {
+	// ControlPlane is the namespace/name of the XDSControlPlane
+	ControlPlane string `json:"controlPlane"`
+
+	// Accepted is True if the control plane serves the resource
+	Accepted metav1.ConditionStatus `json:"accepted"`
+
+	// Reason is Accepted or the reason the resource is not served
+	Reason string `json:"reason"`
+
+	// Message is a human readable description of the outcome
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// ObservedGeneration is the generation of the resource the outcome was computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// XDSResourceStatus defines the observed state of a resource attached to control planes
+type XDSResourceStatus struct {
+	// ControlPlanes reports the outcome of the attachment to each control plane
+	// +optional
+	ControlPlanes []AttachmentStatus `json:"controlPlanes,omitempty"`
+}
@@ -0,0 +1,38 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// XDSClusterSpec defines the desired state of an XDSCluster
+type XDSClusterSpec struct {
+	AttachmentSpec `json:",inline"`
+	ClusterSpec    `json:",inline"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Control Plane",type=string,JSONPath=`.spec.controlPlaneRef.name`
+// +kubebuilder:printcolumn:name="Accepted",type=string,JSONPath=`.status.controlPlanes[*].accepted`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.controlPlanes[*].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// XDSCluster is an Envoy cluster served by the control planes it attaches to
+type XDSCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XDSClusterSpec    `json:"spec,omitempty"`
+	Status XDSResourceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// XDSClusterList contains a list of XDSCluster
+type XDSClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XDSCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XDSCluster{}, &XDSClusterList{})
+}
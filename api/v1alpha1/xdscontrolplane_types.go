@@ -1,11 +1,18 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// EndpointSelectorSpec discovers the endpoints of a cluster. Node selects the
+// internal IPs of the nodes matching the selector, Service the cluster IPs of
+// the Service name in namespace, with port being the port of the Service.
+// EndpointSlice selects the ready pod endpoints of the Service, with port
+// being the port of the Service, translated to the target port of the pods.
 type EndpointSelectorSpec struct {
+	// +kubebuilder:validation:Enum=Node;Service;EndpointSlice
 	Type      string                `json:"type"`
 	Selector  *metav1.LabelSelector `json:"selector,omitempty"`
 	Port      int                   `json:"port,omitempty"`
@@ -13,8 +20,14 @@ type EndpointSelectorSpec struct {
 	Namespace string                `json:"namespace,omitempty"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!(has(self.endpointsFrom) && has(self.internalListener))",message="only one of endpointsFrom and internalListener may be set"
 type LoadAssignmentSpec struct {
 	EndpointsFrom *EndpointSelectorSpec `json:"endpointsFrom,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// InternalListener is the name of an internal listener receiving the
+	// connections of the cluster, instead of discovered endpoints
+	InternalListener string `json:"internalListener,omitempty"`
 }
 
 type TransportSocketSpec struct {
@@ -134,7 +147,9 @@ type HTTPStatusRangeSpec struct {
 }
 
 type ClusterSpec struct {
-	Name            string               `json:"name"`
+	Name string `json:"name"`
+	// Type is static, strict_dns or eds. The endpoints of eds clusters are
+	// served over EDS instead of being inlined in the cluster.
 	Type            string               `json:"type"`
 	LbPolicy        string               `json:"lbPolicy"`
 	ConnectTimeout  string               `json:"connectTimeout,omitempty"`
@@ -150,19 +165,497 @@ type FilterSpec struct {
 	TypedConfig apiextensionsv1.JSON `json:"typedConfig"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!(has(self.tls) && has(self.transportSocket))",message="only one of tls and transportSocket may be set"
 type FilterChainSpec struct {
+	// +kubebuilder:validation:Optional
+	// Name identifies the filter chain in statistics and access logs
+	Name string `json:"name,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// FilterChainMatch selects the connections handled by the filter chain
+	FilterChainMatch *FilterChainMatchSpec `json:"filterChainMatch,omitempty"`
+
 	Filters []FilterSpec `json:"filters"`
+
+	// +kubebuilder:validation:Optional
+	// TransportSocket terminates the connections of the filter chain, such as
+	// a DownstreamTlsContext
+	TransportSocket *TransportSocketSpec `json:"transportSocket,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TLS terminates the connections of the filter chain with the certificate
+	// of a kubernetes.io/tls Secret, served to Envoy over SDS
+	TLS *DownstreamTLSSpec `json:"tls,omitempty"`
+}
+
+// DownstreamTLSSpec references the certificate of a filter chain. The Secret
+// is read when the snapshot is built and is never copied into resources.
+type DownstreamTLSSpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// SecretName is the name of a kubernetes.io/tls Secret
+	SecretName string `json:"secretName"`
+
+	// +kubebuilder:validation:Optional
+	// SecretNamespace defaults to the namespace of the resource defining the
+	// listener. Listeners may only reference Secrets of that namespace,
+	// unless an Ingress of the class translated for the control plane lists
+	// the Secret in its own namespace.
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ALPNProtocols are the protocols offered during the TLS handshake, such
+	// as h2 and http/1.1
+	ALPNProtocols []string `json:"alpnProtocols,omitempty"`
+}
+
+// FilterChainMatchSpec defines the criteria of a filter chain. A connection
+// is handled by the filter chain whose criteria match most specifically.
+type FilterChainMatchSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// DestinationPort matches the original destination port of connections
+	// redirected to the listener, such as with iptables
+	DestinationPort int32 `json:"destinationPort,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PrefixRanges matches the destination address, as CIDRs such as 10.0.0.0/8
+	PrefixRanges []string `json:"prefixRanges,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Any;SameIPOrLoopback;External
+	// SourceType matches local, loopback or external source addresses
+	SourceType string `json:"sourceType,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// SourcePrefixRanges matches the source address, as CIDRs
+	SourcePrefixRanges []string `json:"sourcePrefixRanges,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// DirectSourcePrefixRanges matches the address of the peer of the
+	// connection, which differs from the source address when it is
+	// restored from a PROXY protocol header
+	DirectSourcePrefixRanges []string `json:"directSourcePrefixRanges,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Minimum=1
+	// +kubebuilder:validation:items:Maximum=65535
+	// SourcePorts matches the source port
+	SourcePorts []int32 `json:"sourcePorts,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ServerNames matches the SNI of TLS connections, wildcards such as
+	// *.example.com are supported
+	ServerNames []string `json:"serverNames,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TransportProtocol matches the transport protocol detected by a listener
+	// filter, such as tls with the tls_inspector or raw_buffer
+	TransportProtocol string `json:"transportProtocol,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ApplicationProtocols matches the ALPN of TLS connections, such as h2 or http/1.1
+	ApplicationProtocols []string `json:"applicationProtocols,omitempty"`
 }
 
 // ListenerSpec defines the Envoy listener configuration
+// +kubebuilder:validation:XValidation:rule="[has(self.http), has(self.tcp), has(self.udp), has(self.filterChains)].filter(x, x).size() <= 1",message="only one of http, tcp, udp and filterChains may be set"
+// +kubebuilder:validation:XValidation:rule="has(self.internal) != (has(self.address) && has(self.port))",message="exactly one of address with port and internal is required"
+// +kubebuilder:validation:XValidation:rule="!has(self.protocol) || self.protocol != 'UDP' || [has(self.http), has(self.tcp), has(self.filterChains)].all(x, !x)",message="UDP listeners have no http, tcp or filterChains"
+// +kubebuilder:validation:XValidation:rule="!has(self.udp) || !has(self.protocol) || self.protocol == 'UDP'",message="listeners with udp have protocol UDP"
 type ListenerSpec struct {
-	Name            string               `json:"name"`
-	Address         string               `json:"address"`
-	Port            int                  `json:"port"`
+	Name string `json:"name"`
+	// +kubebuilder:validation:Optional
+	Address string `json:"address,omitempty"`
+	// +kubebuilder:validation:Optional
+	Port int `json:"port,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=TCP;UDP
+	// Protocol of the listener socket, TCP if empty, UDP with udp. UDP
+	// listeners handle datagrams with listener filters such as udp_proxy.
+	Protocol string `json:"protocol,omitempty"`
+
 	ListenerFilters []ListenerFilterSpec `json:"listenerFilters,omitempty"`
-	FilterChains    []FilterChainSpec    `json:"filterChains"`
 	// +kubebuilder:validation:Optional
+	// FilterChains are the filter chains of the listener, with the typed
+	// config of every filter. Not set when http or tcp is used.
+	FilterChains []FilterChainSpec `json:"filterChains,omitempty"`
+	// +kubebuilder:validation:Optional
+	AccessLog []AccessLogSpec `json:"accessLog,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// DefaultFilterChain handles the connections no filter chain matches,
+	// instead of closing them
+	DefaultFilterChain *FilterChainSpec `json:"defaultFilterChain,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// HTTP expands into a filter chain with an http_connection_manager filter
+	HTTP *HTTPListenerSpec `json:"http,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TCP expands into a filter chain with a tcp_proxy filter
+	TCP *TCPListenerSpec `json:"tcp,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// UDP makes the listener a UDP listener with a udp_proxy listener filter
+	UDP *UDPListenerSpec `json:"udp,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// PerConnectionBufferLimitBytes limits the read and write buffers of
+	// the connections, 1MiB if not set
+	PerConnectionBufferLimitBytes *int32 `json:"perConnectionBufferLimitBytes,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// SocketOptions are set on the listener socket
+	SocketOptions []SocketOptionSpec `json:"socketOptions,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TCPKeepalive enables TCP keepalive on the accepted connections
+	TCPKeepalive *TCPKeepaliveSpec `json:"tcpKeepalive,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// EnableReusePort sets SO_REUSEPORT so that every worker thread has a
+	// socket of its own. Envoy enables it by default on Linux.
+	EnableReusePort *bool `json:"enableReusePort,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Exact
+	// ConnectionBalance balances the accepted connections between worker
+	// threads. Exact gives each new connection to the thread with the fewest
+	// connections.
+	ConnectionBalance string `json:"connectionBalance,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// BindToPort binds the listener to its port, true by default. Listeners
+	// that are not bound receive the connections redirected to them by a
+	// listener with the original_dst listener filter.
+	BindToPort *bool `json:"bindToPort,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Transparent sets IP_TRANSPARENT so that the listener accepts
+	// connections to addresses that are not local, for transparent proxying
+	Transparent *bool `json:"transparent,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AdditionalAddresses are listened on in addition to address, such as
+	// the IPv6 address of a dual-stack listener
+	AdditionalAddresses []ListenerAddressSpec `json:"additionalAddresses,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// UDPListenerConfig configures the socket of a UDP listener
+	UDPListenerConfig *UDPListenerConfigSpec `json:"udpListenerConfig,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Internal makes the listener an Envoy internal listener, without
+	// address, reached by clusters with loadAssignment.internalListener
+	Internal *InternalListenerSpec `json:"internal,omitempty"`
+}
+
+// UDPListenerSpec defines a udp_proxy without its typed config. The
+// datagrams of a downstream address form a session with a host of cluster.
+type UDPListenerSpec struct {
+	// +kubebuilder:validation:Optional
+	// StatPrefix prefixes the statistics of the proxy
+	// If empty, defaults to the listener name
+	StatPrefix string `json:"statPrefix,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// Cluster receives all sessions
+	Cluster string `json:"cluster"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// IdleTimeout ends sessions without datagrams in either direction for
+	// this duration, such as 30s. If empty, Envoy defaults to 60s.
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// HashPolicy selects the upstream host for load balancers hashing the
+	// session, such as ring_hash and maglev
+	HashPolicy *UDPHashPolicySpec `json:"hashPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AccessLog logs the sessions proxied by the filter
+	AccessLog []AccessLogSpec `json:"accessLog,omitempty"`
+}
+
+// UDPHashPolicySpec defines the hash of a session
+// +kubebuilder:validation:XValidation:rule="(self.type == 'Key') == has(self.key)",message="key is required for type Key only"
+type UDPHashPolicySpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=SourceIP;Key
+	// Type hashes the source IP of the datagrams or a fixed key
+	Type string `json:"type"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MinLength=1
+	// Key is hashed instead of the source IP, so that the sessions of
+	// listeners with the same key go to the same host
+	Key string `json:"key,omitempty"`
+}
+
+// SocketOptionSpec defines a socket option, with the level and name of the
+// platform of the data plane, such as 1 (SOL_SOCKET) and 15 (SO_REUSEPORT) on Linux
+// +kubebuilder:validation:XValidation:rule="has(self.intValue) != has(self.bufValue)",message="exactly one of intValue and bufValue is required"
+type SocketOptionSpec struct {
+	// +kubebuilder:validation:Optional
+	// Description of the option, for logs
+	Description string `json:"description,omitempty"`
+
+	// +kubebuilder:validation:Required
+	Level int64 `json:"level"`
+
+	// +kubebuilder:validation:Required
+	Name int64 `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	// IntValue is the value of an integer option
+	IntValue *int64 `json:"intValue,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// BufValue is the value of a buffer option, base64 encoded
+	BufValue []byte `json:"bufValue,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Prebind;Bound;Listening
+	// State is the state of the socket when the option is set, Prebind by default
+	State string `json:"state,omitempty"`
+}
+
+// TCPKeepaliveSpec defines the TCP keepalive of the accepted connections.
+// Unset values use the defaults of the kernel.
+type TCPKeepaliveSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// Probes is the number of unanswered probes before the connection is dropped
+	Probes *int32 `json:"probes,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// Time is the number of seconds a connection is idle before probes are sent
+	Time *int32 `json:"time,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// Interval is the number of seconds between probes
+	Interval *int32 `json:"interval,omitempty"`
+}
+
+// ListenerAddressSpec defines an additional address of a listener
+type ListenerAddressSpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int `json:"port"`
+}
+
+// UDPListenerConfigSpec defines the socket of a UDP listener
+type UDPListenerConfigSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// MaxRxDatagramSize is the largest datagram received, 1500 bytes by default
+	MaxRxDatagramSize *int64 `json:"maxRxDatagramSize,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PreferGro receives datagrams with UDP GRO when the kernel supports it
+	PreferGro *bool `json:"preferGro,omitempty"`
+}
+
+// InternalListenerSpec defines an Envoy internal listener. Internal
+// listeners accept the connections of clusters of the same Envoy, in
+// memory, and need the internal_listener bootstrap extension, which is
+// added to the bootstrap of the data plane.
+type InternalListenerSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// BufferSizeKb is the size of the buffers of the internal connections
+	// in KiB, 1024 by default. Applies to every internal listener of the
+	// data plane, the largest value is used.
+	BufferSizeKb *int32 `json:"bufferSizeKb,omitempty"`
+}
+
+// HTTPListenerSpec defines an http_connection_manager without its typed
+// config. The routes are served over RDS from routeConfigName or inlined
+// from virtualHosts.
+// +kubebuilder:validation:XValidation:rule="has(self.routeConfigName) != has(self.virtualHosts)",message="exactly one of routeConfigName and virtualHosts is required"
+type HTTPListenerSpec struct {
+	// +kubebuilder:validation:Optional
+	// StatPrefix prefixes the statistics of the connection manager
+	// If empty, defaults to the listener name
+	StatPrefix string `json:"statPrefix,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// RouteConfigName is the name of a route configuration of the control plane, served over RDS
+	RouteConfigName string `json:"routeConfigName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// VirtualHosts are the virtual hosts of a route configuration inlined in the listener
+	VirtualHosts []VirtualHostSpec `json:"virtualHosts,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=AUTO;HTTP1;HTTP2;HTTP3
+	// CodecType selects the HTTP codec, AUTO detects HTTP/1.1 and HTTP/2
+	CodecType string `json:"codecType,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// StreamIdleTimeout is the time a stream may be idle, such as 5m
+	StreamIdleTimeout string `json:"streamIdleTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// RequestTimeout is the time to receive a whole request
+	RequestTimeout string `json:"requestTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// RequestHeadersTimeout is the time to receive the headers of a request
+	RequestHeadersTimeout string `json:"requestHeadersTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// IdleTimeout is the time a connection may be without streams
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// DrainTimeout is the time between the GOAWAY announcing a graceful close
+	// of an HTTP/2 connection and its close
+	DrainTimeout string `json:"drainTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// UseRemoteAddress uses the address of the downstream connection as the
+	// client address instead of x-forwarded-for
+	UseRemoteAddress *bool `json:"useRemoteAddress,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// XffNumTrustedHops is the number of proxies in front of Envoy trusted
+	// to append to x-forwarded-for
+	XffNumTrustedHops int32 `json:"xffNumTrustedHops,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// HTTPFilters are the HTTP filters in order. The router filter is
+	// appended if it is not the last one.
+	HTTPFilters []FilterSpec `json:"httpFilters,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Tracing enables the tracing of requests
+	Tracing *HTTPTracingSpec `json:"tracing,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=8192
+	// MaxRequestHeadersKb is the maximum size of the request headers in KiB
+	MaxRequestHeadersKb int32 `json:"maxRequestHeadersKb,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// MaxHeadersCount is the maximum number of headers of a request or response
+	MaxHeadersCount int32 `json:"maxHeadersCount,omitempty"`
+}
+
+// TCPListenerSpec defines a tcp_proxy without its typed config. The
+// connections are forwarded to cluster or split between weightedClusters.
+// +kubebuilder:validation:XValidation:rule="has(self.cluster) != has(self.weightedClusters)",message="exactly one of cluster and weightedClusters is required"
+type TCPListenerSpec struct {
+	// +kubebuilder:validation:Optional
+	// StatPrefix prefixes the statistics of the proxy
+	// If empty, defaults to the listener name
+	StatPrefix string `json:"statPrefix,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MinLength=1
+	// Cluster receives all connections
+	Cluster string `json:"cluster,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MinItems=1
+	// WeightedClusters split the connections by weight
+	WeightedClusters []WeightedClusterSpec `json:"weightedClusters,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// IdleTimeout closes connections without data in either direction for
+	// this duration, such as 30m. If empty, Envoy defaults to 1h.
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// MaxConnectAttempts is the number of attempts to connect to the upstream
+	// If zero, Envoy defaults to 1
+	MaxConnectAttempts int32 `json:"maxConnectAttempts,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AccessLog logs the connections proxied by the filter
 	AccessLog []AccessLogSpec `json:"accessLog,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// HashPolicy selects the upstream host for load balancers hashing the
+	// connection, such as ring_hash and maglev
+	HashPolicy *TCPHashPolicySpec `json:"hashPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=V1;V2
+	// UpstreamProxyProtocol sends a PROXY protocol header of this version to
+	// the upstream, with the address of the downstream connection. The
+	// clusters are served with a copy carrying the transport socket.
+	UpstreamProxyProtocol string `json:"upstreamProxyProtocol,omitempty"`
+}
+
+// WeightedClusterSpec defines a cluster receiving a share of the connections
+type WeightedClusterSpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	Weight int32 `json:"weight"`
+}
+
+// TCPHashPolicySpec defines the hash of a connection
+// +kubebuilder:validation:XValidation:rule="(self.type == 'FilterState') == has(self.filterStateKey)",message="filterStateKey is required for type FilterState only"
+type TCPHashPolicySpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=SourceIP;FilterState
+	// Type hashes the source IP of the connection or a filter state object
+	Type string `json:"type"`
+
+	// +kubebuilder:validation:Optional
+	// FilterStateKey is the name of the filter state object hashed
+	FilterStateKey string `json:"filterStateKey,omitempty"`
+}
+
+// HTTPTracingSpec defines the tracing of an http_connection_manager
+type HTTPTracingSpec struct {
+	// +kubebuilder:validation:Optional
+	// Provider is the tracer, such as envoy.tracers.opentelemetry with an
+	// OpenTelemetryConfig typed config
+	Provider *FilterSpec `json:"provider,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// RandomSampling is the percentage of requests traced, 100 if not set
+	RandomSampling *int32 `json:"randomSampling,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// ClientSampling is the percentage of requests traced when the client
+	// sets x-client-trace-id, 100 if not set
+	ClientSampling *int32 `json:"clientSampling,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// OverallSampling caps the percentage of requests traced after the other
+	// sampling decisions, 100 if not set
+	OverallSampling *int32 `json:"overallSampling,omitempty"`
 }
 
 // ListenerFilterSpec defines the listener filter configuration
@@ -188,49 +681,492 @@ type RouteConfigSpec struct {
 	VirtualHosts []VirtualHostSpec `json:"virtualHosts"`
 }
 
+// CanarySpec defines a progressive traffic shift from a stable cluster to a canary cluster
+type CanarySpec struct {
+	// +kubebuilder:validation:Required
+	// StableCluster is the name of the cluster currently receiving the traffic
+	StableCluster string `json:"stableCluster"`
+
+	// +kubebuilder:validation:Required
+	// CanaryCluster is the name of the cluster the traffic is shifted to
+	CanaryCluster string `json:"canaryCluster"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Minimum=0
+	// +kubebuilder:validation:items:Maximum=100
+	// Steps specifies the canary weights in percent applied one after another
+	// If empty, defaults to [10, 25, 50, 100]
+	Steps []int32 `json:"steps,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Pause specifies how long each step is held before advancing to the next one
+	// If empty, defaults to 1m
+	Pause string `json:"pause,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Analysis specifies the metric checks run before advancing to the next step
+	Analysis *CanaryAnalysisSpec `json:"analysis,omitempty"`
+}
+
+// CanaryAnalysisSpec defines how the health of the canary is evaluated
+type CanaryAnalysisSpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^(0(\.[0-9]+)?|1(\.0+)?)$`
+	// MaxErrorRate is the highest tolerated error ratio between 0 and 1, e.g. "0.05"
+	MaxErrorRate string `json:"maxErrorRate"`
+
+	// +kubebuilder:validation:Optional
+	// Prometheus specifies a Prometheus query returning the canary error ratio
+	Prometheus *PrometheusAnalysisSpec `json:"prometheus,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// EnvoyAdmin specifies Envoy admin endpoints whose canary cluster stats are evaluated
+	EnvoyAdmin *EnvoyAdminAnalysisSpec `json:"envoyAdmin,omitempty"`
+}
+
+// PrometheusAnalysisSpec defines a Prometheus based canary check
+type PrometheusAnalysisSpec struct {
+	// +kubebuilder:validation:Required
+	// Address is the base URL of the Prometheus server, e.g. http://prometheus.monitoring:9090
+	Address string `json:"address"`
+
+	// +kubebuilder:validation:Required
+	// Query is a PromQL expression evaluating to the canary error ratio
+	Query string `json:"query"`
+}
+
+// EnvoyAdminAnalysisSpec defines an Envoy stats based canary check
+type EnvoyAdminAnalysisSpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	// URLs lists the Envoy admin base URLs, e.g. http://envoy-0.envoy:9901
+	URLs []string `json:"urls"`
+}
+
+// NodeSelectorSpec selects Envoy nodes by the attributes they report in their node information
+// +kubebuilder:validation:MinProperties=1
+type NodeSelectorSpec struct {
+	// +kubebuilder:validation:Optional
+	// NodeIDs lists glob patterns matched against the node ID, e.g. "edge-dc1-*"
+	NodeIDs []string `json:"nodeIDs,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Cluster matches the node cluster reported by Envoy (--service-cluster)
+	Cluster string `json:"cluster,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Locality matches the node locality, empty fields match any value
+	Locality *LocalitySpec `json:"locality,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Metadata matches string values of the node metadata
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// LocalitySpec defines an Envoy locality
+type LocalitySpec struct {
+	// +kubebuilder:validation:Optional
+	Region string `json:"region,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Zone string `json:"zone,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	SubZone string `json:"subZone,omitempty"`
+}
+
+// ResourcePatchSpec defines a JSON merge patch (RFC 7386) applied to a resource with the given name
+type ResourcePatchSpec struct {
+	// +kubebuilder:validation:Required
+	// Name is the name of the listener, cluster or route configuration to patch
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+	// Patch is merged into the resource spec, lists are replaced as a whole
+	Patch apiextensionsv1.JSON `json:"patch"`
+}
+
+// NodeGroupSpec defines a configuration variant served to a group of Envoy nodes
+type NodeGroupSpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// Name identifies the node group
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+	// Selector selects the Envoy nodes belonging to this group
+	// Nodes are assigned to the first matching group
+	Selector NodeSelectorSpec `json:"selector"`
+
+	// +kubebuilder:validation:Optional
+	// Listeners specifies patches applied to listeners for this group
+	Listeners []ResourcePatchSpec `json:"listeners,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Clusters specifies patches applied to clusters for this group
+	Clusters []ResourcePatchSpec `json:"clusters,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Routes specifies patches applied to route configurations for this group
+	Routes []ResourcePatchSpec `json:"routes,omitempty"`
+}
+
 type XDSControlPlaneSpec struct {
 	// +kubebuilder:validation:Minimum=1
 	// +kubebuilder:validation:Maximum=65535
 	XdsPort int `json:"xdsPort"`
 
+	// +kubebuilder:validation:Optional
+	// XdsBindAddress is the address the xDS server listens on, an IPv4 or IPv6
+	// address or a unix domain socket such as "unix:///var/run/xds/xds.sock".
+	// Defaults to all interfaces.
+	XdsBindAddress string `json:"xdsBindAddress,omitempty"`
+
 	// +kubebuilder:validation:Optional
 	// NodeIDs specifies the list of Envoy node IDs that should receive this configuration
-	// If empty, defaults to ["external-envoy"]
+	// Entries may be glob patterns such as "edge-envoy-*", matched when a node connects
+	// If empty and no other node match is configured, defaults to ["external-envoy"]
 	NodeIDs []string `json:"nodeIDs,omitempty"`
 
-	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:MinItems=1
-	Listeners []ListenerSpec `json:"listeners"`
+	// +kubebuilder:validation:Optional
+	// NodeIDRegex lists regular expressions matched against the ID of connecting nodes
+	NodeIDRegex []string `json:"nodeIDRegex,omitempty"`
 
-	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:MinItems=1
-	Clusters []ClusterSpec `json:"clusters"`
+	// +kubebuilder:validation:Optional
+	// NodeClusters lists node clusters (--service-cluster) whose nodes receive this configuration
+	NodeClusters []string `json:"nodeClusters,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Listeners are served in addition to the attached XDSListener resources
+	Listeners []ListenerSpec `json:"listeners,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Clusters are served in addition to the attached XDSCluster resources
+	Clusters []ClusterSpec `json:"clusters,omitempty"`
 
 	// +kubebuilder:validation:Optional
+	// Routes are served in addition to the attached XDSRouteConfig resources
 	Routes []RouteConfigSpec `json:"routes,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ResourceSelector attaches the XDSListener, XDSCluster and XDSRouteConfig
+	// resources of the namespace matching its labels, in addition to those
+	// referring to this control plane in spec.controlPlaneRef
+	ResourceSelector *metav1.LabelSelector `json:"resourceSelector,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AllowedNamespaces lists the namespaces whose resources may attach to this
+	// control plane with a spec.controlPlaneRef, defaults to its own namespace
+	AllowedNamespaces *AllowedNamespacesSpec `json:"allowedNamespaces,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// NodeGroups specifies configuration variants for groups of Envoy nodes
+	// Nodes not matching any group receive the configuration as specified
+	NodeGroups []NodeGroupSpec `json:"nodeGroups,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Canary specifies a progressive traffic shift between two of the clusters
+	Canary *CanarySpec `json:"canary,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Abort;KeepLastGood;SkipInvalid
+	// +kubebuilder:default=Abort
+	// FailurePolicy defines what happens when the snapshot cannot be built
+	// Abort fails the reconcile, KeepLastGood keeps serving the previous snapshot,
+	// SkipInvalid serves the snapshot without the resources that failed to build
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=10
+	// RevisionHistoryLimit is the number of served snapshot revisions kept for rollback
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// PinnedRevision serves the snapshot of a revision from status.history instead of
+	// building it from the spec. Remove the field to resume serving the spec.
+	PinnedRevision *int64 `json:"pinnedRevision,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PortChangeOverlap is how long the previous port keeps serving after xdsPort
+	// changes, for example "2m". By default the previous port is closed as soon as
+	// the new port serves the current snapshots.
+	PortChangeOverlap string `json:"portChangeOverlap,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// GracefulStopTimeout bounds the graceful stop of an xDS server, streams still
+	// open afterwards are closed forcefully. Defaults to 10s.
+	GracefulStopTimeout string `json:"gracefulStopTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Bootstrap renders an Envoy bootstrap ConfigMap for each node ID in spec.nodeIDs
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// DataPlane runs Envoy proxies served by this control plane, with a Service
+	// exposing the ports of the listeners
+	DataPlane *DataPlaneSpec `json:"dataPlane,omitempty"`
+}
+
+// AllowedNamespacesSpec selects the namespaces allowed to contribute resources to a control plane
+type AllowedNamespacesSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Same;Selector;All
+	// +kubebuilder:default=Same
+	// From is Same for the namespace of the control plane only, Selector for
+	// the namespaces matching the selector, or All for every namespace
+	From string `json:"from,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Selector selects namespaces by label when from is Selector
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// DataPlaneSpec describes the Envoy proxies the operator runs for a control plane.
+// The proxies use the name of their pod as node ID.
+type DataPlaneSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Deployment;DaemonSet
+	// +kubebuilder:default=Deployment
+	// Kind is the workload running the proxies
+	Kind string `json:"kind,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Image is the Envoy image, defaults to envoyproxy/envoy:v1.28-latest
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// Replicas is the number of proxies of a Deployment, defaults to 1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Resources are the compute resources of the Envoy container
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// HostNetwork runs the proxies in the network namespace of the node
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// NodeSelector restricts the nodes the proxies run on
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Tolerations of the proxy pods
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default=ClusterIP
+	// ServiceType is the type of the Service exposing the listener ports
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+}
+
+// BootstrapSpec configures the Envoy bootstraps rendered for the nodes of a control plane
+type BootstrapSpec struct {
+	// +kubebuilder:validation:Optional
+	// Cluster is the node cluster written in the bootstrap
+	// Defaults to the first entry of spec.nodeClusters, or the name of the control plane
+	Cluster string `json:"cluster,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// XdsAddress is the host:port or unix:// socket Envoy connects to for xDS
+	// Defaults to status.xdsServerAddress
+	XdsAddress string `json:"xdsAddress,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="127.0.0.1"
+	// AdminAddress is the address of the Envoy admin listener
+	AdminAddress string `json:"adminAddress,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=9901
+	// AdminPort is the port of the Envoy admin listener
+	AdminPort int32 `json:"adminPort,omitempty"`
+}
+
+// CanaryStatus defines the observed state of a canary rollout
+type CanaryStatus struct {
+	// Phase represents the current phase of the rollout
+	// +kubebuilder:validation:Enum=Progressing;Succeeded;Aborted
+	Phase string `json:"phase,omitempty"`
+
+	// StableCluster is the stable cluster of the rollout in progress
+	StableCluster string `json:"stableCluster,omitempty"`
+
+	// CanaryCluster is the canary cluster of the rollout in progress
+	CanaryCluster string `json:"canaryCluster,omitempty"`
+
+	// CurrentStep is the index of the step currently applied
+	CurrentStep int32 `json:"currentStep"`
+
+	// CanaryWeight is the percentage of traffic currently sent to the canary cluster
+	CanaryWeight int32 `json:"canaryWeight"`
+
+	// LastStepTime is the time the current step was applied
+	// +optional
+	LastStepTime *metav1.Time `json:"lastStepTime,omitempty"`
+
+	// ObservedErrorRate is the error ratio measured by the last analysis
+	// +optional
+	ObservedErrorRate string `json:"observedErrorRate,omitempty"`
+
+	// Message is a human readable description of the last rollout decision
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// SnapshotRevision records a snapshot served by the control plane
+type SnapshotRevision struct {
+	// Revision is the sequence number of the revision
+	Revision int64 `json:"revision"`
+
+	// Version is the xDS version of the snapshot served to the default nodes
+	Version string `json:"version"`
+
+	// Hash is a digest of the served resources
+	Hash string `json:"hash"`
+
+	// Generation is the spec generation the snapshot was built from
+	Generation int64 `json:"generation"`
+
+	// Time is when the revision was first served
+	Time metav1.Time `json:"time"`
+
+	// Author is the field manager of the last spec change, taken from managedFields
+	// +optional
+	Author string `json:"author,omitempty"`
+}
+
+// NodeStatus reports the configuration state of a connected Envoy node
+type NodeStatus struct {
+	// NodeID is the ID reported by the node
+	NodeID string `json:"nodeID"`
+
+	// AckedVersion is the latest snapshot version acknowledged by the node
+	// +optional
+	AckedVersion string `json:"ackedVersion,omitempty"`
+
+	// ObservedGeneration is the spec generation the acknowledged version was built from
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastAckTime is when the node last acknowledged a response
+	// +optional
+	LastAckTime *metav1.Time `json:"lastAckTime,omitempty"`
+
+	// LastNackTime is when the node last rejected a response
+	// +optional
+	LastNackTime *metav1.Time `json:"lastNackTime,omitempty"`
+
+	// LastNackMessage is the error reported with the last rejected response
+	// +optional
+	LastNackMessage string `json:"lastNackMessage,omitempty"`
+}
+
+// PropagationStatus reports the propagation of the latest spec generation to the nodes
+type PropagationStatus struct {
+	// Generation is the latest spec generation observed by the operator
+	Generation int64 `json:"generation"`
+
+	// ObservedTime is when the generation was first observed
+	ObservedTime metav1.Time `json:"observedTime"`
+
+	// SnapshotVersion is the latest snapshot version set for the generation
+	// +optional
+	SnapshotVersion string `json:"snapshotVersion,omitempty"`
+
+	// SnapshotTime is when the latest snapshot of the generation was set
+	// +optional
+	SnapshotTime *metav1.Time `json:"snapshotTime,omitempty"`
+
+	// UpdatedNodes is the number of connected nodes that acknowledged the generation
+	UpdatedNodes int32 `json:"updatedNodes"`
+
+	// ConnectedNodes is the number of nodes with an open xDS stream
+	ConnectedNodes int32 `json:"connectedNodes"`
+}
+
+// FallbackStatus records how a snapshot build failure was handled
+type FallbackStatus struct {
+	// Decision is the action taken according to the failure policy
+	// +kubebuilder:validation:Enum=KeptLastGood;SkippedInvalid
+	Decision string `json:"decision"`
+
+	// Reason describes the build failure
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// ExcludedResources lists the resources left out of the served snapshot, as kind/name
+	// +optional
+	ExcludedResources []string `json:"excludedResources,omitempty"`
+
+	// ServedVersion is the version of the snapshot served to the default nodes
+	// +optional
+	ServedVersion string `json:"servedVersion,omitempty"`
+
+	// Time is when the decision was made
+	// +optional
+	Time *metav1.Time `json:"time,omitempty"`
 }
 
 // XDSControlPlaneStatus defines the observed state of XDSControlPlane
 type XDSControlPlaneStatus struct {
 	// Phase represents the current phase of the XDSControlPlane
-	// +kubebuilder:validation:Enum=Pending;Ready;Error
+	// +kubebuilder:validation:Enum=Pending;Ready;Degraded;Error
 	Phase string `json:"phase,omitempty"`
 
+	// ObservedGeneration is the most recent generation processed by the operator.
+	// The conditions reflect this generation once it equals metadata.generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Conditions represent the latest available observations of the XDSControlPlane's state
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
-	// ConnectedNodeIDs lists the Envoy node IDs currently connected to the xDS server
+	// ConnectedNodeIDs lists the Envoy node IDs the configuration is currently served to,
+	// including nodes matched by a pattern since the xDS server started
 	// +optional
 	ConnectedNodeIDs []string `json:"connectedNodeIDs,omitempty"`
 
-	// XdsServerAddress is the address where the xDS server is listening
+	// XdsServerAddress is the address Envoy connects to for the xDS server, ready
+	// to be used in an Envoy bootstrap
 	// +optional
 	XdsServerAddress string `json:"xdsServerAddress,omitempty"`
 
 	// LastSnapshotVersion indicates the version of the last successfully created snapshot
 	// +optional
 	LastSnapshotVersion string `json:"lastSnapshotVersion,omitempty"`
+
+	// Canary reports the progress of the canary rollout
+	// +optional
+	Canary *CanaryStatus `json:"canary,omitempty"`
+
+	// Fallback is set while the served configuration deviates from the spec
+	// because of a snapshot build failure
+	// +optional
+	Fallback *FallbackStatus `json:"fallback,omitempty"`
+
+	// CurrentRevision is the revision currently served
+	// +optional
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+
+	// History lists the most recent revisions, oldest first
+	// +optional
+	History []SnapshotRevision `json:"history,omitempty"`
+
+	// Propagation reports how far the latest generation has reached the nodes
+	// +optional
+	Propagation *PropagationStatus `json:"propagation,omitempty"`
+
+	// Nodes lists the nodes with an open xDS stream
+	// +optional
+	Nodes []NodeStatus `json:"nodes,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -238,6 +1174,9 @@ type XDSControlPlaneStatus struct {
 // +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="XDS Port",type=integer,JSONPath=`.spec.xdsPort`
 // +kubebuilder:printcolumn:name="Connected Nodes",type=string,JSONPath=`.status.connectedNodeIDs`
+// +kubebuilder:printcolumn:name="Canary",type=integer,JSONPath=`.status.canary.canaryWeight`,priority=1
+// +kubebuilder:printcolumn:name="Updated",type=integer,JSONPath=`.status.propagation.updatedNodes`,priority=1
+// +kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.status.currentRevision`,priority=1
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 type XDSControlPlane struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -0,0 +1,38 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// XDSListenerSpec defines the desired state of an XDSListener
+type XDSListenerSpec struct {
+	AttachmentSpec `json:",inline"`
+	ListenerSpec   `json:",inline"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Control Plane",type=string,JSONPath=`.spec.controlPlaneRef.name`
+// +kubebuilder:printcolumn:name="Accepted",type=string,JSONPath=`.status.controlPlanes[*].accepted`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.controlPlanes[*].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// XDSListener is an Envoy listener served by the control planes it attaches to
+type XDSListener struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XDSListenerSpec   `json:"spec,omitempty"`
+	Status XDSResourceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// XDSListenerList contains a list of XDSListener
+type XDSListenerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XDSListener `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XDSListener{}, &XDSListenerList{})
+}
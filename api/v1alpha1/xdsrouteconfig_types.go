@@ -0,0 +1,38 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// XDSRouteConfigSpec defines the desired state of an XDSRouteConfig
+type XDSRouteConfigSpec struct {
+	AttachmentSpec  `json:",inline"`
+	RouteConfigSpec `json:",inline"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Control Plane",type=string,JSONPath=`.spec.controlPlaneRef.name`
+// +kubebuilder:printcolumn:name="Accepted",type=string,JSONPath=`.status.controlPlanes[*].accepted`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.controlPlanes[*].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// XDSRouteConfig is an Envoy route configuration served by the control planes it attaches to
+type XDSRouteConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XDSRouteConfigSpec `json:"spec,omitempty"`
+	Status XDSResourceStatus  `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// XDSRouteConfigList contains a list of XDSRouteConfig
+type XDSRouteConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XDSRouteConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XDSRouteConfig{}, &XDSRouteConfigList{})
+}
@@ -21,6 +21,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
@@ -42,6 +43,145 @@ func (in *AccessLogSpec) DeepCopy() *AccessLogSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AllowedNamespacesSpec) DeepCopyInto(out *AllowedNamespacesSpec) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllowedNamespacesSpec.
+func (in *AllowedNamespacesSpec) DeepCopy() *AllowedNamespacesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AllowedNamespacesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttachmentSpec) DeepCopyInto(out *AttachmentSpec) {
+	*out = *in
+	if in.ControlPlaneRef != nil {
+		in, out := &in.ControlPlaneRef, &out.ControlPlaneRef
+		*out = new(ControlPlaneReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttachmentSpec.
+func (in *AttachmentSpec) DeepCopy() *AttachmentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AttachmentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttachmentStatus) DeepCopyInto(out *AttachmentStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttachmentStatus.
+func (in *AttachmentStatus) DeepCopy() *AttachmentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AttachmentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BootstrapSpec) DeepCopyInto(out *BootstrapSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BootstrapSpec.
+func (in *BootstrapSpec) DeepCopy() *BootstrapSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BootstrapSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryAnalysisSpec) DeepCopyInto(out *CanaryAnalysisSpec) {
+	*out = *in
+	if in.Prometheus != nil {
+		in, out := &in.Prometheus, &out.Prometheus
+		*out = new(PrometheusAnalysisSpec)
+		**out = **in
+	}
+	if in.EnvoyAdmin != nil {
+		in, out := &in.EnvoyAdmin, &out.EnvoyAdmin
+		*out = new(EnvoyAdminAnalysisSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryAnalysisSpec.
+func (in *CanaryAnalysisSpec) DeepCopy() *CanaryAnalysisSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryAnalysisSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanarySpec) DeepCopyInto(out *CanarySpec) {
+	*out = *in
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.Analysis != nil {
+		in, out := &in.Analysis, &out.Analysis
+		*out = new(CanaryAnalysisSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanarySpec.
+func (in *CanarySpec) DeepCopy() *CanarySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CanarySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryStatus) DeepCopyInto(out *CanaryStatus) {
+	*out = *in
+	if in.LastStepTime != nil {
+		in, out := &in.LastStepTime, &out.LastStepTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryStatus.
+func (in *CanaryStatus) DeepCopy() *CanaryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterSpec) DeepCopyInto(out *ClusterSpec) {
 	*out = *in
@@ -72,6 +212,76 @@ func (in *ClusterSpec) DeepCopy() *ClusterSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneReference) DeepCopyInto(out *ControlPlaneReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneReference.
+func (in *ControlPlaneReference) DeepCopy() *ControlPlaneReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataPlaneSpec) DeepCopyInto(out *DataPlaneSpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataPlaneSpec.
+func (in *DataPlaneSpec) DeepCopy() *DataPlaneSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DataPlaneSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DownstreamTLSSpec) DeepCopyInto(out *DownstreamTLSSpec) {
+	*out = *in
+	if in.ALPNProtocols != nil {
+		in, out := &in.ALPNProtocols, &out.ALPNProtocols
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DownstreamTLSSpec.
+func (in *DownstreamTLSSpec) DeepCopy() *DownstreamTLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DownstreamTLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EndpointSelectorSpec) DeepCopyInto(out *EndpointSelectorSpec) {
 	*out = *in
@@ -92,9 +302,103 @@ func (in *EndpointSelectorSpec) DeepCopy() *EndpointSelectorSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvoyAdminAnalysisSpec) DeepCopyInto(out *EnvoyAdminAnalysisSpec) {
+	*out = *in
+	if in.URLs != nil {
+		in, out := &in.URLs, &out.URLs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvoyAdminAnalysisSpec.
+func (in *EnvoyAdminAnalysisSpec) DeepCopy() *EnvoyAdminAnalysisSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvoyAdminAnalysisSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FallbackStatus) DeepCopyInto(out *FallbackStatus) {
+	*out = *in
+	if in.ExcludedResources != nil {
+		in, out := &in.ExcludedResources, &out.ExcludedResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Time != nil {
+		in, out := &in.Time, &out.Time
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FallbackStatus.
+func (in *FallbackStatus) DeepCopy() *FallbackStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FallbackStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FilterChainMatchSpec) DeepCopyInto(out *FilterChainMatchSpec) {
+	*out = *in
+	if in.PrefixRanges != nil {
+		in, out := &in.PrefixRanges, &out.PrefixRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SourcePrefixRanges != nil {
+		in, out := &in.SourcePrefixRanges, &out.SourcePrefixRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DirectSourcePrefixRanges != nil {
+		in, out := &in.DirectSourcePrefixRanges, &out.DirectSourcePrefixRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SourcePorts != nil {
+		in, out := &in.SourcePorts, &out.SourcePorts
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServerNames != nil {
+		in, out := &in.ServerNames, &out.ServerNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ApplicationProtocols != nil {
+		in, out := &in.ApplicationProtocols, &out.ApplicationProtocols
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FilterChainMatchSpec.
+func (in *FilterChainMatchSpec) DeepCopy() *FilterChainMatchSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FilterChainMatchSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FilterChainSpec) DeepCopyInto(out *FilterChainSpec) {
 	*out = *in
+	if in.FilterChainMatch != nil {
+		in, out := &in.FilterChainMatch, &out.FilterChainMatch
+		*out = new(FilterChainMatchSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Filters != nil {
 		in, out := &in.Filters, &out.Filters
 		*out = make([]FilterSpec, len(*in))
@@ -102,6 +406,16 @@ func (in *FilterChainSpec) DeepCopyInto(out *FilterChainSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.TransportSocket != nil {
+		in, out := &in.TransportSocket, &out.TransportSocket
+		*out = new(TransportSocketSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(DownstreamTLSSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FilterChainSpec.
@@ -170,6 +484,45 @@ func (in *HTTPHealthCheckSpec) DeepCopy() *HTTPHealthCheckSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPListenerSpec) DeepCopyInto(out *HTTPListenerSpec) {
+	*out = *in
+	if in.VirtualHosts != nil {
+		in, out := &in.VirtualHosts, &out.VirtualHosts
+		*out = make([]VirtualHostSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.UseRemoteAddress != nil {
+		in, out := &in.UseRemoteAddress, &out.UseRemoteAddress
+		*out = new(bool)
+		**out = **in
+	}
+	if in.HTTPFilters != nil {
+		in, out := &in.HTTPFilters, &out.HTTPFilters
+		*out = make([]FilterSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Tracing != nil {
+		in, out := &in.Tracing, &out.Tracing
+		*out = new(HTTPTracingSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPListenerSpec.
+func (in *HTTPListenerSpec) DeepCopy() *HTTPListenerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPListenerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HTTPStatusRangeSpec) DeepCopyInto(out *HTTPStatusRangeSpec) {
 	*out = *in
@@ -185,6 +538,41 @@ func (in *HTTPStatusRangeSpec) DeepCopy() *HTTPStatusRangeSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPTracingSpec) DeepCopyInto(out *HTTPTracingSpec) {
+	*out = *in
+	if in.Provider != nil {
+		in, out := &in.Provider, &out.Provider
+		*out = new(FilterSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RandomSampling != nil {
+		in, out := &in.RandomSampling, &out.RandomSampling
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ClientSampling != nil {
+		in, out := &in.ClientSampling, &out.ClientSampling
+		*out = new(int32)
+		**out = **in
+	}
+	if in.OverallSampling != nil {
+		in, out := &in.OverallSampling, &out.OverallSampling
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPTracingSpec.
+func (in *HTTPTracingSpec) DeepCopy() *HTTPTracingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPTracingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HeaderValueOptionSpec) DeepCopyInto(out *HeaderValueOptionSpec) {
 	*out = *in
@@ -247,17 +635,52 @@ func (in *HealthCheckSpec) DeepCopy() *HealthCheckSpec {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ListenerFilterSpec) DeepCopyInto(out *ListenerFilterSpec) {
+func (in *InternalListenerSpec) DeepCopyInto(out *InternalListenerSpec) {
 	*out = *in
-	in.TypedConfig.DeepCopyInto(&out.TypedConfig)
+	if in.BufferSizeKb != nil {
+		in, out := &in.BufferSizeKb, &out.BufferSizeKb
+		*out = new(int32)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerFilterSpec.
-func (in *ListenerFilterSpec) DeepCopy() *ListenerFilterSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InternalListenerSpec.
+func (in *InternalListenerSpec) DeepCopy() *InternalListenerSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(ListenerFilterSpec)
+	out := new(InternalListenerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ListenerAddressSpec) DeepCopyInto(out *ListenerAddressSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerAddressSpec.
+func (in *ListenerAddressSpec) DeepCopy() *ListenerAddressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ListenerAddressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ListenerFilterSpec) DeepCopyInto(out *ListenerFilterSpec) {
+	*out = *in
+	in.TypedConfig.DeepCopyInto(&out.TypedConfig)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerFilterSpec.
+func (in *ListenerFilterSpec) DeepCopy() *ListenerFilterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ListenerFilterSpec)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -286,6 +709,73 @@ func (in *ListenerSpec) DeepCopyInto(out *ListenerSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.DefaultFilterChain != nil {
+		in, out := &in.DefaultFilterChain, &out.DefaultFilterChain
+		*out = new(FilterChainSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPListenerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TCP != nil {
+		in, out := &in.TCP, &out.TCP
+		*out = new(TCPListenerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UDP != nil {
+		in, out := &in.UDP, &out.UDP
+		*out = new(UDPListenerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PerConnectionBufferLimitBytes != nil {
+		in, out := &in.PerConnectionBufferLimitBytes, &out.PerConnectionBufferLimitBytes
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SocketOptions != nil {
+		in, out := &in.SocketOptions, &out.SocketOptions
+		*out = make([]SocketOptionSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TCPKeepalive != nil {
+		in, out := &in.TCPKeepalive, &out.TCPKeepalive
+		*out = new(TCPKeepaliveSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EnableReusePort != nil {
+		in, out := &in.EnableReusePort, &out.EnableReusePort
+		*out = new(bool)
+		**out = **in
+	}
+	if in.BindToPort != nil {
+		in, out := &in.BindToPort, &out.BindToPort
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Transparent != nil {
+		in, out := &in.Transparent, &out.Transparent
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AdditionalAddresses != nil {
+		in, out := &in.AdditionalAddresses, &out.AdditionalAddresses
+		*out = make([]ListenerAddressSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.UDPListenerConfig != nil {
+		in, out := &in.UDPListenerConfig, &out.UDPListenerConfig
+		*out = new(UDPListenerConfigSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Internal != nil {
+		in, out := &in.Internal, &out.Internal
+		*out = new(InternalListenerSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerSpec.
@@ -308,108 +798,542 @@ func (in *LoadAssignmentSpec) DeepCopyInto(out *LoadAssignmentSpec) {
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LoadAssignmentSpec.
-func (in *LoadAssignmentSpec) DeepCopy() *LoadAssignmentSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LoadAssignmentSpec.
+func (in *LoadAssignmentSpec) DeepCopy() *LoadAssignmentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LoadAssignmentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LocalitySpec) DeepCopyInto(out *LocalitySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocalitySpec.
+func (in *LocalitySpec) DeepCopy() *LocalitySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LocalitySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupSpec) DeepCopyInto(out *NodeGroupSpec) {
+	*out = *in
+	in.Selector.DeepCopyInto(&out.Selector)
+	if in.Listeners != nil {
+		in, out := &in.Listeners, &out.Listeners
+		*out = make([]ResourcePatchSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]ResourcePatchSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]ResourcePatchSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupSpec.
+func (in *NodeGroupSpec) DeepCopy() *NodeGroupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeSelectorSpec) DeepCopyInto(out *NodeSelectorSpec) {
+	*out = *in
+	if in.NodeIDs != nil {
+		in, out := &in.NodeIDs, &out.NodeIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Locality != nil {
+		in, out := &in.Locality, &out.Locality
+		*out = new(LocalitySpec)
+		**out = **in
+	}
+	if in.Metadata != nil {
+		in, out := &in.Metadata, &out.Metadata
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSelectorSpec.
+func (in *NodeSelectorSpec) DeepCopy() *NodeSelectorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeSelectorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeStatus) DeepCopyInto(out *NodeStatus) {
+	*out = *in
+	if in.LastAckTime != nil {
+		in, out := &in.LastAckTime, &out.LastAckTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastNackTime != nil {
+		in, out := &in.LastNackTime, &out.LastNackTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStatus.
+func (in *NodeStatus) DeepCopy() *NodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusAnalysisSpec) DeepCopyInto(out *PrometheusAnalysisSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusAnalysisSpec.
+func (in *PrometheusAnalysisSpec) DeepCopy() *PrometheusAnalysisSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusAnalysisSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PropagationStatus) DeepCopyInto(out *PropagationStatus) {
+	*out = *in
+	in.ObservedTime.DeepCopyInto(&out.ObservedTime)
+	if in.SnapshotTime != nil {
+		in, out := &in.SnapshotTime, &out.SnapshotTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PropagationStatus.
+func (in *PropagationStatus) DeepCopy() *PropagationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PropagationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourcePatchSpec) DeepCopyInto(out *ResourcePatchSpec) {
+	*out = *in
+	in.Patch.DeepCopyInto(&out.Patch)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourcePatchSpec.
+func (in *ResourcePatchSpec) DeepCopy() *ResourcePatchSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourcePatchSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouteConfigSpec) DeepCopyInto(out *RouteConfigSpec) {
+	*out = *in
+	if in.VirtualHosts != nil {
+		in, out := &in.VirtualHosts, &out.VirtualHosts
+		*out = make([]VirtualHostSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouteConfigSpec.
+func (in *RouteConfigSpec) DeepCopy() *RouteConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RouteConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotRevision) DeepCopyInto(out *SnapshotRevision) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotRevision.
+func (in *SnapshotRevision) DeepCopy() *SnapshotRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SocketOptionSpec) DeepCopyInto(out *SocketOptionSpec) {
+	*out = *in
+	if in.IntValue != nil {
+		in, out := &in.IntValue, &out.IntValue
+		*out = new(int64)
+		**out = **in
+	}
+	if in.BufValue != nil {
+		in, out := &in.BufValue, &out.BufValue
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SocketOptionSpec.
+func (in *SocketOptionSpec) DeepCopy() *SocketOptionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SocketOptionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TCPHashPolicySpec) DeepCopyInto(out *TCPHashPolicySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TCPHashPolicySpec.
+func (in *TCPHashPolicySpec) DeepCopy() *TCPHashPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TCPHashPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TCPHealthCheckSpec) DeepCopyInto(out *TCPHealthCheckSpec) {
+	*out = *in
+	if in.Send != nil {
+		in, out := &in.Send, &out.Send
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.Receive != nil {
+		in, out := &in.Receive, &out.Receive
+		*out = make([][]byte, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = make([]byte, len(*in))
+				copy(*out, *in)
+			}
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TCPHealthCheckSpec.
+func (in *TCPHealthCheckSpec) DeepCopy() *TCPHealthCheckSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TCPHealthCheckSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TCPKeepaliveSpec) DeepCopyInto(out *TCPKeepaliveSpec) {
+	*out = *in
+	if in.Probes != nil {
+		in, out := &in.Probes, &out.Probes
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Time != nil {
+		in, out := &in.Time, &out.Time
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TCPKeepaliveSpec.
+func (in *TCPKeepaliveSpec) DeepCopy() *TCPKeepaliveSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TCPKeepaliveSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TCPListenerSpec) DeepCopyInto(out *TCPListenerSpec) {
+	*out = *in
+	if in.WeightedClusters != nil {
+		in, out := &in.WeightedClusters, &out.WeightedClusters
+		*out = make([]WeightedClusterSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.AccessLog != nil {
+		in, out := &in.AccessLog, &out.AccessLog
+		*out = make([]AccessLogSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HashPolicy != nil {
+		in, out := &in.HashPolicy, &out.HashPolicy
+		*out = new(TCPHashPolicySpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TCPListenerSpec.
+func (in *TCPListenerSpec) DeepCopy() *TCPListenerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TCPListenerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TransportSocketSpec) DeepCopyInto(out *TransportSocketSpec) {
+	*out = *in
+	in.TypedConfig.DeepCopyInto(&out.TypedConfig)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TransportSocketSpec.
+func (in *TransportSocketSpec) DeepCopy() *TransportSocketSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TransportSocketSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UDPHashPolicySpec) DeepCopyInto(out *UDPHashPolicySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UDPHashPolicySpec.
+func (in *UDPHashPolicySpec) DeepCopy() *UDPHashPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UDPHashPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UDPListenerConfigSpec) DeepCopyInto(out *UDPListenerConfigSpec) {
+	*out = *in
+	if in.MaxRxDatagramSize != nil {
+		in, out := &in.MaxRxDatagramSize, &out.MaxRxDatagramSize
+		*out = new(int64)
+		**out = **in
+	}
+	if in.PreferGro != nil {
+		in, out := &in.PreferGro, &out.PreferGro
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UDPListenerConfigSpec.
+func (in *UDPListenerConfigSpec) DeepCopy() *UDPListenerConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UDPListenerConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UDPListenerSpec) DeepCopyInto(out *UDPListenerSpec) {
+	*out = *in
+	if in.HashPolicy != nil {
+		in, out := &in.HashPolicy, &out.HashPolicy
+		*out = new(UDPHashPolicySpec)
+		**out = **in
+	}
+	if in.AccessLog != nil {
+		in, out := &in.AccessLog, &out.AccessLog
+		*out = make([]AccessLogSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UDPListenerSpec.
+func (in *UDPListenerSpec) DeepCopy() *UDPListenerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UDPListenerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualHostSpec) DeepCopyInto(out *VirtualHostSpec) {
+	*out = *in
+	if in.Domains != nil {
+		in, out := &in.Domains, &out.Domains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]apiextensionsv1.JSON, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualHostSpec.
+func (in *VirtualHostSpec) DeepCopy() *VirtualHostSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(LoadAssignmentSpec)
+	out := new(VirtualHostSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RouteConfigSpec) DeepCopyInto(out *RouteConfigSpec) {
+func (in *WeightedClusterSpec) DeepCopyInto(out *WeightedClusterSpec) {
 	*out = *in
-	if in.VirtualHosts != nil {
-		in, out := &in.VirtualHosts, &out.VirtualHosts
-		*out = make([]VirtualHostSpec, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouteConfigSpec.
-func (in *RouteConfigSpec) DeepCopy() *RouteConfigSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeightedClusterSpec.
+func (in *WeightedClusterSpec) DeepCopy() *WeightedClusterSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(RouteConfigSpec)
+	out := new(WeightedClusterSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *TCPHealthCheckSpec) DeepCopyInto(out *TCPHealthCheckSpec) {
+func (in *XDSCluster) DeepCopyInto(out *XDSCluster) {
 	*out = *in
-	if in.Send != nil {
-		in, out := &in.Send, &out.Send
-		*out = make([]byte, len(*in))
-		copy(*out, *in)
-	}
-	if in.Receive != nil {
-		in, out := &in.Receive, &out.Receive
-		*out = make([][]byte, len(*in))
-		for i := range *in {
-			if (*in)[i] != nil {
-				in, out := &(*in)[i], &(*out)[i]
-				*out = make([]byte, len(*in))
-				copy(*out, *in)
-			}
-		}
-	}
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TCPHealthCheckSpec.
-func (in *TCPHealthCheckSpec) DeepCopy() *TCPHealthCheckSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSCluster.
+func (in *XDSCluster) DeepCopy() *XDSCluster {
 	if in == nil {
 		return nil
 	}
-	out := new(TCPHealthCheckSpec)
+	out := new(XDSCluster)
 	in.DeepCopyInto(out)
 	return out
 }
 
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *XDSCluster) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *TransportSocketSpec) DeepCopyInto(out *TransportSocketSpec) {
+func (in *XDSClusterList) DeepCopyInto(out *XDSClusterList) {
 	*out = *in
-	in.TypedConfig.DeepCopyInto(&out.TypedConfig)
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]XDSCluster, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TransportSocketSpec.
-func (in *TransportSocketSpec) DeepCopy() *TransportSocketSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSClusterList.
+func (in *XDSClusterList) DeepCopy() *XDSClusterList {
 	if in == nil {
 		return nil
 	}
-	out := new(TransportSocketSpec)
+	out := new(XDSClusterList)
 	in.DeepCopyInto(out)
 	return out
 }
 
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *XDSClusterList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *VirtualHostSpec) DeepCopyInto(out *VirtualHostSpec) {
+func (in *XDSClusterSpec) DeepCopyInto(out *XDSClusterSpec) {
 	*out = *in
-	if in.Domains != nil {
-		in, out := &in.Domains, &out.Domains
-		*out = make([]string, len(*in))
-		copy(*out, *in)
-	}
-	if in.Routes != nil {
-		in, out := &in.Routes, &out.Routes
-		*out = make([]apiextensionsv1.JSON, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
+	in.AttachmentSpec.DeepCopyInto(&out.AttachmentSpec)
+	in.ClusterSpec.DeepCopyInto(&out.ClusterSpec)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualHostSpec.
-func (in *VirtualHostSpec) DeepCopy() *VirtualHostSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSClusterSpec.
+func (in *XDSClusterSpec) DeepCopy() *XDSClusterSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(VirtualHostSpec)
+	out := new(XDSClusterSpec)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -481,6 +1405,16 @@ func (in *XDSControlPlaneSpec) DeepCopyInto(out *XDSControlPlaneSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.NodeIDRegex != nil {
+		in, out := &in.NodeIDRegex, &out.NodeIDRegex
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeClusters != nil {
+		in, out := &in.NodeClusters, &out.NodeClusters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Listeners != nil {
 		in, out := &in.Listeners, &out.Listeners
 		*out = make([]ListenerSpec, len(*in))
@@ -502,6 +1436,48 @@ func (in *XDSControlPlaneSpec) DeepCopyInto(out *XDSControlPlaneSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ResourceSelector != nil {
+		in, out := &in.ResourceSelector, &out.ResourceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = new(AllowedNamespacesSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeGroups != nil {
+		in, out := &in.NodeGroups, &out.NodeGroups
+		*out = make([]NodeGroupSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanarySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PinnedRevision != nil {
+		in, out := &in.PinnedRevision, &out.PinnedRevision
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Bootstrap != nil {
+		in, out := &in.Bootstrap, &out.Bootstrap
+		*out = new(BootstrapSpec)
+		**out = **in
+	}
+	if in.DataPlane != nil {
+		in, out := &in.DataPlane, &out.DataPlane
+		*out = new(DataPlaneSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSControlPlaneSpec.
@@ -529,6 +1505,35 @@ func (in *XDSControlPlaneStatus) DeepCopyInto(out *XDSControlPlaneStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanaryStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fallback != nil {
+		in, out := &in.Fallback, &out.Fallback
+		*out = new(FallbackStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]SnapshotRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Propagation != nil {
+		in, out := &in.Propagation, &out.Propagation
+		*out = new(PropagationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSControlPlaneStatus.
@@ -540,3 +1545,175 @@ func (in *XDSControlPlaneStatus) DeepCopy() *XDSControlPlaneStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *XDSListener) DeepCopyInto(out *XDSListener) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSListener.
+func (in *XDSListener) DeepCopy() *XDSListener {
+	if in == nil {
+		return nil
+	}
+	out := new(XDSListener)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *XDSListener) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *XDSListenerList) DeepCopyInto(out *XDSListenerList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]XDSListener, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSListenerList.
+func (in *XDSListenerList) DeepCopy() *XDSListenerList {
+	if in == nil {
+		return nil
+	}
+	out := new(XDSListenerList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *XDSListenerList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *XDSListenerSpec) DeepCopyInto(out *XDSListenerSpec) {
+	*out = *in
+	in.AttachmentSpec.DeepCopyInto(&out.AttachmentSpec)
+	in.ListenerSpec.DeepCopyInto(&out.ListenerSpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSListenerSpec.
+func (in *XDSListenerSpec) DeepCopy() *XDSListenerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(XDSListenerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *XDSResourceStatus) DeepCopyInto(out *XDSResourceStatus) {
+	*out = *in
+	if in.ControlPlanes != nil {
+		in, out := &in.ControlPlanes, &out.ControlPlanes
+		*out = make([]AttachmentStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSResourceStatus.
+func (in *XDSResourceStatus) DeepCopy() *XDSResourceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(XDSResourceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *XDSRouteConfig) DeepCopyInto(out *XDSRouteConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSRouteConfig.
+func (in *XDSRouteConfig) DeepCopy() *XDSRouteConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(XDSRouteConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *XDSRouteConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *XDSRouteConfigList) DeepCopyInto(out *XDSRouteConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]XDSRouteConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSRouteConfigList.
+func (in *XDSRouteConfigList) DeepCopy() *XDSRouteConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(XDSRouteConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *XDSRouteConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *XDSRouteConfigSpec) DeepCopyInto(out *XDSRouteConfigSpec) {
+	*out = *in
+	in.AttachmentSpec.DeepCopyInto(&out.AttachmentSpec)
+	in.RouteConfigSpec.DeepCopyInto(&out.RouteConfigSpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new XDSRouteConfigSpec.
+func (in *XDSRouteConfigSpec) DeepCopy() *XDSRouteConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(XDSRouteConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -3,11 +3,17 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -34,6 +40,13 @@ func main() {
 		Scheme:                 scheme,
 		MetricsBindAddress:     ":8082", // Changed from default :8080 to avoid conflict
 		HealthProbeBindAddress: ":8081", // Health check endpoint
+		Cache: cache.Options{
+			// Only TLS Secrets are read, for listeners terminating TLS and
+			// Ingresses, other Secrets are not cached
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Secret{}: {Field: fields.OneTermEqualSelector("type", string(corev1.SecretTypeTLS))},
+			},
+		},
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to start manager: %v\n", err)
@@ -50,13 +63,91 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Ingresses of a class are translated for a control plane, disabled unless a class is set
+	ingressClass := os.Getenv("INGRESS_CLASS")
+	var ingressControlPlane client.ObjectKey
+	if ingressClass != "" {
+		namespace, name, ok := strings.Cut(os.Getenv("INGRESS_CONTROL_PLANE"), "/")
+		if !ok || namespace == "" || name == "" {
+			fmt.Fprintf(os.Stderr, "INGRESS_CONTROL_PLANE must be set to <namespace>/<name> when INGRESS_CLASS is set\n")
+			os.Exit(1)
+		}
+		ingressControlPlane = client.ObjectKey{Namespace: namespace, Name: name}
+	}
+
 	if err = (&controller.XDSControlPlaneReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("xds-cp-operator"),
+		// Advertised in status as the address of the xDS servers
+		PodIP:       os.Getenv("POD_IP"),
+		ServiceHost: os.Getenv("XDS_SERVICE_HOST"),
+		// The Ingresses of the class grant their TLS Secrets to the control plane
+		IngressClassName:    ingressClass,
+		IngressControlPlane: ingressControlPlane,
 	}).SetupWithManager(mgr); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to setup controller: %v\n", err)
 		os.Exit(1)
 	}
+	// The Gateway API CRDs are optional
+	if gateways, tcpRoutes := controller.GatewayAPIInstalled(mgr.GetRESTMapper()); gateways {
+		if err = (&controller.GatewayReconciler{
+			Client:    mgr.GetClient(),
+			Scheme:    mgr.GetScheme(),
+			TCPRoutes: tcpRoutes,
+		}).SetupWithManager(mgr); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to setup gateway controller: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		log.Log.Info("Gateway API CRDs not installed, the gateway controller is disabled")
+	}
+	if ingressClass != "" {
+		ingress := &controller.IngressReconciler{
+			Client:           mgr.GetClient(),
+			Scheme:           mgr.GetScheme(),
+			Recorder:         mgr.GetEventRecorderFor("xds-cp-operator"),
+			IngressClassName: ingressClass,
+			ControlPlane:     ingressControlPlane,
+		}
+		for env, port := range map[string]*int{"INGRESS_HTTP_PORT": &ingress.HTTPPort, "INGRESS_HTTPS_PORT": &ingress.HTTPSPort} {
+			if value := os.Getenv(env); value != "" {
+				if *port, err = strconv.Atoi(value); err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid %s: %v\n", env, err)
+					os.Exit(1)
+				}
+			}
+		}
+		if err = ingress.SetupWithManager(mgr); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to setup ingress controller: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Debug endpoints exposing the served snapshots, disabled unless an address is set
+	if addr := os.Getenv("DEBUG_BIND_ADDRESS"); addr != "" {
+		tokenFile := os.Getenv("DEBUG_TOKEN_FILE")
+		if tokenFile == "" {
+			fmt.Fprintf(os.Stderr, "DEBUG_TOKEN_FILE must be set when DEBUG_BIND_ADDRESS is set\n")
+			os.Exit(1)
+		}
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read debug token: %v\n", err)
+			os.Exit(1)
+		}
+		if strings.TrimSpace(string(token)) == "" {
+			fmt.Fprintf(os.Stderr, "Debug token file %s is empty\n", tokenFile)
+			os.Exit(1)
+		}
+		if err := mgr.Add(&controller.DebugServer{
+			Addr:  addr,
+			Token: strings.TrimSpace(string(token)),
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to setup debug server: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		fmt.Fprintf(os.Stderr, "Manager exited with error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	defaultXDSPort = 18000
+
+	unixScheme = "unix://"
+)
+
+func xdsPort(crd *api.XDSControlPlane) int {
+	if crd.Spec.XdsPort == 0 {
+		return defaultXDSPort
+	}
+	return crd.Spec.XdsPort
+}
+
+// xdsListenAddress returns the network and the address the xDS server of a CR
+// listens on. Without spec.xdsBindAddress the server listens on all interfaces.
+func xdsListenAddress(crd *api.XDSControlPlane) (string, string, error) {
+	bind := crd.Spec.XdsBindAddress
+	if path, ok := strings.CutPrefix(bind, unixScheme); ok {
+		if path == "" {
+			return "", "", permanent(fmt.Errorf("invalid xdsBindAddress %q: missing socket path", bind))
+		}
+		return "unix", path, nil
+	}
+
+	port := strconv.Itoa(xdsPort(crd))
+	if bind == "" {
+		return "tcp", net.JoinHostPort("", port), nil
+	}
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(bind, "["), "]"))
+	if ip == nil {
+		return "", "", permanent(fmt.Errorf("invalid xdsBindAddress %q: not an IP address or unix:// socket", bind))
+	}
+	return "tcp", net.JoinHostPort(ip.String(), port), nil
+}
+
+// listenXDS opens the listener of an xDS server. A socket file left behind by
+// a previous operator process is removed first, other files are never removed.
+func listenXDS(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		info, err := os.Lstat(address)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("failed to stat socket %s: %w", address, err)
+		case info.Mode()&fs.ModeSocket == 0:
+			return nil, permanent(fmt.Errorf("invalid xdsBindAddress %q: %s exists and is not a socket", unixScheme+address, address))
+		default:
+			if err := os.Remove(address); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to remove stale socket %s: %w", address, err)
+			}
+		}
+	}
+	lis, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	return lis, nil
+}
+
+// socketOwner returns the key of another registered server listening on a
+// unix socket, whose socket file must not be replaced. The server manager lock
+// must be held.
+func socketOwner(serverKey, network, address string) (string, bool) {
+	if network != "unix" {
+		return "", false
+	}
+	for key, server := range serverManager.servers {
+		if key != serverKey && server.network == "unix" && server.address == address {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// describeAddress returns the listen address of a server as shown in events
+// and conditions
+func (s *XDSServerInstance) describeAddress() string {
+	if s.network == "unix" {
+		return unixScheme + s.address
+	}
+	if host, _, _ := net.SplitHostPort(s.address); host == "" {
+		return fmt.Sprintf("port %d", s.port)
+	}
+	return s.address
+}
+
+// advertisedAddress returns the address Envoy connects to for the xDS server.
+// A server listening on all interfaces or on the pod IP is reached through the
+// xDS Service of the operator if known, otherwise through the pod IP. A server
+// bound to another address is only reachable there.
+func (r *XDSControlPlaneReconciler) advertisedAddress(server *XDSServerInstance) string {
+	if server.network == "unix" {
+		return unixScheme + server.address
+	}
+
+	host, port, err := net.SplitHostPort(server.address)
+	if err != nil {
+		return server.address
+	}
+	ip := net.ParseIP(host)
+	wildcard := host == "" || ip.IsUnspecified()
+	podIP := r.PodIP != "" && ip.Equal(net.ParseIP(r.PodIP))
+
+	switch {
+	case (wildcard || podIP) && r.ServiceHost != "":
+		return net.JoinHostPort(r.ServiceHost, port)
+	case wildcard && r.PodIP != "":
+		return net.JoinHostPort(r.PodIP, port)
+	}
+	return server.address
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestXDSListenAddress(t *testing.T) {
+	tests := []struct {
+		bind    string
+		network string
+		address string
+		wantErr bool
+	}{
+		{bind: "", network: "tcp", address: ":18000"},
+		{bind: "10.0.0.5", network: "tcp", address: "10.0.0.5:18000"},
+		{bind: "::", network: "tcp", address: "[::]:18000"},
+		{bind: "[fd00::1]", network: "tcp", address: "[fd00::1]:18000"},
+		{bind: "unix:///var/run/xds/xds.sock", network: "unix", address: "/var/run/xds/xds.sock"},
+		{bind: "unix://", wantErr: true},
+		{bind: "envoy.local", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bind, func(t *testing.T) {
+			crd := &api.XDSControlPlane{Spec: api.XDSControlPlaneSpec{XdsBindAddress: tt.bind}}
+			network, address, err := xdsListenAddress(crd)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.True(t, isPermanent(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.network, network)
+			assert.Equal(t, tt.address, address)
+		})
+	}
+}
+
+func TestAdvertisedAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		podIP       string
+		serviceHost string
+		network     string
+		address     string
+		want        string
+	}{
+		{name: "Service", podIP: "10.1.2.3", serviceHost: "xds.system.svc", network: "tcp", address: ":18000", want: "xds.system.svc:18000"},
+		{name: "Service for pod IP", podIP: "10.1.2.3", serviceHost: "xds.system.svc", network: "tcp", address: "10.1.2.3:18000", want: "xds.system.svc:18000"},
+		{name: "Pod IP", podIP: "10.1.2.3", network: "tcp", address: "[::]:18000", want: "10.1.2.3:18000"},
+		{name: "IPv6 pod IP", podIP: "fd00::3", network: "tcp", address: ":18000", want: "[fd00::3]:18000"},
+		{name: "Bound address", podIP: "10.1.2.3", serviceHost: "xds.system.svc", network: "tcp", address: "127.0.0.1:18000", want: "127.0.0.1:18000"},
+		{name: "Unknown pod", network: "tcp", address: ":18000", want: ":18000"},
+		{name: "Unix socket", podIP: "10.1.2.3", network: "unix", address: "/var/run/xds.sock", want: "unix:///var/run/xds.sock"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &XDSControlPlaneReconciler{PodIP: tt.podIP, ServiceHost: tt.serviceHost}
+			server := &XDSServerInstance{network: tt.network, address: tt.address}
+			assert.Equal(t, tt.want, r.advertisedAddress(server))
+		})
+	}
+}
+
+func TestBindAddressChange(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	port := freePort(t)
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "bind", Namespace: "default"},
+		Spec:       api.XDSControlPlaneSpec{XdsPort: port},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+	serverKey := "default/bind"
+	defer r.cleanupServer(serverKey, time.Second)
+
+	previous, err := r.ensureXDSServer(ctx, crd, serverKey)
+	require.NoError(t, err)
+
+	// The same port on a single interface
+	crd.Spec.XdsBindAddress = "127.0.0.1"
+	server, err := r.ensureXDSServer(ctx, crd, serverKey)
+	require.NoError(t, err)
+	require.NotSame(t, previous, server)
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", port), server.address)
+	assert.Same(t, previous.cache, server.cache)
+
+	// An address the server cannot bind, the previous address keeps serving
+	crd.Spec.XdsBindAddress = "192.0.2.1"
+	_, err = r.ensureXDSServer(ctx, crd, serverKey)
+	require.Error(t, err)
+	serverManager.RLock()
+	restored := serverManager.servers[serverKey]
+	serverManager.RUnlock()
+	require.NotNil(t, restored)
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", port), restored.address)
+	assert.Same(t, previous.cache, restored.cache)
+	conn, err := net.Dial("tcp", restored.address)
+	require.NoError(t, err)
+	conn.Close()
+
+	// A unix domain socket, replacing a stale socket file
+	socket := filepath.Join(t.TempDir(), "xds.sock")
+	stale, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	crd.Spec.XdsBindAddress = "unix://" + socket
+	server, err = r.ensureXDSServer(ctx, crd, serverKey)
+	require.NoError(t, err)
+	assert.Equal(t, "unix://"+socket, r.advertisedAddress(server))
+
+	conn, err = net.Dial("unix", socket)
+	require.NoError(t, err)
+	conn.Close()
+
+	// The socket of another server is not replaced
+	other := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+		Spec:       api.XDSControlPlaneSpec{XdsBindAddress: "unix://" + socket},
+	}
+	_, err = r.ensureXDSServer(ctx, other, "default/other")
+	require.Error(t, err)
+	assert.True(t, isPermanent(err))
+	conn, err = net.Dial("unix", socket)
+	require.NoError(t, err)
+	conn.Close()
+
+	// Files other than sockets are never removed
+	file := filepath.Join(t.TempDir(), "xds.sock")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0o600))
+	other.Spec.XdsBindAddress = "unix://" + file
+	_, err = r.ensureXDSServer(ctx, other, "default/other")
+	require.Error(t, err)
+	assert.True(t, isPermanent(err))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
@@ -0,0 +1,398 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// xdsResource is an XDSListener, XDSCluster or XDSRouteConfig
+type xdsResource struct {
+	obj    client.Object
+	kind   string
+	ref    *api.ControlPlaneReference
+	status *api.XDSResourceStatus
+	// name is the name of the Envoy resource
+	name string
+	// outcome is the result of the attachment, nil if the resource does not
+	// attach to the control plane
+	outcome *api.AttachmentStatus
+}
+
+// listXDSResources returns the XDSListener, XDSCluster and XDSRouteConfig
+// resources of all namespaces, oldest first within each kind
+func (r *XDSControlPlaneReconciler) listXDSResources(ctx context.Context) ([]*xdsResource, error) {
+	var listeners api.XDSListenerList
+	if err := r.List(ctx, &listeners); err != nil {
+		return nil, fmt.Errorf("failed to list XDSListeners: %w", err)
+	}
+	var clusters api.XDSClusterList
+	if err := r.List(ctx, &clusters); err != nil {
+		return nil, fmt.Errorf("failed to list XDSClusters: %w", err)
+	}
+	var routes api.XDSRouteConfigList
+	if err := r.List(ctx, &routes); err != nil {
+		return nil, fmt.Errorf("failed to list XDSRouteConfigs: %w", err)
+	}
+
+	var resources []*xdsResource
+	for i := range listeners.Items {
+		l := &listeners.Items[i]
+		resources = append(resources, &xdsResource{obj: l, kind: "XDSListener", ref: l.Spec.ControlPlaneRef, status: &l.Status, name: l.Spec.Name})
+	}
+	for i := range clusters.Items {
+		c := &clusters.Items[i]
+		resources = append(resources, &xdsResource{obj: c, kind: "XDSCluster", ref: c.Spec.ControlPlaneRef, status: &c.Status, name: c.Spec.Name})
+	}
+	for i := range routes.Items {
+		rc := &routes.Items[i]
+		resources = append(resources, &xdsResource{obj: rc, kind: "XDSRouteConfig", ref: rc.Spec.ControlPlaneRef, status: &rc.Status, name: rc.Spec.Name})
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		a, b := resources[i].obj, resources[j].obj
+		if resources[i].kind != resources[j].kind {
+			return resources[i].kind < resources[j].kind
+		}
+		ta, tb := a.GetCreationTimestamp(), b.GetCreationTimestamp()
+		if !ta.Equal(&tb) {
+			return ta.Before(&tb)
+		}
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+	return resources, nil
+}
+
+// refKey returns the namespace/name of the control plane a resource refers to
+func (res *xdsResource) refKey() client.ObjectKey {
+	namespace := res.ref.Namespace
+	if namespace == "" {
+		namespace = res.obj.GetNamespace()
+	}
+	return client.ObjectKey{Namespace: namespace, Name: res.ref.Name}
+}
+
+// attaches reports whether a resource attaches to a control plane. A resource
+// with a controlPlaneRef only attaches to the referenced control plane, the
+// resource selector only matches resources of the control plane namespace.
+func attaches(crd *api.XDSControlPlane, selector labels.Selector, res *xdsResource) bool {
+	if res.ref != nil {
+		return res.refKey() == client.ObjectKeyFromObject(crd)
+	}
+	return res.obj.GetNamespace() == crd.Namespace &&
+		selector != nil && selector.Matches(labels.Set(res.obj.GetLabels()))
+}
+
+// namespaceAllower reports whether the resources of a namespace may attach to
+// a control plane, looking up the labels of each namespace once
+type namespaceAllower struct {
+	reader   client.Reader
+	crd      *api.XDSControlPlane
+	selector labels.Selector
+	allowed  map[string]bool
+}
+
+func newNamespaceAllower(c client.Reader, crd *api.XDSControlPlane) (*namespaceAllower, error) {
+	a := &namespaceAllower{
+		reader:  c,
+		crd:     crd,
+		allowed: map[string]bool{crd.Namespace: true},
+	}
+	if spec := crd.Spec.AllowedNamespaces; spec != nil && spec.From == "Selector" {
+		if spec.Selector == nil {
+			return nil, permanent(fmt.Errorf("allowedNamespaces.selector is required when from is Selector"))
+		}
+		selector, err := metav1.LabelSelectorAsSelector(spec.Selector)
+		if err != nil {
+			return nil, permanent(fmt.Errorf("invalid allowedNamespaces.selector: %w", err))
+		}
+		a.selector = selector
+	}
+	return a, nil
+}
+
+func (a *namespaceAllower) allows(ctx context.Context, namespace string) (bool, error) {
+	if allowed, ok := a.allowed[namespace]; ok {
+		return allowed, nil
+	}
+
+	allowed := false
+	switch {
+	case a.crd.Spec.AllowedNamespaces == nil:
+	case a.crd.Spec.AllowedNamespaces.From == "All":
+		allowed = true
+	case a.selector != nil:
+		ns := &corev1.Namespace{}
+		err := a.reader.Get(ctx, client.ObjectKey{Name: namespace}, ns)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+		}
+		allowed = err == nil && a.selector.Matches(labels.Set(ns.Labels))
+	}
+	a.allowed[namespace] = allowed
+	return allowed, nil
+}
+
+// resolveAttachments merges the resources attached to a control plane into its
+// spec. A resource whose name is already defined by the control plane or by an
+// older attached resource of the same kind is rejected as conflicted, as is a
+// listener on an address already in use. Listeners may only terminate TLS with
+// the Secrets of the namespace defining them, unless the Secrets are granted to
+// the control plane: an inline listener referencing another Secret fails the
+// merge, an attached one is rejected as not allowed.
+func (r *XDSControlPlaneReconciler) resolveAttachments(ctx context.Context, crd *api.XDSControlPlane) ([]*xdsResource, error) {
+	var selector labels.Selector
+	if crd.Spec.ResourceSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(crd.Spec.ResourceSelector)
+		if err != nil {
+			return nil, permanent(fmt.Errorf("invalid resourceSelector: %w", err))
+		}
+		selector = s
+	}
+
+	allower, err := newNamespaceAllower(r.Client, crd)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := r.listXDSResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inline listeners are defined in the control plane's namespace, like the
+	// Secrets they may terminate TLS with
+	for _, l := range crd.Spec.Listeners {
+		secret, disallowed, err := r.disallowedTLSSecret(ctx, crd, withTLSSecretNamespace(l, crd.Namespace), crd.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if disallowed {
+			return nil, permanent(fmt.Errorf("listener %s: TLS secret %s is not in namespace %s", l.Name, secret, crd.Namespace))
+		}
+	}
+
+	// Owners of the names and listener addresses, the inline resources first
+	owners := map[string]string{}
+	for _, l := range crd.Spec.Listeners {
+		owners["XDSListener/"+l.Name] = "the control plane"
+		owners[listenerAddressKey(l)] = "listener " + l.Name
+	}
+	for _, c := range crd.Spec.Clusters {
+		owners["XDSCluster/"+c.Name] = "the control plane"
+	}
+	for _, rc := range crd.Spec.Routes {
+		owners["XDSRouteConfig/"+rc.Name] = "the control plane"
+	}
+
+	for _, res := range resources {
+		if !attaches(crd, selector, res) {
+			continue
+		}
+		owner := fmt.Sprintf("%s %s", res.kind, res.obj.GetName())
+		if res.obj.GetNamespace() != crd.Namespace {
+			owner = fmt.Sprintf("%s %s", res.kind, client.ObjectKeyFromObject(res.obj))
+		}
+
+		res.outcome = &api.AttachmentStatus{
+			ControlPlane:       client.ObjectKeyFromObject(crd).String(),
+			ObservedGeneration: res.obj.GetGeneration(),
+		}
+		allowed, err := allower.allows(ctx, res.obj.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			res.outcome.Accepted = metav1.ConditionFalse
+			res.outcome.Reason = ReasonNotAllowed
+			res.outcome.Message = fmt.Sprintf("Namespace %s is not allowed by spec.allowedNamespaces of the control plane", res.obj.GetNamespace())
+			continue
+		}
+		nameKey := res.kind + "/" + res.name
+		if existing, ok := owners[nameKey]; ok {
+			res.outcome.Accepted = metav1.ConditionFalse
+			res.outcome.Reason = ReasonConflicted
+			res.outcome.Message = fmt.Sprintf("Name %s is already defined by %s", res.name, existing)
+			continue
+		}
+
+		switch obj := res.obj.(type) {
+		case *api.XDSListener:
+			addressKey := listenerAddressKey(obj.Spec.ListenerSpec)
+			if existing, ok := owners[addressKey]; ok {
+				res.outcome.Accepted = metav1.ConditionFalse
+				res.outcome.Reason = ReasonConflicted
+				res.outcome.Message = fmt.Sprintf("Address %s:%d is already used by %s", obj.Spec.Address, obj.Spec.Port, existing)
+				continue
+			}
+			listenerSpec := withTLSSecretNamespace(obj.Spec.ListenerSpec, obj.Namespace)
+			secret, disallowed, err := r.disallowedTLSSecret(ctx, crd, listenerSpec, obj.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			if disallowed {
+				res.outcome.Accepted = metav1.ConditionFalse
+				res.outcome.Reason = ReasonNotAllowed
+				res.outcome.Message = fmt.Sprintf("TLS secret %s is not in namespace %s", secret, obj.Namespace)
+				continue
+			}
+			owners[addressKey] = owner
+			crd.Spec.Listeners = append(crd.Spec.Listeners, listenerSpec)
+		case *api.XDSCluster:
+			crd.Spec.Clusters = append(crd.Spec.Clusters, obj.Spec.ClusterSpec)
+		case *api.XDSRouteConfig:
+			crd.Spec.Routes = append(crd.Spec.Routes, obj.Spec.RouteConfigSpec)
+		}
+		owners[nameKey] = owner
+		res.outcome.Accepted = metav1.ConditionTrue
+		res.outcome.Reason = ReasonAccepted
+		res.outcome.Message = "Served by the control plane"
+	}
+
+	ctrlLog.FromContext(ctx).V(1).Info("Resolved attached resources", "xdscontrolplane", crd.Name,
+		"listeners", len(crd.Spec.Listeners), "clusters", len(crd.Spec.Clusters), "routes", len(crd.Spec.Routes))
+	return resources, nil
+}
+
+// listenerAddressKey identifies the socket of a listener, TCP and UDP
+// listeners may share a port. Internal listeners have no socket.
+func listenerAddressKey(l api.ListenerSpec) string {
+	if l.Internal != nil {
+		return "internal/" + l.Name
+	}
+	return fmt.Sprintf("address/%s:%d/%s", l.Address, l.Port, listenerProtocol(l))
+}
+
+// updateAttachmentStatus reports the outcome of the attachment to the control
+// plane in the status of the resources, and removes the control plane from the
+// status of resources no longer attached
+func (r *XDSControlPlaneReconciler) updateAttachmentStatus(ctx context.Context, crd *api.XDSControlPlane, resources []*xdsResource) error {
+	key := client.ObjectKeyFromObject(crd).String()
+
+	for _, res := range resources {
+		base := res.obj.DeepCopyObject().(client.Object)
+		before := res.status.DeepCopy()
+
+		var statuses []api.AttachmentStatus
+		for _, s := range res.status.ControlPlanes {
+			if s.ControlPlane != key {
+				statuses = append(statuses, s)
+			}
+		}
+		if res.outcome != nil {
+			statuses = append(statuses, *res.outcome)
+			sort.Slice(statuses, func(i, j int) bool { return statuses[i].ControlPlane < statuses[j].ControlPlane })
+		}
+		res.status.ControlPlanes = statuses
+
+		if equality.Semantic.DeepEqual(before, res.status) {
+			continue
+		}
+		if err := r.Status().Patch(ctx, res.obj, client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{})); err != nil {
+			return fmt.Errorf("failed to update status of %s %s: %w", res.kind, res.obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// controlPlanesForResource returns the control planes a resource may attach
+// to: the referenced control plane, the control planes of its namespace with
+// a resource selector, so that they release resources whose labels no longer
+// match, and the control planes listed in its status.
+func (r *XDSControlPlaneReconciler) controlPlanesForResource(ctx context.Context, obj client.Object) []reconcile.Request {
+	var list api.XDSControlPlaneList
+	if err := r.List(ctx, &list, client.InNamespace(obj.GetNamespace())); err != nil {
+		ctrlLog.FromContext(ctx).Error(err, "failed to list XDSControlPlanes for resource", "resource", obj.GetName())
+		return nil
+	}
+
+	res := &xdsResource{obj: obj}
+	var status api.XDSResourceStatus
+	switch o := obj.(type) {
+	case *api.XDSListener:
+		res.ref, status = o.Spec.ControlPlaneRef, o.Status
+	case *api.XDSCluster:
+		res.ref, status = o.Spec.ControlPlaneRef, o.Status
+	case *api.XDSRouteConfig:
+		res.ref, status = o.Spec.ControlPlaneRef, o.Status
+	}
+
+	seen := make(map[client.ObjectKey]bool)
+	var requests []reconcile.Request
+	enqueue := func(key client.ObjectKey) {
+		if !seen[key] {
+			seen[key] = true
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	if res.ref != nil {
+		enqueue(res.refKey())
+	}
+	for _, crd := range list.Items {
+		if crd.Spec.ResourceSelector != nil {
+			enqueue(client.ObjectKeyFromObject(&crd))
+		}
+	}
+	// The control planes the resource is attached to, which release it when
+	// its reference changes or it is deleted
+	for _, outcome := range status.ControlPlanes {
+		if namespace, name, ok := strings.Cut(outcome.ControlPlane, "/"); ok {
+			enqueue(client.ObjectKey{Namespace: namespace, Name: name})
+		}
+	}
+	return requests
+}
+
+// controlPlanesForNamespace returns the control planes allowing namespaces by
+// selector when the labels of a namespace change
+func (r *XDSControlPlaneReconciler) controlPlanesForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	var list api.XDSControlPlaneList
+	if err := r.List(ctx, &list); err != nil {
+		ctrlLog.FromContext(ctx).Error(err, "failed to list XDSControlPlanes for namespace", "namespace", obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, crd := range list.Items {
+		if crd.Spec.AllowedNamespaces != nil && crd.Spec.AllowedNamespaces.From == "Selector" && crd.Namespace != obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&crd)})
+		}
+	}
+	return requests
+}
+
+// attachedResourceChanged filters the events of XDSListener, XDSCluster and
+// XDSRouteConfig resources to spec and label changes, so that the status
+// updates of the control planes do not trigger reconciles
+func attachedResourceChanged() predicate.Predicate {
+	return predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{})
+}
+
+// releaseAttachments removes a deleted control plane from the status of its resources
+func (r *XDSControlPlaneReconciler) releaseAttachments(ctx context.Context, crd *api.XDSControlPlane) error {
+	resources, err := r.listXDSResources(ctx)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	return r.updateAttachmentStatus(ctx, crd, resources)
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestResolveAttachments(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+
+	created := func(minutes int) metav1.Time {
+		return metav1.NewTime(time.Date(2024, 1, 1, 0, minutes, 0, 0, time.UTC))
+	}
+	ref := &api.ControlPlaneReference{Name: "edge"}
+
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+		Spec: api.XDSControlPlaneSpec{
+			Listeners: []api.ListenerSpec{{Name: "http", Address: "0.0.0.0", Port: 8080}},
+			Clusters:  []api.ClusterSpec{{Name: "inline"}},
+			ResourceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"gateway": "edge"},
+			},
+		},
+	}
+	objs := []client.Object{
+		crd,
+		// Attached by reference
+		&api.XDSListener{
+			ObjectMeta: metav1.ObjectMeta{Name: "https", Namespace: "default", CreationTimestamp: created(1)},
+			Spec: api.XDSListenerSpec{
+				AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: ref},
+				ListenerSpec:   api.ListenerSpec{Name: "https", Address: "0.0.0.0", Port: 8443},
+			},
+		},
+		// Same address as the inline listener
+		&api.XDSListener{
+			ObjectMeta: metav1.ObjectMeta{Name: "http-alt", Namespace: "default", CreationTimestamp: created(2)},
+			Spec: api.XDSListenerSpec{
+				AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: ref},
+				ListenerSpec:   api.ListenerSpec{Name: "http-alt", Address: "0.0.0.0", Port: 8080},
+			},
+		},
+		// Attached by selector, the older one wins the name
+		&api.XDSCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "default", CreationTimestamp: created(1),
+				Labels: map[string]string{"gateway": "edge"}},
+			Spec: api.XDSClusterSpec{ClusterSpec: api.ClusterSpec{Name: "backend"}},
+		},
+		&api.XDSCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "backend-v2", Namespace: "default", CreationTimestamp: created(2),
+				Labels: map[string]string{"gateway": "edge"}},
+			Spec: api.XDSClusterSpec{ClusterSpec: api.ClusterSpec{Name: "backend"}},
+		},
+		// Same name as the inline cluster
+		&api.XDSCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "inline", Namespace: "default", CreationTimestamp: created(1)},
+			Spec: api.XDSClusterSpec{
+				AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: ref},
+				ClusterSpec:    api.ClusterSpec{Name: "inline"},
+			},
+		},
+		// A reference to another control plane takes precedence over the selector
+		&api.XDSCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", CreationTimestamp: created(1),
+				Labels: map[string]string{"gateway": "edge"}},
+			Spec: api.XDSClusterSpec{
+				AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: &api.ControlPlaneReference{Name: "other"}},
+				ClusterSpec:    api.ClusterSpec{Name: "other"},
+			},
+		},
+		&api.XDSRouteConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "routes", Namespace: "default", CreationTimestamp: created(1)},
+			Spec: api.XDSRouteConfigSpec{
+				AttachmentSpec:  api.AttachmentSpec{ControlPlaneRef: ref},
+				RouteConfigSpec: api.RouteConfigSpec{Name: "routes"},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithStatusSubresource(&api.XDSListener{}, &api.XDSCluster{}, &api.XDSRouteConfig{}).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	resolved := crd.DeepCopy()
+	resources, err := r.resolveAttachments(ctx, resolved)
+	require.NoError(t, err)
+	require.NoError(t, r.updateAttachmentStatus(ctx, resolved, resources))
+
+	// Attached resources are appended to the inline ones
+	require.Len(t, resolved.Spec.Listeners, 2)
+	assert.Equal(t, "https", resolved.Spec.Listeners[1].Name)
+	require.Len(t, resolved.Spec.Clusters, 2)
+	assert.Equal(t, "backend", resolved.Spec.Clusters[1].Name)
+	require.Len(t, resolved.Spec.Routes, 1)
+
+	status := func(obj client.Object) []api.AttachmentStatus {
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(obj), obj))
+		switch o := obj.(type) {
+		case *api.XDSListener:
+			return o.Status.ControlPlanes
+		case *api.XDSCluster:
+			return o.Status.ControlPlanes
+		case *api.XDSRouteConfig:
+			return o.Status.ControlPlanes
+		}
+		return nil
+	}
+	listener := func(name string) client.Object {
+		return &api.XDSListener{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	}
+	cluster := func(name string) client.Object {
+		return &api.XDSCluster{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	}
+
+	accepted := status(listener("https"))
+	require.Len(t, accepted, 1)
+	assert.Equal(t, "default/edge", accepted[0].ControlPlane)
+	assert.Equal(t, metav1.ConditionTrue, accepted[0].Accepted)
+	assert.Equal(t, ReasonAccepted, accepted[0].Reason)
+
+	for name, obj := range map[string]client.Object{
+		"http-alt":   listener("http-alt"),
+		"backend-v2": cluster("backend-v2"),
+		"inline":     cluster("inline"),
+	} {
+		conflicted := status(obj)
+		require.Len(t, conflicted, 1, name)
+		assert.Equal(t, metav1.ConditionFalse, conflicted[0].Accepted, name)
+		assert.Equal(t, ReasonConflicted, conflicted[0].Reason, name)
+	}
+	assert.Empty(t, status(cluster("other")))
+
+	// Resources no longer selected are released
+	resolved = crd.DeepCopy()
+	resolved.Spec.ResourceSelector = nil
+	resources, err = r.resolveAttachments(ctx, resolved)
+	require.NoError(t, err)
+	require.NoError(t, r.updateAttachmentStatus(ctx, resolved, resources))
+	assert.Len(t, resolved.Spec.Clusters, 1)
+	assert.Empty(t, status(cluster("backend")))
+	assert.Len(t, status(listener("https")), 1)
+
+	// Deleting the control plane releases all its resources
+	require.NoError(t, r.releaseAttachments(ctx, crd))
+	assert.Empty(t, status(listener("https")))
+}
+
+func TestControlPlanesForResource(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"}},
+		&api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "default"}},
+		&api.XDSControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "selecting", Namespace: "default"},
+			Spec:       api.XDSControlPlaneSpec{ResourceSelector: &metav1.LabelSelector{}},
+		},
+		&api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "other"}},
+	).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	requests := r.controlPlanesForResource(context.Background(), &api.XDSRouteConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "routes", Namespace: "default"},
+		Spec: api.XDSRouteConfigSpec{
+			AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: &api.ControlPlaneReference{Name: "edge"}},
+		},
+	})
+	var names []string
+	for _, req := range requests {
+		names = append(names, req.String())
+	}
+	assert.ElementsMatch(t, []string{"default/edge", "default/selecting"}, names)
+
+	// A resource moved to another control plane releases the previous one
+	requests = r.controlPlanesForResource(context.Background(), &api.XDSListener{
+		ObjectMeta: metav1.ObjectMeta{Name: "https", Namespace: "other"},
+		Spec: api.XDSListenerSpec{
+			AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: &api.ControlPlaneReference{Name: "edge"}},
+		},
+		Status: api.XDSResourceStatus{ControlPlanes: []api.AttachmentStatus{
+			{ControlPlane: "default/internal"},
+			{ControlPlane: "other/edge"},
+		}},
+	})
+	names = nil
+	for _, req := range requests {
+		names = append(names, req.String())
+	}
+	assert.ElementsMatch(t, []string{"other/edge", "default/internal"}, names)
+}
+
+func TestAllowedNamespaces(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "platform"}}
+	ref := &api.ControlPlaneReference{Name: "edge", Namespace: "platform"}
+	teamCluster := func(namespace string) *api.XDSCluster {
+		return &api.XDSCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: namespace},
+			Spec: api.XDSClusterSpec{
+				AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: ref},
+				ClusterSpec:    api.ClusterSpec{Name: namespace + "-backend"},
+			},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		crd,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"xds-tenant": "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+		teamCluster("team-a"),
+		teamCluster("team-b"),
+	).WithStatusSubresource(&api.XDSCluster{}).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	resolve := func(allowed *api.AllowedNamespacesSpec) map[string]string {
+		resolved := crd.DeepCopy()
+		resolved.Spec.AllowedNamespaces = allowed
+		resources, err := r.resolveAttachments(ctx, resolved)
+		require.NoError(t, err)
+		require.NoError(t, r.updateAttachmentStatus(ctx, resolved, resources))
+
+		reasons := make(map[string]string)
+		for _, namespace := range []string{"team-a", "team-b"} {
+			cluster := &api.XDSCluster{}
+			require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "backend"}, cluster))
+			require.Len(t, cluster.Status.ControlPlanes, 1)
+			assert.Equal(t, "platform/edge", cluster.Status.ControlPlanes[0].ControlPlane)
+			reasons[namespace] = cluster.Status.ControlPlanes[0].Reason
+		}
+		return reasons
+	}
+
+	// Only the namespace of the control plane by default
+	assert.Equal(t, map[string]string{"team-a": ReasonNotAllowed, "team-b": ReasonNotAllowed}, resolve(nil))
+
+	assert.Equal(t, map[string]string{"team-a": ReasonAccepted, "team-b": ReasonNotAllowed}, resolve(&api.AllowedNamespacesSpec{
+		From:     "Selector",
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"xds-tenant": "true"}},
+	}))
+
+	assert.Equal(t, map[string]string{"team-a": ReasonAccepted, "team-b": ReasonAccepted}, resolve(&api.AllowedNamespacesSpec{From: "All"}))
+
+	_, err := r.resolveAttachments(ctx, &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "platform"},
+		Spec:       api.XDSControlPlaneSpec{AllowedNamespaces: &api.AllowedNamespacesSpec{From: "Selector"}},
+	})
+	assert.True(t, isPermanent(err))
+
+	// The referenced control plane is reconciled on changes in other namespaces
+	requests := r.controlPlanesForResource(ctx, teamCluster("team-a"))
+	require.Len(t, requests, 1)
+	assert.Equal(t, "platform/edge", requests[0].String())
+}
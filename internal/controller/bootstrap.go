@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	internal_listener "github.com/envoyproxy/go-control-plane/envoy/extensions/bootstrap/internal_listener/v3"
+	upstreamhttp "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/yaml"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	// BootstrapLabel is set on the ConfigMaps holding Envoy bootstraps
+	BootstrapLabel = "xds.okassov/bootstrap"
+	// NodeIDAnnotation holds the node ID of a bootstrap ConfigMap
+	NodeIDAnnotation = "xds.okassov/node-id"
+
+	bootstrapDataKey = "envoy.yaml"
+	xdsClusterName   = "xds_cluster"
+
+	defaultAdminAddress = "127.0.0.1"
+	defaultAdminPort    = 9901
+)
+
+// bootstrapConfigMapName returns the name of the bootstrap ConfigMap of a node.
+// Node IDs that are not valid in a name are sanitized and suffixed with a hash
+// of the ID, so that different IDs never share a ConfigMap.
+func bootstrapConfigMapName(crd *api.XDSControlPlane, nodeID string) string {
+	sanitized := sanitizeName(nodeID)
+
+	name := fmt.Sprintf("%s-bootstrap-%s", crd.Name, sanitized)
+	if sanitized == nodeID && len(name) <= 253 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(nodeID))
+	if len(name) > 244 {
+		name = strings.TrimRight(name[:244], "-.")
+	}
+	return fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:4]))
+}
+
+// sanitizeName replaces the characters that are not valid in a name with dashes
+func sanitizeName(s string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(s)), "-.")
+}
+
+func bootstrapCluster(crd *api.XDSControlPlane) string {
+	switch {
+	case crd.Spec.Bootstrap != nil && crd.Spec.Bootstrap.Cluster != "":
+		return crd.Spec.Bootstrap.Cluster
+	case len(crd.Spec.NodeClusters) > 0:
+		return crd.Spec.NodeClusters[0]
+	}
+	return crd.Name
+}
+
+// xdsClusterAddress returns the address of the xds_cluster of a bootstrap and
+// the discovery type it needs
+func xdsClusterAddress(xdsAddress string) (*core.Address, cluster.Cluster_DiscoveryType, error) {
+	if path, ok := strings.CutPrefix(xdsAddress, unixScheme); ok {
+		return &core.Address{Address: &core.Address_Pipe{Pipe: &core.Pipe{Path: path}}}, cluster.Cluster_STATIC, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(xdsAddress)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid xDS address %q: %w", xdsAddress, err)
+	}
+	if host == "" {
+		return nil, 0, fmt.Errorf("xDS address %q has no host, set spec.bootstrap.xdsAddress", xdsAddress)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid xDS port %q: %w", portStr, err)
+	}
+
+	discoveryType := cluster.Cluster_STRICT_DNS
+	if net.ParseIP(host) != nil {
+		discoveryType = cluster.Cluster_STATIC
+	}
+	return socketAddress(host, uint32(port)), discoveryType, nil
+}
+
+func socketAddress(host string, port uint32) *core.Address {
+	return &core.Address{Address: &core.Address_SocketAddress{SocketAddress: &core.SocketAddress{
+		Protocol:      core.SocketAddress_TCP,
+		Address:       host,
+		PortSpecifier: &core.SocketAddress_PortValue{PortValue: port},
+	}}}
+}
+
+// renderBootstrap renders the Envoy bootstrap of a node connecting to the
+// control plane at xdsAddress over ADS
+func renderBootstrap(crd *api.XDSControlPlane, nodeID, xdsAddress string) ([]byte, error) {
+	address, discoveryType, err := xdsClusterAddress(xdsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	adminAddress, adminPort := defaultAdminAddress, uint32(defaultAdminPort)
+	if spec := crd.Spec.Bootstrap; spec != nil {
+		if spec.AdminAddress != "" {
+			adminAddress = spec.AdminAddress
+		}
+		if spec.AdminPort != 0 {
+			adminPort = uint32(spec.AdminPort)
+		}
+	}
+
+	// The xDS server speaks gRPC, which requires HTTP/2 to the xds_cluster
+	protocolOptions, err := anypb.New(&upstreamhttp.HttpProtocolOptions{
+		UpstreamProtocolOptions: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+					Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ads := &core.ConfigSource{
+		ResourceApiVersion:    core.ApiVersion_V3,
+		ConfigSourceSpecifier: &core.ConfigSource_Ads{Ads: &core.AggregatedConfigSource{}},
+	}
+
+	config := &bootstrap.Bootstrap{
+		Node: &core.Node{
+			Id:      nodeID,
+			Cluster: bootstrapCluster(crd),
+		},
+		Admin: &bootstrap.Admin{Address: socketAddress(adminAddress, adminPort)},
+		DynamicResources: &bootstrap.Bootstrap_DynamicResources{
+			AdsConfig: &core.ApiConfigSource{
+				ApiType:             core.ApiConfigSource_GRPC,
+				TransportApiVersion: core.ApiVersion_V3,
+				GrpcServices: []*core.GrpcService{{
+					TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: xdsClusterName},
+					},
+				}},
+				SetNodeOnFirstMessageOnly: true,
+			},
+			CdsConfig: ads,
+			LdsConfig: ads,
+		},
+		StaticResources: &bootstrap.Bootstrap_StaticResources{
+			Clusters: []*cluster.Cluster{{
+				Name:                 xdsClusterName,
+				ConnectTimeout:       durationpb.New(5 * time.Second),
+				ClusterDiscoveryType: &cluster.Cluster_Type{Type: discoveryType},
+				TypedExtensionProtocolOptions: map[string]*anypb.Any{
+					"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": protocolOptions,
+				},
+				UpstreamConnectionOptions: &cluster.UpstreamConnectionOptions{
+					TcpKeepalive: &core.TcpKeepalive{
+						KeepaliveProbes:   wrapperspb.UInt32(3),
+						KeepaliveTime:     wrapperspb.UInt32(10),
+						KeepaliveInterval: wrapperspb.UInt32(5),
+					},
+				},
+				LoadAssignment: &endpoint.ClusterLoadAssignment{
+					ClusterName: xdsClusterName,
+					Endpoints: []*endpoint.LocalityLbEndpoints{{
+						LbEndpoints: []*endpoint.LbEndpoint{{
+							HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+								Endpoint: &endpoint.Endpoint{Address: address},
+							},
+						}},
+					}},
+				},
+			}},
+		},
+	}
+
+	// Internal listeners are served by the internal_listener extension
+	if ok, bufferSizeKb := internalListenerBufferSizeKb(crd); ok {
+		internalListener := &internal_listener.InternalListener{}
+		if bufferSizeKb > 0 {
+			internalListener.BufferSizeKb = wrapperspb.UInt32(uint32(bufferSizeKb))
+		}
+		typedConfig, err := anypb.New(internalListener)
+		if err != nil {
+			return nil, err
+		}
+		config.BootstrapExtensions = []*core.TypedExtensionConfig{{
+			Name:        "envoy.bootstrap.internal_listener",
+			TypedConfig: typedConfig,
+		}}
+	}
+
+	if err := config.ValidateAll(); err != nil {
+		return nil, fmt.Errorf("invalid bootstrap: %w", err)
+	}
+
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bootstrap: %w", err)
+	}
+	return yaml.JSONToYAML(data)
+}
+
+// reconcileBootstraps writes an Envoy bootstrap ConfigMap owned by the CR for
+// each node ID of the spec, and removes the bootstraps of node IDs no longer
+// configured. Node IDs given as patterns have no bootstrap.
+func (r *XDSControlPlaneReconciler) reconcileBootstraps(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance) error {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	desired := make(map[string]bool)
+	if crd.Spec.Bootstrap != nil {
+		matcher, err := newNodeMatcher(crd.Spec)
+		if err != nil {
+			return err
+		}
+
+		xdsAddress := crd.Spec.Bootstrap.XdsAddress
+		if xdsAddress == "" {
+			xdsAddress = r.advertisedAddress(server)
+		}
+
+		for _, nodeID := range matcher.staticIDs() {
+			data, err := renderBootstrap(crd, nodeID, xdsAddress)
+			if err != nil {
+				return fmt.Errorf("failed to render bootstrap of node %s: %w", nodeID, err)
+			}
+
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      bootstrapConfigMapName(crd, nodeID),
+				Namespace: crd.Namespace,
+			}}
+			_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+				cm.Labels = map[string]string{
+					ControlPlaneLabel:              crd.Name,
+					BootstrapLabel:                 "true",
+					"app.kubernetes.io/managed-by": "xds-cp-operator",
+				}
+				cm.Annotations = map[string]string{NodeIDAnnotation: nodeID}
+				cm.Data = map[string]string{bootstrapDataKey: string(data)}
+				return controllerutil.SetControllerReference(crd, cm, r.Scheme)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to write bootstrap ConfigMap: %w", err)
+			}
+			desired[cm.Name] = true
+		}
+	}
+
+	existing := &corev1.ConfigMapList{}
+	if err := r.List(ctx, existing, client.InNamespace(crd.Namespace),
+		client.MatchingLabels{ControlPlaneLabel: crd.Name, BootstrapLabel: "true"}); err != nil {
+		return fmt.Errorf("failed to list bootstrap ConfigMaps: %w", err)
+	}
+	for i := range existing.Items {
+		cm := &existing.Items[i]
+		if desired[cm.Name] {
+			continue
+		}
+		if err := r.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete bootstrap ConfigMap: %w", err)
+		}
+		log.Info("Removed bootstrap ConfigMap", "configMap", cm.Name, "nodeID", cm.Annotations[NodeIDAnnotation])
+	}
+	return nil
+}
+
+// bootstrapConfigMapChanged filters owned ConfigMaps down to the bootstraps of
+// nodes and of the data plane whose data changed, so that writing the
+// persisted snapshot and revisions does not trigger reconciles
+func bootstrapConfigMapChanged() predicate.Predicate {
+	isBootstrap := func(obj client.Object) bool {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return false
+		}
+		_, ok = cm.Data[bootstrapDataKey]
+		return ok || cm.Labels[BootstrapLabel] == "true" || cm.Labels["app.kubernetes.io/component"] == "envoy"
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return isBootstrap(e.Object) },
+		DeleteFunc: func(e event.DeleteEvent) bool { return isBootstrap(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldCM, ok := e.ObjectOld.(*corev1.ConfigMap)
+			if !ok || !(isBootstrap(oldCM) || isBootstrap(e.ObjectNew)) {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(oldCM.Data, e.ObjectNew.(*corev1.ConfigMap).Data)
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
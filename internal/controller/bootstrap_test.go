@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	internal_listener "github.com/envoyproxy/go-control-plane/envoy/extensions/bootstrap/internal_listener/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func parseBootstrap(t *testing.T, data []byte) *bootstrap.Bootstrap {
+	t.Helper()
+	raw, err := yaml.YAMLToJSON(data)
+	require.NoError(t, err)
+	config := &bootstrap.Bootstrap{}
+	require.NoError(t, protojson.Unmarshal(raw, config))
+	require.NoError(t, config.ValidateAll())
+	return config
+}
+
+func TestRenderBootstrap(t *testing.T) {
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+		Spec:       api.XDSControlPlaneSpec{NodeClusters: []string{"edge"}},
+	}
+
+	t.Run("Service host", func(t *testing.T) {
+		data, err := renderBootstrap(crd, "envoy-1", "xds.system.svc:18000")
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "set_node_on_first_message_only: true")
+
+		config := parseBootstrap(t, data)
+		assert.Equal(t, "envoy-1", config.Node.Id)
+		assert.Equal(t, "edge", config.Node.Cluster)
+		assert.Equal(t, uint32(defaultAdminPort), config.Admin.Address.GetSocketAddress().GetPortValue())
+		assert.Equal(t, xdsClusterName, config.DynamicResources.AdsConfig.GrpcServices[0].GetEnvoyGrpc().ClusterName)
+		assert.NotNil(t, config.DynamicResources.CdsConfig.GetAds())
+		assert.NotNil(t, config.DynamicResources.LdsConfig.GetAds())
+
+		xds := config.StaticResources.Clusters[0]
+		assert.Equal(t, cluster.Cluster_STRICT_DNS, xds.GetType())
+		address := xds.LoadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint().Address.GetSocketAddress()
+		assert.Equal(t, "xds.system.svc", address.Address)
+		assert.Equal(t, uint32(18000), address.GetPortValue())
+	})
+
+	t.Run("Pod IP", func(t *testing.T) {
+		data, err := renderBootstrap(crd, "envoy-1", "[fd00::3]:18000")
+		require.NoError(t, err)
+		xds := parseBootstrap(t, data).StaticResources.Clusters[0]
+		assert.Equal(t, cluster.Cluster_STATIC, xds.GetType())
+		assert.Equal(t, "fd00::3", xds.LoadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint().Address.GetSocketAddress().Address)
+	})
+
+	t.Run("Unix socket", func(t *testing.T) {
+		data, err := renderBootstrap(crd, "envoy-1", "unix:///var/run/xds.sock")
+		require.NoError(t, err)
+		xds := parseBootstrap(t, data).StaticResources.Clusters[0]
+		assert.Equal(t, "/var/run/xds.sock", xds.LoadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint().Address.GetPipe().Path)
+	})
+
+	t.Run("Admin listener", func(t *testing.T) {
+		withAdmin := crd.DeepCopy()
+		withAdmin.Spec.Bootstrap = &api.BootstrapSpec{Cluster: "dc1", AdminAddress: "0.0.0.0", AdminPort: 10000}
+		data, err := renderBootstrap(withAdmin, "envoy-1", "10.0.0.1:18000")
+		require.NoError(t, err)
+		config := parseBootstrap(t, data)
+		assert.Equal(t, "dc1", config.Node.Cluster)
+		assert.Equal(t, "0.0.0.0", config.Admin.Address.GetSocketAddress().Address)
+		assert.Equal(t, uint32(10000), config.Admin.Address.GetSocketAddress().GetPortValue())
+	})
+
+	t.Run("Internal listeners", func(t *testing.T) {
+		data, err := renderBootstrap(crd, "envoy-1", "10.0.0.1:18000")
+		require.NoError(t, err)
+		assert.Empty(t, parseBootstrap(t, data).BootstrapExtensions)
+
+		withInternal := crd.DeepCopy()
+		withInternal.Spec.Listeners = []api.ListenerSpec{
+			{Name: "a", Internal: &api.InternalListenerSpec{BufferSizeKb: ptr.To(int32(64))}},
+			{Name: "b", Internal: &api.InternalListenerSpec{BufferSizeKb: ptr.To(int32(256))}},
+		}
+		data, err = renderBootstrap(withInternal, "envoy-1", "10.0.0.1:18000")
+		require.NoError(t, err)
+		extensions := parseBootstrap(t, data).BootstrapExtensions
+		require.Len(t, extensions, 1)
+		assert.Equal(t, "envoy.bootstrap.internal_listener", extensions[0].Name)
+		var internalListener internal_listener.InternalListener
+		require.NoError(t, extensions[0].TypedConfig.UnmarshalTo(&internalListener))
+		assert.Equal(t, uint32(256), internalListener.BufferSizeKb.GetValue())
+	})
+
+	t.Run("Address without host", func(t *testing.T) {
+		_, err := renderBootstrap(crd, "envoy-1", ":18000")
+		assert.Error(t, err)
+	})
+}
+
+func TestBootstrapConfigMapName(t *testing.T) {
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "edge"}}
+
+	assert.Equal(t, "edge-bootstrap-envoy-1", bootstrapConfigMapName(crd, "envoy-1"))
+
+	upper, underscore := bootstrapConfigMapName(crd, "Envoy_1"), bootstrapConfigMapName(crd, "envoy_1")
+	assert.True(t, strings.HasPrefix(upper, "edge-bootstrap-envoy-1-"))
+	assert.NotEqual(t, upper, underscore)
+
+	long := bootstrapConfigMapName(crd, strings.Repeat("a", 300))
+	assert.LessOrEqual(t, len(long), 253)
+}
+
+func TestReconcileBootstraps(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default", UID: "uid-1"},
+		Spec: api.XDSControlPlaneSpec{
+			NodeIDs:   []string{"envoy-1", "envoy-2", "edge-*"},
+			Bootstrap: &api.BootstrapSpec{},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme, ServiceHost: "xds.system.svc"}
+	server := &XDSServerInstance{network: "tcp", address: ":18000"}
+
+	bootstraps := func() map[string]string {
+		list := &corev1.ConfigMapList{}
+		require.NoError(t, c.List(ctx, list, client.MatchingLabels{BootstrapLabel: "true"}))
+		out := make(map[string]string)
+		for _, cm := range list.Items {
+			out[cm.Annotations[NodeIDAnnotation]] = cm.Data[bootstrapDataKey]
+		}
+		return out
+	}
+
+	require.NoError(t, r.reconcileBootstraps(ctx, crd, server))
+	got := bootstraps()
+	require.Len(t, got, 2)
+	config := parseBootstrap(t, []byte(got["envoy-2"]))
+	assert.Equal(t, "envoy-2", config.Node.Id)
+	assert.Equal(t, "xds.system.svc", config.StaticResources.Clusters[0].LoadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint().Address.GetSocketAddress().Address)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "edge-bootstrap-envoy-1"}, cm))
+	require.Len(t, cm.OwnerReferences, 1)
+	assert.Equal(t, "edge", cm.OwnerReferences[0].Name)
+
+	// Removed node IDs lose their bootstrap
+	crd.Spec.NodeIDs = []string{"envoy-1"}
+	crd.Spec.Bootstrap.XdsAddress = "10.0.0.1:18000"
+	require.NoError(t, r.reconcileBootstraps(ctx, crd, server))
+	got = bootstraps()
+	require.Len(t, got, 1)
+	assert.Contains(t, got["envoy-1"], "10.0.0.1")
+
+	crd.Spec.Bootstrap = nil
+	require.NoError(t, r.reconcileBootstraps(ctx, crd, server))
+	assert.Empty(t, bootstraps())
+}
@@ -0,0 +1,332 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// xdsCallbacks implements the go-control-plane server callbacks for a single
+// xDS server instance and keeps track of the node behind every open stream
+type xdsCallbacks struct {
+	server *XDSServerInstance
+
+	mu      sync.Mutex
+	streams map[int64]*streamState
+	// deltaStreams holds the state of every open delta stream, whose IDs are
+	// counted separately from the state of the world streams
+	deltaStreams map[int64]*deltaStreamState
+}
+
+// deltaStreamState is the state of an open delta xDS stream. Delta requests
+// carry no version, an ACK is matched to the version of the response by nonce.
+type deltaStreamState struct {
+	node *core.Node
+	// versions holds the system version of the responses sent, by nonce,
+	// until they are acknowledged or rejected
+	versions map[string]string
+}
+
+// streamState is the state of an open xDS stream
+type streamState struct {
+	node    *core.Node
+	typeURL string
+	opened  time.Time
+	types   map[string]*typeState
+}
+
+// typeState holds the last response of a resource type sent on a stream and
+// the resources the node acknowledged
+type typeState struct {
+	requested    []string
+	sentVersion  string
+	sentNonce    string
+	sent         []*anypb.Any
+	ackedVersion string
+	acked        []*anypb.Any
+	lastAck      time.Time
+	lastNack     time.Time
+	nackMessage  string
+}
+
+func newXDSCallbacks(server *XDSServerInstance) *xdsCallbacks {
+	return &xdsCallbacks{
+		server:       server,
+		streams:      make(map[int64]*streamState),
+		deltaStreams: make(map[int64]*deltaStreamState),
+	}
+}
+
+func (c *xdsCallbacks) OnStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stream(streamID).typeURL = typeURL
+	return nil
+}
+
+func (c *xdsCallbacks) OnStreamClosed(streamID int64, node *core.Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if known, ok := c.streams[streamID]; ok && known.node != nil {
+		streamDisconnected(c.server.namespace, c.server.name, known.node.GetId())
+		c.server.tracker.streamClosed(known.node.GetId())
+		c.server.nodeStreamClosed(known.node)
+	}
+	delete(c.streams, streamID)
+}
+
+// OnStreamRequest is called before the cache watch of the request is created,
+// which allows serving nodes that were not known when the snapshot was set
+func (c *xdsCallbacks) OnStreamRequest(streamID int64, req *discovery.DiscoveryRequest) error {
+	node := c.streamNode(streamID, req.GetNode())
+	if node == nil {
+		return nil
+	}
+	c.recordRequest(streamID, node, req)
+	c.server.ensureNodeSnapshot(node)
+	return nil
+}
+
+func (c *xdsCallbacks) OnStreamResponse(ctx context.Context, streamID int64, req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	xdsResponses.WithLabelValues(c.server.namespace, c.server.name, resp.GetTypeUrl()).Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.stream(streamID).typeState(resp.GetTypeUrl())
+	state.sentVersion = resp.GetVersionInfo()
+	state.sentNonce = resp.GetNonce()
+	state.sent = resp.GetResources()
+}
+
+func (c *xdsCallbacks) OnFetchRequest(ctx context.Context, req *discovery.DiscoveryRequest) error {
+	if req.GetNode() != nil {
+		c.server.ensureNodeSnapshot(req.GetNode())
+	}
+	return nil
+}
+
+func (c *xdsCallbacks) OnFetchResponse(req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	xdsResponses.WithLabelValues(c.server.namespace, c.server.name, resp.GetTypeUrl()).Inc()
+}
+
+func (c *xdsCallbacks) OnDeltaStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	return nil
+}
+
+func (c *xdsCallbacks) OnDeltaStreamClosed(streamID int64, node *core.Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if known, ok := c.deltaStreams[streamID]; ok {
+		streamDisconnected(c.server.namespace, c.server.name, known.node.GetId())
+		c.server.tracker.streamClosed(known.node.GetId())
+		c.server.nodeStreamClosed(known.node)
+		delete(c.deltaStreams, streamID)
+	}
+}
+
+func (c *xdsCallbacks) OnStreamDeltaRequest(streamID int64, req *discovery.DeltaDiscoveryRequest) error {
+	node := c.deltaStreamNode(streamID, req.GetNode())
+	c.recordDeltaRequest(streamID, node, req)
+	if node != nil {
+		c.server.ensureNodeSnapshot(node)
+	}
+	return nil
+}
+
+func (c *xdsCallbacks) OnStreamDeltaResponse(streamID int64, req *discovery.DeltaDiscoveryRequest, resp *discovery.DeltaDiscoveryResponse) {
+	xdsResponses.WithLabelValues(c.server.namespace, c.server.name, resp.GetTypeUrl()).Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if state, ok := c.deltaStreams[streamID]; ok {
+		state.versions[resp.GetNonce()] = resp.GetSystemVersionInfo()
+	}
+}
+
+// deltaStreamNode records the node of a delta stream, Envoy may only send it
+// on the first request
+func (c *xdsCallbacks) deltaStreamNode(streamID int64, node *core.Node) *core.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if state, ok := c.deltaStreams[streamID]; ok {
+		return state.node
+	}
+	if node == nil {
+		return nil
+	}
+	c.deltaStreams[streamID] = &deltaStreamState{node: node, versions: make(map[string]string)}
+	streamConnected(c.server.namespace, c.server.name, node.GetId())
+	c.server.tracker.streamOpened(node.GetId())
+	c.server.nodeStreamOpened(node)
+	return node
+}
+
+// recordDeltaRequest counts the ACK or NACK carried by a delta request, like
+// recordRequest for state of the world streams
+func (c *xdsCallbacks) recordDeltaRequest(streamID int64, node *core.Node, req *discovery.DeltaDiscoveryRequest) {
+	nonce := req.GetResponseNonce()
+	if nonce == "" {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	var version string
+	if state, ok := c.deltaStreams[streamID]; ok {
+		version = state.versions[nonce]
+		delete(state.versions, nonce)
+	}
+	c.mu.Unlock()
+
+	if req.GetErrorDetail() != nil {
+		xdsNacks.WithLabelValues(c.server.namespace, c.server.name, req.GetTypeUrl()).Inc()
+		if node != nil {
+			c.server.tracker.nack(node.GetId(), req.GetErrorDetail().GetMessage(), now)
+		}
+		return
+	}
+
+	xdsAcks.WithLabelValues(c.server.namespace, c.server.name, req.GetTypeUrl()).Inc()
+	if node == nil || version == "" {
+		return
+	}
+	if latency, ok := c.server.tracker.ack(node.GetId(), version, now); ok {
+		configPropagation.WithLabelValues(c.server.namespace, c.server.name).Observe(latency.Seconds())
+	}
+}
+
+// stream returns the state of a stream, creating it if needed. c.mu must be held.
+func (c *xdsCallbacks) stream(streamID int64) *streamState {
+	state, ok := c.streams[streamID]
+	if !ok {
+		state = &streamState{opened: time.Now(), types: make(map[string]*typeState)}
+		c.streams[streamID] = state
+	}
+	return state
+}
+
+func (s *streamState) typeState(typeURL string) *typeState {
+	state, ok := s.types[typeURL]
+	if !ok {
+		state = &typeState{}
+		s.types[typeURL] = state
+	}
+	return state
+}
+
+// streamNode records the node of a stream, Envoy may only send it on the first request
+func (c *xdsCallbacks) streamNode(streamID int64, node *core.Node) *core.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node != nil {
+		state := c.stream(streamID)
+		if state.node == nil {
+			streamConnected(c.server.namespace, c.server.name, node.GetId())
+			c.server.tracker.streamOpened(node.GetId())
+			c.server.nodeStreamOpened(node)
+		}
+		state.node = node
+		return node
+	}
+	if state, ok := c.streams[streamID]; ok {
+		return state.node
+	}
+	return nil
+}
+
+// recordRequest counts the ACK or NACK carried by a request. Requests answering
+// a response carry its nonce, and an error detail if the response was rejected.
+func (c *xdsCallbacks) recordRequest(streamID int64, node *core.Node, req *discovery.DiscoveryRequest) {
+	now := time.Now()
+	c.recordTypeState(streamID, req, now)
+
+	if req.GetResponseNonce() == "" {
+		return
+	}
+
+	if req.GetErrorDetail() != nil {
+		xdsNacks.WithLabelValues(c.server.namespace, c.server.name, req.GetTypeUrl()).Inc()
+		c.server.tracker.nack(node.GetId(), req.GetErrorDetail().GetMessage(), now)
+		return
+	}
+
+	xdsAcks.WithLabelValues(c.server.namespace, c.server.name, req.GetTypeUrl()).Inc()
+	if latency, ok := c.server.tracker.ack(node.GetId(), req.GetVersionInfo(), now); ok {
+		configPropagation.WithLabelValues(c.server.namespace, c.server.name).Observe(latency.Seconds())
+	}
+}
+
+// recordTypeState updates the requested resources of a type and, for an ACK
+// of the last response sent, the resources the node acknowledged
+func (c *xdsCallbacks) recordTypeState(streamID int64, req *discovery.DiscoveryRequest, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.stream(streamID).typeState(req.GetTypeUrl())
+	state.requested = req.GetResourceNames()
+
+	if req.GetResponseNonce() == "" {
+		return
+	}
+	if req.GetErrorDetail() != nil {
+		state.lastNack = now
+		state.nackMessage = req.GetErrorDetail().GetMessage()
+		return
+	}
+	state.lastAck = now
+	state.ackedVersion = req.GetVersionInfo()
+	if req.GetResponseNonce() == state.sentNonce {
+		state.acked = state.sent
+	}
+}
+
+// streamInfo is a copy of the state of an open stream
+type streamInfo struct {
+	id      int64
+	node    *core.Node
+	typeURL string
+	opened  time.Time
+	types   map[string]typeState
+}
+
+// snapshotStreams returns a copy of the state of all streams with a known node, ordered by stream ID
+func (c *xdsCallbacks) snapshotStreams() []streamInfo {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	streams := make([]streamInfo, 0, len(c.streams))
+	for id, state := range c.streams {
+		if state.node == nil {
+			continue
+		}
+		info := streamInfo{
+			id:      id,
+			node:    state.node,
+			typeURL: state.typeURL,
+			opened:  state.opened,
+			types:   make(map[string]typeState, len(state.types)),
+		}
+		for typeURL, ts := range state.types {
+			info.types[typeURL] = *ts
+		}
+		streams = append(streams, info)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].id < streams[j].id })
+	return streams
+}
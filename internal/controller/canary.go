@@ -0,0 +1,425 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	http_connection_manager "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	// Canary phase values
+	CanaryPhaseProgressing = "Progressing"
+	CanaryPhaseSucceeded   = "Succeeded"
+	CanaryPhaseAborted     = "Aborted"
+
+	defaultCanaryPause = time.Minute
+
+	// maxCanaryResponseBytes caps the responses read from the Prometheus and
+	// Envoy admin endpoints of the CR
+	maxCanaryResponseBytes = 4 << 20
+)
+
+var (
+	defaultCanarySteps = []int32{10, 25, 50, 100}
+
+	// canaryHTTPClient is used to query Prometheus and Envoy admin endpoints
+	canaryHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// progressCanary advances the canary rollout recorded in the status of the CR.
+// It returns the delay after which the rollout has to be evaluated again, or
+// zero if no further evaluation is needed.
+func (r *XDSControlPlaneReconciler) progressCanary(ctx context.Context, crd *api.XDSControlPlane) time.Duration {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	spec := crd.Spec.Canary
+	if spec == nil {
+		crd.Status.Canary = nil
+		return 0
+	}
+
+	steps := canarySteps(spec)
+	pause := canaryPause(spec)
+	now := metav1.Now()
+
+	// Start a new rollout when none is recorded or the clusters changed
+	status := crd.Status.Canary
+	if status == nil || status.StableCluster != spec.StableCluster || status.CanaryCluster != spec.CanaryCluster {
+		crd.Status.Canary = &api.CanaryStatus{
+			Phase:         CanaryPhaseProgressing,
+			StableCluster: spec.StableCluster,
+			CanaryCluster: spec.CanaryCluster,
+			CurrentStep:   0,
+			CanaryWeight:  steps[0],
+			LastStepTime:  &now,
+			Message:       fmt.Sprintf("Started canary rollout with weight %d", steps[0]),
+		}
+		log.Info("Started canary rollout", "stable", spec.StableCluster, "canary", spec.CanaryCluster, "weight", steps[0])
+		return pause
+	}
+
+	if status.Phase != CanaryPhaseProgressing {
+		return 0
+	}
+
+	if status.LastStepTime != nil {
+		if elapsed := now.Sub(status.LastStepTime.Time); elapsed < pause {
+			return pause - elapsed
+		}
+	}
+
+	if spec.Analysis != nil {
+		errorRate, err := analyzeCanary(ctx, spec)
+		if err != nil {
+			// Hold the current step until the metrics can be evaluated
+			log.Error(err, "Canary analysis failed, holding current step")
+			status.Message = fmt.Sprintf("Analysis failed, holding step %d: %v", status.CurrentStep, err)
+			return pause
+		}
+
+		maxErrorRate, err := strconv.ParseFloat(spec.Analysis.MaxErrorRate, 64)
+		if err != nil {
+			status.Message = fmt.Sprintf("Invalid maxErrorRate %q: %v", spec.Analysis.MaxErrorRate, err)
+			return pause
+		}
+
+		status.ObservedErrorRate = strconv.FormatFloat(errorRate, 'f', 4, 64)
+		if errorRate > maxErrorRate {
+			log.Info("Canary error rate exceeded threshold, rolling back", "errorRate", errorRate, "maxErrorRate", maxErrorRate)
+			status.Phase = CanaryPhaseAborted
+			status.CanaryWeight = 0
+			status.LastStepTime = &now
+			status.Message = fmt.Sprintf("Rolled back: error rate %s exceeded %s", status.ObservedErrorRate, spec.Analysis.MaxErrorRate)
+			return 0
+		}
+	}
+
+	next := int(status.CurrentStep) + 1
+	if next >= len(steps) {
+		status.Phase = CanaryPhaseSucceeded
+		status.Message = fmt.Sprintf("Canary rollout completed with weight %d", status.CanaryWeight)
+		log.Info("Canary rollout completed", "weight", status.CanaryWeight)
+		return 0
+	}
+
+	status.CurrentStep = int32(next)
+	status.CanaryWeight = steps[next]
+	status.LastStepTime = &now
+	status.Message = fmt.Sprintf("Advanced to step %d with weight %d", next, steps[next])
+	log.Info("Advanced canary rollout", "step", next, "weight", steps[next])
+	return pause
+}
+
+func canarySteps(spec *api.CanarySpec) []int32 {
+	if len(spec.Steps) == 0 {
+		return defaultCanarySteps
+	}
+	return spec.Steps
+}
+
+func canaryPause(spec *api.CanarySpec) time.Duration {
+	if spec.Pause == "" {
+		return defaultCanaryPause
+	}
+	pause, err := time.ParseDuration(spec.Pause)
+	if err != nil || pause <= 0 {
+		return defaultCanaryPause
+	}
+	return pause
+}
+
+// analyzeCanary returns the highest error ratio reported by the configured metric sources
+func analyzeCanary(ctx context.Context, spec *api.CanarySpec) (float64, error) {
+	var errorRate float64
+
+	if p := spec.Analysis.Prometheus; p != nil {
+		rate, err := queryPrometheus(ctx, p.Address, p.Query)
+		if err != nil {
+			return 0, fmt.Errorf("prometheus query failed: %w", err)
+		}
+		errorRate = max(errorRate, rate)
+	}
+
+	if e := spec.Analysis.EnvoyAdmin; e != nil {
+		rate, err := queryEnvoyClusterErrorRate(ctx, e.URLs, spec.CanaryCluster)
+		if err != nil {
+			return 0, fmt.Errorf("envoy admin query failed: %w", err)
+		}
+		errorRate = max(errorRate, rate)
+	}
+
+	return errorRate, nil
+}
+
+// queryPrometheus evaluates an instant query and returns the first sample value
+func queryPrometheus(ctx context.Context, address, query string) (float64, error) {
+	endpoint := strings.TrimSuffix(address, "/") + "/api/v1/query?query=" + url.QueryEscape(query)
+	body, err := httpGet(ctx, endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	var resp struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if resp.Status != "success" {
+		return 0, fmt.Errorf("query returned status %q: %s", resp.Status, resp.Error)
+	}
+
+	var sample []interface{}
+	switch resp.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(resp.Data.Result, &sample); err != nil {
+			return 0, fmt.Errorf("failed to decode scalar result: %w", err)
+		}
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(resp.Data.Result, &vector); err != nil {
+			return 0, fmt.Errorf("failed to decode vector result: %w", err)
+		}
+		if len(vector) == 0 {
+			// No traffic reached the canary yet
+			return 0, nil
+		}
+		sample = vector[0].Value
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", resp.Data.ResultType)
+	}
+
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("unexpected sample %v", sample)
+	}
+	value, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected sample value %v", sample[1])
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// queryEnvoyClusterErrorRate sums the canary cluster stats of all Envoy admin
+// endpoints and returns the ratio of failed requests, or of failed connections
+// for clusters that only carry TCP traffic.
+func queryEnvoyClusterErrorRate(ctx context.Context, adminURLs []string, clusterName string) (float64, error) {
+	filter := fmt.Sprintf(`^cluster\.%s\.(upstream_rq_5xx|upstream_rq_completed|upstream_cx_connect_fail|upstream_cx_total)$`, regexp.QuoteMeta(clusterName))
+
+	totals := map[string]uint64{}
+	for _, adminURL := range adminURLs {
+		endpoint := strings.TrimSuffix(adminURL, "/") + "/stats?filter=" + url.QueryEscape(filter)
+		body, err := httpGet(ctx, endpoint)
+		if err != nil {
+			return 0, err
+		}
+		stats, err := parseEnvoyStats(string(body))
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse stats from %s: %w", adminURL, err)
+		}
+		prefix := "cluster." + clusterName + "."
+		for name, value := range stats {
+			totals[strings.TrimPrefix(name, prefix)] += value
+		}
+	}
+
+	if completed := totals["upstream_rq_completed"]; completed > 0 {
+		return float64(totals["upstream_rq_5xx"]) / float64(completed), nil
+	}
+	if total := totals["upstream_cx_total"]; total > 0 {
+		return float64(totals["upstream_cx_connect_fail"]) / float64(total), nil
+	}
+	return 0, nil
+}
+
+// parseEnvoyStats parses the plain text output of the Envoy admin /stats endpoint
+func parseEnvoyStats(body string) (map[string]uint64, error) {
+	stats := map[string]uint64{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			// Histograms are reported in a different format and are not needed here
+			continue
+		}
+		stats[strings.TrimSpace(name)] = v
+	}
+	return stats, scanner.Err()
+}
+
+func httpGet(ctx context.Context, endpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := canaryHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCanaryResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxCanaryResponseBytes {
+		return nil, fmt.Errorf("GET %s returned more than %d bytes", endpoint, maxCanaryResponseBytes)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", endpoint, resp.Status)
+	}
+	return body, nil
+}
+
+// applyCanaryWeights rewrites the tcp_proxy filters and routes pointing at the
+// stable cluster so that the current canary weight is sent to the canary
+// cluster. Both clusters must be among the built clusters, inline or attached.
+func applyCanaryWeights(crd *api.XDSControlPlane, clusters, listeners, routes []types.Resource) error {
+	spec, status := crd.Spec.Canary, crd.Status.Canary
+	if spec == nil || status == nil || status.CanaryWeight == 0 {
+		return nil
+	}
+
+	if !hasCluster(clusters, spec.StableCluster) {
+		return fmt.Errorf("stable cluster %s is not defined", spec.StableCluster)
+	}
+	if !hasCluster(clusters, spec.CanaryCluster) {
+		return fmt.Errorf("canary cluster %s is not defined", spec.CanaryCluster)
+	}
+
+	weight := uint32(status.CanaryWeight)
+
+	for _, l := range listeners {
+		for _, chain := range filterChains(l.(*listener.Listener)) {
+			for _, f := range chain.Filters {
+				if err := applyCanaryWeightsToFilter(f, spec, weight); err != nil {
+					return fmt.Errorf("listener %s: %w", l.(*listener.Listener).Name, err)
+				}
+			}
+		}
+	}
+
+	for _, rc := range routes {
+		applyCanaryWeightsToRoutes(rc.(*route.RouteConfiguration), spec, weight)
+	}
+
+	return nil
+}
+
+func applyCanaryWeightsToFilter(f *listener.Filter, spec *api.CanarySpec, weight uint32) error {
+	typed := f.GetTypedConfig()
+	if typed == nil {
+		return nil
+	}
+
+	switch {
+	case typed.MessageIs(&tcp_proxy.TcpProxy{}):
+		var tp tcp_proxy.TcpProxy
+		if err := typed.UnmarshalTo(&tp); err != nil {
+			return fmt.Errorf("failed to decode tcp_proxy config: %w", err)
+		}
+		if tp.GetCluster() != spec.StableCluster {
+			return nil
+		}
+		if weight >= 100 {
+			// Weighted clusters have weights of at least 1
+			tp.ClusterSpecifier = &tcp_proxy.TcpProxy_Cluster{Cluster: spec.CanaryCluster}
+			return remarshalFilter(f, &tp)
+		}
+		tp.ClusterSpecifier = &tcp_proxy.TcpProxy_WeightedClusters{
+			WeightedClusters: &tcp_proxy.TcpProxy_WeightedCluster{
+				Clusters: []*tcp_proxy.TcpProxy_WeightedCluster_ClusterWeight{
+					{Name: spec.StableCluster, Weight: 100 - weight},
+					{Name: spec.CanaryCluster, Weight: weight},
+				},
+			},
+		}
+		return remarshalFilter(f, &tp)
+
+	case typed.MessageIs(&http_connection_manager.HttpConnectionManager{}):
+		var hcm http_connection_manager.HttpConnectionManager
+		if err := typed.UnmarshalTo(&hcm); err != nil {
+			return fmt.Errorf("failed to decode http_connection_manager config: %w", err)
+		}
+		if hcm.GetRouteConfig() == nil {
+			return nil
+		}
+		applyCanaryWeightsToRoutes(hcm.GetRouteConfig(), spec, weight)
+		return remarshalFilter(f, &hcm)
+	}
+
+	return nil
+}
+
+func applyCanaryWeightsToRoutes(rc *route.RouteConfiguration, spec *api.CanarySpec, weight uint32) {
+	for _, vh := range rc.VirtualHosts {
+		for _, rt := range vh.Routes {
+			action := rt.GetRoute()
+			if action == nil || action.GetCluster() != spec.StableCluster {
+				continue
+			}
+			if weight >= 100 {
+				action.ClusterSpecifier = &route.RouteAction_Cluster{Cluster: spec.CanaryCluster}
+				continue
+			}
+			action.ClusterSpecifier = &route.RouteAction_WeightedClusters{
+				WeightedClusters: &route.WeightedCluster{
+					Clusters: []*route.WeightedCluster_ClusterWeight{
+						{Name: spec.StableCluster, Weight: wrapperspb.UInt32(100 - weight)},
+						{Name: spec.CanaryCluster, Weight: wrapperspb.UInt32(weight)},
+					},
+				},
+			}
+		}
+	}
+}
+
+func remarshalFilter(f *listener.Filter, msg proto.Message) error {
+	anyCfg, err := anypb.New(msg)
+	if err != nil {
+		return err
+	}
+	f.ConfigType = &listener.Filter_TypedConfig{TypedConfig: anyCfg}
+	return nil
+}
+
+func hasCluster(clusters []types.Resource, name string) bool {
+	for _, c := range clusters {
+		if c.(*cluster.Cluster).Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	resource "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func newCanaryCRD(canary *api.CanarySpec) *api.XDSControlPlane {
+	return &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "canary", Namespace: "default"},
+		Spec: api.XDSControlPlaneSpec{
+			Clusters: []api.ClusterSpec{
+				{Name: "backend-v1", Type: "static", LbPolicy: "round_robin"},
+				{Name: "backend-v2", Type: "static", LbPolicy: "round_robin"},
+			},
+			Canary: canary,
+		},
+	}
+}
+
+func TestProgressCanary(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+	ctx := context.Background()
+
+	t.Run("Starts and advances rollout", func(t *testing.T) {
+		crd := newCanaryCRD(&api.CanarySpec{
+			StableCluster: "backend-v1",
+			CanaryCluster: "backend-v2",
+			Steps:         []int32{20, 100},
+			Pause:         "30s",
+		})
+
+		requeue := reconciler.progressCanary(ctx, crd)
+		require.NotNil(t, crd.Status.Canary)
+		assert.Equal(t, CanaryPhaseProgressing, crd.Status.Canary.Phase)
+		assert.Equal(t, int32(20), crd.Status.Canary.CanaryWeight)
+		assert.Equal(t, 30*time.Second, requeue)
+
+		// Pause not elapsed yet
+		requeue = reconciler.progressCanary(ctx, crd)
+		assert.Equal(t, int32(0), crd.Status.Canary.CurrentStep)
+		assert.True(t, requeue > 0 && requeue <= 30*time.Second)
+
+		// Pause elapsed
+		past := metav1.NewTime(time.Now().Add(-time.Minute))
+		crd.Status.Canary.LastStepTime = &past
+		reconciler.progressCanary(ctx, crd)
+		assert.Equal(t, int32(1), crd.Status.Canary.CurrentStep)
+		assert.Equal(t, int32(100), crd.Status.Canary.CanaryWeight)
+
+		crd.Status.Canary.LastStepTime = &past
+		requeue = reconciler.progressCanary(ctx, crd)
+		assert.Equal(t, CanaryPhaseSucceeded, crd.Status.Canary.Phase)
+		assert.Equal(t, time.Duration(0), requeue)
+	})
+
+	t.Run("Aborts when error rate exceeds threshold", func(t *testing.T) {
+		prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v1/query", r.URL.Path)
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"0.2"]}]}}`)
+		}))
+		defer prom.Close()
+
+		crd := newCanaryCRD(&api.CanarySpec{
+			StableCluster: "backend-v1",
+			CanaryCluster: "backend-v2",
+			Analysis: &api.CanaryAnalysisSpec{
+				MaxErrorRate: "0.05",
+				Prometheus:   &api.PrometheusAnalysisSpec{Address: prom.URL, Query: "error_ratio"},
+			},
+		})
+
+		reconciler.progressCanary(ctx, crd)
+		past := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		crd.Status.Canary.LastStepTime = &past
+
+		requeue := reconciler.progressCanary(ctx, crd)
+		assert.Equal(t, CanaryPhaseAborted, crd.Status.Canary.Phase)
+		assert.Equal(t, int32(0), crd.Status.Canary.CanaryWeight)
+		assert.Equal(t, "0.2000", crd.Status.Canary.ObservedErrorRate)
+		assert.Equal(t, time.Duration(0), requeue)
+	})
+
+	t.Run("Uses Envoy admin stats", func(t *testing.T) {
+		envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "cluster.backend-v2.upstream_rq_5xx: 1\ncluster.backend-v2.upstream_rq_completed: 100\n")
+		}))
+		defer envoy.Close()
+
+		crd := newCanaryCRD(&api.CanarySpec{
+			StableCluster: "backend-v1",
+			CanaryCluster: "backend-v2",
+			Analysis: &api.CanaryAnalysisSpec{
+				MaxErrorRate: "0.05",
+				EnvoyAdmin:   &api.EnvoyAdminAnalysisSpec{URLs: []string{envoy.URL}},
+			},
+		})
+
+		reconciler.progressCanary(ctx, crd)
+		past := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		crd.Status.Canary.LastStepTime = &past
+
+		reconciler.progressCanary(ctx, crd)
+		assert.Equal(t, CanaryPhaseProgressing, crd.Status.Canary.Phase)
+		assert.Equal(t, int32(25), crd.Status.Canary.CanaryWeight)
+		assert.Equal(t, "0.0100", crd.Status.Canary.ObservedErrorRate)
+	})
+
+	t.Run("Clears status when canary is removed", func(t *testing.T) {
+		crd := newCanaryCRD(nil)
+		crd.Status.Canary = &api.CanaryStatus{Phase: CanaryPhaseSucceeded}
+
+		reconciler.progressCanary(ctx, crd)
+		assert.Nil(t, crd.Status.Canary)
+	})
+}
+
+func TestApplyCanaryWeights(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+
+	crd := newCanaryCRD(&api.CanarySpec{StableCluster: "backend-v1", CanaryCluster: "backend-v2"})
+	crd.Status.Canary = &api.CanaryStatus{Phase: CanaryPhaseProgressing, CanaryWeight: 25}
+
+	l, err := reconciler.buildListener(api.ListenerSpec{
+		Name:    "tcp",
+		Address: "0.0.0.0",
+		Port:    9000,
+		FilterChains: []api.FilterChainSpec{{
+			Filters: []api.FilterSpec{{
+				Name: "envoy.filters.network.tcp_proxy",
+				TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{
+					"@type": "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
+					"stat_prefix": "tcp",
+					"cluster": "backend-v1"
+				}`)},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+
+	rc, err := reconciler.buildRouteConfiguration(api.RouteConfigSpec{
+		Name: "local_route",
+		VirtualHosts: []api.VirtualHostSpec{{
+			Name:    "backend",
+			Domains: []string{"*"},
+			Routes: []apiextensionsv1.JSON{
+				{Raw: []byte(`{"match": {"prefix": "/"}, "route": {"cluster": "backend-v1"}}`)},
+			},
+		}},
+	})
+	require.NoError(t, err)
+
+	clusters := []types.Resource{&cluster.Cluster{Name: "backend-v1"}, &cluster.Cluster{Name: "backend-v2"}}
+	err = applyCanaryWeights(crd, clusters, []types.Resource{l}, []types.Resource{rc})
+	require.NoError(t, err)
+
+	var tp tcp_proxy.TcpProxy
+	require.NoError(t, l.FilterChains[0].Filters[0].GetTypedConfig().UnmarshalTo(&tp))
+	weighted := tp.GetWeightedClusters().GetClusters()
+	require.Len(t, weighted, 2)
+	assert.Equal(t, "backend-v1", weighted[0].Name)
+	assert.Equal(t, uint32(75), weighted[0].Weight)
+	assert.Equal(t, "backend-v2", weighted[1].Name)
+	assert.Equal(t, uint32(25), weighted[1].Weight)
+
+	routeWeights := rc.VirtualHosts[0].Routes[0].GetRoute().GetWeightedClusters().GetClusters()
+	require.Len(t, routeWeights, 2)
+	assert.Equal(t, uint32(75), routeWeights[0].Weight.GetValue())
+	assert.Equal(t, uint32(25), routeWeights[1].Weight.GetValue())
+
+	t.Run("Default filter chain", func(t *testing.T) {
+		l, err := reconciler.buildListener(api.ListenerSpec{
+			Name:    "tls",
+			Address: "0.0.0.0",
+			Port:    9443,
+			DefaultFilterChain: &api.FilterChainSpec{
+				Filters: []api.FilterSpec{{
+					Name: "envoy.filters.network.tcp_proxy",
+					TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{
+						"@type": "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
+						"stat_prefix": "tls",
+						"cluster": "backend-v1"
+					}`)},
+				}},
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, applyCanaryWeights(crd, clusters, []types.Resource{l}, nil))
+
+		var tp tcp_proxy.TcpProxy
+		require.NoError(t, l.DefaultFilterChain.Filters[0].GetTypedConfig().UnmarshalTo(&tp))
+		weighted := tp.GetWeightedClusters().GetClusters()
+		require.Len(t, weighted, 2)
+		assert.Equal(t, uint32(75), weighted[0].Weight)
+		assert.Equal(t, uint32(25), weighted[1].Weight)
+	})
+
+	t.Run("Unknown canary cluster", func(t *testing.T) {
+		crd := newCanaryCRD(&api.CanarySpec{StableCluster: "backend-v1", CanaryCluster: "missing"})
+		crd.Status.Canary = &api.CanaryStatus{CanaryWeight: 10}
+
+		err := applyCanaryWeights(crd, clusters, nil, []types.Resource{&route.RouteConfiguration{}})
+		assert.ErrorContains(t, err, "canary cluster missing is not defined")
+	})
+
+	t.Run("Zero weight leaves listeners untouched", func(t *testing.T) {
+		crd.Status.Canary.CanaryWeight = 0
+		plain := &listener.Listener{Name: "untouched"}
+		require.NoError(t, applyCanaryWeights(crd, clusters, []types.Resource{plain}, nil))
+	})
+
+	t.Run("Full weight sends everything to the canary cluster", func(t *testing.T) {
+		crd := newCanaryCRD(&api.CanarySpec{StableCluster: "backend-v1", CanaryCluster: "backend-v2"})
+		crd.Status.Canary = &api.CanaryStatus{Phase: CanaryPhaseProgressing, CanaryWeight: 100}
+		l := &listener.Listener{Name: "tcp", FilterChains: []*listener.FilterChain{{Filters: []*listener.Filter{{Name: "envoy.filters.network.tcp_proxy"}}}}}
+		require.NoError(t, remarshalFilter(l.FilterChains[0].Filters[0], &tcp_proxy.TcpProxy{
+			StatPrefix:       "tcp",
+			ClusterSpecifier: &tcp_proxy.TcpProxy_Cluster{Cluster: "backend-v1"},
+		}))
+		rc := &route.RouteConfiguration{VirtualHosts: []*route.VirtualHost{{Routes: []*route.Route{{
+			Action: &route.Route_Route{Route: &route.RouteAction{ClusterSpecifier: &route.RouteAction_Cluster{Cluster: "backend-v1"}}},
+		}}}}}
+
+		require.NoError(t, applyCanaryWeights(crd, clusters, []types.Resource{l}, []types.Resource{rc}))
+
+		var tp tcp_proxy.TcpProxy
+		require.NoError(t, l.FilterChains[0].Filters[0].GetTypedConfig().UnmarshalTo(&tp))
+		assert.Equal(t, "backend-v2", tp.GetCluster())
+		assert.Nil(t, tp.GetWeightedClusters())
+		require.NoError(t, tp.ValidateAll())
+		action := rc.VirtualHosts[0].Routes[0].GetRoute()
+		assert.Equal(t, "backend-v2", action.GetCluster())
+		assert.Nil(t, action.GetWeightedClusters())
+	})
+}
+
+func TestCanaryToAttachedCluster(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := newCanaryCRD(&api.CanarySpec{StableCluster: "backend-v1", CanaryCluster: "backend-v3"})
+	crd.Spec.Routes = []api.RouteConfigSpec{{
+		Name: "local_route",
+		VirtualHosts: []api.VirtualHostSpec{{
+			Name:    "backend",
+			Domains: []string{"*"},
+			Routes:  []apiextensionsv1.JSON{{Raw: []byte(`{"match": {"prefix": "/"}, "route": {"cluster": "backend-v1"}}`)}},
+		}},
+	}}
+	crd.Status.Canary = &api.CanaryStatus{Phase: CanaryPhaseProgressing, CanaryWeight: 50}
+	attached := &api.XDSCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend-v3", Namespace: "default"},
+		Spec: api.XDSClusterSpec{
+			AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: &api.ControlPlaneReference{Name: "canary"}},
+			ClusterSpec:    api.ClusterSpec{Name: "backend-v3", Type: "static", LbPolicy: "round_robin"},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd, attached).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	_, err := r.resolveAttachments(ctx, crd)
+	require.NoError(t, err)
+	snapshot, skipped, err := r.buildXDSSnapshot(ctx, crd)
+	require.NoError(t, err)
+	assert.Empty(t, skipped)
+
+	rc := snapshot.GetResources(resource.RouteType)["local_route"].(*route.RouteConfiguration)
+	weighted := rc.VirtualHosts[0].Routes[0].GetRoute().GetWeightedClusters().GetClusters()
+	require.Len(t, weighted, 2)
+	assert.Equal(t, "backend-v3", weighted[1].Name)
+}
+
+func TestHTTPGetLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := maxCanaryResponseBytes
+		if r.URL.Path == "/large" {
+			size++
+		}
+		_, _ = w.Write(make([]byte, size))
+	}))
+	defer server.Close()
+
+	body, err := httpGet(context.Background(), server.URL+"/stats")
+	require.NoError(t, err)
+	assert.Len(t, body, maxCanaryResponseBytes)
+
+	_, err = httpGet(context.Background(), server.URL+"/large")
+	assert.ErrorContains(t, err, "returned more than")
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// Condition reasons
+const (
+	ReasonInitializing        = "Initializing"
+	ReasonReady               = "Ready"
+	ReasonPinned              = "Pinned"
+	ReasonServerRunning       = "ServerRunning"
+	ReasonServerFailed        = "ServerFailed"
+	ReasonSnapshotSet         = "SnapshotSet"
+	ReasonBuildFailed         = "BuildFailed"
+	ReasonSetFailed           = "SetFailed"
+	ReasonRevisionUnavailable = "RevisionUnavailable"
+	ReasonEndpointsResolved   = "EndpointsResolved"
+	ReasonNoEndpoints         = "NoEndpoints"
+	ReasonDiscoveryFailed     = "DiscoveryFailed"
+	ReasonNoEndpointSelectors = "NoEndpointSelectors"
+	ReasonAccepted            = "Accepted"
+	ReasonRejected            = "Rejected"
+	ReasonPending             = "Pending"
+	ReasonNoNodesConnected    = "NoNodesConnected"
+	ReasonConflicted          = "Conflicted"
+	ReasonNotAllowed          = "NotAllowed"
+	ReasonAttachmentFailed    = "AttachmentFailed"
+	ReasonDataPlaneReconciled = "Reconciled"
+	ReasonBootstrapFailed     = "BootstrapFailed"
+	ReasonDataPlaneFailed     = "DataPlaneFailed"
+)
+
+// endpointDiscoveryError is returned when the endpoints of a cluster cannot be discovered
+type endpointDiscoveryError struct {
+	err error
+}
+
+func (e *endpointDiscoveryError) Error() string {
+	return fmt.Sprintf("failed to discover endpoints: %v", e.err)
+}
+
+func (e *endpointDiscoveryError) Unwrap() error {
+	return e.err
+}
+
+// setCondition sets a condition for the current generation of the CR. The
+// transition time is only updated when the status of the condition changes.
+func setCondition(crd *api.XDSControlPlane, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&crd.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: crd.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setReadyCondition derives the Ready condition and the phase from the
+// ServerUp and SnapshotReady conditions and the fallback status
+func setReadyCondition(crd *api.XDSControlPlane) {
+	server := meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeServerUp)
+	snapshot := meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeSnapshot)
+	fallback := crd.Status.Fallback
+
+	if server != nil && server.Status == metav1.ConditionFalse {
+		crd.Status.Phase = PhaseError
+		setCondition(crd, ConditionTypeReady, metav1.ConditionFalse, server.Reason, server.Message)
+		return
+	}
+
+	switch {
+	case fallback != nil && fallback.Decision == FallbackKeptLastGood:
+		crd.Status.Phase = PhaseDegraded
+		setCondition(crd, ConditionTypeReady, metav1.ConditionFalse, PhaseDegraded,
+			fmt.Sprintf("Serving last-known-good snapshot %s: %s", fallback.ServedVersion, fallback.Reason))
+	case snapshot != nil && snapshot.Status == metav1.ConditionFalse:
+		crd.Status.Phase = PhaseError
+		setCondition(crd, ConditionTypeReady, metav1.ConditionFalse, snapshot.Reason, snapshot.Message)
+	case server == nil || snapshot == nil:
+		crd.Status.Phase = PhasePending
+		setCondition(crd, ConditionTypeReady, metav1.ConditionUnknown, ReasonInitializing, "Initializing xDS control plane")
+	case fallback != nil:
+		crd.Status.Phase = PhaseDegraded
+		setCondition(crd, ConditionTypeReady, metav1.ConditionFalse, PhaseDegraded,
+			fmt.Sprintf("Serving configuration without invalid resources: %s", strings.Join(fallback.ExcludedResources, ", ")))
+	case crd.Spec.PinnedRevision != nil:
+		crd.Status.Phase = PhaseReady
+		setCondition(crd, ConditionTypeReady, metav1.ConditionTrue, ReasonPinned,
+			fmt.Sprintf("Serving pinned revision %d", *crd.Spec.PinnedRevision))
+	default:
+		crd.Status.Phase = PhaseReady
+		setCondition(crd, ConditionTypeReady, metav1.ConditionTrue, ReasonReady,
+			"XDS control plane is ready and serving configuration")
+	}
+}
+
+// setEndpointsCondition reports whether endpoints were discovered for every
+// cluster selecting them
+func setEndpointsCondition(crd *api.XDSControlPlane, snapshot *cache.Snapshot, skipped []skippedResource) {
+	var failed []string
+	for _, s := range skipped {
+		var discoveryErr *endpointDiscoveryError
+		if errors.As(s.Err, &discoveryErr) {
+			failed = append(failed, fmt.Sprintf("%s: %v", s.Name, discoveryErr.err))
+		}
+	}
+	if len(failed) > 0 {
+		setCondition(crd, ConditionTypeEndpoints, metav1.ConditionFalse, ReasonDiscoveryFailed,
+			fmt.Sprintf("Failed to discover endpoints of cluster(s) %s", strings.Join(failed, "; ")))
+		return
+	}
+
+	var empty []string
+	resources := snapshot.GetResources(res.EndpointType)
+	for name, r := range resources {
+		if cla, ok := r.(*endpoint.ClusterLoadAssignment); ok && len(endpointAddresses(cla)) == 0 {
+			empty = append(empty, name)
+		}
+	}
+	sort.Strings(empty)
+
+	switch {
+	case len(empty) > 0:
+		setCondition(crd, ConditionTypeEndpoints, metav1.ConditionFalse, ReasonNoEndpoints,
+			fmt.Sprintf("No endpoints discovered for cluster(s) %s", strings.Join(empty, ", ")))
+	case len(resources) == 0:
+		setCondition(crd, ConditionTypeEndpoints, metav1.ConditionTrue, ReasonNoEndpointSelectors,
+			"No cluster discovers its endpoints")
+	default:
+		setCondition(crd, ConditionTypeEndpoints, metav1.ConditionTrue, ReasonEndpointsResolved,
+			fmt.Sprintf("Endpoints discovered for %d cluster(s)", len(resources)))
+	}
+}
+
+// setEndpointsDiscoveryFailed marks the endpoints as unresolved if the
+// snapshot build failed because of the endpoint discovery
+func setEndpointsDiscoveryFailed(crd *api.XDSControlPlane, buildErr error) {
+	var discoveryErr *endpointDiscoveryError
+	if errors.As(buildErr, &discoveryErr) {
+		setCondition(crd, ConditionTypeEndpoints, metav1.ConditionFalse, ReasonDiscoveryFailed, buildErr.Error())
+	}
+}
+
+// setAcceptedCondition reports whether the connected nodes accepted the
+// configuration of the current generation
+func setAcceptedCondition(crd *api.XDSControlPlane) {
+	propagation, nodes := crd.Status.Propagation, crd.Status.Nodes
+	if propagation == nil || propagation.ConnectedNodes == 0 {
+		setCondition(crd, ConditionTypeAccepted, metav1.ConditionUnknown, ReasonNoNodesConnected, "No Envoy node is connected")
+		return
+	}
+
+	var rejected []string
+	for _, n := range nodes {
+		if n.LastNackTime != nil && (n.LastAckTime == nil || n.LastNackTime.After(n.LastAckTime.Time)) {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", n.NodeID, n.LastNackMessage))
+		}
+	}
+
+	switch {
+	case len(rejected) > 0:
+		setCondition(crd, ConditionTypeAccepted, metav1.ConditionFalse, ReasonRejected,
+			fmt.Sprintf("Configuration rejected by %d node(s): %s", len(rejected), strings.Join(rejected, "; ")))
+	case propagation.UpdatedNodes < propagation.ConnectedNodes:
+		setCondition(crd, ConditionTypeAccepted, metav1.ConditionUnknown, ReasonPending,
+			fmt.Sprintf("%d of %d node(s) acknowledged generation %d", propagation.UpdatedNodes, propagation.ConnectedNodes, propagation.Generation))
+	default:
+		setCondition(crd, ConditionTypeAccepted, metav1.ConditionTrue, ReasonAccepted,
+			fmt.Sprintf("Generation %d acknowledged by all %d connected node(s)", propagation.Generation, propagation.ConnectedNodes))
+	}
+}
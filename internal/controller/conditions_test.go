@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestReadyCondition(t *testing.T) {
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "test", Generation: 2}}
+
+	setReadyCondition(crd)
+	assert.Equal(t, PhasePending, crd.Status.Phase)
+	assert.Equal(t, metav1.ConditionUnknown, meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeReady).Status)
+
+	setCondition(crd, ConditionTypeServerUp, metav1.ConditionTrue, ReasonServerRunning, "")
+	setCondition(crd, ConditionTypeSnapshot, metav1.ConditionTrue, ReasonSnapshotSet, "")
+	setReadyCondition(crd)
+	assert.Equal(t, PhaseReady, crd.Status.Phase)
+	ready := meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeReady)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, int64(2), ready.ObservedGeneration)
+
+	// The transition time only changes on transitions
+	transition := metav1.NewTime(time.Now().Add(-time.Hour))
+	ready.LastTransitionTime = transition
+	setReadyCondition(crd)
+	assert.Equal(t, transition, meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeReady).LastTransitionTime)
+
+	setCondition(crd, ConditionTypeSnapshot, metav1.ConditionFalse, ReasonBuildFailed, "invalid listener")
+	setReadyCondition(crd)
+	assert.Equal(t, PhaseError, crd.Status.Phase)
+	ready = meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, ReasonBuildFailed, ready.Reason)
+	assert.NotEqual(t, transition, ready.LastTransitionTime)
+
+	crd.Status.Fallback = &api.FallbackStatus{Decision: FallbackKeptLastGood, ServedVersion: "1", Reason: "invalid listener"}
+	setReadyCondition(crd)
+	assert.Equal(t, PhaseDegraded, crd.Status.Phase)
+
+	setCondition(crd, ConditionTypeServerUp, metav1.ConditionFalse, ReasonServerFailed, "port in use")
+	setReadyCondition(crd)
+	assert.Equal(t, PhaseError, crd.Status.Phase)
+	assert.Equal(t, ReasonServerFailed, meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeReady).Reason)
+}
+
+func TestEndpointsCondition(t *testing.T) {
+	crd := &api.XDSControlPlane{}
+	reason := func() string {
+		return meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeEndpoints).Reason
+	}
+	snapshot := func(resources ...types.Resource) *cache.Snapshot {
+		s, err := cache.NewSnapshot("1", map[res.Type][]types.Resource{res.EndpointType: resources})
+		require.NoError(t, err)
+		return s
+	}
+
+	setEndpointsCondition(crd, snapshot(), nil)
+	assert.Equal(t, ReasonNoEndpointSelectors, reason())
+
+	setEndpointsCondition(crd, snapshot(testLoadAssignment("10.0.0.1")), nil)
+	assert.Equal(t, ReasonEndpointsResolved, reason())
+
+	setEndpointsCondition(crd, snapshot(&endpoint.ClusterLoadAssignment{ClusterName: "backend"}), nil)
+	assert.Equal(t, ReasonNoEndpoints, reason())
+	assert.Contains(t, meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeEndpoints).Message, "backend")
+
+	skipped := []skippedResource{{Kind: "cluster", Name: "backend", Err: &endpointDiscoveryError{err: errors.New("forbidden")}}}
+	setEndpointsCondition(crd, snapshot(), skipped)
+	assert.Equal(t, ReasonDiscoveryFailed, reason())
+}
+
+func TestAcceptedCondition(t *testing.T) {
+	crd := &api.XDSControlPlane{}
+	reason := func() string {
+		return meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeAccepted).Reason
+	}
+
+	setAcceptedCondition(crd)
+	assert.Equal(t, ReasonNoNodesConnected, reason())
+
+	now := time.Now()
+	crd.Status.Propagation = &api.PropagationStatus{Generation: 2, UpdatedNodes: 1, ConnectedNodes: 2}
+	crd.Status.Nodes = []api.NodeStatus{
+		{NodeID: "envoy-1", LastAckTime: &metav1.Time{Time: now}},
+		{NodeID: "envoy-2", LastAckTime: &metav1.Time{Time: now.Add(-time.Minute)}},
+	}
+	setAcceptedCondition(crd)
+	assert.Equal(t, ReasonPending, reason())
+
+	crd.Status.Nodes[1].LastNackTime = &metav1.Time{Time: now}
+	crd.Status.Nodes[1].LastNackMessage = "duplicate listener"
+	setAcceptedCondition(crd)
+	assert.Equal(t, ReasonRejected, reason())
+	assert.Contains(t, meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeAccepted).Message, "envoy-2: duplicate listener")
+
+	crd.Status.Propagation.UpdatedNodes = 2
+	crd.Status.Nodes[1].LastAckTime = &metav1.Time{Time: now.Add(time.Second)}
+	setAcceptedCondition(crd)
+	assert.Equal(t, ReasonAccepted, reason())
+}
+
+func TestUpdateStatusObservedGeneration(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 4}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).WithStatusSubresource(crd).Build()
+	reconciler := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	_, err := reconciler.updateStatusFailed(ctx, crd, ConditionTypeServerUp, ReasonServerFailed, "port in use")
+	require.NoError(t, err)
+
+	updated := &api.XDSControlPlane{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(crd), updated))
+	assert.Equal(t, int64(4), updated.Status.ObservedGeneration)
+	assert.Equal(t, PhaseError, updated.Status.Phase)
+	assert.True(t, meta.IsStatusConditionFalse(updated.Status.Conditions, ConditionTypeServerUp))
+	assert.True(t, meta.IsStatusConditionFalse(updated.Status.Conditions, ConditionTypeReady))
+}
+
+func TestPatchStatus(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 1}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).WithStatusSubresource(crd).Build()
+	reconciler := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	// A stale object does not conflict with the merge patch
+	stale := crd.DeepCopy()
+	crd.Status.LastSnapshotVersion = "1"
+	require.NoError(t, reconciler.patchStatus(ctx, crd))
+	stale.Status.LastSnapshotVersion = "2"
+	require.NoError(t, reconciler.patchStatus(ctx, stale))
+
+	written := &api.XDSControlPlane{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(crd), written))
+	assert.Equal(t, "2", written.Status.LastSnapshotVersion)
+
+	// Unchanged status is not written
+	require.NoError(t, reconciler.patchStatus(ctx, stale))
+	unchanged := &api.XDSControlPlane{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(crd), unchanged))
+	assert.Equal(t, written.ResourceVersion, unchanged.ResourceVersion)
+}
@@ -0,0 +1,369 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	// BootstrapHashAnnotation is set on the data plane pods to roll them when
+	// their bootstrap changes
+	BootstrapHashAnnotation = "xds.okassov/bootstrap-hash"
+
+	defaultEnvoyImage = "envoyproxy/envoy:v1.28-latest"
+	envoyConfigDir    = "/etc/envoy"
+
+	// dataPlaneRetryInterval is the delay before retrying to write the
+	// bootstraps or the data plane
+	dataPlaneRetryInterval = 30 * time.Second
+)
+
+// reconcileDataPlaneObjects writes the bootstraps and the data plane of a CR
+// and reports them in the DataPlaneReady condition. A failure does not affect
+// the configuration served to connected nodes, it is retried after the
+// returned delay.
+func (r *XDSControlPlaneReconciler) reconcileDataPlaneObjects(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance) time.Duration {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	bootstrapErr := r.reconcileBootstraps(ctx, crd, server)
+	if bootstrapErr != nil {
+		log.Error(bootstrapErr, "failed to reconcile Envoy bootstraps")
+		r.eventf(crd, corev1.EventTypeWarning, EventReasonBootstrapFailed, "Failed to write Envoy bootstraps: %v", bootstrapErr)
+	}
+	dataPlaneErr := r.reconcileDataPlane(ctx, crd, server)
+	if dataPlaneErr != nil {
+		log.Error(dataPlaneErr, "failed to reconcile data plane")
+		r.eventf(crd, corev1.EventTypeWarning, EventReasonDataPlaneFailed, "Failed to reconcile data plane: %v", dataPlaneErr)
+	}
+
+	switch {
+	case bootstrapErr != nil:
+		setCondition(crd, ConditionTypeDataPlane, metav1.ConditionFalse, ReasonBootstrapFailed, bootstrapErr.Error())
+	case dataPlaneErr != nil:
+		setCondition(crd, ConditionTypeDataPlane, metav1.ConditionFalse, ReasonDataPlaneFailed, dataPlaneErr.Error())
+	case crd.Spec.Bootstrap == nil && crd.Spec.DataPlane == nil:
+		meta.RemoveStatusCondition(&crd.Status.Conditions, ConditionTypeDataPlane)
+	default:
+		setCondition(crd, ConditionTypeDataPlane, metav1.ConditionTrue, ReasonDataPlaneReconciled, "Bootstraps and data plane are up to date")
+	}
+	if bootstrapErr != nil || dataPlaneErr != nil {
+		return dataPlaneRetryInterval
+	}
+	return 0
+}
+
+// requeueSooner returns a result requeued after a delay, unless it is
+// already requeued earlier
+func requeueSooner(result ctrl.Result, after time.Duration) ctrl.Result {
+	if after > 0 && (result.RequeueAfter == 0 || after < result.RequeueAfter) {
+		result.RequeueAfter = after
+	}
+	return result
+}
+
+// dataPlaneName is the name of the workload, the Service and the bootstrap
+// ConfigMap of the data plane of a CR
+func dataPlaneName(crd *api.XDSControlPlane) string {
+	return crd.Name + "-envoy"
+}
+
+// dataPlaneNodePattern matches the node IDs of the data plane proxies, which
+// are the names of their pods
+func dataPlaneNodePattern(crd *api.XDSControlPlane) string {
+	return dataPlaneName(crd) + "-*"
+}
+
+func dataPlaneSelector(crd *api.XDSControlPlane) map[string]string {
+	return map[string]string{
+		ControlPlaneLabel:             crd.Name,
+		"app.kubernetes.io/component": "envoy",
+	}
+}
+
+func dataPlaneLabels(crd *api.XDSControlPlane) map[string]string {
+	labels := dataPlaneSelector(crd)
+	labels["app.kubernetes.io/name"] = "envoy"
+	labels["app.kubernetes.io/managed-by"] = "xds-cp-operator"
+	return labels
+}
+
+// dataPlanePorts returns the ports of the listeners of a CR, with their
+// additional addresses. Listener names that are not valid port names are
+// replaced by the protocol and port number. Internal listeners and listeners
+// not bound to their port have no port.
+func dataPlanePorts(crd *api.XDSControlPlane) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	seen := make(map[string]bool)
+	for _, l := range crd.Spec.Listeners {
+		if l.Internal != nil || (l.BindToPort != nil && !*l.BindToPort) {
+			continue
+		}
+		protocol := corev1.ProtocolTCP
+		if listenerProtocol(l) == "UDP" {
+			protocol = corev1.ProtocolUDP
+		}
+
+		listenerPorts := []int{l.Port}
+		for _, a := range l.AdditionalAddresses {
+			listenerPorts = append(listenerPorts, a.Port)
+		}
+		for _, port := range listenerPorts {
+			key := fmt.Sprintf("%s/%d", protocol, port)
+			if port <= 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			name := strings.ToLower(l.Name)
+			if port != l.Port || len(validation.IsValidPortName(name)) > 0 || seen["name/"+name] {
+				name = fmt.Sprintf("port-%d", port)
+				if protocol == corev1.ProtocolUDP {
+					name = fmt.Sprintf("udp-%d", port)
+				}
+			}
+			seen["name/"+name] = true
+			ports = append(ports, corev1.ServicePort{
+				Name:       name,
+				Protocol:   protocol,
+				Port:       int32(port),
+				TargetPort: intstr.FromInt(port),
+			})
+		}
+	}
+	return ports
+}
+
+// dataPlanePodTemplate returns the pod template of the data plane proxies. The
+// proxies read the bootstrap from the ConfigMap of the data plane and use the
+// name of their pod as node ID.
+func dataPlanePodTemplate(crd *api.XDSControlPlane, bootstrapHash string) corev1.PodTemplateSpec {
+	spec := crd.Spec.DataPlane
+
+	image := spec.Image
+	if image == "" {
+		image = defaultEnvoyImage
+	}
+	dnsPolicy := corev1.DNSClusterFirst
+	if spec.HostNetwork {
+		// Resolve the xDS Service from the network namespace of the node
+		dnsPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+
+	var containerPorts []corev1.ContainerPort
+	for _, p := range dataPlanePorts(crd) {
+		containerPorts = append(containerPorts, corev1.ContainerPort{
+			Name:          p.Name,
+			ContainerPort: p.Port,
+			Protocol:      p.Protocol,
+		})
+	}
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      dataPlaneLabels(crd),
+			Annotations: map[string]string{BootstrapHashAnnotation: bootstrapHash},
+		},
+		Spec: corev1.PodSpec{
+			HostNetwork:  spec.HostNetwork,
+			DNSPolicy:    dnsPolicy,
+			NodeSelector: spec.NodeSelector,
+			Tolerations:  spec.Tolerations,
+			Containers: []corev1.Container{{
+				Name:  "envoy",
+				Image: image,
+				Args: []string{
+					"-c", envoyConfigDir + "/" + bootstrapDataKey,
+					"--service-node", "$(POD_NAME)",
+				},
+				Env: []corev1.EnvVar{{
+					Name: "POD_NAME",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+					},
+				}},
+				Ports:     containerPorts,
+				Resources: spec.Resources,
+				VolumeMounts: []corev1.VolumeMount{{
+					Name:      "bootstrap",
+					MountPath: envoyConfigDir,
+					ReadOnly:  true,
+				}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "bootstrap",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: dataPlaneName(crd)},
+					},
+				},
+			}},
+		},
+	}
+}
+
+// reconcileDataPlane runs the Envoy proxies of spec.dataPlane with their
+// bootstrap and Service, all owned by the CR. The objects are removed when
+// spec.dataPlane is removed.
+func (r *XDSControlPlaneReconciler) reconcileDataPlane(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance) error {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	spec := crd.Spec.DataPlane
+	name := dataPlaneName(crd)
+	meta := metav1.ObjectMeta{Name: name, Namespace: crd.Namespace}
+
+	if spec == nil {
+		return r.deleteDataPlane(ctx, crd, &appsv1.Deployment{ObjectMeta: meta}, &appsv1.DaemonSet{ObjectMeta: meta},
+			&corev1.Service{ObjectMeta: meta}, &corev1.ConfigMap{ObjectMeta: meta})
+	}
+
+	xdsAddress := r.advertisedAddress(server)
+	if crd.Spec.Bootstrap != nil && crd.Spec.Bootstrap.XdsAddress != "" {
+		xdsAddress = crd.Spec.Bootstrap.XdsAddress
+	}
+	// The socket of a unix domain socket server is only reachable from the
+	// operator pod
+	if strings.HasPrefix(xdsAddress, unixScheme) {
+		return fmt.Errorf("spec.bootstrap.xdsAddress is required: the data plane cannot reach the xDS server on %s", xdsAddress)
+	}
+	data, err := renderBootstrap(crd, "", xdsAddress)
+	if err != nil {
+		return fmt.Errorf("failed to render data plane bootstrap: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	cm := &corev1.ConfigMap{ObjectMeta: meta}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Labels = dataPlaneLabels(crd)
+		cm.Data = map[string]string{bootstrapDataKey: string(data)}
+		return controllerutil.SetControllerReference(crd, cm, r.Scheme)
+	}); err != nil {
+		return fmt.Errorf("failed to write data plane bootstrap ConfigMap: %w", err)
+	}
+
+	template := dataPlanePodTemplate(crd, hex.EncodeToString(sum[:8]))
+	selector := &metav1.LabelSelector{MatchLabels: dataPlaneSelector(crd)}
+
+	if spec.Kind == "DaemonSet" {
+		ds := &appsv1.DaemonSet{ObjectMeta: meta}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, ds, func() error {
+			ds.Labels = dataPlaneLabels(crd)
+			if ds.CreationTimestamp.IsZero() {
+				ds.Spec.Selector = selector
+			}
+			setPodTemplate(&ds.Spec.Template, template)
+			return controllerutil.SetControllerReference(crd, ds, r.Scheme)
+		}); err != nil {
+			return fmt.Errorf("failed to write data plane DaemonSet: %w", err)
+		}
+		if err := r.deleteDataPlane(ctx, crd, &appsv1.Deployment{ObjectMeta: meta}); err != nil {
+			return err
+		}
+	} else {
+		replicas := int32(1)
+		if spec.Replicas != nil {
+			replicas = *spec.Replicas
+		}
+		deploy := &appsv1.Deployment{ObjectMeta: meta}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+			deploy.Labels = dataPlaneLabels(crd)
+			if deploy.CreationTimestamp.IsZero() {
+				deploy.Spec.Selector = selector
+			}
+			deploy.Spec.Replicas = &replicas
+			setPodTemplate(&deploy.Spec.Template, template)
+			return controllerutil.SetControllerReference(crd, deploy, r.Scheme)
+		}); err != nil {
+			return fmt.Errorf("failed to write data plane Deployment: %w", err)
+		}
+		if err := r.deleteDataPlane(ctx, crd, &appsv1.DaemonSet{ObjectMeta: meta}); err != nil {
+			return err
+		}
+	}
+
+	svc := &corev1.Service{ObjectMeta: meta}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = dataPlaneLabels(crd)
+		svc.Spec.Type = spec.ServiceType
+		if svc.Spec.Type == "" {
+			svc.Spec.Type = corev1.ServiceTypeClusterIP
+		}
+		svc.Spec.Selector = dataPlaneSelector(crd)
+		ports := dataPlanePorts(crd)
+		if svc.Spec.Type != corev1.ServiceTypeClusterIP {
+			ports = keepNodePorts(svc.Spec.Ports, ports)
+		}
+		svc.Spec.Ports = ports
+		return controllerutil.SetControllerReference(crd, svc, r.Scheme)
+	}); err != nil {
+		return fmt.Errorf("failed to write data plane Service: %w", err)
+	}
+
+	log.V(1).Info("Reconciled data plane", "kind", spec.Kind, "name", name)
+	return nil
+}
+
+// setPodTemplate sets the desired pod template of a workload. A template that
+// already matches, with the defaults set by the API server on top, is kept so
+// that unchanged reconciles do not update the workload.
+func setPodTemplate(existing *corev1.PodTemplateSpec, desired corev1.PodTemplateSpec) {
+	if !equality.Semantic.DeepDerivative(desired, *existing) {
+		*existing = desired
+	}
+}
+
+// keepNodePorts returns the desired ports with the node ports already
+// allocated to them, by protocol and port
+func keepNodePorts(existing, desired []corev1.ServicePort) []corev1.ServicePort {
+	key := func(p corev1.ServicePort) string {
+		if p.Protocol == "" {
+			p.Protocol = corev1.ProtocolTCP
+		}
+		return fmt.Sprintf("%s/%d", p.Protocol, p.Port)
+	}
+	nodePorts := make(map[string]int32)
+	for _, p := range existing {
+		nodePorts[key(p)] = p.NodePort
+	}
+	for i := range desired {
+		desired[i].NodePort = nodePorts[key(desired[i])]
+	}
+	return desired
+}
+
+// deleteDataPlane deletes data plane objects owned by the CR
+func (r *XDSControlPlaneReconciler) deleteDataPlane(ctx context.Context, crd *api.XDSControlPlane, objs ...client.Object) error {
+	for _, obj := range objs {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get data plane %T: %w", obj, err)
+		}
+		if !metav1.IsControlledBy(obj, crd) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete data plane %T: %w", obj, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestReconcileDataPlane(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default", UID: "uid-1"},
+		Spec: api.XDSControlPlaneSpec{
+			Listeners: []api.ListenerSpec{
+				{Name: "http", Port: 8080},
+				{Name: "TLS_Listener", Port: 8443},
+			},
+			DataPlane: &api.DataPlaneSpec{
+				Replicas:     ptr.To(int32(3)),
+				NodeSelector: map[string]string{"edge": "true"},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme, ServiceHost: "xds.system.svc"}
+	server := &XDSServerInstance{network: "tcp", address: ":18000"}
+	key := client.ObjectKey{Namespace: "default", Name: "edge-envoy"}
+
+	require.NoError(t, r.reconcileDataPlane(ctx, crd, server))
+
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, c.Get(ctx, key, deploy))
+	assert.Equal(t, int32(3), *deploy.Spec.Replicas)
+	assert.True(t, metav1.IsControlledBy(deploy, crd))
+	pod := deploy.Spec.Template.Spec
+	assert.Equal(t, map[string]string{"edge": "true"}, pod.NodeSelector)
+	assert.Equal(t, defaultEnvoyImage, pod.Containers[0].Image)
+	assert.Equal(t, []string{"-c", "/etc/envoy/envoy.yaml", "--service-node", "$(POD_NAME)"}, pod.Containers[0].Args)
+	assert.Equal(t, "metadata.name", pod.Containers[0].Env[0].ValueFrom.FieldRef.FieldPath)
+	assert.Equal(t, "edge-envoy", pod.Volumes[0].ConfigMap.Name)
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[BootstrapHashAnnotation])
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, c.Get(ctx, key, cm))
+	config := parseBootstrap(t, []byte(cm.Data[bootstrapDataKey]))
+	assert.Empty(t, config.Node.Id)
+	assert.Equal(t, "edge", config.Node.Cluster)
+
+	svc := &corev1.Service{}
+	require.NoError(t, c.Get(ctx, key, svc))
+	assert.Equal(t, corev1.ServiceTypeClusterIP, svc.Spec.Type)
+	require.Len(t, svc.Spec.Ports, 2)
+	assert.Equal(t, "http", svc.Spec.Ports[0].Name)
+	assert.Equal(t, "port-8443", svc.Spec.Ports[1].Name)
+	assert.Equal(t, dataPlaneSelector(crd), svc.Spec.Selector)
+
+	// The proxies are served under the name of their pod
+	matcher, err := newControlPlaneMatcher(crd)
+	require.NoError(t, err)
+	assert.True(t, matcher.matches(&core.Node{Id: "edge-envoy-7d9f8b6c5-x2k4p"}))
+	assert.False(t, matcher.matches(&core.Node{Id: "other-envoy-7d9f8b6c5-x2k4p"}))
+
+	// The defaults set by the API server do not cause updates, changes to the
+	// template are reverted
+	deploy.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	deploy.Spec.Template.Spec.SchedulerName = corev1.DefaultSchedulerName
+	deploy.Spec.Template.Spec.Containers[0].TerminationMessagePath = corev1.TerminationMessagePathDefault
+	deploy.Spec.Template.Spec.Containers[0].ImagePullPolicy = corev1.PullIfNotPresent
+	require.NoError(t, c.Update(ctx, deploy))
+	require.NoError(t, r.reconcileDataPlane(ctx, crd, server))
+	defaulted := &appsv1.Deployment{}
+	require.NoError(t, c.Get(ctx, key, defaulted))
+	assert.Equal(t, deploy.ResourceVersion, defaulted.ResourceVersion)
+
+	defaulted.Spec.Template.Spec.Containers[0].Image = "envoyproxy/envoy:dev"
+	require.NoError(t, c.Update(ctx, defaulted))
+	require.NoError(t, r.reconcileDataPlane(ctx, crd, server))
+	require.NoError(t, c.Get(ctx, key, defaulted))
+	assert.Equal(t, defaultEnvoyImage, defaulted.Spec.Template.Spec.Containers[0].Image)
+
+	// Switching to a DaemonSet replaces the Deployment
+	crd.Spec.DataPlane.Kind = "DaemonSet"
+	crd.Spec.DataPlane.HostNetwork = true
+	require.NoError(t, r.reconcileDataPlane(ctx, crd, server))
+	ds := &appsv1.DaemonSet{}
+	require.NoError(t, c.Get(ctx, key, ds))
+	assert.True(t, ds.Spec.Template.Spec.HostNetwork)
+	assert.Equal(t, corev1.DNSClusterFirstWithHostNet, ds.Spec.Template.Spec.DNSPolicy)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, key, &appsv1.Deployment{})))
+
+	// The operator socket of a unix domain socket server is not reachable
+	unixServer := &XDSServerInstance{network: "unix", address: "/var/run/xds.sock"}
+	assert.ErrorContains(t, r.reconcileDataPlane(ctx, crd, unixServer), "spec.bootstrap.xdsAddress is required")
+	crd.Spec.Bootstrap = &api.BootstrapSpec{XdsAddress: "xds.example.com:18000"}
+	require.NoError(t, r.reconcileDataPlane(ctx, crd, unixServer))
+	crd.Spec.Bootstrap = nil
+
+	// Removing the data plane removes its objects
+	crd.Spec.DataPlane = nil
+	require.NoError(t, r.reconcileDataPlane(ctx, crd, server))
+	for _, obj := range []client.Object{&appsv1.DaemonSet{}, &corev1.Service{}, &corev1.ConfigMap{}} {
+		assert.True(t, apierrors.IsNotFound(c.Get(ctx, key, obj)), "%T", obj)
+	}
+}
+
+func TestKeepNodePorts(t *testing.T) {
+	existing := []corev1.ServicePort{{Port: 8080, NodePort: 30080}, {Port: 9090, NodePort: 30090}}
+	desired := []corev1.ServicePort{{Port: 8080}, {Port: 8443}}
+
+	ports := keepNodePorts(existing, desired)
+	assert.Equal(t, int32(30080), ports[0].NodePort)
+	assert.Equal(t, int32(0), ports[1].NodePort)
+
+	// TCP and UDP ports with the same number have node ports of their own
+	existing = []corev1.ServicePort{
+		{Port: 53, Protocol: corev1.ProtocolTCP, NodePort: 30053},
+		{Port: 53, Protocol: corev1.ProtocolUDP, NodePort: 30054},
+	}
+	desired = []corev1.ServicePort{{Port: 53, Protocol: corev1.ProtocolUDP}}
+	assert.Equal(t, int32(30054), keepNodePorts(existing, desired)[0].NodePort)
+}
+
+func TestDataPlanePorts(t *testing.T) {
+	crd := &api.XDSControlPlane{Spec: api.XDSControlPlaneSpec{Listeners: []api.ListenerSpec{
+		{Name: "http", Address: "0.0.0.0", Port: 80, AdditionalAddresses: []api.ListenerAddressSpec{{Address: "::", Port: 80}}},
+		{Name: "dns_tcp", Address: "0.0.0.0", Port: 53},
+		{Name: "dns", Address: "0.0.0.0", Port: 53, Protocol: "UDP"},
+		{Name: "dual", Address: "0.0.0.0", Port: 8443, AdditionalAddresses: []api.ListenerAddressSpec{{Address: "::", Port: 9443}}},
+		{Name: "redirected", Address: "0.0.0.0", Port: 15001, BindToPort: ptr.To(false)},
+		{Name: "tunnel", Internal: &api.InternalListenerSpec{}},
+	}}}
+
+	assert.Equal(t, []corev1.ServicePort{
+		{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(80)},
+		{Name: "port-53", Protocol: corev1.ProtocolTCP, Port: 53, TargetPort: intstr.FromInt(53)},
+		{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53, TargetPort: intstr.FromInt(53)},
+		{Name: "dual", Protocol: corev1.ProtocolTCP, Port: 8443, TargetPort: intstr.FromInt(8443)},
+		{Name: "port-9443", Protocol: corev1.ProtocolTCP, Port: 9443, TargetPort: intstr.FromInt(9443)},
+	}, dataPlanePorts(crd))
+}
+
+func TestReconcileDataPlaneObjects(t *testing.T) {
+	ctx := context.Background()
+
+	// The Deployment cannot be written without the apps types in the scheme
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default", UID: "uid-1"},
+		Spec: api.XDSControlPlaneSpec{
+			NodeIDs:   []string{"envoy-1"},
+			Bootstrap: &api.BootstrapSpec{},
+			DataPlane: &api.DataPlaneSpec{},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme, ServiceHost: "xds.system.svc"}
+	server := &XDSServerInstance{network: "tcp", address: ":18000"}
+
+	assert.Equal(t, dataPlaneRetryInterval, r.reconcileDataPlaneObjects(ctx, crd, server))
+	cond := meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeDataPlane)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, ReasonDataPlaneFailed, cond.Reason)
+
+	// The bootstraps are written regardless
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "edge-bootstrap-envoy-1"}, &corev1.ConfigMap{}))
+
+	// The next reconcile succeeds
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	r.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	assert.Zero(t, r.reconcileDataPlaneObjects(ctx, crd, server))
+	cond = meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeDataPlane)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+
+	crd.Spec.Bootstrap = nil
+	crd.Spec.DataPlane = nil
+	assert.Zero(t, r.reconcileDataPlaneObjects(ctx, crd, server))
+	assert.Nil(t, meta.FindStatusCondition(crd.Status.Conditions, ConditionTypeDataPlane))
+}
+
+func TestRequeueSooner(t *testing.T) {
+	assert.Equal(t, ctrl.Result{}, requeueSooner(ctrl.Result{}, 0))
+	assert.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, requeueSooner(ctrl.Result{}, time.Minute))
+	assert.Equal(t, ctrl.Result{RequeueAfter: time.Second}, requeueSooner(ctrl.Result{RequeueAfter: time.Second}, time.Minute))
+	assert.Equal(t, ctrl.Result{RequeueAfter: time.Second}, requeueSooner(ctrl.Result{RequeueAfter: time.Minute}, time.Second))
+}
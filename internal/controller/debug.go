@@ -0,0 +1,373 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// DebugServer serves the state of the running xDS servers over HTTP: the
+// snapshots set per node, the open streams, the last ACK/NACK per resource type
+// and the difference between the desired resources and the ones each node
+// acknowledged last
+type DebugServer struct {
+	// Addr is the address the debug server listens on
+	Addr string
+	// Token is the bearer token required on every request
+	Token string
+}
+
+type debugServerSummary struct {
+	Namespace    string   `json:"namespace"`
+	Name         string   `json:"name"`
+	Port         int      `json:"port"`
+	Streams      int      `json:"streams"`
+	SnapshotKeys []string `json:"snapshotKeys"`
+}
+
+type debugServerDetail struct {
+	debugServerSummary
+	Propagation *api.PropagationStatus `json:"propagation,omitempty"`
+	Nodes       []debugNode            `json:"nodes"`
+}
+
+type debugNode struct {
+	NodeID         string        `json:"nodeId"`
+	Cluster        string        `json:"cluster,omitempty"`
+	SnapshotKey    string        `json:"snapshotKey"`
+	DesiredVersion string        `json:"desiredVersion,omitempty"`
+	Streams        []debugStream `json:"streams"`
+}
+
+type debugStream struct {
+	ID      int64       `json:"id"`
+	TypeURL string      `json:"typeUrl,omitempty"`
+	Opened  time.Time   `json:"opened"`
+	Types   []debugType `json:"types"`
+}
+
+type debugType struct {
+	TypeURL            string     `json:"typeUrl"`
+	RequestedResources []string   `json:"requestedResources,omitempty"`
+	SentVersion        string     `json:"sentVersion,omitempty"`
+	AckedVersion       string     `json:"ackedVersion,omitempty"`
+	LastAckTime        *time.Time `json:"lastAckTime,omitempty"`
+	LastNackTime       *time.Time `json:"lastNackTime,omitempty"`
+	LastNackMessage    string     `json:"lastNackMessage,omitempty"`
+	Diff               *debugDiff `json:"diff,omitempty"`
+}
+
+// debugDiff lists the resources differing between the desired snapshot and
+// the last response acknowledged by a node
+type debugDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	InSync  bool     `json:"inSync"`
+}
+
+type debugSnapshot struct {
+	Key       string                                `json:"key"`
+	Versions  map[string]string                     `json:"versions"`
+	Resources map[string]map[string]json.RawMessage `json:"resources"`
+}
+
+func (d *DebugServer) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the debug endpoints until the context is cancelled
+func (d *DebugServer) Start(ctx context.Context) error {
+	log := ctrlLog.FromContext(ctx).WithName("debug")
+
+	srv := &http.Server{
+		Addr:              d.Addr,
+		Handler:           d.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info("Debug server listening", "addr", d.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("debug server exited: %w", err)
+	}
+}
+
+// Handler returns the authenticated handler of the debug endpoints
+func (d *DebugServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/servers", d.listServers)
+	mux.HandleFunc("GET /debug/servers/{namespace}/{name}", d.getServer)
+	mux.HandleFunc("GET /debug/servers/{namespace}/{name}/snapshots/{key...}", d.getSnapshot)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if d.Token == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(d.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="xds-debug"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func (d *DebugServer) listServers(w http.ResponseWriter, req *http.Request) {
+	serverManager.RLock()
+	servers := make([]*XDSServerInstance, 0, len(serverManager.servers))
+	for _, server := range serverManager.servers {
+		servers = append(servers, server)
+	}
+	serverManager.RUnlock()
+
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].namespace != servers[j].namespace {
+			return servers[i].namespace < servers[j].namespace
+		}
+		return servers[i].name < servers[j].name
+	})
+
+	summaries := make([]debugServerSummary, 0, len(servers))
+	for _, server := range servers {
+		summaries = append(summaries, server.debugSummary())
+	}
+	writeJSON(w, summaries)
+}
+
+func (d *DebugServer) getServer(w http.ResponseWriter, req *http.Request) {
+	server := lookupServer(req)
+	if server == nil {
+		http.Error(w, "xDS server not found", http.StatusNotFound)
+		return
+	}
+
+	detail := debugServerDetail{debugServerSummary: server.debugSummary()}
+	detail.Propagation, _ = server.tracker.status()
+
+	nodes := map[string]*debugNode{}
+	for _, stream := range server.callbacks.snapshotStreams() {
+		nodeID := stream.node.GetId()
+		node, ok := nodes[nodeID]
+		if !ok {
+			node = &debugNode{
+				NodeID:      nodeID,
+				Cluster:     stream.node.GetCluster(),
+				SnapshotKey: server.nodeHash.ID(stream.node),
+			}
+			nodes[nodeID] = node
+		}
+
+		var desired cache.ResourceSnapshot
+		if snapshot, err := server.cache.GetSnapshot(node.SnapshotKey); err == nil {
+			desired = snapshot
+		}
+		node.Streams = append(node.Streams, debugStreamState(stream, desired))
+		if desired != nil && node.DesiredVersion == "" {
+			node.DesiredVersion = desiredVersion(desired)
+		}
+	}
+
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	detail.Nodes = make([]debugNode, 0, len(ids))
+	for _, id := range ids {
+		detail.Nodes = append(detail.Nodes, *nodes[id])
+	}
+	writeJSON(w, detail)
+}
+
+func (d *DebugServer) getSnapshot(w http.ResponseWriter, req *http.Request) {
+	server := lookupServer(req)
+	if server == nil {
+		http.Error(w, "xDS server not found", http.StatusNotFound)
+		return
+	}
+
+	key := req.PathValue("key")
+	snapshot, err := server.cache.GetSnapshot(key)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("no snapshot set for %q", key), http.StatusNotFound)
+		return
+	}
+
+	out := debugSnapshot{
+		Key:       key,
+		Versions:  map[string]string{},
+		Resources: map[string]map[string]json.RawMessage{},
+	}
+	for _, typeURL := range persistedTypes {
+		out.Versions[typeURL] = snapshot.GetVersion(typeURL)
+		resources := map[string]json.RawMessage{}
+		for name, r := range snapshot.GetResources(typeURL) {
+			data, err := protojson.Marshal(r)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to marshal %s %q: %v", typeURL, name, err), http.StatusInternalServerError)
+				return
+			}
+			resources[name] = data
+		}
+		out.Resources[typeURL] = resources
+	}
+	writeJSON(w, out)
+}
+
+func lookupServer(req *http.Request) *XDSServerInstance {
+	serverManager.RLock()
+	defer serverManager.RUnlock()
+	return serverManager.servers[req.PathValue("namespace")+"/"+req.PathValue("name")]
+}
+
+func (s *XDSServerInstance) debugSummary() debugServerSummary {
+	return debugServerSummary{
+		Namespace:    s.namespace,
+		Name:         s.name,
+		Port:         s.port,
+		Streams:      len(s.callbacks.snapshotStreams()),
+		SnapshotKeys: s.snapshotKeys(),
+	}
+}
+
+// snapshotKeys returns the cache keys snapshots are set for
+func (s *XDSServerInstance) snapshotKeys() []string {
+	s.nodesMu.Lock()
+	var keys []string
+	if s.matcher != nil {
+		keys = append(keys, s.matcher.staticIDs()...)
+	}
+	for nodeID := range s.dynamicNodes {
+		keys = append(keys, nodeID)
+	}
+	s.nodesMu.Unlock()
+
+	keys = append(keys, s.nodeHash.keys()...)
+	sort.Strings(keys)
+	return keys
+}
+
+func debugStreamState(stream streamInfo, desired cache.ResourceSnapshot) debugStream {
+	out := debugStream{ID: stream.id, TypeURL: stream.typeURL, Opened: stream.opened}
+
+	typeURLs := make([]string, 0, len(stream.types))
+	for typeURL := range stream.types {
+		typeURLs = append(typeURLs, typeURL)
+	}
+	sort.Strings(typeURLs)
+
+	for _, typeURL := range typeURLs {
+		state := stream.types[typeURL]
+		t := debugType{
+			TypeURL:            typeURL,
+			RequestedResources: state.requested,
+			SentVersion:        state.sentVersion,
+			AckedVersion:       state.ackedVersion,
+			LastNackMessage:    state.nackMessage,
+		}
+		if !state.lastAck.IsZero() {
+			t.LastAckTime = &state.lastAck
+		}
+		if !state.lastNack.IsZero() {
+			t.LastNackTime = &state.lastNack
+		}
+		if desired != nil && state.acked != nil {
+			t.Diff = diffResources(desiredResources(desired, typeURL, state.requested), state.acked)
+		}
+		out.Types = append(out.Types, t)
+	}
+	return out
+}
+
+// desiredResources returns the resources of a type in the snapshot, limited to
+// the requested names for non-wildcard requests
+func desiredResources(snapshot cache.ResourceSnapshot, typeURL string, requested []string) map[string]types.Resource {
+	resources := snapshot.GetResources(typeURL)
+	if len(requested) == 0 {
+		return resources
+	}
+	filtered := make(map[string]types.Resource, len(requested))
+	for _, name := range requested {
+		if r, ok := resources[name]; ok {
+			filtered[name] = r
+		}
+	}
+	return filtered
+}
+
+func desiredVersion(snapshot cache.ResourceSnapshot) string {
+	for _, typeURL := range persistedTypes {
+		if version := snapshot.GetVersion(typeURL); version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+// diffResources compares the desired resources with the acknowledged ones
+func diffResources(desired map[string]types.Resource, acked []*anypb.Any) *debugDiff {
+	ackedByName := make(map[string]proto.Message, len(acked))
+	for _, a := range acked {
+		msg, err := a.UnmarshalNew()
+		if err != nil {
+			continue
+		}
+		ackedByName[cache.GetResourceName(msg)] = msg
+	}
+
+	diff := &debugDiff{}
+	for name, r := range desired {
+		msg, ok := ackedByName[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case !proto.Equal(r, msg):
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range ackedByName {
+		if _, ok := desired[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	diff.InSync = len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+	return diff
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestDebugServer(t *testing.T) {
+	ctx := context.Background()
+
+	server := newTestServerInstance()
+	server.name = "debug"
+	server.callbacks = newXDSCallbacks(server)
+
+	serverManager.Lock()
+	serverManager.servers["default/debug"] = server
+	serverManager.Unlock()
+	t.Cleanup(func() {
+		serverManager.Lock()
+		delete(serverManager.servers, "default/debug")
+		serverManager.Unlock()
+	})
+
+	snapshot, err := cache.NewSnapshot("2", map[res.Type][]types.Resource{
+		res.ClusterType: {
+			&cluster.Cluster{Name: "a", ConnectTimeout: durationpb.New(2)},
+			&cluster.Cluster{Name: "b"},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, server.cache.SetSnapshot(ctx, "envoy-1", snapshot))
+
+	// The node acknowledged an older version of the clusters
+	ackedA, err := anypb.New(&cluster.Cluster{Name: "a", ConnectTimeout: durationpb.New(1)})
+	require.NoError(t, err)
+	ackedC, err := anypb.New(&cluster.Cluster{Name: "c"})
+	require.NoError(t, err)
+
+	callbacks := server.callbacks
+	require.NoError(t, callbacks.OnStreamOpen(ctx, 1, res.ClusterType))
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "envoy-1"}, TypeUrl: res.ClusterType,
+	}))
+	callbacks.OnStreamResponse(ctx, 1, nil, &discovery.DiscoveryResponse{
+		TypeUrl: res.ClusterType, VersionInfo: "1", Nonce: "1", Resources: []*anypb.Any{ackedA, ackedC},
+	})
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		TypeUrl: res.ClusterType, VersionInfo: "1", ResponseNonce: "1",
+	}))
+
+	handler := (&DebugServer{Token: "secret"}).Handler()
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Requires token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, get("/debug/servers", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, get("/debug/servers", "wrong").Code)
+	})
+
+	t.Run("Lists servers", func(t *testing.T) {
+		rec := get("/debug/servers", "secret")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var servers []debugServerSummary
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &servers))
+		var found bool
+		for _, s := range servers {
+			if s.Namespace == "default" && s.Name == "debug" {
+				found = true
+				assert.Equal(t, 1, s.Streams)
+			}
+		}
+		assert.True(t, found)
+	})
+
+	t.Run("Diffs acknowledged resources", func(t *testing.T) {
+		rec := get("/debug/servers/default/debug", "secret")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var detail debugServerDetail
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &detail))
+		require.Len(t, detail.Nodes, 1)
+		node := detail.Nodes[0]
+		assert.Equal(t, "envoy-1", node.NodeID)
+		assert.Equal(t, "envoy-1", node.SnapshotKey)
+		assert.Equal(t, "2", node.DesiredVersion)
+
+		require.Len(t, node.Streams, 1)
+		require.Len(t, node.Streams[0].Types, 1)
+		state := node.Streams[0].Types[0]
+		assert.Equal(t, "1", state.AckedVersion)
+		require.NotNil(t, state.Diff)
+		assert.Equal(t, []string{"b"}, state.Diff.Added)
+		assert.Equal(t, []string{"c"}, state.Diff.Removed)
+		assert.Equal(t, []string{"a"}, state.Diff.Changed)
+		assert.False(t, state.Diff.InSync)
+	})
+
+	t.Run("Serves snapshot", func(t *testing.T) {
+		rec := get("/debug/servers/default/debug/snapshots/envoy-1", "secret")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var out debugSnapshot
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		assert.Equal(t, "2", out.Versions[res.ClusterType])
+		assert.Contains(t, out.Resources[res.ClusterType], "a")
+		assert.JSONEq(t, `{"name":"b"}`, string(out.Resources[res.ClusterType]["b"]))
+
+		assert.Equal(t, http.StatusNotFound, get("/debug/servers/default/debug/snapshots/unknown", "secret").Code)
+		assert.Equal(t, http.StatusNotFound, get("/debug/servers/default/missing", "secret").Code)
+	})
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"errors"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// permanentError marks a failure caused by the spec of the CR, such as an
+// invalid resource. Retrying does not help until the spec changes.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks an error as permanent
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// errorResult returns the result of a failed reconcile. Transient errors are
+// retried with the exponential backoff of the controller, permanent errors are
+// not retried until the CR changes again.
+func errorResult(err error) (ctrl.Result, error) {
+	if isPermanent(err) {
+		return ctrl.Result{}, reconcile.TerminalError(err)
+	}
+	return ctrl.Result{}, err
+}
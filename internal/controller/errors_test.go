@@ -0,0 +1,26 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestErrorResult(t *testing.T) {
+	transient := errors.New("connection refused")
+	result, err := errorResult(fmt.Errorf("failed to list nodes: %w", transient))
+	assert.Zero(t, result)
+	assert.ErrorIs(t, err, transient)
+	assert.False(t, errors.Is(err, reconcile.TerminalError(nil)))
+
+	invalid := errors.New("unknown filter")
+	result, err = errorResult(fmt.Errorf("failed to build listener: %w", permanent(invalid)))
+	assert.Zero(t, result)
+	assert.ErrorIs(t, err, invalid)
+	assert.True(t, errors.Is(err, reconcile.TerminalError(nil)))
+
+	assert.Nil(t, permanent(nil))
+}
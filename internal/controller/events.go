@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// Event reasons
+const (
+	EventReasonServerStarted    = "ServerStarted"
+	EventReasonServerStopped    = "ServerStopped"
+	EventReasonServerFailed     = "ServerFailed"
+	EventReasonPortChanged      = "PortChanged"
+	EventReasonAddressChanged   = "AddressChanged"
+	EventReasonSnapshotPushed   = "SnapshotPushed"
+	EventReasonEndpointsChanged = "EndpointsChanged"
+	EventReasonBuildFailed      = "SnapshotBuildFailed"
+	EventReasonResourcesSkipped = "ResourcesSkipped"
+	EventReasonBootstrapFailed  = "BootstrapFailed"
+	EventReasonDataPlaneFailed  = "DataPlaneFailed"
+
+	// Ingress events
+	EventReasonBackendNotFound  = "BackendNotFound"
+	EventReasonTLSSecretInvalid = "TLSSecretInvalid"
+	EventReasonIngressConflict  = "IngressConflict"
+)
+
+// eventf records an event on the object if the reconciler has a recorder
+func (r *XDSControlPlaneReconciler) eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
+
+// recordSnapshotEvents emits an event when the content of the pushed snapshots
+// or the endpoints of a cluster changed since the last push of the server
+func (r *XDSControlPlaneReconciler) recordSnapshotEvents(crd *api.XDSControlPlane, server *XDSServerInstance, snapshot *cache.Snapshot, hash string, nodeIDs []string) {
+	if hash != server.pushedHash {
+		server.pushedHash = hash
+		r.eventf(crd, corev1.EventTypeNormal, EventReasonSnapshotPushed,
+			"Pushed snapshot %s to %d node(s) and %d node group(s): %d clusters, %d endpoints, %d listeners, %d routes",
+			snapshotVersion(snapshot), len(nodeIDs), len(crd.Spec.NodeGroups),
+			len(snapshot.GetResources(res.ClusterType)), len(snapshot.GetResources(res.EndpointType)),
+			len(snapshot.GetResources(res.ListenerType)), len(snapshot.GetResources(res.RouteType)))
+	}
+
+	endpointSets := make(map[string][]string)
+	for name, r := range snapshot.GetResources(res.EndpointType) {
+		if cla, ok := r.(*endpoint.ClusterLoadAssignment); ok {
+			endpointSets[name] = endpointAddresses(cla)
+		}
+	}
+
+	// The endpoints found when the server started are not a change
+	if server.endpointSets != nil {
+		names := make([]string, 0, len(endpointSets))
+		for name := range endpointSets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			previous, known := server.endpointSets[name]
+			current := endpointSets[name]
+			if !known || strings.Join(previous, ",") == strings.Join(current, ",") {
+				continue
+			}
+			r.eventf(crd, corev1.EventTypeNormal, EventReasonEndpointsChanged,
+				"Endpoints of cluster %s changed from %d to %d: %s", name, len(previous), len(current), endpointDiff(previous, current))
+		}
+	}
+	server.endpointSets = endpointSets
+}
+
+// endpointAddresses returns the sorted socket addresses of a load assignment
+func endpointAddresses(cla *endpoint.ClusterLoadAssignment) []string {
+	var addrs []string
+	for _, lle := range cla.GetEndpoints() {
+		for _, lbe := range lle.GetLbEndpoints() {
+			address := lbe.GetEndpoint().GetAddress()
+			if internal := address.GetEnvoyInternalAddress(); internal != nil {
+				addrs = append(addrs, "internal:"+internal.GetServerListenerName())
+				continue
+			}
+			sa := address.GetSocketAddress()
+			addrs = append(addrs, fmt.Sprintf("%s:%d", sa.GetAddress(), sa.GetPortValue()))
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func endpointDiff(previous, current []string) string {
+	before := make(map[string]bool, len(previous))
+	for _, a := range previous {
+		before[a] = true
+	}
+	after := make(map[string]bool, len(current))
+	for _, a := range current {
+		after[a] = true
+	}
+
+	var changes []string
+	for _, a := range current {
+		if !before[a] {
+			changes = append(changes, "+"+a)
+		}
+	}
+	for _, a := range previous {
+		if !after[a] {
+			changes = append(changes, "-"+a)
+		}
+	}
+	return strings.Join(changes, " ")
+}
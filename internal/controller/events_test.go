@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func testLoadAssignment(addrs ...string) *endpoint.ClusterLoadAssignment {
+	cla := &endpoint.ClusterLoadAssignment{ClusterName: "backend"}
+	lle := &endpoint.LocalityLbEndpoints{}
+	for _, addr := range addrs {
+		lle.LbEndpoints = append(lle.LbEndpoints, &endpoint.LbEndpoint{
+			HostIdentifier: &endpoint.LbEndpoint_Endpoint{Endpoint: &endpoint.Endpoint{
+				Address: &core.Address{Address: &core.Address_SocketAddress{SocketAddress: &core.SocketAddress{
+					Address:       addr,
+					PortSpecifier: &core.SocketAddress_PortValue{PortValue: 8080},
+				}}},
+			}},
+		})
+	}
+	cla.Endpoints = []*endpoint.LocalityLbEndpoints{lle}
+	return cla
+}
+
+func TestRecordSnapshotEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &XDSControlPlaneReconciler{Recorder: recorder}
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	server := newTestServerInstance()
+
+	push := func(nodeIDs []string, cla *endpoint.ClusterLoadAssignment) {
+		snapshot, err := cache.NewSnapshot("1", map[res.Type][]types.Resource{res.ClusterType: nil, res.EndpointType: {cla}})
+		require.NoError(t, err)
+		state := &persistedState{NodeIDs: nodeIDs}
+		state.Default, err = encodeSnapshot(snapshot)
+		require.NoError(t, err)
+		reconciler.recordSnapshotEvents(crd, server, snapshot, state.resourceHash(), nodeIDs)
+	}
+
+	push([]string{"envoy-1"}, testLoadAssignment("10.0.0.1", "10.0.0.2"))
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Normal SnapshotPushed Pushed snapshot 1 to 1 node(s) and 0 node group(s): 0 clusters, 1 endpoints, 0 listeners, 0 routes", <-recorder.Events)
+
+	// Unchanged content does not emit events, even when served to other nodes
+	push([]string{"envoy-1"}, testLoadAssignment("10.0.0.1", "10.0.0.2"))
+	assert.Empty(t, recorder.Events)
+	push([]string{"envoy-1", "edge-envoy-7f9c-abcde"}, testLoadAssignment("10.0.0.1", "10.0.0.2"))
+	assert.Empty(t, recorder.Events)
+
+	push([]string{"envoy-1"}, testLoadAssignment("10.0.0.2", "10.0.0.3", "10.0.0.4"))
+	require.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, "SnapshotPushed")
+	assert.Equal(t, "Normal EndpointsChanged Endpoints of cluster backend changed from 2 to 3: +10.0.0.3:8080 +10.0.0.4:8080 -10.0.0.1:8080", <-recorder.Events)
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	// Failure policies
+	FailurePolicyAbort        = "Abort"
+	FailurePolicyKeepLastGood = "KeepLastGood"
+	FailurePolicySkipInvalid  = "SkipInvalid"
+
+	// Fallback decisions
+	FallbackKeptLastGood   = "KeptLastGood"
+	FallbackSkippedInvalid = "SkippedInvalid"
+)
+
+// skippedResource is a resource left out of a snapshot by the SkipInvalid policy
+type skippedResource struct {
+	Kind string
+	Name string
+	Err  error
+}
+
+func (s skippedResource) String() string {
+	return s.Kind + "/" + s.Name
+}
+
+func failurePolicy(crd *api.XDSControlPlane) string {
+	if crd.Spec.FailurePolicy == "" {
+		return FailurePolicyAbort
+	}
+	return crd.Spec.FailurePolicy
+}
+
+// skippedFallback returns the fallback status for a snapshot served without
+// the given resources, or nil if nothing was skipped
+func skippedFallback(skipped []skippedResource, version string) *api.FallbackStatus {
+	if len(skipped) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(skipped))
+	reasons := make([]string, 0, len(skipped))
+	for _, s := range skipped {
+		names = append(names, s.String())
+		reasons = append(reasons, fmt.Sprintf("%s: %v", s, s.Err))
+	}
+
+	now := metav1.Now()
+	return &api.FallbackStatus{
+		Decision:          FallbackSkippedInvalid,
+		Reason:            strings.Join(reasons, "; "),
+		ExcludedResources: names,
+		ServedVersion:     version,
+		Time:              &now,
+	}
+}
+
+// handleSnapshotFailure applies the failure policy of the CR after the
+// snapshot could not be built or set. With KeepLastGood the nodes keep the
+// snapshot they are served and the CR is marked Degraded instead of Error.
+// Permanent errors are not retried until the spec changes, transient ones are
+// retried with backoff, or every 30 seconds while the last-known-good snapshot
+// is served.
+func (r *XDSControlPlaneReconciler) handleSnapshotFailure(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance, buildErr error) (ctrl.Result, error) {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	server.nodesMu.Lock()
+	lastGood := server.defaultSnapshot
+	server.nodesMu.Unlock()
+
+	setEndpointsDiscoveryFailed(crd, buildErr)
+
+	if failurePolicy(crd) != FailurePolicyKeepLastGood || lastGood == nil {
+		log.Error(buildErr, "Error building xDS snapshot")
+		crd.Status.Fallback = nil
+		r.updateStatusFailed(ctx, crd, ConditionTypeSnapshot, ReasonBuildFailed, fmt.Sprintf("Failed to build snapshot: %v", buildErr))
+		return errorResult(buildErr)
+	}
+
+	version := snapshotVersion(lastGood)
+	log.Error(buildErr, "Error building xDS snapshot, keeping last-known-good snapshot", "version", version)
+
+	now := metav1.Now()
+	crd.Status.Fallback = keepFallbackTime(crd.Status.Fallback, &api.FallbackStatus{
+		Decision:      FallbackKeptLastGood,
+		Reason:        buildErr.Error(),
+		ServedVersion: version,
+		Time:          &now,
+	})
+
+	if _, err := r.updateStatusFailed(ctx, crd, ConditionTypeSnapshot, ReasonBuildFailed, buildErr.Error()); err != nil {
+		return ctrl.Result{}, err
+	}
+	if isPermanent(buildErr) {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Second * 30}, nil
+}
+
+// sameFallback reports whether two fallback decisions are equal apart from their time
+func sameFallback(a, b *api.FallbackStatus) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Decision == b.Decision && a.Reason == b.Reason && a.ServedVersion == b.ServedVersion &&
+		equality.Semantic.DeepEqual(a.ExcludedResources, b.ExcludedResources)
+}
+
+// keepFallbackTime returns next, with the time of the previous decision if it did not change
+func keepFallbackTime(previous, next *api.FallbackStatus) *api.FallbackStatus {
+	if next != nil && sameFallback(previous, next) {
+		next.Time = previous.Time
+	}
+	return next
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func newFailurePolicyCRD(policy string) *api.XDSControlPlane {
+	tcpProxy := func(cluster string) apiextensionsv1.JSON {
+		return apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy","stat_prefix":"tcp","cluster":"` + cluster + `"}`)}
+	}
+
+	return &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec: api.XDSControlPlaneSpec{
+			FailurePolicy: policy,
+			Clusters: []api.ClusterSpec{
+				{Name: "backend", Type: "static", LbPolicy: "round_robin"},
+			},
+			Listeners: []api.ListenerSpec{
+				{
+					Name: "good", Address: "0.0.0.0", Port: 8080,
+					FilterChains: []api.FilterChainSpec{{Filters: []api.FilterSpec{
+						{Name: "envoy.filters.network.tcp_proxy", TypedConfig: tcpProxy("backend")},
+					}}},
+				},
+				{
+					Name: "bad", Address: "0.0.0.0", Port: 8081,
+					FilterChains: []api.FilterChainSpec{{Filters: []api.FilterSpec{
+						{Name: "envoy.filters.network.tcp_proxy", TypedConfig: apiextensionsv1.JSON{
+							Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy","unknownField":true}`),
+						}},
+					}}},
+				},
+			},
+		},
+	}
+}
+
+func TestBuildSnapshotFailurePolicy(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+	ctx := context.Background()
+
+	t.Run("Abort", func(t *testing.T) {
+		_, _, err := reconciler.buildXDSSnapshot(ctx, newFailurePolicyCRD(""))
+		assert.ErrorContains(t, err, "failed to build listener bad")
+	})
+
+	t.Run("SkipInvalid", func(t *testing.T) {
+		snapshot, skipped, err := reconciler.buildXDSSnapshot(ctx, newFailurePolicyCRD(FailurePolicySkipInvalid))
+		require.NoError(t, err)
+
+		listeners := snapshot.GetResources(res.ListenerType)
+		assert.Contains(t, listeners, "good")
+		assert.NotContains(t, listeners, "bad")
+
+		require.Len(t, skipped, 1)
+		assert.Equal(t, "listener/bad", skipped[0].String())
+
+		fallback := skippedFallback(skipped, "1")
+		require.NotNil(t, fallback)
+		assert.Equal(t, FallbackSkippedInvalid, fallback.Decision)
+		assert.Equal(t, []string{"listener/bad"}, fallback.ExcludedResources)
+		assert.Nil(t, skippedFallback(nil, "1"))
+	})
+}
+
+func TestHandleSnapshotFailure(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+
+	setup := func(policy string) (*XDSControlPlaneReconciler, *api.XDSControlPlane) {
+		crd := newFailurePolicyCRD(policy)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).WithStatusSubresource(crd).Build()
+		return &XDSControlPlaneReconciler{Client: c, Scheme: scheme}, crd
+	}
+	buildErr := errors.New("failed to build cluster backend: failed to discover endpoints")
+
+	t.Run("KeepLastGood serves previous snapshot", func(t *testing.T) {
+		reconciler, crd := setup(FailurePolicyKeepLastGood)
+		server := newTestServerInstance()
+		lastGood, err := cache.NewSnapshot("41", map[res.Type][]types.Resource{res.ClusterType: nil})
+		require.NoError(t, err)
+		server.defaultSnapshot = lastGood
+
+		result, err := reconciler.handleSnapshotFailure(ctx, crd, server, buildErr)
+		require.NoError(t, err)
+		assert.NotZero(t, result.RequeueAfter)
+
+		assert.Equal(t, PhaseDegraded, crd.Status.Phase)
+		require.NotNil(t, crd.Status.Fallback)
+		assert.Equal(t, FallbackKeptLastGood, crd.Status.Fallback.Decision)
+		assert.Equal(t, "41", crd.Status.Fallback.ServedVersion)
+		assert.Contains(t, crd.Status.Fallback.Reason, "failed to discover endpoints")
+	})
+
+	t.Run("KeepLastGood without previous snapshot", func(t *testing.T) {
+		reconciler, crd := setup(FailurePolicyKeepLastGood)
+
+		_, err := reconciler.handleSnapshotFailure(ctx, crd, newTestServerInstance(), buildErr)
+		assert.ErrorIs(t, err, buildErr)
+		assert.Equal(t, PhaseError, crd.Status.Phase)
+		assert.Nil(t, crd.Status.Fallback)
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		reconciler, crd := setup(FailurePolicyAbort)
+		server := newTestServerInstance()
+		lastGood, err := cache.NewSnapshot("41", map[res.Type][]types.Resource{res.ClusterType: nil})
+		require.NoError(t, err)
+		server.defaultSnapshot = lastGood
+
+		_, err = reconciler.handleSnapshotFailure(ctx, crd, server, buildErr)
+		assert.ErrorIs(t, err, buildErr)
+		assert.Equal(t, PhaseError, crd.Status.Phase)
+	})
+}
+
+func TestKeepLastGoodNodeGroups(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := newFailurePolicyCRD(FailurePolicyKeepLastGood)
+	crd.Spec.XdsPort = freePort(t)
+	crd.Spec.NodeIDs = []string{"envoy-1"}
+	crd.Spec.Listeners = crd.Spec.Listeners[:1]
+	crd.Spec.NodeGroups = []api.NodeGroupSpec{{
+		Name:      "dc1",
+		Selector:  api.NodeSelectorSpec{NodeIDs: []string{"edge-dc1-*"}},
+		Listeners: []api.ResourcePatchSpec{{Name: "good", Patch: apiextensionsv1.JSON{Raw: []byte(`{"port": 9080}`)}}},
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).WithStatusSubresource(crd).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(crd)}
+	defer r.cleanupServer(req.String(), time.Second)
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	server := serverManager.servers[req.String()]
+	require.NotNil(t, server)
+
+	// A node group failing to build leaves the default snapshot untouched,
+	// although the default configuration builds
+	require.NoError(t, c.Get(ctx, req.NamespacedName, crd))
+	crd.Spec.Listeners[0].Port = 8082
+	crd.Spec.NodeGroups[0].Listeners[0].Name = "missing"
+	require.NoError(t, c.Update(ctx, crd))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	served, err := server.cache.GetSnapshot("envoy-1")
+	require.NoError(t, err)
+	l := served.(*cache.Snapshot).GetResources(res.ListenerType)["good"].(*listener.Listener)
+	assert.Equal(t, uint32(8080), l.Address.GetSocketAddress().GetPortValue())
+	require.NoError(t, c.Get(ctx, req.NamespacedName, crd))
+	assert.Equal(t, PhaseDegraded, crd.Status.Phase)
+	assert.Equal(t, FallbackKeptLastGood, crd.Status.Fallback.Decision)
+}
@@ -0,0 +1,809 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// The Gateway API objects are handled as unstructured objects, so that the
+// operator neither depends on the Gateway API module nor requires its CRDs.
+// The types below mirror the fields of the Gateway API the operator uses.
+
+const (
+	// GatewayControllerName is the controllerName of the GatewayClasses
+	// implemented by the operator
+	GatewayControllerName = "xds.okassov/gateway-controller"
+
+	gatewayGroup = "gateway.networking.k8s.io"
+)
+
+var (
+	gatewayClassGVK = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1", Kind: "GatewayClass"}
+	gatewayGVK      = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1", Kind: "Gateway"}
+	httpRouteGVK    = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1", Kind: "HTTPRoute"}
+	tcpRouteGVK     = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1alpha2", Kind: "TCPRoute"}
+)
+
+type gatewayClassSpec struct {
+	ControllerName string                      `json:"controllerName"`
+	ParametersRef  *gatewayParametersReference `json:"parametersRef,omitempty"`
+}
+
+type gatewayParametersReference struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type gatewaySpec struct {
+	GatewayClassName string            `json:"gatewayClassName"`
+	Listeners        []gatewayListener `json:"listeners"`
+}
+
+type gatewayListener struct {
+	Name          string                `json:"name"`
+	Hostname      string                `json:"hostname,omitempty"`
+	Port          int32                 `json:"port"`
+	Protocol      string                `json:"protocol"`
+	AllowedRoutes *gatewayAllowedRoutes `json:"allowedRoutes,omitempty"`
+}
+
+type gatewayAllowedRoutes struct {
+	Namespaces *gatewayRouteNamespaces `json:"namespaces,omitempty"`
+	Kinds      []gatewayRouteGroupKind `json:"kinds,omitempty"`
+}
+
+type gatewayRouteNamespaces struct {
+	From     string                `json:"from,omitempty"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type gatewayRouteGroupKind struct {
+	Group *string `json:"group,omitempty"`
+	Kind  string  `json:"kind"`
+}
+
+type routeSpec struct {
+	ParentRefs []parentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+	Rules      []routeRule       `json:"rules,omitempty"`
+}
+
+type parentReference struct {
+	Group       *string `json:"group,omitempty"`
+	Kind        *string `json:"kind,omitempty"`
+	Namespace   *string `json:"namespace,omitempty"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName,omitempty"`
+	Port        *int32  `json:"port,omitempty"`
+}
+
+type routeRule struct {
+	Matches     []httpRouteMatch `json:"matches,omitempty"`
+	BackendRefs []backendRef     `json:"backendRefs,omitempty"`
+}
+
+type httpRouteMatch struct {
+	Path    *httpPathMatch    `json:"path,omitempty"`
+	Headers []httpHeaderMatch `json:"headers,omitempty"`
+	Method  string            `json:"method,omitempty"`
+}
+
+type httpPathMatch struct {
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+type httpHeaderMatch struct {
+	Type  string `json:"type,omitempty"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type backendRef struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+	Port      *int32  `json:"port,omitempty"`
+	Weight    *int32  `json:"weight,omitempty"`
+}
+
+type gatewayStatusAddress struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type gatewayListenerStatus struct {
+	Name           string                  `json:"name"`
+	SupportedKinds []gatewayRouteGroupKind `json:"supportedKinds"`
+	AttachedRoutes int32                   `json:"attachedRoutes"`
+	Conditions     []metav1.Condition      `json:"conditions"`
+}
+
+type routeParentStatus struct {
+	ParentRef      parentReference    `json:"parentRef"`
+	ControllerName string             `json:"controllerName"`
+	Conditions     []metav1.Condition `json:"conditions"`
+}
+
+// Gateway API condition types and reasons
+const (
+	gatewayConditionAccepted     = "Accepted"
+	gatewayConditionProgrammed   = "Programmed"
+	gatewayConditionResolvedRefs = "ResolvedRefs"
+	gatewayConditionConflicted   = "Conflicted"
+
+	gatewayReasonAccepted                   = "Accepted"
+	gatewayReasonProgrammed                 = "Programmed"
+	gatewayReasonPending                    = "Pending"
+	gatewayReasonInvalid                    = "Invalid"
+	gatewayReasonInvalidParameters          = "InvalidParameters"
+	gatewayReasonResolvedRefs               = "ResolvedRefs"
+	gatewayReasonInvalidRouteKinds          = "InvalidRouteKinds"
+	gatewayReasonUnsupportedProtocol        = "UnsupportedProtocol"
+	gatewayReasonProtocolConflict           = "ProtocolConflict"
+	gatewayReasonNoConflicts                = "NoConflicts"
+	gatewayReasonNotAllowedByListeners      = "NotAllowedByListeners"
+	gatewayReasonNoMatchingListenerHostname = "NoMatchingListenerHostname"
+	gatewayReasonNoMatchingParent           = "NoMatchingParent"
+	gatewayReasonUnsupportedValue           = "UnsupportedValue"
+	gatewayReasonBackendNotFound            = "BackendNotFound"
+	gatewayReasonInvalidKind                = "InvalidKind"
+	gatewayReasonRefNotPermitted            = "RefNotPermitted"
+)
+
+func newGatewayObject(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	return obj
+}
+
+func newGatewayObjectList(gvk schema.GroupVersionKind) *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	return list
+}
+
+// decodeField decodes a field of an unstructured object, a missing field
+// decodes to the zero value
+func decodeField(obj *unstructured.Unstructured, out interface{}, fields ...string) error {
+	value, found, err := unstructured.NestedFieldNoCopy(obj.Object, fields...)
+	if err != nil || !found {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid %s of %s %s: %w", strings.Join(fields, "."), obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
+
+// encodeField sets a field of an unstructured object
+func encodeField(obj *unstructured.Unstructured, in interface{}, fields ...string) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return unstructured.SetNestedField(obj.Object, value, fields...)
+}
+
+// gatewayRoute is an HTTPRoute or a TCPRoute with one of its parentRefs
+// referring to the Gateway being translated
+type gatewayRoute struct {
+	obj  *unstructured.Unstructured
+	spec routeSpec
+
+	// parents holds the outcome of each parentRef referring to the Gateway
+	parents []routeParent
+	// resolvedRefs is the outcome of the resolution of the backendRefs
+	resolvedRefs metav1.Condition
+}
+
+type routeParent struct {
+	ref      parentReference
+	accepted metav1.Condition
+}
+
+// gatewayResources are the objects a Gateway translation depends on
+type gatewayResources struct {
+	// namespaces holds the labels of the namespaces of the routes
+	namespaces map[string]map[string]string
+	services   map[client.ObjectKey]*corev1.Service
+	// controlPlane is the XDSControlPlane serving the Gateway
+	controlPlane client.ObjectKey
+}
+
+// gatewayTranslation is the xDS configuration of a Gateway
+type gatewayTranslation struct {
+	translatedResources
+	// listenerStatus holds the status of each Gateway listener, without the
+	// Programmed condition which depends on the control plane
+	listenerStatus []gatewayListenerStatus
+}
+
+func gatewayResourcePrefix(gw *unstructured.Unstructured) string {
+	return fmt.Sprintf("gateway/%s/%s", gw.GetNamespace(), gw.GetName())
+}
+
+// supportedRouteKind returns the route kind served by a listener protocol
+func supportedRouteKind(protocol string) string {
+	switch protocol {
+	case "HTTP":
+		return httpRouteGVK.Kind
+	case "TCP":
+		return tcpRouteGVK.Kind
+	}
+	return ""
+}
+
+// refersToGateway reports whether a parentRef of a route refers to the Gateway
+func refersToGateway(route *unstructured.Unstructured, ref parentReference, gw *unstructured.Unstructured) bool {
+	if ref.Group != nil && *ref.Group != gatewayGroup {
+		return false
+	}
+	if ref.Kind != nil && *ref.Kind != gatewayGVK.Kind {
+		return false
+	}
+	namespace := route.GetNamespace()
+	if ref.Namespace != nil && *ref.Namespace != "" {
+		namespace = *ref.Namespace
+	}
+	return namespace == gw.GetNamespace() && ref.Name == gw.GetName()
+}
+
+// routeAllowed reports whether a listener accepts routes of the kind and namespace of a route
+func routeAllowed(gw *unstructured.Unstructured, l gatewayListener, route *unstructured.Unstructured, resources *gatewayResources) bool {
+	kind := route.GetKind()
+	if kind != supportedRouteKind(l.Protocol) {
+		return false
+	}
+	if l.AllowedRoutes == nil {
+		return route.GetNamespace() == gw.GetNamespace()
+	}
+	if len(l.AllowedRoutes.Kinds) > 0 {
+		found := false
+		for _, k := range l.AllowedRoutes.Kinds {
+			if k.Kind == kind && (k.Group == nil || *k.Group == gatewayGroup) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	namespaces := l.AllowedRoutes.Namespaces
+	switch {
+	case namespaces == nil || namespaces.From == "" || namespaces.From == "Same":
+		return route.GetNamespace() == gw.GetNamespace()
+	case namespaces.From == "All":
+		return true
+	case namespaces.From == "Selector" && namespaces.Selector != nil:
+		selector, err := metav1.LabelSelectorAsSelector(namespaces.Selector)
+		if err != nil {
+			return false
+		}
+		return selector.Matches(labels.Set(resources.namespaces[route.GetNamespace()]))
+	}
+	return false
+}
+
+// hostnameMatches reports whether host is matched by pattern, which may be a
+// wildcard hostname
+func hostnameMatches(pattern, host string) bool {
+	if pattern == host {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(pattern, "*")
+	if !ok {
+		return false
+	}
+	return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+}
+
+// routeHostnames returns the hostnames a route is served for on a listener,
+// nil if none of the hostnames of the route match the listener
+func routeHostnames(listenerHostname string, hostnames []string) []string {
+	switch {
+	case listenerHostname == "" && len(hostnames) == 0:
+		return []string{"*"}
+	case listenerHostname == "":
+		return hostnames
+	case len(hostnames) == 0:
+		return []string{listenerHostname}
+	}
+
+	var out []string
+	seen := make(map[string]bool)
+	for _, h := range hostnames {
+		match := ""
+		switch {
+		case hostnameMatches(listenerHostname, h):
+			match = h
+		case hostnameMatches(h, listenerHostname):
+			match = listenerHostname
+		}
+		if match != "" && !seen[match] {
+			seen[match] = true
+			out = append(out, match)
+		}
+	}
+	return out
+}
+
+func condition(conditionType string, status metav1.ConditionStatus, reason, message string) metav1.Condition {
+	return metav1.Condition{Type: conditionType, Status: status, Reason: reason, Message: message}
+}
+
+// weightedCluster is a backend of a route rule
+type weightedCluster struct {
+	name   string
+	weight int32
+}
+
+// resolveBackends returns the clusters of the backendRefs of a rule, adding
+// the clusters to the translation. Unresolved backends are left out and
+// reported in the ResolvedRefs condition of the route.
+func (t *gatewayTranslation) resolveBackends(gw *unstructured.Unstructured, route *gatewayRoute, refs []backendRef, resources *gatewayResources) []weightedCluster {
+	var backends []weightedCluster
+	for _, ref := range refs {
+		if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != "Service") {
+			route.resolvedRefs = condition(gatewayConditionResolvedRefs, metav1.ConditionFalse, gatewayReasonInvalidKind,
+				fmt.Sprintf("Backend %s is not a Service", ref.Name))
+			continue
+		}
+		namespace := route.obj.GetNamespace()
+		if ref.Namespace != nil && *ref.Namespace != "" && *ref.Namespace != namespace {
+			route.resolvedRefs = condition(gatewayConditionResolvedRefs, metav1.ConditionFalse, gatewayReasonRefNotPermitted,
+				fmt.Sprintf("Backend %s/%s is in another namespace", *ref.Namespace, ref.Name))
+			continue
+		}
+		if ref.Port == nil {
+			route.resolvedRefs = condition(gatewayConditionResolvedRefs, metav1.ConditionFalse, gatewayReasonUnsupportedValue,
+				fmt.Sprintf("Backend %s has no port", ref.Name))
+			continue
+		}
+
+		svc, ok := resources.services[client.ObjectKey{Namespace: namespace, Name: ref.Name}]
+		found := false
+		if ok {
+			for _, p := range svc.Spec.Ports {
+				found = found || p.Port == *ref.Port
+			}
+		}
+		if !found {
+			route.resolvedRefs = condition(gatewayConditionResolvedRefs, metav1.ConditionFalse, gatewayReasonBackendNotFound,
+				fmt.Sprintf("Service %s with port %d not found", ref.Name, *ref.Port))
+			continue
+		}
+
+		weight := int32(1)
+		if ref.Weight != nil {
+			weight = *ref.Weight
+		}
+		if weight == 0 {
+			continue
+		}
+
+		name := fmt.Sprintf("%s/service/%s/%s/%d", gatewayResourcePrefix(gw), namespace, ref.Name, *ref.Port)
+		t.addCluster(api.ClusterSpec{
+			Name:     name,
+			Type:     "static",
+			LbPolicy: "round_robin",
+			LoadAssignment: &api.LoadAssignmentSpec{EndpointsFrom: &api.EndpointSelectorSpec{
+				Type:      "Service",
+				Name:      ref.Name,
+				Namespace: namespace,
+				Port:      int(*ref.Port),
+			}},
+		})
+		backends = append(backends, weightedCluster{name: name, weight: weight})
+	}
+	return backends
+}
+
+// clusterAction returns the destination of a route or a TCP proxy
+func clusterAction(backends []weightedCluster) map[string]interface{} {
+	if len(backends) == 1 {
+		return map[string]interface{}{"cluster": backends[0].name}
+	}
+	clusters := make([]interface{}, 0, len(backends))
+	for _, b := range backends {
+		clusters = append(clusters, map[string]interface{}{"name": b.name, "weight": b.weight})
+	}
+	return map[string]interface{}{"weighted_clusters": map[string]interface{}{"clusters": clusters}}
+}
+
+func rawJSON(v interface{}) apiextensionsv1.JSON {
+	data, _ := json.Marshal(v)
+	return apiextensionsv1.JSON{Raw: data}
+}
+
+// httpRouteEntry is an Envoy route with the keys ordering it by the
+// precedence rules of the Gateway API
+type httpRouteEntry struct {
+	route map[string]interface{}
+
+	pathRank   int
+	pathLength int
+	method     bool
+	headers    int
+	created    metav1.Time
+	key        string
+	rule       int
+	match      int
+}
+
+func httpRouteEntryLess(a, b httpRouteEntry) bool {
+	switch {
+	case a.pathRank != b.pathRank:
+		return a.pathRank < b.pathRank
+	case a.pathLength != b.pathLength:
+		return a.pathLength > b.pathLength
+	case a.method != b.method:
+		return a.method
+	case a.headers != b.headers:
+		return a.headers > b.headers
+	case !a.created.Equal(&b.created):
+		return a.created.Before(&b.created)
+	case a.key != b.key:
+		return a.key < b.key
+	case a.rule != b.rule:
+		return a.rule < b.rule
+	}
+	return a.match < b.match
+}
+
+// envoyRouteMatch translates an HTTPRoute match, it returns an error for
+// values Envoy cannot match
+func envoyRouteMatch(m httpRouteMatch) (map[string]interface{}, int, int, error) {
+	match := map[string]interface{}{}
+	pathType, value := "PathPrefix", "/"
+	if m.Path != nil {
+		if m.Path.Type != "" {
+			pathType = m.Path.Type
+		}
+		if m.Path.Value != "" {
+			value = m.Path.Value
+		}
+	}
+
+	rank := 0
+	switch pathType {
+	case "Exact":
+		match["path"] = value
+	case "PathPrefix":
+		rank = 1
+		// Prefixes match whole path segments
+		if trimmed := strings.TrimSuffix(value, "/"); trimmed == "" {
+			match["prefix"] = "/"
+		} else {
+			match["path_separated_prefix"] = trimmed
+		}
+	case "RegularExpression":
+		rank = 2
+		match["safe_regex"] = map[string]interface{}{"regex": value}
+	default:
+		return nil, 0, 0, fmt.Errorf("unsupported path match type %s", pathType)
+	}
+
+	var headers []interface{}
+	for _, h := range m.Headers {
+		switch h.Type {
+		case "", "Exact":
+			headers = append(headers, map[string]interface{}{"name": h.Name, "string_match": map[string]interface{}{"exact": h.Value}})
+		case "RegularExpression":
+			headers = append(headers, map[string]interface{}{"name": h.Name, "string_match": map[string]interface{}{
+				"safe_regex": map[string]interface{}{"regex": h.Value},
+			}})
+		default:
+			return nil, 0, 0, fmt.Errorf("unsupported header match type %s", h.Type)
+		}
+	}
+	if m.Method != "" {
+		headers = append(headers, map[string]interface{}{"name": ":method", "string_match": map[string]interface{}{"exact": m.Method}})
+	}
+	if len(headers) > 0 {
+		match["headers"] = headers
+	}
+	return match, rank, len(value), nil
+}
+
+// translateGateway translates a Gateway and the routes referring to it into
+// listeners, clusters and route configurations. Gateway listeners sharing a
+// port are served by a single Envoy listener.
+func translateGateway(gw *unstructured.Unstructured, spec gatewaySpec, routes []*gatewayRoute, resources *gatewayResources) *gatewayTranslation {
+	t := &gatewayTranslation{}
+	prefix := gatewayResourcePrefix(gw)
+
+	for _, route := range routes {
+		route.resolvedRefs = condition(gatewayConditionResolvedRefs, metav1.ConditionTrue, gatewayReasonResolvedRefs, "All references resolved")
+	}
+
+	// Protocols of the ports, a port serves a single protocol
+	protocols := make(map[int32]string)
+	for _, l := range spec.Listeners {
+		if supportedRouteKind(l.Protocol) == "" {
+			continue
+		}
+		if _, ok := protocols[l.Port]; !ok {
+			protocols[l.Port] = l.Protocol
+		}
+	}
+
+	// Virtual hosts of the HTTP ports by domain, and the backends of the TCP ports
+	vhosts := make(map[int32]map[string][]httpRouteEntry)
+	tcpBackends := make(map[int32][]weightedCluster)
+	tcpRoutes := make(map[int32]*gatewayRoute)
+	var ports []int32
+	seenPorts := make(map[int32]bool)
+
+	// Envoy routes of each HTTPRoute, and the domains they were added to
+	httpEntries := make(map[*gatewayRoute][]httpRouteEntry)
+	httpErrors := make(map[*gatewayRoute]error)
+	added := make(map[string]bool)
+
+	for _, l := range spec.Listeners {
+		status := gatewayListenerStatus{Name: l.Name, SupportedKinds: []gatewayRouteGroupKind{}}
+		kind := supportedRouteKind(l.Protocol)
+
+		switch {
+		case kind == "":
+			status.Conditions = append(status.Conditions,
+				condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonUnsupportedProtocol,
+					fmt.Sprintf("Protocol %s is not supported, use HTTP or TCP", l.Protocol)),
+				condition(gatewayConditionConflicted, metav1.ConditionFalse, gatewayReasonNoConflicts, ""),
+				condition(gatewayConditionResolvedRefs, metav1.ConditionTrue, gatewayReasonResolvedRefs, ""))
+			t.listenerStatus = append(t.listenerStatus, status)
+			continue
+		case protocols[l.Port] != l.Protocol:
+			status.Conditions = append(status.Conditions,
+				condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonProtocolConflict,
+					fmt.Sprintf("Port %d is used with protocol %s by another listener", l.Port, protocols[l.Port])),
+				condition(gatewayConditionConflicted, metav1.ConditionTrue, gatewayReasonProtocolConflict,
+					fmt.Sprintf("Port %d is used with protocol %s by another listener", l.Port, protocols[l.Port])),
+				condition(gatewayConditionResolvedRefs, metav1.ConditionTrue, gatewayReasonResolvedRefs, ""))
+			t.listenerStatus = append(t.listenerStatus, status)
+			continue
+		}
+
+		resolved := condition(gatewayConditionResolvedRefs, metav1.ConditionTrue, gatewayReasonResolvedRefs, "")
+		if l.AllowedRoutes != nil && len(l.AllowedRoutes.Kinds) > 0 {
+			supported := false
+			for _, k := range l.AllowedRoutes.Kinds {
+				supported = supported || (k.Kind == kind && (k.Group == nil || *k.Group == gatewayGroup))
+			}
+			if !supported {
+				resolved = condition(gatewayConditionResolvedRefs, metav1.ConditionFalse, gatewayReasonInvalidRouteKinds,
+					fmt.Sprintf("Protocol %s only supports %s", l.Protocol, kind))
+			}
+		}
+		group := gatewayGroup
+		status.SupportedKinds = []gatewayRouteGroupKind{{Group: &group, Kind: kind}}
+		status.Conditions = append(status.Conditions,
+			condition(gatewayConditionAccepted, metav1.ConditionTrue, gatewayReasonAccepted, ""),
+			condition(gatewayConditionConflicted, metav1.ConditionFalse, gatewayReasonNoConflicts, ""),
+			resolved)
+
+		if !seenPorts[l.Port] {
+			seenPorts[l.Port] = true
+			ports = append(ports, l.Port)
+		}
+		if kind == httpRouteGVK.Kind && vhosts[l.Port] == nil {
+			vhosts[l.Port] = make(map[string][]httpRouteEntry)
+		}
+
+		for _, route := range routes {
+			if route.obj.GetKind() != kind {
+				continue
+			}
+			for i := range route.parents {
+				parent := &route.parents[i]
+				ref := parent.ref
+				if ref.SectionName != nil && *ref.SectionName != l.Name {
+					continue
+				}
+				if ref.Port != nil && *ref.Port != l.Port {
+					continue
+				}
+				if !routeAllowed(gw, l, route.obj, resources) {
+					if parent.accepted.Reason == "" {
+						parent.accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonNotAllowedByListeners,
+							"The route is not allowed by the listeners of the Gateway")
+					}
+					continue
+				}
+
+				if kind == tcpRouteGVK.Kind {
+					if existing := tcpRoutes[l.Port]; existing != nil && existing != route {
+						parent.accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonUnsupportedValue,
+							fmt.Sprintf("Listener %s already serves TCPRoute %s", l.Name, existing.obj.GetName()))
+						continue
+					}
+					if tcpRoutes[l.Port] == nil {
+						tcpRoutes[l.Port] = route
+						for _, rule := range route.spec.Rules {
+							tcpBackends[l.Port] = append(tcpBackends[l.Port], t.resolveBackends(gw, route, rule.BackendRefs, resources)...)
+						}
+					}
+				} else {
+					hostnames := routeHostnames(l.Hostname, route.spec.Hostnames)
+					if len(hostnames) == 0 {
+						if parent.accepted.Status != metav1.ConditionTrue {
+							parent.accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonNoMatchingListenerHostname,
+								"No hostname of the route matches the listeners of the Gateway")
+						}
+						continue
+					}
+					if _, ok := httpEntries[route]; !ok && httpErrors[route] == nil {
+						httpEntries[route], httpErrors[route] = t.translateHTTPRoute(gw, route, resources)
+					}
+					if err := httpErrors[route]; err != nil {
+						parent.accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonUnsupportedValue, err.Error())
+						continue
+					}
+					for _, h := range hostnames {
+						key := fmt.Sprintf("%s/%d/%s", client.ObjectKeyFromObject(route.obj), l.Port, h)
+						if !added[key] {
+							added[key] = true
+							vhosts[l.Port][h] = append(vhosts[l.Port][h], httpEntries[route]...)
+						}
+					}
+				}
+				parent.accepted = condition(gatewayConditionAccepted, metav1.ConditionTrue, gatewayReasonAccepted, "")
+				status.AttachedRoutes++
+			}
+		}
+		t.listenerStatus = append(t.listenerStatus, status)
+	}
+
+	for _, route := range routes {
+		for i := range route.parents {
+			if route.parents[i].accepted.Reason == "" {
+				route.parents[i].accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonNoMatchingParent,
+					"No listener of the Gateway matches the parentRef")
+			}
+		}
+	}
+
+	for _, port := range ports {
+		name := fmt.Sprintf("%s/%d", prefix, port)
+		var filter map[string]interface{}
+
+		if domains, ok := vhosts[port]; ok {
+			filter = rdsConnectionManager(name, name)
+			t.routes = append(t.routes, httpRouteConfig(name, domains))
+		} else {
+			filter = map[string]interface{}{
+				"@type":       "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
+				"stat_prefix": strings.ReplaceAll(name, "/", "_"),
+			}
+			if backends := tcpBackends[port]; len(backends) > 0 {
+				for k, v := range clusterAction(backends) {
+					filter[k] = v
+				}
+			} else {
+				// Connections are closed until a backend is available
+				filter["cluster"] = name + "/unavailable"
+				t.addCluster(api.ClusterSpec{Name: name + "/unavailable", Type: "static", LbPolicy: "round_robin"})
+			}
+		}
+
+		filterName := "envoy.filters.network.tcp_proxy"
+		if _, ok := vhosts[port]; ok {
+			filterName = "envoy.filters.network.http_connection_manager"
+		}
+		t.listeners = append(t.listeners, api.ListenerSpec{
+			Name:    name,
+			Address: "0.0.0.0",
+			Port:    int(port),
+			FilterChains: []api.FilterChainSpec{{
+				Filters: []api.FilterSpec{{Name: filterName, TypedConfig: rawJSON(filter)}},
+			}},
+		})
+	}
+	return t
+}
+
+// rdsConnectionManager returns an HttpConnectionManager routing with the
+// route configuration of the given name, served over ADS
+func rdsConnectionManager(statPrefix, routeConfig string) map[string]interface{} {
+	return map[string]interface{}{
+		"@type":               "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+		"stat_prefix":         strings.ReplaceAll(statPrefix, "/", "_"),
+		"strip_any_host_port": true,
+		"rds": map[string]interface{}{
+			"route_config_name": routeConfig,
+			"config_source": map[string]interface{}{
+				"ads":                  map[string]interface{}{},
+				"resource_api_version": "V3",
+			},
+		},
+		"http_filters": []interface{}{map[string]interface{}{
+			"name":         "envoy.filters.http.router",
+			"typed_config": map[string]interface{}{"@type": "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router"},
+		}},
+	}
+}
+
+// translateHTTPRoute returns the Envoy routes of the rules of an HTTPRoute
+func (t *gatewayTranslation) translateHTTPRoute(gw *unstructured.Unstructured, route *gatewayRoute, resources *gatewayResources) ([]httpRouteEntry, error) {
+	var entries []httpRouteEntry
+	for ri, rule := range route.spec.Rules {
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []httpRouteMatch{{}}
+		}
+		backends := t.resolveBackends(gw, route, rule.BackendRefs, resources)
+
+		for mi, m := range matches {
+			match, rank, length, err := envoyRouteMatch(m)
+			if err != nil {
+				return nil, err
+			}
+			envoyRoute := map[string]interface{}{"match": match}
+			if len(backends) == 0 {
+				// Requests to unresolved backends fail as the Gateway API requires
+				envoyRoute["direct_response"] = map[string]interface{}{"status": 500}
+			} else {
+				envoyRoute["route"] = clusterAction(backends)
+			}
+			entries = append(entries, httpRouteEntry{
+				route:      envoyRoute,
+				pathRank:   rank,
+				pathLength: length,
+				method:     m.Method != "",
+				headers:    len(m.Headers),
+				created:    route.obj.GetCreationTimestamp(),
+				key:        client.ObjectKeyFromObject(route.obj).String(),
+				rule:       ri,
+				match:      mi,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// httpRouteConfig returns the route configuration of an HTTP port with a
+// virtual host per domain, routes ordered by precedence
+func httpRouteConfig(name string, domains map[string][]httpRouteEntry) api.RouteConfigSpec {
+	names := make([]string, 0, len(domains))
+	for domain := range domains {
+		names = append(names, domain)
+	}
+	sort.Strings(names)
+
+	rc := api.RouteConfigSpec{Name: name, VirtualHosts: []api.VirtualHostSpec{}}
+	for _, domain := range names {
+		entries := domains[domain]
+		sort.SliceStable(entries, func(i, j int) bool { return httpRouteEntryLess(entries[i], entries[j]) })
+
+		vh := api.VirtualHostSpec{Name: domain, Domains: []string{domain}, Routes: []apiextensionsv1.JSON{}}
+		for _, e := range entries {
+			vh.Routes = append(vh.Routes, rawJSON(e.route))
+		}
+		rc.VirtualHosts = append(rc.VirtualHosts, vh)
+	}
+	return rc
+}
@@ -0,0 +1,591 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// GatewayLabel is set on the resources translated from a Gateway to its name
+const GatewayLabel = "xds.okassov/gateway"
+
+// GatewayReconciler translates the Gateways of the GatewayClasses of
+// GatewayControllerName and their routes into XDSListener, XDSCluster and
+// XDSRouteConfig resources owned by the Gateway. The resources attach to the
+// XDSControlPlane referenced by the parametersRef of the GatewayClass, whose
+// xDS server serves them.
+type GatewayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// TCPRoutes enables TCPRoutes, whose CRD is only part of the experimental
+	// channel of the Gateway API
+	TCPRoutes bool
+}
+
+// GatewayAPIInstalled reports whether the Gateway and the TCPRoute CRDs are installed
+func GatewayAPIInstalled(mapper meta.RESTMapper) (gateways, tcpRoutes bool) {
+	_, err := mapper.RESTMapping(gatewayGVK.GroupKind(), gatewayGVK.Version)
+	gateways = err == nil
+	_, err = mapper.RESTMapping(tcpRouteGVK.GroupKind(), tcpRouteGVK.Version)
+	return gateways, gateways && err == nil
+}
+
+// SetupWithManager watches Gateways and the objects their translation depends
+// on. The translated resources are owned, so that the Programmed condition
+// follows their acceptance by the control plane.
+func (r *GatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	specChanged := builder.WithPredicates(predicate.GenerationChangedPredicate{})
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("gateway").
+		For(newGatewayObject(gatewayGVK), specChanged).
+		Owns(&api.XDSListener{}).
+		Owns(&api.XDSCluster{}).
+		Owns(&api.XDSRouteConfig{}).
+		Watches(newGatewayObject(gatewayClassGVK), handler.EnqueueRequestsFromMapFunc(r.gatewaysForClass), specChanged).
+		Watches(newGatewayObject(httpRouteGVK), handler.EnqueueRequestsFromMapFunc(r.gatewaysForRoute), specChanged).
+		Watches(&api.XDSControlPlane{}, handler.EnqueueRequestsFromMapFunc(r.gatewaysForControlPlane), specChanged).
+		// Services have no generation
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(r.gatewaysForService))
+	if r.TCPRoutes {
+		b = b.Watches(newGatewayObject(tcpRouteGVK), handler.EnqueueRequestsFromMapFunc(r.gatewaysForRoute), specChanged)
+	}
+	return b.Complete(r)
+}
+
+func (r *GatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrlLog.FromContext(ctx).WithValues("gateway", req.NamespacedName)
+
+	// The translated resources of deleted Gateways are garbage collected
+	gw := newGatewayObject(gatewayGVK)
+	if err := r.Get(ctx, req.NamespacedName, gw); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	var spec gatewaySpec
+	if err := decodeField(gw, &spec, "spec"); err != nil {
+		return errorResult(permanent(err))
+	}
+
+	class := newGatewayObject(gatewayClassGVK)
+	if err := r.Get(ctx, client.ObjectKey{Name: spec.GatewayClassName}, class); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	var classSpec gatewayClassSpec
+	if err := decodeField(class, &classSpec, "spec"); err != nil {
+		return errorResult(permanent(err))
+	}
+	if classSpec.ControllerName != GatewayControllerName {
+		return ctrl.Result{}, nil
+	}
+
+	cp, classErr := r.gatewayControlPlane(ctx, classSpec)
+	if classErr != nil && !isPermanent(classErr) {
+		return errorResult(classErr)
+	}
+	if err := r.updateClassStatus(ctx, class, classErr); err != nil {
+		return errorResult(err)
+	}
+
+	routes, stale, err := r.gatewayRoutes(ctx, gw)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	var (
+		translation = &gatewayTranslation{}
+		children    []client.Object
+		accepted    = condition(gatewayConditionAccepted, metav1.ConditionTrue, gatewayReasonAccepted, "Gateway is accepted")
+	)
+	if classErr != nil {
+		// Nothing is served without a control plane
+		accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonInvalidParameters, classErr.Error())
+		for _, route := range routes {
+			for i := range route.parents {
+				route.parents[i].accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonPending,
+					"The Gateway is not accepted")
+			}
+		}
+	} else {
+		resources, err := r.gatewayResources(ctx, cp, routes)
+		if err != nil {
+			return errorResult(err)
+		}
+		translation = translateGateway(gw, spec, routes, resources)
+	}
+
+	children, err = r.writeGatewayResources(ctx, gw, cp, translation)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	programmed := gatewayProgrammed(children, cp)
+	if classErr != nil {
+		programmed = condition(gatewayConditionProgrammed, metav1.ConditionFalse, gatewayReasonInvalid, "The Gateway is not accepted")
+	}
+	if err := r.updateGatewayStatus(ctx, gw, cp, accepted, programmed, translation.listenerStatus); err != nil {
+		return errorResult(err)
+	}
+	for _, route := range append(routes, stale...) {
+		if err := r.updateRouteStatus(ctx, gw, route); err != nil {
+			return errorResult(err)
+		}
+	}
+
+	log.V(1).Info("Translated Gateway", "listeners", len(translation.listeners), "clusters", len(translation.clusters),
+		"routes", len(translation.routes), "programmed", programmed.Status)
+	return ctrl.Result{}, nil
+}
+
+// gatewayControlPlane returns the XDSControlPlane referenced by the parametersRef of a GatewayClass
+func (r *GatewayReconciler) gatewayControlPlane(ctx context.Context, spec gatewayClassSpec) (*api.XDSControlPlane, error) {
+	ref := spec.ParametersRef
+	if ref == nil || ref.Group != api.GroupVersion.Group || ref.Kind != "XDSControlPlane" {
+		return nil, permanent(fmt.Errorf("parametersRef must refer to an XDSControlPlane of group %s", api.GroupVersion.Group))
+	}
+	if ref.Namespace == "" {
+		return nil, permanent(fmt.Errorf("parametersRef.namespace is required"))
+	}
+
+	cp := &api.XDSControlPlane{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, cp); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, permanent(fmt.Errorf("XDSControlPlane %s/%s not found", ref.Namespace, ref.Name))
+		}
+		return nil, fmt.Errorf("failed to get XDSControlPlane %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	return cp, nil
+}
+
+// gatewayRoutes returns the routes referring to a Gateway, oldest first, and
+// the routes whose status still reports the Gateway although they no longer
+// refer to it
+func (r *GatewayReconciler) gatewayRoutes(ctx context.Context, gw *unstructured.Unstructured) ([]*gatewayRoute, []*gatewayRoute, error) {
+	kinds := []schema.GroupVersionKind{httpRouteGVK}
+	if r.TCPRoutes {
+		kinds = append(kinds, tcpRouteGVK)
+	}
+
+	var routes, stale []*gatewayRoute
+	for _, gvk := range kinds {
+		list := newGatewayObjectList(gvk)
+		if err := r.List(ctx, list); err != nil {
+			return nil, nil, fmt.Errorf("failed to list %ss: %w", gvk.Kind, err)
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			route := &gatewayRoute{obj: obj}
+			if err := decodeField(obj, &route.spec, "spec"); err != nil {
+				ctrlLog.FromContext(ctx).Error(err, "ignoring route", "route", client.ObjectKeyFromObject(obj))
+				continue
+			}
+			for _, ref := range route.spec.ParentRefs {
+				if refersToGateway(obj, ref, gw) {
+					route.parents = append(route.parents, routeParent{ref: ref})
+				}
+			}
+
+			switch {
+			case len(route.parents) > 0:
+				routes = append(routes, route)
+			case len(routeStatusParents(obj, gw)) > 0:
+				stale = append(stale, route)
+			}
+		}
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		a, b := routes[i].obj.GetCreationTimestamp(), routes[j].obj.GetCreationTimestamp()
+		if !a.Equal(&b) {
+			return a.Before(&b)
+		}
+		return client.ObjectKeyFromObject(routes[i].obj).String() < client.ObjectKeyFromObject(routes[j].obj).String()
+	})
+	return routes, stale, nil
+}
+
+// gatewayResources looks up the namespaces and the Services of the routes
+func (r *GatewayReconciler) gatewayResources(ctx context.Context, cp *api.XDSControlPlane, routes []*gatewayRoute) (*gatewayResources, error) {
+	resources := &gatewayResources{
+		namespaces:   make(map[string]map[string]string),
+		services:     make(map[client.ObjectKey]*corev1.Service),
+		controlPlane: client.ObjectKeyFromObject(cp),
+	}
+	for _, route := range routes {
+		namespace := route.obj.GetNamespace()
+		if _, ok := resources.namespaces[namespace]; !ok {
+			ns := &corev1.Namespace{}
+			if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+			}
+			resources.namespaces[namespace] = ns.Labels
+		}
+
+		for _, rule := range route.spec.Rules {
+			for _, ref := range rule.BackendRefs {
+				key := client.ObjectKey{Namespace: namespace, Name: ref.Name}
+				if _, ok := resources.services[key]; ok {
+					continue
+				}
+				svc := &corev1.Service{}
+				if err := r.Get(ctx, key, svc); err != nil {
+					if apierrors.IsNotFound(err) {
+						continue
+					}
+					return nil, fmt.Errorf("failed to get service %s: %w", key, err)
+				}
+				resources.services[key] = svc
+			}
+		}
+	}
+	return resources, nil
+}
+
+// writeGatewayResources writes the translated resources of a Gateway and
+// deletes the ones no longer translated. It returns the written resources.
+func (r *GatewayReconciler) writeGatewayResources(ctx context.Context, gw *unstructured.Unstructured, cp *api.XDSControlPlane, t *gatewayTranslation) ([]client.Object, error) {
+	var ref *api.ControlPlaneReference
+	if cp != nil {
+		ref = &api.ControlPlaneReference{Name: cp.Name}
+		if cp.Namespace != gw.GetNamespace() {
+			ref.Namespace = cp.Namespace
+		}
+	}
+	prefix := gatewayResourcePrefix(gw) + "/"
+	return writeTranslatedResources(ctx, r.Client, r.Scheme, translatedObjects{
+		owner:     gw,
+		namespace: gw.GetNamespace(),
+		labels: map[string]string{
+			GatewayLabel:                   gw.GetName(),
+			"app.kubernetes.io/managed-by": "xds-cp-operator",
+		},
+		ref: ref,
+		name: func(envoyName string) string {
+			rest := strings.TrimPrefix(strings.TrimPrefix(envoyName, prefix), "service/")
+			return translatedObjectName(gw.GetName(), rest)
+		},
+	}, &t.translatedResources)
+}
+
+// gatewayProgrammed returns the Programmed condition of a Gateway from the
+// acceptance of its translated resources by the control plane
+func gatewayProgrammed(children []client.Object, cp *api.XDSControlPlane) metav1.Condition {
+	if cp == nil {
+		return condition(gatewayConditionProgrammed, metav1.ConditionFalse, gatewayReasonInvalid, "No control plane")
+	}
+	key := client.ObjectKeyFromObject(cp).String()
+
+	var pending []string
+	for _, obj := range children {
+		var status api.XDSResourceStatus
+		switch o := obj.(type) {
+		case *api.XDSListener:
+			status = o.Status
+		case *api.XDSCluster:
+			status = o.Status
+		case *api.XDSRouteConfig:
+			status = o.Status
+		}
+
+		found := false
+		for _, s := range status.ControlPlanes {
+			if s.ControlPlane != key {
+				continue
+			}
+			found = s.ObservedGeneration == obj.GetGeneration()
+			if found && s.Accepted != metav1.ConditionTrue {
+				return condition(gatewayConditionProgrammed, metav1.ConditionFalse, gatewayReasonInvalid,
+					fmt.Sprintf("%T %s is not accepted by XDSControlPlane %s: %s", obj, obj.GetName(), key, s.Message))
+			}
+		}
+		if !found {
+			pending = append(pending, obj.GetName())
+		}
+	}
+	if len(pending) > 0 {
+		return condition(gatewayConditionProgrammed, metav1.ConditionFalse, gatewayReasonPending,
+			fmt.Sprintf("Waiting for XDSControlPlane %s to accept %s", key, strings.Join(pending, ", ")))
+	}
+	return condition(gatewayConditionProgrammed, metav1.ConditionTrue, gatewayReasonProgrammed,
+		fmt.Sprintf("Served by XDSControlPlane %s", key))
+}
+
+// setConditions sets conditions for a generation, keeping the transition
+// time of the conditions whose status did not change
+func setConditions(existing []metav1.Condition, generation int64, conditions ...metav1.Condition) []metav1.Condition {
+	out := append([]metav1.Condition{}, existing...)
+	for _, c := range conditions {
+		if c.Type == "" {
+			continue
+		}
+		c.ObservedGeneration = generation
+		meta.SetStatusCondition(&out, c)
+	}
+	return out
+}
+
+// updateClassStatus sets the Accepted condition of a GatewayClass
+func (r *GatewayReconciler) updateClassStatus(ctx context.Context, class *unstructured.Unstructured, classErr error) error {
+	accepted := condition(gatewayConditionAccepted, metav1.ConditionTrue, gatewayReasonAccepted, "GatewayClass is accepted")
+	if classErr != nil {
+		accepted = condition(gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonInvalidParameters, classErr.Error())
+	}
+
+	var conditions []metav1.Condition
+	if err := decodeField(class, &conditions, "status", "conditions"); err != nil {
+		return err
+	}
+	updated := setConditions(conditions, class.GetGeneration(), accepted)
+	return r.patchGatewayStatus(ctx, class, conditions, updated, "status", "conditions")
+}
+
+type gatewayStatus struct {
+	Addresses  []gatewayStatusAddress  `json:"addresses,omitempty"`
+	Conditions []metav1.Condition      `json:"conditions,omitempty"`
+	Listeners  []gatewayListenerStatus `json:"listeners,omitempty"`
+}
+
+// updateGatewayStatus writes the conditions, the listeners and the address of
+// the data plane of the control plane to the status of a Gateway
+func (r *GatewayReconciler) updateGatewayStatus(ctx context.Context, gw *unstructured.Unstructured, cp *api.XDSControlPlane,
+	accepted, programmed metav1.Condition, listeners []gatewayListenerStatus) error {
+	var existing gatewayStatus
+	if err := decodeField(gw, &existing, "status"); err != nil {
+		return err
+	}
+	generation := gw.GetGeneration()
+
+	status := gatewayStatus{Conditions: setConditions(existing.Conditions, generation, accepted, programmed)}
+	if cp != nil && cp.Spec.DataPlane != nil {
+		status.Addresses = []gatewayStatusAddress{{
+			Type:  "Hostname",
+			Value: fmt.Sprintf("%s.%s.svc", dataPlaneName(cp), cp.Namespace),
+		}}
+	}
+	for _, l := range listeners {
+		var previous []metav1.Condition
+		for _, e := range existing.Listeners {
+			if e.Name == l.Name {
+				previous = e.Conditions
+			}
+		}
+		listenerProgrammed := programmed
+		if !meta.IsStatusConditionTrue(l.Conditions, gatewayConditionAccepted) {
+			listenerProgrammed = condition(gatewayConditionProgrammed, metav1.ConditionFalse, gatewayReasonInvalid, "The listener is not accepted")
+		}
+		l.Conditions = setConditions(previous, generation, append(l.Conditions, listenerProgrammed)...)
+		status.Listeners = append(status.Listeners, l)
+	}
+	return r.patchGatewayStatus(ctx, gw, existing, status, "status")
+}
+
+// routeStatusParents returns the status entries of this controller for a Gateway
+func routeStatusParents(route, gw *unstructured.Unstructured) []routeParentStatus {
+	var parents, out []routeParentStatus
+	if err := decodeField(route, &parents, "status", "parents"); err != nil {
+		return nil
+	}
+	for _, p := range parents {
+		if p.ControllerName == GatewayControllerName && refersToGateway(route, p.ParentRef, gw) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// updateRouteStatus writes the outcome of the parentRefs of a route referring
+// to a Gateway, keeping the entries of other Gateways and controllers
+func (r *GatewayReconciler) updateRouteStatus(ctx context.Context, gw *unstructured.Unstructured, route *gatewayRoute) error {
+	var existing []routeParentStatus
+	if err := decodeField(route.obj, &existing, "status", "parents"); err != nil {
+		return err
+	}
+
+	var parents []routeParentStatus
+	previous := make(map[string][]metav1.Condition)
+	for _, p := range existing {
+		if p.ControllerName == GatewayControllerName && refersToGateway(route.obj, p.ParentRef, gw) {
+			previous[parentKey(p.ParentRef)] = p.Conditions
+			continue
+		}
+		parents = append(parents, p)
+	}
+	for _, p := range route.parents {
+		parents = append(parents, routeParentStatus{
+			ParentRef:      p.ref,
+			ControllerName: GatewayControllerName,
+			Conditions:     setConditions(previous[parentKey(p.ref)], route.obj.GetGeneration(), p.accepted, route.resolvedRefs),
+		})
+	}
+	if parents == nil {
+		parents = []routeParentStatus{}
+	}
+	return r.patchGatewayStatus(ctx, route.obj, existing, parents, "status", "parents")
+}
+
+func parentKey(ref parentReference) string {
+	key := ref.Name
+	if ref.Namespace != nil {
+		key = *ref.Namespace + "/" + key
+	}
+	if ref.SectionName != nil {
+		key += "#" + *ref.SectionName
+	}
+	if ref.Port != nil {
+		key += fmt.Sprintf(":%d", *ref.Port)
+	}
+	return key
+}
+
+// patchGatewayStatus writes a status field of a Gateway API object if it changed
+func (r *GatewayReconciler) patchGatewayStatus(ctx context.Context, obj *unstructured.Unstructured, existing, updated interface{}, fields ...string) error {
+	if equality.Semantic.DeepEqual(existing, updated) {
+		return nil
+	}
+	base := obj.DeepCopy()
+	if err := encodeField(obj, updated, fields...); err != nil {
+		return err
+	}
+	if err := r.Status().Patch(ctx, obj, client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{})); err != nil {
+		return fmt.Errorf("failed to update status of %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
+
+// gatewaysOfClasses returns the Gateways of the GatewayClasses accepted by the filter
+func (r *GatewayReconciler) gatewaysOfClasses(ctx context.Context, accept func(name string, spec gatewayClassSpec) bool) []reconcile.Request {
+	log := ctrlLog.FromContext(ctx)
+
+	classes := newGatewayObjectList(gatewayClassGVK)
+	if err := r.List(ctx, classes); err != nil {
+		log.Error(err, "failed to list GatewayClasses")
+		return nil
+	}
+	names := make(map[string]bool)
+	for i := range classes.Items {
+		var spec gatewayClassSpec
+		if err := decodeField(&classes.Items[i], &spec, "spec"); err != nil {
+			continue
+		}
+		if spec.ControllerName == GatewayControllerName && accept(classes.Items[i].GetName(), spec) {
+			names[classes.Items[i].GetName()] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	gateways := newGatewayObjectList(gatewayGVK)
+	if err := r.List(ctx, gateways); err != nil {
+		log.Error(err, "failed to list Gateways")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range gateways.Items {
+		className, _, _ := unstructured.NestedString(gateways.Items[i].Object, "spec", "gatewayClassName")
+		if names[className] {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gateways.Items[i])})
+		}
+	}
+	return requests
+}
+
+// gatewaysForClass returns the Gateways of a GatewayClass
+func (r *GatewayReconciler) gatewaysForClass(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.gatewaysOfClasses(ctx, func(name string, _ gatewayClassSpec) bool { return name == obj.GetName() })
+}
+
+// gatewaysForControlPlane returns the Gateways of the GatewayClasses referring to a control plane
+func (r *GatewayReconciler) gatewaysForControlPlane(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.gatewaysOfClasses(ctx, func(_ string, spec gatewayClassSpec) bool {
+		ref := spec.ParametersRef
+		return ref != nil && ref.Kind == "XDSControlPlane" && ref.Name == obj.GetName() && ref.Namespace == obj.GetNamespace()
+	})
+}
+
+// gatewaysForRoute returns the Gateways a route refers to, and the Gateways
+// of this controller in its status, which must release the route
+func (r *GatewayReconciler) gatewaysForRoute(_ context.Context, obj client.Object) []reconcile.Request {
+	route, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	var spec routeSpec
+	if err := decodeField(route, &spec, "spec"); err != nil {
+		return nil
+	}
+	refs := spec.ParentRefs
+	var parents []routeParentStatus
+	if err := decodeField(route, &parents, "status", "parents"); err == nil {
+		for _, p := range parents {
+			if p.ControllerName == GatewayControllerName {
+				refs = append(refs, p.ParentRef)
+			}
+		}
+	}
+
+	seen := make(map[client.ObjectKey]bool)
+	var requests []reconcile.Request
+	for _, ref := range refs {
+		if (ref.Group != nil && *ref.Group != gatewayGroup) || (ref.Kind != nil && *ref.Kind != gatewayGVK.Kind) {
+			continue
+		}
+		key := client.ObjectKey{Namespace: route.GetNamespace(), Name: ref.Name}
+		if ref.Namespace != nil && *ref.Namespace != "" {
+			key.Namespace = *ref.Namespace
+		}
+		if !seen[key] {
+			seen[key] = true
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return requests
+}
+
+// gatewaysForService returns the Gateways of the routes with a backend in a Service
+func (r *GatewayReconciler) gatewaysForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	kinds := []schema.GroupVersionKind{httpRouteGVK}
+	if r.TCPRoutes {
+		kinds = append(kinds, tcpRouteGVK)
+	}
+
+	var requests []reconcile.Request
+	for _, gvk := range kinds {
+		list := newGatewayObjectList(gvk)
+		if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+			ctrlLog.FromContext(ctx).Error(err, "failed to list routes for service", "service", obj.GetName())
+			return nil
+		}
+		for i := range list.Items {
+			var spec routeSpec
+			if err := decodeField(&list.Items[i], &spec, "spec"); err != nil {
+				continue
+			}
+			for _, rule := range spec.Rules {
+				for _, ref := range rule.BackendRefs {
+					if ref.Name == obj.GetName() && (ref.Kind == nil || *ref.Kind == "Service") {
+						requests = append(requests, r.gatewaysForRoute(ctx, &list.Items[i])...)
+					}
+				}
+			}
+		}
+	}
+	return requests
+}
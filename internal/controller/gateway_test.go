@@ -0,0 +1,363 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func gatewayTestObject(gvk schema.GroupVersionKind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := newGatewayObject(gvk)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetGeneration(1)
+	obj.Object["spec"] = spec
+	return obj
+}
+
+func TestRouteHostnames(t *testing.T) {
+	tests := []struct {
+		name     string
+		listener string
+		route    []string
+		want     []string
+	}{
+		{name: "Any", want: []string{"*"}},
+		{name: "Listener only", listener: "example.com", want: []string{"example.com"}},
+		{name: "Route only", route: []string{"a.example.com", "b.example.com"}, want: []string{"a.example.com", "b.example.com"}},
+		{name: "Wildcard listener", listener: "*.example.com", route: []string{"a.example.com", "other.com"}, want: []string{"a.example.com"}},
+		{name: "Wildcard route", listener: "a.example.com", route: []string{"*.example.com"}, want: []string{"a.example.com"}},
+		{name: "No match", listener: "example.com", route: []string{"other.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, routeHostnames(tt.listener, tt.route))
+		})
+	}
+}
+
+func TestTranslateGateway(t *testing.T) {
+	created := func(minutes int) metav1.Time {
+		return metav1.NewTime(time.Date(2024, 1, 1, 0, minutes, 0, 0, time.UTC))
+	}
+
+	gw := gatewayTestObject(gatewayGVK, "default", "edge", nil)
+	spec := gatewaySpec{
+		GatewayClassName: "xds",
+		Listeners: []gatewayListener{
+			{Name: "http", Port: 80, Protocol: "HTTP", Hostname: "*.example.com"},
+			{Name: "tcp", Port: 5432, Protocol: "TCP"},
+			{Name: "conflict", Port: 80, Protocol: "TCP"},
+			{Name: "udp", Port: 53, Protocol: "UDP"},
+		},
+	}
+
+	httpRoute := func(name string, minutes int, rules []routeRule) *gatewayRoute {
+		obj := gatewayTestObject(httpRouteGVK, "default", name, nil)
+		obj.SetCreationTimestamp(created(minutes))
+		return &gatewayRoute{
+			obj: obj,
+			spec: routeSpec{
+				ParentRefs: []parentReference{{Name: "edge"}},
+				Hostnames:  []string{"api.example.com"},
+				Rules:      rules,
+			},
+			parents: []routeParent{{ref: parentReference{Name: "edge"}}},
+		}
+	}
+	port := int32(8080)
+	prefix := httpRoute("prefix", 1, []routeRule{{
+		Matches:     []httpRouteMatch{{Path: &httpPathMatch{Type: "PathPrefix", Value: "/api"}}},
+		BackendRefs: []backendRef{{Name: "api", Port: &port}},
+	}})
+	exact := httpRoute("exact", 2, []routeRule{{
+		Matches:     []httpRouteMatch{{Path: &httpPathMatch{Type: "Exact", Value: "/api/health"}}},
+		BackendRefs: []backendRef{{Name: "missing", Port: &port}},
+	}})
+	otherHost := httpRoute("other", 3, nil)
+	otherHost.spec.Hostnames = []string{"other.com"}
+
+	section := "tcp"
+	tcpObj := gatewayTestObject(tcpRouteGVK, "default", "db", nil)
+	db := &gatewayRoute{
+		obj:     tcpObj,
+		spec:    routeSpec{Rules: []routeRule{{BackendRefs: []backendRef{{Name: "db", Port: &port}}}}},
+		parents: []routeParent{{ref: parentReference{Name: "edge", SectionName: &section}}},
+	}
+
+	resources := &gatewayResources{
+		namespaces: map[string]map[string]string{"default": {}},
+		services: map[client.ObjectKey]*corev1.Service{
+			{Namespace: "default", Name: "api"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}},
+			},
+			{Namespace: "default", Name: "db"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}},
+			},
+		},
+		controlPlane: client.ObjectKey{Namespace: "default", Name: "edge"},
+	}
+
+	translation := translateGateway(gw, spec, []*gatewayRoute{prefix, exact, otherHost, db}, resources)
+
+	// One Envoy listener per port
+	require.Len(t, translation.listeners, 2)
+	assert.Equal(t, "gateway/default/edge/80", translation.listeners[0].Name)
+	assert.Equal(t, "gateway/default/edge/5432", translation.listeners[1].Name)
+
+	var names []string
+	for _, c := range translation.clusters {
+		names = append(names, c.Name)
+		assert.Equal(t, "Service", c.LoadAssignment.EndpointsFrom.Type)
+	}
+	assert.ElementsMatch(t, []string{
+		"gateway/default/edge/service/default/api/8080",
+		"gateway/default/edge/service/default/db/8080",
+	}, names)
+
+	// The exact match takes precedence over the older prefix match
+	require.Len(t, translation.routes, 1)
+	rc := translation.routes[0]
+	require.Len(t, rc.VirtualHosts, 1)
+	assert.Equal(t, []string{"api.example.com"}, rc.VirtualHosts[0].Domains)
+	require.Len(t, rc.VirtualHosts[0].Routes, 2)
+	var first, second map[string]interface{}
+	require.NoError(t, json.Unmarshal(rc.VirtualHosts[0].Routes[0].Raw, &first))
+	require.NoError(t, json.Unmarshal(rc.VirtualHosts[0].Routes[1].Raw, &second))
+	assert.Equal(t, map[string]interface{}{"path": "/api/health"}, first["match"])
+	assert.Equal(t, map[string]interface{}{"status": float64(500)}, first["direct_response"])
+	assert.Equal(t, map[string]interface{}{"path_separated_prefix": "/api"}, second["match"])
+
+	// Routes and listeners status
+	assert.Equal(t, gatewayReasonAccepted, prefix.parents[0].accepted.Reason)
+	assert.Equal(t, gatewayReasonBackendNotFound, exact.resolvedRefs.Reason)
+	assert.Equal(t, gatewayReasonNoMatchingListenerHostname, otherHost.parents[0].accepted.Reason)
+	assert.Equal(t, gatewayReasonAccepted, db.parents[0].accepted.Reason)
+
+	require.Len(t, translation.listenerStatus, 4)
+	assert.Equal(t, int32(2), translation.listenerStatus[0].AttachedRoutes)
+	assert.Equal(t, int32(1), translation.listenerStatus[1].AttachedRoutes)
+	assert.Equal(t, gatewayReasonProtocolConflict, meta.FindStatusCondition(translation.listenerStatus[2].Conditions, gatewayConditionAccepted).Reason)
+	assert.Equal(t, gatewayReasonUnsupportedProtocol, meta.FindStatusCondition(translation.listenerStatus[3].Conditions, gatewayConditionAccepted).Reason)
+
+	// The translated resources are valid Envoy resources
+	r := &XDSControlPlaneReconciler{}
+	for _, l := range translation.listeners {
+		_, err := r.buildListener(l)
+		assert.NoError(t, err, l.Name)
+	}
+	_, err := r.buildRouteConfiguration(rc)
+	assert.NoError(t, err)
+}
+
+func TestHTTPRouteEntryPrecedence(t *testing.T) {
+	created := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	entry := func(m httpRouteMatch, key string) httpRouteEntry {
+		_, rank, length, err := envoyRouteMatch(m)
+		require.NoError(t, err)
+		return httpRouteEntry{pathRank: rank, pathLength: length, method: m.Method != "", headers: len(m.Headers), created: created, key: key}
+	}
+
+	exact := entry(httpRouteMatch{Path: &httpPathMatch{Type: "Exact", Value: "/"}}, "b")
+	long := entry(httpRouteMatch{Path: &httpPathMatch{Type: "PathPrefix", Value: "/api/v1"}}, "b")
+	short := entry(httpRouteMatch{Path: &httpPathMatch{Type: "PathPrefix", Value: "/api"}}, "b")
+	method := entry(httpRouteMatch{Path: &httpPathMatch{Type: "PathPrefix", Value: "/api"}, Method: "GET"}, "b")
+	headers := entry(httpRouteMatch{Path: &httpPathMatch{Type: "PathPrefix", Value: "/api"},
+		Headers: []httpHeaderMatch{{Name: "x-version", Value: "2"}}}, "b")
+	olderKey := entry(httpRouteMatch{Path: &httpPathMatch{Type: "PathPrefix", Value: "/api"}}, "a")
+
+	ordered := []httpRouteEntry{exact, long, method, headers, olderKey, short}
+	for i := 0; i < len(ordered)-1; i++ {
+		assert.True(t, httpRouteEntryLess(ordered[i], ordered[i+1]), "entry %d before %d", i, i+1)
+		assert.False(t, httpRouteEntryLess(ordered[i+1], ordered[i]), "entry %d after %d", i+1, i)
+	}
+}
+
+func TestGatewayReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	for _, gvk := range []schema.GroupVersionKind{gatewayClassGVK, gatewayGVK, httpRouteGVK} {
+		scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+
+	class := gatewayTestObject(gatewayClassGVK, "", "xds", map[string]interface{}{
+		"controllerName": GatewayControllerName,
+		"parametersRef": map[string]interface{}{
+			"group": "xds.okassov", "kind": "XDSControlPlane", "name": "edge", "namespace": "infra",
+		},
+	})
+	gw := gatewayTestObject(gatewayGVK, "apps", "web", map[string]interface{}{
+		"gatewayClassName": "xds",
+		"listeners": []interface{}{
+			map[string]interface{}{"name": "http", "port": int64(80), "protocol": "HTTP"},
+		},
+	})
+	route := gatewayTestObject(httpRouteGVK, "apps", "web", map[string]interface{}{
+		"parentRefs": []interface{}{map[string]interface{}{"name": "web"}},
+		"rules": []interface{}{map[string]interface{}{
+			"backendRefs": []interface{}{map[string]interface{}{"name": "web", "port": int64(8080)}},
+		}},
+	})
+	// A route that no longer refers to the Gateway
+	stale := gatewayTestObject(httpRouteGVK, "apps", "old", map[string]interface{}{})
+	stale.Object["status"] = map[string]interface{}{"parents": []interface{}{
+		map[string]interface{}{
+			"parentRef":      map[string]interface{}{"name": "web"},
+			"controllerName": GatewayControllerName,
+			"conditions":     []interface{}{},
+		},
+		map[string]interface{}{
+			"parentRef":      map[string]interface{}{"name": "web"},
+			"controllerName": "example.com/other",
+			"conditions":     []interface{}{},
+		},
+	}}
+	cp := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "infra"},
+		Spec:       api.XDSControlPlaneSpec{DataPlane: &api.DataPlaneSpec{}},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(class, gw, route, stale, cp,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}},
+			}).
+		WithStatusSubresource(class, gw, route, stale, &api.XDSListener{}, &api.XDSCluster{}, &api.XDSRouteConfig{}).
+		Build()
+	r := &GatewayReconciler{Client: c, Scheme: scheme}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "apps", Name: "web"}}
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	// The translated resources attach to the control plane of the class
+	var listeners api.XDSListenerList
+	require.NoError(t, c.List(ctx, &listeners, client.InNamespace("apps")))
+	require.Len(t, listeners.Items, 1)
+	l := listeners.Items[0]
+	assert.Equal(t, "gateway/apps/web/80", l.Spec.Name)
+	assert.Equal(t, &api.ControlPlaneReference{Name: "edge", Namespace: "infra"}, l.Spec.ControlPlaneRef)
+	assert.Equal(t, "web", l.Labels[GatewayLabel])
+	assert.True(t, metav1.IsControlledBy(&l, gw))
+
+	var clusters api.XDSClusterList
+	require.NoError(t, c.List(ctx, &clusters, client.InNamespace("apps")))
+	assert.Len(t, clusters.Items, 1)
+	var routeConfigs api.XDSRouteConfigList
+	require.NoError(t, c.List(ctx, &routeConfigs, client.InNamespace("apps")))
+	assert.Len(t, routeConfigs.Items, 1)
+
+	status := func(obj *unstructured.Unstructured, out interface{}, fields ...string) {
+		t.Helper()
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(obj), obj))
+		require.NoError(t, decodeField(obj, out, fields...))
+	}
+
+	var classConditions []metav1.Condition
+	status(class, &classConditions, "status", "conditions")
+	assert.True(t, meta.IsStatusConditionTrue(classConditions, gatewayConditionAccepted))
+
+	// Programmed waits for the control plane
+	var gwStatus gatewayStatus
+	status(gw, &gwStatus, "status")
+	assert.True(t, meta.IsStatusConditionTrue(gwStatus.Conditions, gatewayConditionAccepted))
+	assert.Equal(t, gatewayReasonPending, meta.FindStatusCondition(gwStatus.Conditions, gatewayConditionProgrammed).Reason)
+	assert.Equal(t, []gatewayStatusAddress{{Type: "Hostname", Value: "edge-envoy.infra.svc"}}, gwStatus.Addresses)
+	require.Len(t, gwStatus.Listeners, 1)
+	assert.Equal(t, int32(1), gwStatus.Listeners[0].AttachedRoutes)
+
+	var parents []routeParentStatus
+	status(route, &parents, "status", "parents")
+	require.Len(t, parents, 1)
+	assert.True(t, meta.IsStatusConditionTrue(parents[0].Conditions, gatewayConditionAccepted))
+	assert.True(t, meta.IsStatusConditionTrue(parents[0].Conditions, gatewayConditionResolvedRefs))
+
+	// Only the entry of this controller is removed from the stale route
+	var staleParents []routeParentStatus
+	status(stale, &staleParents, "status", "parents")
+	require.Len(t, staleParents, 1)
+	assert.Equal(t, "example.com/other", staleParents[0].ControllerName)
+
+	// Programmed once the control plane accepted the resources
+	key := client.ObjectKeyFromObject(cp).String()
+	for _, list := range []client.ObjectList{&listeners, &clusters, &routeConfigs} {
+		items, err := meta.ExtractList(list)
+		require.NoError(t, err)
+		for _, item := range items {
+			obj := item.(client.Object)
+			accepted := api.XDSResourceStatus{ControlPlanes: []api.AttachmentStatus{{
+				ControlPlane: key, Accepted: metav1.ConditionTrue, ObservedGeneration: obj.GetGeneration(),
+			}}}
+			switch o := obj.(type) {
+			case *api.XDSListener:
+				o.Status = accepted
+			case *api.XDSCluster:
+				o.Status = accepted
+			case *api.XDSRouteConfig:
+				o.Status = accepted
+			}
+			require.NoError(t, c.Status().Update(ctx, obj))
+		}
+	}
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	status(gw, &gwStatus, "status")
+	assert.True(t, meta.IsStatusConditionTrue(gwStatus.Conditions, gatewayConditionProgrammed))
+
+	// The translated resources are removed with the routes
+	require.NoError(t, c.Delete(ctx, route))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, c.List(ctx, &clusters, client.InNamespace("apps")))
+	assert.Empty(t, clusters.Items)
+}
+
+func TestDiscoverServiceEndpoints(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+			Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.10", ClusterIPs: []string{"10.0.0.10", "fd00::10"}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "headless", Namespace: "apps"},
+			Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone},
+		},
+	).Build()
+	r := &XDSControlPlaneReconciler{Client: c}
+
+	addrs, err := r.discoverEndpoints(ctx, &api.EndpointSelectorSpec{Type: "Service", Name: "web", Namespace: "apps", Port: 80})
+	require.NoError(t, err)
+	assert.Equal(t, []discoveredEndpoint{{IP: "10.0.0.10", Port: 80}, {IP: "fd00::10", Port: 80}}, addrs)
+
+	_, err = r.discoverEndpoints(ctx, &api.EndpointSelectorSpec{Type: "Service", Name: "headless", Namespace: "apps", Port: 80})
+	assert.Error(t, err)
+	_, err = r.discoverEndpoints(ctx, &api.EndpointSelectorSpec{Type: "Service", Name: "missing", Namespace: "apps", Port: 80})
+	assert.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	// RevisionLabel is set on the ConfigMaps holding snapshot revisions
+	RevisionLabel = "xds.okassov/revision"
+
+	defaultRevisionHistoryLimit = 10
+)
+
+func revisionConfigMapName(crd *api.XDSControlPlane, revision int64) string {
+	return fmt.Sprintf("%s-xds-rev-%d", crd.Name, revision)
+}
+
+func revisionHistoryLimit(crd *api.XDSControlPlane) int {
+	if crd.Spec.RevisionHistoryLimit == nil {
+		return defaultRevisionHistoryLimit
+	}
+	return int(*crd.Spec.RevisionHistoryLimit)
+}
+
+// lastSpecAuthor returns the field manager of the most recent change to the spec
+func lastSpecAuthor(crd *api.XDSControlPlane) string {
+	var author string
+	var latest time.Time
+	for _, mf := range crd.ManagedFields {
+		if mf.Subresource != "" || mf.Time == nil || mf.FieldsV1 == nil {
+			continue
+		}
+		if !bytes.Contains(mf.FieldsV1.Raw, []byte(`"f:spec"`)) {
+			continue
+		}
+		if author == "" || mf.Time.Time.After(latest) {
+			author = mf.Manager
+			latest = mf.Time.Time
+		}
+	}
+	return author
+}
+
+// recordRevision adds the served snapshots to the revision history of the CR
+// if they differ from the latest revision. The snapshots of every revision are
+// stored in a ConfigMap owned by the CR, the oldest revisions beyond the
+// history limit are removed.
+func (r *XDSControlPlaneReconciler) recordRevision(ctx context.Context, crd *api.XDSControlPlane, state *persistedState) error {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	hash := state.resourceHash()
+	history := crd.Status.History
+	if n := len(history); n > 0 && history[n-1].Hash == hash {
+		crd.Status.CurrentRevision = history[n-1].Revision
+		return nil
+	}
+
+	revision := int64(1)
+	if n := len(history); n > 0 {
+		revision = history[n-1].Revision + 1
+	}
+
+	data, err := state.compress()
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      revisionConfigMapName(crd, revision),
+			Namespace: crd.Namespace,
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Labels = map[string]string{
+			ControlPlaneLabel:              crd.Name,
+			RevisionLabel:                  fmt.Sprint(revision),
+			"app.kubernetes.io/managed-by": "xds-cp-operator",
+		}
+		cm.Annotations = map[string]string{SnapshotHashAnnotation: hash}
+		cm.BinaryData = map[string][]byte{snapshotDataKey: data}
+		return controllerutil.SetControllerReference(crd, cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write revision ConfigMap: %w", err)
+	}
+
+	history = append(history, api.SnapshotRevision{
+		Revision:   revision,
+		Version:    state.Default.Version,
+		Hash:       hash,
+		Generation: crd.Generation,
+		Time:       metav1.Now(),
+		Author:     lastSpecAuthor(crd),
+	})
+
+	for len(history) > revisionHistoryLimit(crd) {
+		old := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      revisionConfigMapName(crd, history[0].Revision),
+			Namespace: crd.Namespace,
+		}}
+		if err := r.Delete(ctx, old); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete revision ConfigMap: %w", err)
+		}
+		history = history[1:]
+	}
+
+	crd.Status.History = history
+	crd.Status.CurrentRevision = revision
+	log.Info("Recorded snapshot revision", "revision", revision, "version", state.Default.Version, "generation", crd.Generation)
+	return nil
+}
+
+// loadRevision reads the snapshots of a revision
+func (r *XDSControlPlaneReconciler) loadRevision(ctx context.Context, crd *api.XDSControlPlane, revision int64) (*persistedState, error) {
+	cm := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: crd.Namespace, Name: revisionConfigMapName(crd, revision)}
+	if err := r.Get(ctx, key, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, permanent(fmt.Errorf("revision %d not found in history", revision))
+		}
+		return nil, fmt.Errorf("failed to get revision ConfigMap: %w", err)
+	}
+	return decompressState(cm.BinaryData[snapshotDataKey])
+}
+
+// reconcilePinnedRevision serves the snapshots of spec.pinnedRevision instead
+// of building them from the spec
+func (r *XDSControlPlaneReconciler) reconcilePinnedRevision(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance) (ctrl.Result, error) {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+	revision := *crd.Spec.PinnedRevision
+
+	state, err := r.loadRevision(ctx, crd, revision)
+	if err != nil {
+		log.Error(err, "failed to load pinned revision", "revision", revision)
+		r.updateStatusFailed(ctx, crd, ConditionTypeSnapshot, ReasonRevisionUnavailable, fmt.Sprintf("Failed to load pinned revision %d: %v", revision, err))
+		return errorResult(err)
+	}
+
+	snapshot, err := decodeSnapshot(state.Default)
+	if err != nil {
+		r.updateStatusFailed(ctx, crd, ConditionTypeSnapshot, ReasonRevisionUnavailable, fmt.Sprintf("Failed to decode pinned revision %d: %v", revision, err))
+		return errorResult(permanent(err))
+	}
+
+	nodeIDs, err := r.setDefaultSnapshots(ctx, crd, server, snapshot)
+	if err != nil {
+		log.Error(err, "failed to set xDS snapshot")
+		r.updateStatusFailed(ctx, crd, ConditionTypeSnapshot, ReasonSetFailed, err.Error())
+		return errorResult(err)
+	}
+
+	server.nodeHash.setGroups(crd.Spec.NodeGroups)
+	if _, err := setPersistedGroupSnapshots(ctx, server, state); err != nil {
+		log.Error(err, "failed to set node group snapshots")
+		r.updateStatusFailed(ctx, crd, ConditionTypeSnapshot, ReasonSetFailed, err.Error())
+		return errorResult(err)
+	}
+
+	server.tracker.registerVersion(state.Default.Version, state.Generation)
+	log.Info("Serving pinned revision", "revision", revision, "version", state.Default.Version, "nodeIDs", nodeIDs)
+
+	r.recordSnapshotEvents(crd, server, snapshot, state.resourceHash(), nodeIDs)
+
+	// Keep serving the pinned revision across operator restarts
+	if state.NodeIDs, err = persistedNodeIDs(crd); err != nil {
+		log.Error(err, "failed to persist xDS snapshots")
+	} else if err := r.persistSnapshots(ctx, crd, state); err != nil {
+		log.Error(err, "failed to persist xDS snapshots")
+	}
+
+	crd.Status.CurrentRevision = revision
+	crd.Status.Fallback = nil
+	crd.Status.Propagation, crd.Status.Nodes = server.tracker.status()
+	setEndpointsCondition(crd, snapshot, nil)
+	return r.updateStatusReady(ctx, crd, nodeIDs, server, state.Default.Version)
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func newHistoryState(t *testing.T, crd *api.XDSControlPlane, version, clusterName string) *persistedState {
+	snapshot, err := cache.NewSnapshot(version, map[res.Type][]types.Resource{
+		res.ClusterType: {&cluster.Cluster{Name: clusterName}},
+	})
+	require.NoError(t, err)
+	state, err := newPersistedState(crd, snapshot, nil)
+	require.NoError(t, err)
+	return state
+}
+
+func TestRecordRevision(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	limit := int32(2)
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "history", Namespace: "default", UID: "uid-1", Generation: 1},
+		Spec:       api.XDSControlPlaneSpec{RevisionHistoryLimit: &limit},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	reconciler := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	require.NoError(t, reconciler.recordRevision(ctx, crd, newHistoryState(t, crd, "1", "v1")))
+	require.Len(t, crd.Status.History, 1)
+	assert.Equal(t, int64(1), crd.Status.CurrentRevision)
+	assert.Equal(t, "1", crd.Status.History[0].Version)
+
+	// Unchanged resources with a new version do not create a revision
+	require.NoError(t, reconciler.recordRevision(ctx, crd, newHistoryState(t, crd, "2", "v1")))
+	assert.Len(t, crd.Status.History, 1)
+
+	// Neither do other nodes being served the same resources
+	state := newHistoryState(t, crd, "2", "v1")
+	state.NodeIDs = []string{"envoy-1", "envoy-2"}
+	require.NoError(t, reconciler.recordRevision(ctx, crd, state))
+	assert.Len(t, crd.Status.History, 1)
+
+	crd.Generation = 2
+	require.NoError(t, reconciler.recordRevision(ctx, crd, newHistoryState(t, crd, "3", "v2")))
+	crd.Generation = 3
+	require.NoError(t, reconciler.recordRevision(ctx, crd, newHistoryState(t, crd, "4", "v3")))
+
+	require.Len(t, crd.Status.History, 2)
+	assert.Equal(t, int64(2), crd.Status.History[0].Revision)
+	assert.Equal(t, int64(3), crd.Status.History[1].Revision)
+	assert.Equal(t, int64(3), crd.Status.History[1].Generation)
+	assert.Equal(t, int64(3), crd.Status.CurrentRevision)
+
+	// The oldest revision is pruned together with its ConfigMap
+	err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "history-xds-rev-1"}, &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	state, err = reconciler.loadRevision(ctx, crd, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "3", state.Default.Version)
+
+	_, err = reconciler.loadRevision(ctx, crd, 1)
+	assert.ErrorContains(t, err, "revision 1 not found")
+}
+
+func TestLastSpecAuthor(t *testing.T) {
+	at := func(minutes int) *metav1.Time {
+		tm := metav1.NewTime(time.Date(2025, 7, 20, 8, minutes, 0, 0, time.UTC))
+		return &tm
+	}
+
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{
+		{Manager: "kubectl-client-side-apply", Time: at(1), FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:xdsPort":{}}}`)}},
+		{Manager: "argocd-controller", Time: at(5), FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:clusters":{}}}`)}},
+		{Manager: "manager", Time: at(6), FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:finalizers":{}}}`)}},
+		{Manager: "manager", Time: at(7), Subresource: "status", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:status":{}}`)}},
+	}}}
+
+	assert.Equal(t, "argocd-controller", lastSpecAuthor(crd))
+	assert.Empty(t, lastSpecAuthor(&api.XDSControlPlane{}))
+}
+
+func TestReconcilePinnedRevision(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "pinned", Namespace: "default", UID: "uid-1", Generation: 1},
+		Spec:       api.XDSControlPlaneSpec{NodeIDs: []string{"envoy-1"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).WithStatusSubresource(crd).Build()
+	reconciler := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	require.NoError(t, reconciler.recordRevision(ctx, crd, newHistoryState(t, crd, "100", "v1")))
+	require.NoError(t, reconciler.recordRevision(ctx, crd, newHistoryState(t, crd, "200", "v2")))
+
+	server := newTestServerInstance()
+	pinned := int64(1)
+	crd.Spec.PinnedRevision = &pinned
+	_, err := reconciler.reconcilePinnedRevision(ctx, crd, server)
+	require.NoError(t, err)
+
+	served, err := server.cache.GetSnapshot("envoy-1")
+	require.NoError(t, err)
+	assert.Contains(t, served.GetResources(res.ClusterType), "v1")
+	assert.Equal(t, PhaseReady, crd.Status.Phase)
+	assert.Equal(t, int64(1), crd.Status.CurrentRevision)
+	assert.Equal(t, "100", crd.Status.LastSnapshotVersion)
+
+	pinned = 7
+	_, err = reconciler.reconcilePinnedRevision(ctx, crd, server)
+	assert.ErrorContains(t, err, "revision 7 not found")
+	assert.Equal(t, PhaseError, crd.Status.Phase)
+}
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	// IngressClassAnnotation is the legacy annotation selecting the class of an Ingress
+	IngressClassAnnotation = "kubernetes.io/ingress.class"
+
+	defaultIngressHTTPPort  = 80
+	defaultIngressHTTPSPort = 443
+)
+
+// ingressInputs are the objects referenced by the Ingresses of a class
+type ingressInputs struct {
+	services map[client.ObjectKey]*corev1.Service
+	secrets  map[client.ObjectKey]*corev1.Secret
+}
+
+// ingressTranslation holds the Envoy resources translated from the Ingresses
+// of a class, and the problems found in each Ingress
+type ingressTranslation struct {
+	translatedResources
+	warnings map[client.ObjectKey][]ingressWarning
+}
+
+type ingressWarning struct {
+	reason  string
+	message string
+}
+
+func (t *ingressTranslation) warn(ing *networkingv1.Ingress, reason, format string, args ...interface{}) {
+	key := client.ObjectKeyFromObject(ing)
+	t.warnings[key] = append(t.warnings[key], ingressWarning{reason: reason, message: fmt.Sprintf(format, args...)})
+}
+
+// ingressClassOf returns the class of an Ingress, from spec.ingressClassName
+// or the legacy annotation
+func ingressClassOf(ing *networkingv1.Ingress) string {
+	if ing.Spec.IngressClassName != nil {
+		return *ing.Spec.IngressClassName
+	}
+	return ing.Annotations[IngressClassAnnotation]
+}
+
+// ingressRoute is an Envoy route of an Ingress path, ordered by precedence
+type ingressRoute struct {
+	route map[string]interface{}
+
+	exact      bool
+	pathLength int
+	order      int
+}
+
+// ingressRouteMatch translates the path of an Ingress rule
+func ingressRouteMatch(path networkingv1.HTTPIngressPath) (map[string]interface{}, bool) {
+	value := path.Path
+	if value == "" {
+		value = "/"
+	}
+	pathType := networkingv1.PathTypeImplementationSpecific
+	if path.PathType != nil {
+		pathType = *path.PathType
+	}
+
+	switch pathType {
+	case networkingv1.PathTypeExact:
+		return map[string]interface{}{"path": value}, true
+	case networkingv1.PathTypePrefix:
+		// Prefixes match whole path segments
+		if trimmed := strings.TrimSuffix(value, "/"); trimmed != "" {
+			return map[string]interface{}{"path_separated_prefix": trimmed}, false
+		}
+	}
+	return map[string]interface{}{"prefix": value}, false
+}
+
+// resolveBackend returns the cluster of an Ingress backend, empty if the
+// backend cannot be resolved
+func (t *ingressTranslation) resolveBackend(prefix string, ing *networkingv1.Ingress, backend networkingv1.IngressBackend, inputs *ingressInputs) string {
+	if backend.Service == nil {
+		t.warn(ing, EventReasonBackendNotFound, "Resource backends are not supported")
+		return ""
+	}
+	key := client.ObjectKey{Namespace: ing.Namespace, Name: backend.Service.Name}
+	svc, ok := inputs.services[key]
+	if !ok {
+		t.warn(ing, EventReasonBackendNotFound, "Service %s not found", backend.Service.Name)
+		return ""
+	}
+
+	port := backend.Service.Port
+	for _, p := range svc.Spec.Ports {
+		if (port.Name != "" && p.Name == port.Name) || (port.Name == "" && p.Port == port.Number) {
+			name := fmt.Sprintf("%s/%s/%s/%d", prefix, ing.Namespace, svc.Name, p.Port)
+			t.addCluster(api.ClusterSpec{
+				Name:     name,
+				Type:     "eds",
+				LbPolicy: "round_robin",
+				LoadAssignment: &api.LoadAssignmentSpec{EndpointsFrom: &api.EndpointSelectorSpec{
+					Type:      "EndpointSlice",
+					Name:      svc.Name,
+					Namespace: svc.Namespace,
+					Port:      int(p.Port),
+				}},
+			})
+			return name
+		}
+	}
+	portName := port.Name
+	if portName == "" {
+		portName = fmt.Sprint(port.Number)
+	}
+	t.warn(ing, EventReasonBackendNotFound, "Service %s has no port %s", svc.Name, portName)
+	return ""
+}
+
+func ingressRouteAction(match map[string]interface{}, cluster string) map[string]interface{} {
+	if cluster == "" {
+		// Requests to unresolved backends fail like requests to a backend without endpoints
+		return map[string]interface{}{"match": match, "direct_response": map[string]interface{}{"status": 503}}
+	}
+	return map[string]interface{}{"match": match, "route": map[string]interface{}{"cluster": cluster}}
+}
+
+// ingressTLS returns a reference to the certificate of a kubernetes.io/tls
+// Secret. The key is served to Envoy over SDS and never copied into the
+// translated listener.
+func ingressTLS(secret *corev1.Secret) (*api.DownstreamTLSSpec, error) {
+	if len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, fmt.Errorf("secret %s has no %s or %s", secret.Name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+	return &api.DownstreamTLSSpec{
+		SecretName:      secret.Name,
+		SecretNamespace: secret.Namespace,
+		ALPNProtocols:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// translateIngresses translates the Ingresses of a class, oldest first, into
+// an HTTP listener, an HTTPS listener with a filter chain per TLS Secret, a
+// route configuration shared by both listeners and an EDS cluster per Service
+// port. Hosts and TLS server names of older Ingresses take precedence.
+func translateIngresses(class string, ingresses []*networkingv1.Ingress, inputs *ingressInputs, httpPort, httpsPort int) *ingressTranslation {
+	t := &ingressTranslation{warnings: make(map[client.ObjectKey][]ingressWarning)}
+	prefix := "ingress/" + class
+
+	vhosts := make(map[string][]ingressRoute)
+	var defaultRoute map[string]interface{}
+	order := 0
+
+	// Filter chains of the HTTPS listener by Secret, and the server names already served
+	var chains []api.FilterChainSpec
+	var defaultChain *api.FilterChainSpec
+	chainOf := make(map[client.ObjectKey]int)
+	serverNames := make(map[string]bool)
+
+	for _, ing := range ingresses {
+		if backend := ing.Spec.DefaultBackend; backend != nil {
+			cluster := t.resolveBackend(prefix, ing, *backend, inputs)
+			if defaultRoute == nil {
+				defaultRoute = ingressRouteAction(map[string]interface{}{"prefix": "/"}, cluster)
+			} else {
+				t.warn(ing, EventReasonIngressConflict, "The default backend of an older Ingress of class %s is used", class)
+			}
+		}
+
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			host := rule.Host
+			if host == "" {
+				host = "*"
+			}
+			for _, path := range rule.HTTP.Paths {
+				match, exact := ingressRouteMatch(path)
+				cluster := t.resolveBackend(prefix, ing, path.Backend, inputs)
+				vhosts[host] = append(vhosts[host], ingressRoute{
+					route:      ingressRouteAction(match, cluster),
+					exact:      exact,
+					pathLength: len(path.Path),
+					order:      order,
+				})
+				order++
+			}
+		}
+
+		for _, tls := range ing.Spec.TLS {
+			if tls.SecretName == "" {
+				continue
+			}
+			key := client.ObjectKey{Namespace: ing.Namespace, Name: tls.SecretName}
+			secret, ok := inputs.secrets[key]
+			if !ok {
+				t.warn(ing, EventReasonTLSSecretInvalid, "TLS secret %s not found", tls.SecretName)
+				continue
+			}
+			tlsRef, err := ingressTLS(secret)
+			if err != nil {
+				t.warn(ing, EventReasonTLSSecretInvalid, "%v", err)
+				continue
+			}
+
+			if len(tls.Hosts) == 0 {
+				// The certificate of connections without a matching server name
+				if defaultChain == nil {
+					defaultChain = &api.FilterChainSpec{Name: "default", TLS: tlsRef}
+				}
+				continue
+			}
+			i, ok := chainOf[key]
+			if !ok {
+				i = len(chains)
+				chainOf[key] = i
+				chains = append(chains, api.FilterChainSpec{
+					Name:             key.String(),
+					FilterChainMatch: &api.FilterChainMatchSpec{},
+					TLS:              tlsRef,
+				})
+			}
+			for _, host := range tls.Hosts {
+				if serverNames[host] {
+					if !slices.Contains(chains[i].FilterChainMatch.ServerNames, host) {
+						t.warn(ing, EventReasonIngressConflict, "The TLS certificate of an older Ingress is used for %s", host)
+					}
+					continue
+				}
+				serverNames[host] = true
+				chains[i].FilterChainMatch.ServerNames = append(chains[i].FilterChainMatch.ServerNames, host)
+			}
+		}
+	}
+
+	if defaultRoute != nil {
+		// Requests not matching a path of their host go to the default backend
+		if _, ok := vhosts["*"]; !ok {
+			vhosts["*"] = nil
+		}
+	}
+
+	hosts := make([]string, 0, len(vhosts))
+	for host := range vhosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	routeConfig := api.RouteConfigSpec{Name: prefix, VirtualHosts: []api.VirtualHostSpec{}}
+	for _, host := range hosts {
+		routes := vhosts[host]
+		// Exact paths first, then the longest prefix, then the oldest Ingress
+		sort.SliceStable(routes, func(i, j int) bool {
+			a, b := routes[i], routes[j]
+			switch {
+			case a.exact != b.exact:
+				return a.exact
+			case a.pathLength != b.pathLength:
+				return a.pathLength > b.pathLength
+			}
+			return a.order < b.order
+		})
+		vh := api.VirtualHostSpec{Name: host, Domains: []string{host}, Routes: []apiextensionsv1.JSON{}}
+		for _, r := range routes {
+			vh.Routes = append(vh.Routes, rawJSON(r.route))
+		}
+		if defaultRoute != nil {
+			vh.Routes = append(vh.Routes, rawJSON(defaultRoute))
+		}
+		routeConfig.VirtualHosts = append(routeConfig.VirtualHosts, vh)
+	}
+	t.routes = append(t.routes, routeConfig)
+
+	httpName := prefix + "/http"
+	t.listeners = append(t.listeners, api.ListenerSpec{
+		Name:    httpName,
+		Address: "0.0.0.0",
+		Port:    httpPort,
+		FilterChains: []api.FilterChainSpec{{
+			Filters: []api.FilterSpec{{
+				Name:        "envoy.filters.network.http_connection_manager",
+				TypedConfig: rawJSON(rdsConnectionManager(httpName, prefix)),
+			}},
+		}},
+	})
+
+	httpsName := prefix + "/https"
+	connectionManager := []api.FilterSpec{{
+		Name:        "envoy.filters.network.http_connection_manager",
+		TypedConfig: rawJSON(rdsConnectionManager(httpsName, prefix)),
+	}}
+	var httpsChains []api.FilterChainSpec
+	for _, chain := range chains {
+		if len(chain.FilterChainMatch.ServerNames) == 0 {
+			// All its hosts are served by the certificate of an older Ingress
+			continue
+		}
+		chain.Filters = connectionManager
+		httpsChains = append(httpsChains, chain)
+	}
+	if defaultChain != nil {
+		defaultChain.Filters = connectionManager
+	}
+	if len(httpsChains) > 0 || defaultChain != nil {
+		t.listeners = append(t.listeners, api.ListenerSpec{
+			Name:    httpsName,
+			Address: "0.0.0.0",
+			Port:    httpsPort,
+			ListenerFilters: []api.ListenerFilterSpec{{
+				Name: "envoy.filters.listener.tls_inspector",
+				TypedConfig: rawJSON(map[string]interface{}{
+					"@type": "type.googleapis.com/envoy.extensions.filters.listener.tls_inspector.v3.TlsInspector",
+				}),
+			}},
+			FilterChains:       httpsChains,
+			DefaultFilterChain: defaultChain,
+		})
+	}
+	return t
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// IngressClassLabel is set on the resources translated from the Ingresses of a class
+const IngressClassLabel = "xds.okassov/ingress-class"
+
+// IngressReconciler translates the Ingresses of a class into XDSListener,
+// XDSCluster and XDSRouteConfig resources attached to a designated
+// XDSControlPlane. The resources are written to the namespace of the control
+// plane and owned by it. All Ingresses of the class are translated together,
+// as they share the listeners and the route configuration.
+type IngressReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// IngressClassName selects the Ingresses by spec.ingressClassName or the
+	// kubernetes.io/ingress.class annotation
+	IngressClassName string
+	// ControlPlane serves the translated resources
+	ControlPlane client.ObjectKey
+	// HTTPPort and HTTPSPort are the ports of the listeners, 80 and 443 if zero
+	HTTPPort  int
+	HTTPSPort int
+}
+
+// SetupWithManager watches Ingresses and the objects their translation
+// depends on. Every event reconciles the whole class.
+func (r *IngressReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	class := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: r.ControlPlane.Namespace, Name: r.IngressClassName}}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("ingress").
+		Watches(&networkingv1.Ingress{}, class, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&api.XDSControlPlane{}, class, builder.WithPredicates(predicate.GenerationChangedPredicate{},
+			predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				return client.ObjectKeyFromObject(obj) == r.ControlPlane
+			}))).
+		// Services have no generation, their ports and the address of the data plane matter
+		Watches(&corev1.Service{}, class).
+		Watches(&corev1.Secret{}, class, builder.WithPredicates(tlsSecretChanged())).
+		Complete(r)
+}
+
+// tlsSecretChanged filters Secrets down to the TLS Secrets whose data changed
+func tlsSecretChanged() predicate.Predicate {
+	isTLS := func(obj client.Object) bool {
+		secret, ok := obj.(*corev1.Secret)
+		return ok && secret.Type == corev1.SecretTypeTLS
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return isTLS(e.Object) },
+		DeleteFunc: func(e event.DeleteEvent) bool { return isTLS(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, ok := e.ObjectOld.(*corev1.Secret)
+			if !ok || !isTLS(e.ObjectNew) {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(oldSecret.Data, e.ObjectNew.(*corev1.Secret).Data)
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+func (r *IngressReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	log := ctrlLog.FromContext(ctx).WithValues("ingressClass", r.IngressClassName, "xdscontrolplane", r.ControlPlane)
+
+	// The translated resources of a deleted control plane are garbage collected
+	cp := &api.XDSControlPlane{}
+	if err := r.Get(ctx, r.ControlPlane, cp); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("XDSControlPlane for Ingresses not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	ingresses, err := r.classIngresses(ctx)
+	if err != nil {
+		return errorResult(err)
+	}
+	inputs, err := r.ingressInputs(ctx, ingresses)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	httpPort, httpsPort := r.HTTPPort, r.HTTPSPort
+	if httpPort == 0 {
+		httpPort = defaultIngressHTTPPort
+	}
+	if httpsPort == 0 {
+		httpsPort = defaultIngressHTTPSPort
+	}
+	translation := translateIngresses(r.IngressClassName, ingresses, inputs, httpPort, httpsPort)
+
+	base := sanitizeName("ingress-" + r.IngressClassName)
+	routeConfig := "ingress/" + r.IngressClassName
+	if _, err := writeTranslatedResources(ctx, r.Client, r.Scheme, translatedObjects{
+		owner:     cp,
+		namespace: cp.Namespace,
+		labels: map[string]string{
+			IngressClassLabel:              r.IngressClassName,
+			"app.kubernetes.io/managed-by": "xds-cp-operator",
+		},
+		ref: &api.ControlPlaneReference{Name: cp.Name},
+		name: func(envoyName string) string {
+			if envoyName == routeConfig {
+				return translatedObjectName(base, "routes")
+			}
+			return translatedObjectName(base, strings.TrimPrefix(envoyName, routeConfig+"/"))
+		},
+	}, &translation.translatedResources); err != nil {
+		return errorResult(err)
+	}
+
+	addresses, err := r.loadBalancerStatus(ctx, cp)
+	if err != nil {
+		return errorResult(err)
+	}
+	for _, ing := range ingresses {
+		if r.Recorder != nil {
+			for _, w := range translation.warnings[client.ObjectKeyFromObject(ing)] {
+				r.Recorder.Event(ing, corev1.EventTypeWarning, w.reason, w.message)
+			}
+		}
+		if err := r.updateIngressStatus(ctx, ing, addresses); err != nil {
+			return errorResult(err)
+		}
+	}
+
+	log.V(1).Info("Translated Ingresses", "ingresses", len(ingresses), "listeners", len(translation.listeners),
+		"clusters", len(translation.clusters))
+	return ctrl.Result{}, nil
+}
+
+// classIngresses returns the Ingresses of the class, oldest first
+func (r *IngressReconciler) classIngresses(ctx context.Context) ([]*networkingv1.Ingress, error) {
+	var list networkingv1.IngressList
+	if err := r.List(ctx, &list); err != nil {
+		return nil, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+	var ingresses []*networkingv1.Ingress
+	for i := range list.Items {
+		if ingressClassOf(&list.Items[i]) == r.IngressClassName {
+			ingresses = append(ingresses, &list.Items[i])
+		}
+	}
+	sort.SliceStable(ingresses, func(i, j int) bool {
+		a, b := ingresses[i], ingresses[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return client.ObjectKeyFromObject(a).String() < client.ObjectKeyFromObject(b).String()
+	})
+	return ingresses, nil
+}
+
+// ingressInputs looks up the Services and the TLS Secrets of the Ingresses
+func (r *IngressReconciler) ingressInputs(ctx context.Context, ingresses []*networkingv1.Ingress) (*ingressInputs, error) {
+	inputs := &ingressInputs{
+		services: make(map[client.ObjectKey]*corev1.Service),
+		secrets:  make(map[client.ObjectKey]*corev1.Secret),
+	}
+	getService := func(namespace string, backend *networkingv1.IngressBackend) error {
+		if backend == nil || backend.Service == nil {
+			return nil
+		}
+		key := client.ObjectKey{Namespace: namespace, Name: backend.Service.Name}
+		if _, ok := inputs.services[key]; ok {
+			return nil
+		}
+		svc := &corev1.Service{}
+		if err := r.Get(ctx, key, svc); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		inputs.services[key] = svc
+		return nil
+	}
+
+	for _, ing := range ingresses {
+		if err := getService(ing.Namespace, ing.Spec.DefaultBackend); err != nil {
+			return nil, fmt.Errorf("failed to get service: %w", err)
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for i := range rule.HTTP.Paths {
+				if err := getService(ing.Namespace, &rule.HTTP.Paths[i].Backend); err != nil {
+					return nil, fmt.Errorf("failed to get service: %w", err)
+				}
+			}
+		}
+		for _, tls := range ing.Spec.TLS {
+			key := client.ObjectKey{Namespace: ing.Namespace, Name: tls.SecretName}
+			if tls.SecretName == "" || inputs.secrets[key] != nil {
+				continue
+			}
+			secret := &corev1.Secret{}
+			if err := r.Get(ctx, key, secret); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to get secret %s: %w", key, err)
+			}
+			inputs.secrets[key] = secret
+		}
+	}
+	return inputs, nil
+}
+
+// loadBalancerStatus returns the addresses of the data plane Service of the
+// control plane: its load balancer addresses, else its external IPs, else its
+// cluster IPs. It returns nil for a control plane without a data plane.
+func (r *IngressReconciler) loadBalancerStatus(ctx context.Context, cp *api.XDSControlPlane) ([]networkingv1.IngressLoadBalancerIngress, error) {
+	if cp.Spec.DataPlane == nil {
+		return nil, nil
+	}
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: cp.Namespace, Name: dataPlaneName(cp)}, svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get data plane service: %w", err)
+	}
+
+	var addresses []networkingv1.IngressLoadBalancerIngress
+	for _, lb := range svc.Status.LoadBalancer.Ingress {
+		addresses = append(addresses, networkingv1.IngressLoadBalancerIngress{IP: lb.IP, Hostname: lb.Hostname})
+	}
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+	ips := svc.Spec.ExternalIPs
+	if len(ips) == 0 {
+		ips = svc.Spec.ClusterIPs
+	}
+	for _, ip := range ips {
+		if ip != "" && ip != corev1.ClusterIPNone {
+			addresses = append(addresses, networkingv1.IngressLoadBalancerIngress{IP: ip})
+		}
+	}
+	return addresses, nil
+}
+
+// updateIngressStatus writes the addresses of the data plane to the status of an Ingress if they changed
+func (r *IngressReconciler) updateIngressStatus(ctx context.Context, ing *networkingv1.Ingress, addresses []networkingv1.IngressLoadBalancerIngress) error {
+	if equality.Semantic.DeepEqual(ing.Status.LoadBalancer.Ingress, addresses) {
+		return nil
+	}
+	base := ing.DeepCopy()
+	ing.Status.LoadBalancer.Ingress = addresses
+	if err := r.Status().Patch(ctx, ing, client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{})); err != nil {
+		return fmt.Errorf("failed to update status of ingress %s/%s: %w", ing.Namespace, ing.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func testIngress(name string, minutes int, spec networkingv1.IngressSpec) *networkingv1.Ingress {
+	class := "xds"
+	spec.IngressClassName = &class
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "apps",
+			CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 1, 0, minutes, 0, 0, time.UTC)),
+		},
+		Spec: spec,
+	}
+}
+
+func ingressRule(host string, paths ...networkingv1.HTTPIngressPath) networkingv1.IngressRule {
+	return networkingv1.IngressRule{
+		Host:             host,
+		IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths}},
+	}
+}
+
+func ingressPath(pathType networkingv1.PathType, path, service string, port int32) networkingv1.HTTPIngressPath {
+	return networkingv1.HTTPIngressPath{
+		Path:     path,
+		PathType: &pathType,
+		Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+			Name: service,
+			Port: networkingv1.ServiceBackendPort{Number: port},
+		}},
+	}
+}
+
+func TestTranslateIngresses(t *testing.T) {
+	web := testIngress("web", 1, networkingv1.IngressSpec{
+		DefaultBackend: &networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+			Name: "web", Port: networkingv1.ServiceBackendPort{Name: "http"},
+		}},
+		Rules: []networkingv1.IngressRule{ingressRule("example.com",
+			ingressPath(networkingv1.PathTypePrefix, "/", "web", 80),
+			ingressPath(networkingv1.PathTypePrefix, "/api", "api", 8080),
+		)},
+		TLS: []networkingv1.IngressTLS{{Hosts: []string{"example.com"}, SecretName: "example"}, {SecretName: "example"}},
+	})
+	api2 := testIngress("api", 2, networkingv1.IngressSpec{
+		Rules: []networkingv1.IngressRule{ingressRule("example.com",
+			ingressPath(networkingv1.PathTypeExact, "/api/health", "missing", 80),
+		)},
+		TLS: []networkingv1.IngressTLS{
+			{Hosts: []string{"example.com", "api.example.com"}, SecretName: "api"},
+			{SecretName: "missing"},
+		},
+	})
+
+	tlsSecret := func(name string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("private-key")},
+		}
+	}
+	inputs := &ingressInputs{
+		services: map[client.ObjectKey]*corev1.Service{
+			{Namespace: "apps", Name: "web"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+			},
+			{Namespace: "apps", Name: "api"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "apps"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}},
+			},
+		},
+		secrets: map[client.ObjectKey]*corev1.Secret{
+			{Namespace: "apps", Name: "example"}: tlsSecret("example"),
+			{Namespace: "apps", Name: "api"}:     tlsSecret("api"),
+		},
+	}
+
+	translation := translateIngresses("xds", []*networkingv1.Ingress{web, api2}, inputs, 8080, 8443)
+
+	var clusters []string
+	for _, c := range translation.clusters {
+		clusters = append(clusters, c.Name)
+		assert.Equal(t, "eds", c.Type)
+		assert.Equal(t, "EndpointSlice", c.LoadAssignment.EndpointsFrom.Type)
+	}
+	assert.Equal(t, []string{"ingress/xds/apps/web/80", "ingress/xds/apps/api/8080"}, clusters)
+
+	// Exact paths first, then the longest prefix, then the default backend
+	require.Len(t, translation.routes, 1)
+	rc := translation.routes[0]
+	require.Len(t, rc.VirtualHosts, 2)
+	assert.Equal(t, []string{"*"}, rc.VirtualHosts[0].Domains)
+	assert.Equal(t, []string{"example.com"}, rc.VirtualHosts[1].Domains)
+	var routes []map[string]interface{}
+	for _, raw := range rc.VirtualHosts[1].Routes {
+		var route map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw.Raw, &route))
+		routes = append(routes, route)
+	}
+	require.Len(t, routes, 4)
+	assert.Equal(t, map[string]interface{}{"path": "/api/health"}, routes[0]["match"])
+	assert.Equal(t, map[string]interface{}{"status": float64(503)}, routes[0]["direct_response"])
+	assert.Equal(t, map[string]interface{}{"path_separated_prefix": "/api"}, routes[1]["match"])
+	assert.Equal(t, map[string]interface{}{"prefix": "/"}, routes[2]["match"])
+	assert.Equal(t, map[string]interface{}{"cluster": "ingress/xds/apps/web/80"}, routes[3]["route"])
+
+	// A filter chain per Secret, the older Ingress keeps its server name
+	require.Len(t, translation.listeners, 2)
+	assert.Equal(t, 8080, translation.listeners[0].Port)
+	https := translation.listeners[1]
+	assert.Equal(t, "ingress/xds/https", https.Name)
+	assert.Equal(t, 8443, https.Port)
+	require.Len(t, https.FilterChains, 2)
+	assert.Equal(t, []string{"example.com"}, https.FilterChains[0].FilterChainMatch.ServerNames)
+	assert.Equal(t, []string{"api.example.com"}, https.FilterChains[1].FilterChainMatch.ServerNames)
+	assert.Equal(t, "apps/api", https.FilterChains[1].Name)
+	assert.Equal(t, &api.DownstreamTLSSpec{SecretName: "api", SecretNamespace: "apps", ALPNProtocols: []string{"h2", "http/1.1"}}, https.FilterChains[1].TLS)
+	// The key stays in the Secret
+	translated, err := json.Marshal(translation.listeners)
+	require.NoError(t, err)
+	assert.NotContains(t, string(translated), "private-key")
+	assert.NotContains(t, string(translated), base64.StdEncoding.EncodeToString([]byte("private-key")))
+	// The Secret without hosts serves the connections matching no server name
+	require.NotNil(t, https.DefaultFilterChain)
+	assert.Nil(t, https.DefaultFilterChain.FilterChainMatch)
+
+	var reasons []string
+	for _, w := range translation.warnings[client.ObjectKeyFromObject(api2)] {
+		reasons = append(reasons, w.reason)
+	}
+	assert.ElementsMatch(t, []string{EventReasonBackendNotFound, EventReasonIngressConflict, EventReasonTLSSecretInvalid}, reasons)
+	assert.Empty(t, translation.warnings[client.ObjectKeyFromObject(web)])
+
+	// The translated resources are valid Envoy resources
+	r := &XDSControlPlaneReconciler{}
+	for _, l := range translation.listeners {
+		listener, err := r.buildListener(l)
+		require.NoError(t, err, l.Name)
+		if l.Name == https.Name {
+			for _, chain := range listener.FilterChains {
+				assert.NotNil(t, chain.TransportSocket)
+			}
+			assert.NotNil(t, listener.DefaultFilterChain.GetTransportSocket())
+		}
+	}
+	_, err = r.buildRouteConfiguration(rc)
+	assert.NoError(t, err)
+}
+
+func TestDiscoverEndpointSliceEndpoints(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, discoveryv1.AddToScheme(scheme))
+
+	name, other := "http", "metrics"
+	port, otherPort := int32(8080), int32(9090)
+	ready, notReady := true, false
+	slice := func(sliceName string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+		return &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      sliceName,
+				Namespace: "apps",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports:       []discoveryv1.EndpointPort{{Name: &other, Port: &otherPort}, {Name: &name, Port: &port}},
+			Endpoints:   endpoints,
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+		},
+		slice("web-b", discoveryv1.Endpoint{Addresses: []string{"10.1.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}}),
+		slice("web-a",
+			discoveryv1.Endpoint{Addresses: []string{"10.1.0.1"}},
+			discoveryv1.Endpoint{Addresses: []string{"10.1.0.3"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}}),
+	).Build()
+	r := &XDSControlPlaneReconciler{Client: c}
+
+	addrs, err := r.discoverEndpoints(ctx, &api.EndpointSelectorSpec{Type: "EndpointSlice", Name: "web", Namespace: "apps", Port: 80})
+	require.NoError(t, err)
+	assert.Equal(t, []discoveredEndpoint{{IP: "10.1.0.1", Port: 8080}, {IP: "10.1.0.2", Port: 8080}}, addrs)
+
+	_, err = r.discoverEndpoints(ctx, &api.EndpointSelectorSpec{Type: "EndpointSlice", Name: "web", Namespace: "apps", Port: 81})
+	assert.Error(t, err)
+
+	// EDS clusters serve the endpoints as a separate resource
+	cl, cla, err := r.buildCluster(ctx, api.ClusterSpec{
+		Name: "web", Type: "eds",
+		LoadAssignment: &api.LoadAssignmentSpec{EndpointsFrom: &api.EndpointSelectorSpec{Type: "EndpointSlice", Name: "web", Namespace: "apps", Port: 80}},
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, cl.GetEdsClusterConfig().GetEdsConfig().GetAds())
+	assert.Nil(t, cl.LoadAssignment)
+	require.NotNil(t, cla)
+	assert.Len(t, cla.Endpoints[0].LbEndpoints, 2)
+}
+
+func TestIngressReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, networkingv1.AddToScheme(scheme))
+
+	cp := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "infra"},
+		Spec:       api.XDSControlPlaneSpec{DataPlane: &api.DataPlaneSpec{}},
+	}
+	dataPlane := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge-envoy", Namespace: "infra"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.20", ClusterIPs: []string{"10.0.0.20"}},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}},
+		}},
+	}
+	web := testIngress("web", 1, networkingv1.IngressSpec{
+		Rules: []networkingv1.IngressRule{ingressRule("example.com", ingressPath(networkingv1.PathTypePrefix, "/", "web", 80))},
+	})
+	other := testIngress("other", 2, networkingv1.IngressSpec{})
+	otherClass := "nginx"
+	other.Spec.IngressClassName = &otherClass
+
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(cp, dataPlane, web, other,
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+			}).
+		WithStatusSubresource(web, other).
+		Build()
+	r := &IngressReconciler{Client: c, Scheme: scheme, IngressClassName: "xds", ControlPlane: client.ObjectKeyFromObject(cp)}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// The translated resources are owned by the control plane, in its namespace
+	var listeners api.XDSListenerList
+	require.NoError(t, c.List(ctx, &listeners, client.InNamespace("infra")))
+	require.Len(t, listeners.Items, 1)
+	assert.Equal(t, "ingress/xds/http", listeners.Items[0].Spec.Name)
+	assert.Equal(t, &api.ControlPlaneReference{Name: "edge"}, listeners.Items[0].Spec.ControlPlaneRef)
+	assert.True(t, metav1.IsControlledBy(&listeners.Items[0], cp))
+	var clusters api.XDSClusterList
+	require.NoError(t, c.List(ctx, &clusters, client.InNamespace("infra")))
+	require.Len(t, clusters.Items, 1)
+	var routeConfigs api.XDSRouteConfigList
+	require.NoError(t, c.List(ctx, &routeConfigs, client.InNamespace("infra")))
+	require.Len(t, routeConfigs.Items, 1)
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(web), web))
+	assert.Equal(t, []networkingv1.IngressLoadBalancerIngress{{IP: "203.0.113.10"}}, web.Status.LoadBalancer.Ingress)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(other), other))
+	assert.Empty(t, other.Status.LoadBalancer.Ingress)
+
+	// Clusters of removed Ingresses are deleted
+	require.NoError(t, c.Delete(ctx, web))
+	_, err = r.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, c.List(ctx, &clusters, client.InNamespace("infra")))
+	assert.Empty(t, clusters.Items)
+}
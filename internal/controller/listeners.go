@@ -0,0 +1,851 @@
+package controller
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	xdscore "github.com/cncf/xds/go/xds/core/v3"
+	matcher "github.com/cncf/xds/go/xds/type/matcher/v3"
+	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	trace "github.com/envoyproxy/go-control-plane/envoy/config/trace/v3"
+	router "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	http_connection_manager "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	udp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	proxy_protocol "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/proxy_protocol/v3"
+	raw_buffer "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/raw_buffer/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoytype "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	// HTTP filters usable in the http section of listeners, in addition to the router
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/grpc_web/v3"
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/health_check/v3"
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/lua/v3"
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/rbac/v3"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	httpConnectionManagerFilter = "envoy.filters.network.http_connection_manager"
+	routerFilter                = "envoy.filters.http.router"
+	tcpProxyFilter              = "envoy.filters.network.tcp_proxy"
+	udpProxyFilter              = "envoy.filters.udp_listener.udp_proxy"
+)
+
+// typedListenerSections returns how many of the mutually exclusive
+// filterChains, http, tcp and udp sections a listener sets
+func typedListenerSections(l api.ListenerSpec) int {
+	n := 0
+	for _, set := range []bool{len(l.FilterChains) > 0, l.HTTP != nil, l.TCP != nil, l.UDP != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// buildHTTPFilterChain expands the http section of a listener into a filter
+// chain with an http_connection_manager filter
+func (r *XDSControlPlaneReconciler) buildHTTPFilterChain(l api.ListenerSpec) (*listener.FilterChain, error) {
+	hcm, err := r.buildHTTPConnectionManager(l.Name, l.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	anyCfg, err := anypb.New(hcm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal http_connection_manager config: %w", err)
+	}
+	return &listener.FilterChain{
+		Filters: []*listener.Filter{{
+			Name:       httpConnectionManagerFilter,
+			ConfigType: &listener.Filter_TypedConfig{TypedConfig: anyCfg},
+		}},
+	}, nil
+}
+
+// buildHTTPConnectionManager builds the http_connection_manager of a listener
+// from its http section
+func (r *XDSControlPlaneReconciler) buildHTTPConnectionManager(listenerName string, h *api.HTTPListenerSpec) (*http_connection_manager.HttpConnectionManager, error) {
+	hcm := &http_connection_manager.HttpConnectionManager{
+		StatPrefix: h.StatPrefix,
+	}
+	if hcm.StatPrefix == "" {
+		hcm.StatPrefix = listenerName
+	}
+
+	switch {
+	case h.RouteConfigName != "" && len(h.VirtualHosts) > 0:
+		return nil, fmt.Errorf("routeConfigName and virtualHosts are mutually exclusive")
+	case h.RouteConfigName != "":
+		hcm.RouteSpecifier = &http_connection_manager.HttpConnectionManager_Rds{
+			Rds: &http_connection_manager.Rds{
+				RouteConfigName: h.RouteConfigName,
+				ConfigSource: &core.ConfigSource{
+					ResourceApiVersion:    core.ApiVersion_V3,
+					ConfigSourceSpecifier: &core.ConfigSource_Ads{Ads: &core.AggregatedConfigSource{}},
+				},
+			},
+		}
+	case len(h.VirtualHosts) > 0:
+		rc, err := r.buildRouteConfiguration(api.RouteConfigSpec{Name: listenerName, VirtualHosts: h.VirtualHosts})
+		if err != nil {
+			return nil, err
+		}
+		hcm.RouteSpecifier = &http_connection_manager.HttpConnectionManager_RouteConfig{RouteConfig: rc}
+	default:
+		return nil, fmt.Errorf("one of routeConfigName and virtualHosts is required")
+	}
+
+	if h.CodecType != "" {
+		codec, ok := http_connection_manager.HttpConnectionManager_CodecType_value[h.CodecType]
+		if !ok {
+			return nil, fmt.Errorf("invalid codec type %s", h.CodecType)
+		}
+		hcm.CodecType = http_connection_manager.HttpConnectionManager_CodecType(codec)
+	}
+
+	timeouts := []struct {
+		field string
+		value string
+		set   func(*durationpb.Duration)
+	}{
+		{"streamIdleTimeout", h.StreamIdleTimeout, func(d *durationpb.Duration) { hcm.StreamIdleTimeout = d }},
+		{"requestTimeout", h.RequestTimeout, func(d *durationpb.Duration) { hcm.RequestTimeout = d }},
+		{"requestHeadersTimeout", h.RequestHeadersTimeout, func(d *durationpb.Duration) { hcm.RequestHeadersTimeout = d }},
+		{"drainTimeout", h.DrainTimeout, func(d *durationpb.Duration) { hcm.DrainTimeout = d }},
+		{"idleTimeout", h.IdleTimeout, func(d *durationpb.Duration) {
+			hcm.CommonHttpProtocolOptions = commonHTTPProtocolOptions(hcm)
+			hcm.CommonHttpProtocolOptions.IdleTimeout = d
+		}},
+	}
+	for _, t := range timeouts {
+		if t.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", t.field, err)
+		}
+		t.set(durationpb.New(d))
+	}
+
+	if h.UseRemoteAddress != nil {
+		hcm.UseRemoteAddress = wrapperspb.Bool(*h.UseRemoteAddress)
+	}
+	hcm.XffNumTrustedHops = uint32(h.XffNumTrustedHops)
+	if h.MaxRequestHeadersKb > 0 {
+		hcm.MaxRequestHeadersKb = wrapperspb.UInt32(uint32(h.MaxRequestHeadersKb))
+	}
+	if h.MaxHeadersCount > 0 {
+		hcm.CommonHttpProtocolOptions = commonHTTPProtocolOptions(hcm)
+		hcm.CommonHttpProtocolOptions.MaxHeadersCount = wrapperspb.UInt32(uint32(h.MaxHeadersCount))
+	}
+
+	for _, f := range h.HTTPFilters {
+		anyCfg, err := typedConfigToAny(f.TypedConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert http filter %s config: %w", f.Name, err)
+		}
+		hcm.HttpFilters = append(hcm.HttpFilters, &http_connection_manager.HttpFilter{
+			Name:       f.Name,
+			ConfigType: &http_connection_manager.HttpFilter_TypedConfig{TypedConfig: anyCfg},
+		})
+	}
+	// The router filter terminates the filter chain
+	if n := len(hcm.HttpFilters); n == 0 || !hcm.HttpFilters[n-1].GetTypedConfig().MessageIs(&router.Router{}) {
+		anyCfg, err := anypb.New(&router.Router{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal router config: %w", err)
+		}
+		hcm.HttpFilters = append(hcm.HttpFilters, &http_connection_manager.HttpFilter{
+			Name:       routerFilter,
+			ConfigType: &http_connection_manager.HttpFilter_TypedConfig{TypedConfig: anyCfg},
+		})
+	}
+
+	if t := h.Tracing; t != nil {
+		tracing := &http_connection_manager.HttpConnectionManager_Tracing{
+			RandomSampling:  samplingPercent(t.RandomSampling),
+			ClientSampling:  samplingPercent(t.ClientSampling),
+			OverallSampling: samplingPercent(t.OverallSampling),
+		}
+		if p := t.Provider; p != nil {
+			anyCfg, err := typedConfigToAny(p.TypedConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert tracing provider %s config: %w", p.Name, err)
+			}
+			tracing.Provider = &trace.Tracing_Http{
+				Name:       p.Name,
+				ConfigType: &trace.Tracing_Http_TypedConfig{TypedConfig: anyCfg},
+			}
+		}
+		hcm.Tracing = tracing
+	}
+
+	if err := hcm.ValidateAll(); err != nil {
+		return nil, fmt.Errorf("invalid http_connection_manager config: %w", err)
+	}
+	return hcm, nil
+}
+
+func commonHTTPProtocolOptions(hcm *http_connection_manager.HttpConnectionManager) *core.HttpProtocolOptions {
+	if hcm.CommonHttpProtocolOptions != nil {
+		return hcm.CommonHttpProtocolOptions
+	}
+	return &core.HttpProtocolOptions{}
+}
+
+func samplingPercent(p *int32) *envoytype.Percent {
+	if p == nil {
+		return nil
+	}
+	return &envoytype.Percent{Value: float64(*p)}
+}
+
+// typedConfigToAny converts a typed config with an @type to an Any. Unlike
+// jsonToAny, it resolves the message type from the protobuf registry, so it
+// works for every extension linked into the operator.
+func typedConfigToAny(in apiextensionsv1.JSON) (*anypb.Any, error) {
+	if len(in.Raw) == 0 {
+		return nil, fmt.Errorf("empty typed config")
+	}
+	out := &anypb.Any{}
+	if err := protojson.Unmarshal(in.Raw, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// buildTCPFilterChain expands the tcp section of a listener into a filter
+// chain with a tcp_proxy filter
+func (r *XDSControlPlaneReconciler) buildTCPFilterChain(l api.ListenerSpec) (*listener.FilterChain, error) {
+	tcp := l.TCP
+	tp := &tcp_proxy.TcpProxy{StatPrefix: tcp.StatPrefix}
+	if tp.StatPrefix == "" {
+		tp.StatPrefix = l.Name
+	}
+
+	switch {
+	case tcp.Cluster != "" && len(tcp.WeightedClusters) > 0:
+		return nil, fmt.Errorf("cluster and weightedClusters are mutually exclusive")
+	case tcp.Cluster != "":
+		tp.ClusterSpecifier = &tcp_proxy.TcpProxy_Cluster{Cluster: tcp.Cluster}
+	case len(tcp.WeightedClusters) > 0:
+		weighted := &tcp_proxy.TcpProxy_WeightedCluster{}
+		for _, wc := range tcp.WeightedClusters {
+			weighted.Clusters = append(weighted.Clusters, &tcp_proxy.TcpProxy_WeightedCluster_ClusterWeight{
+				Name:   wc.Name,
+				Weight: uint32(wc.Weight),
+			})
+		}
+		tp.ClusterSpecifier = &tcp_proxy.TcpProxy_WeightedClusters{WeightedClusters: weighted}
+	default:
+		return nil, fmt.Errorf("one of cluster and weightedClusters is required")
+	}
+
+	if tcp.IdleTimeout != "" {
+		d, err := time.ParseDuration(tcp.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid idleTimeout: %w", err)
+		}
+		tp.IdleTimeout = durationpb.New(d)
+	}
+	if tcp.MaxConnectAttempts > 0 {
+		tp.MaxConnectAttempts = wrapperspb.UInt32(uint32(tcp.MaxConnectAttempts))
+	}
+
+	accessLogs, err := r.buildAccessLogs(tcp.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+	tp.AccessLog = accessLogs
+
+	if hp := tcp.HashPolicy; hp != nil {
+		switch hp.Type {
+		case "SourceIP":
+			tp.HashPolicy = append(tp.HashPolicy, &envoytype.HashPolicy{
+				PolicySpecifier: &envoytype.HashPolicy_SourceIp_{SourceIp: &envoytype.HashPolicy_SourceIp{}},
+			})
+		case "FilterState":
+			tp.HashPolicy = append(tp.HashPolicy, &envoytype.HashPolicy{
+				PolicySpecifier: &envoytype.HashPolicy_FilterState_{FilterState: &envoytype.HashPolicy_FilterState{Key: hp.FilterStateKey}},
+			})
+		default:
+			return nil, fmt.Errorf("invalid hash policy type %s", hp.Type)
+		}
+	}
+	if tcp.UpstreamProxyProtocol != "" {
+		if _, ok := core.ProxyProtocolConfig_Version_value[tcp.UpstreamProxyProtocol]; !ok {
+			return nil, fmt.Errorf("invalid upstream proxy protocol version %s", tcp.UpstreamProxyProtocol)
+		}
+	}
+
+	if err := tp.ValidateAll(); err != nil {
+		return nil, fmt.Errorf("invalid tcp_proxy config: %w", err)
+	}
+	anyCfg, err := anypb.New(tp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tcp_proxy config: %w", err)
+	}
+	return &listener.FilterChain{
+		Filters: []*listener.Filter{{
+			Name:       tcpProxyFilter,
+			ConfigType: &listener.Filter_TypedConfig{TypedConfig: anyCfg},
+		}},
+	}, nil
+}
+
+// buildUDPProxyFilter expands the udp section of a listener into a
+// udp_proxy listener filter, routing all sessions to the cluster
+func (r *XDSControlPlaneReconciler) buildUDPProxyFilter(l api.ListenerSpec) (*listener.ListenerFilter, error) {
+	udp := l.UDP
+	up := &udp_proxy.UdpProxyConfig{StatPrefix: udp.StatPrefix}
+	if up.StatPrefix == "" {
+		up.StatPrefix = l.Name
+	}
+	if udp.Cluster == "" {
+		return nil, fmt.Errorf("cluster is required")
+	}
+
+	// The cluster field is deprecated, the route is the action of a matcher
+	// without matchers
+	route, err := anypb.New(&udp_proxy.Route{Cluster: udp.Cluster})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal udp_proxy route: %w", err)
+	}
+	up.RouteSpecifier = &udp_proxy.UdpProxyConfig_Matcher{Matcher: &matcher.Matcher{
+		OnNoMatch: &matcher.Matcher_OnMatch{OnMatch: &matcher.Matcher_OnMatch_Action{
+			Action: &xdscore.TypedExtensionConfig{Name: "route", TypedConfig: route},
+		}},
+	}}
+
+	if udp.IdleTimeout != "" {
+		d, err := time.ParseDuration(udp.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid idleTimeout: %w", err)
+		}
+		up.IdleTimeout = durationpb.New(d)
+	}
+
+	if hp := udp.HashPolicy; hp != nil {
+		switch hp.Type {
+		case "SourceIP":
+			up.HashPolicies = append(up.HashPolicies, &udp_proxy.UdpProxyConfig_HashPolicy{
+				PolicySpecifier: &udp_proxy.UdpProxyConfig_HashPolicy_SourceIp{SourceIp: true},
+			})
+		case "Key":
+			up.HashPolicies = append(up.HashPolicies, &udp_proxy.UdpProxyConfig_HashPolicy{
+				PolicySpecifier: &udp_proxy.UdpProxyConfig_HashPolicy_Key{Key: hp.Key},
+			})
+		default:
+			return nil, fmt.Errorf("invalid hash policy type %s", hp.Type)
+		}
+	}
+
+	accessLogs, err := r.buildAccessLogs(udp.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+	up.AccessLog = accessLogs
+
+	if err := up.ValidateAll(); err != nil {
+		return nil, fmt.Errorf("invalid udp_proxy config: %w", err)
+	}
+	anyCfg, err := anypb.New(up)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal udp_proxy config: %w", err)
+	}
+	return &listener.ListenerFilter{
+		Name:       udpProxyFilter,
+		ConfigType: &listener.ListenerFilter_TypedConfig{TypedConfig: anyCfg},
+	}, nil
+}
+
+// buildAccessLogs converts access log specs to Envoy access logs
+func (r *XDSControlPlaneReconciler) buildAccessLogs(specs []api.AccessLogSpec) ([]*accesslog.AccessLog, error) {
+	var accessLogs []*accesslog.AccessLog
+	for _, al := range specs {
+		anyConfig, err := r.jsonToAny(al.Name, al.TypedConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert access log config: %w", err)
+		}
+		accessLogs = append(accessLogs, &accesslog.AccessLog{
+			Name: al.Name,
+			ConfigType: &accesslog.AccessLog_TypedConfig{
+				TypedConfig: anyConfig,
+			},
+		})
+	}
+	return accessLogs, nil
+}
+
+// proxyProtocolClusterName returns the name of the copy of a cluster sending
+// a PROXY protocol header
+func proxyProtocolClusterName(name, version string) string {
+	return name + "/proxy_protocol_" + strings.ToLower(version)
+}
+
+// filterChains returns the filter chains of a listener, its default filter
+// chain last
+func filterChains(l *listener.Listener) []*listener.FilterChain {
+	if l.DefaultFilterChain == nil {
+		return l.FilterChains
+	}
+	return append(slices.Clip(l.FilterChains), l.DefaultFilterChain)
+}
+
+// applyUpstreamProxyProtocol points the tcp_proxy of the tcp listeners with
+// upstreamProxyProtocol at copies of their clusters wrapping the transport
+// socket in a ProxyProtocolUpstreamTransport, and returns the copies. The
+// copies share the endpoints of the clusters. It runs after the canary
+// weights are applied so that the canary cluster is copied as well.
+func applyUpstreamProxyProtocol(specs []api.ListenerSpec, listeners, clusters []types.Resource) ([]types.Resource, error) {
+	versions := make(map[string]string)
+	for _, l := range specs {
+		if l.TCP != nil && l.TCP.UpstreamProxyProtocol != "" {
+			versions[l.Name] = l.TCP.UpstreamProxyProtocol
+		}
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	byName := make(map[string]*cluster.Cluster, len(clusters))
+	for _, c := range clusters {
+		byName[c.(*cluster.Cluster).Name] = c.(*cluster.Cluster)
+	}
+
+	var copies []types.Resource
+	copied := make(map[string]bool)
+	copyCluster := func(name, version string) (string, error) {
+		copyName := proxyProtocolClusterName(name, version)
+		original, ok := byName[name]
+		if !ok || copied[copyName] {
+			// Connections to a missing cluster fail like without proxy protocol
+			return copyName, nil
+		}
+		c, err := proxyProtocolCluster(original, copyName, version)
+		if err != nil {
+			return "", err
+		}
+		copied[copyName] = true
+		copies = append(copies, c)
+		return copyName, nil
+	}
+
+	for _, res := range listeners {
+		l := res.(*listener.Listener)
+		version, ok := versions[l.Name]
+		if !ok {
+			continue
+		}
+		for _, chain := range filterChains(l) {
+			for _, f := range chain.Filters {
+				typed := f.GetTypedConfig()
+				if typed == nil || !typed.MessageIs(&tcp_proxy.TcpProxy{}) {
+					continue
+				}
+				var tp tcp_proxy.TcpProxy
+				if err := typed.UnmarshalTo(&tp); err != nil {
+					return nil, fmt.Errorf("listener %s: failed to decode tcp_proxy config: %w", l.Name, err)
+				}
+				if name := tp.GetCluster(); name != "" {
+					copyName, err := copyCluster(name, version)
+					if err != nil {
+						return nil, fmt.Errorf("listener %s: %w", l.Name, err)
+					}
+					tp.ClusterSpecifier = &tcp_proxy.TcpProxy_Cluster{Cluster: copyName}
+				}
+				for _, wc := range tp.GetWeightedClusters().GetClusters() {
+					copyName, err := copyCluster(wc.Name, version)
+					if err != nil {
+						return nil, fmt.Errorf("listener %s: %w", l.Name, err)
+					}
+					wc.Name = copyName
+				}
+				anyCfg, err := anypb.New(&tp)
+				if err != nil {
+					return nil, fmt.Errorf("listener %s: failed to encode tcp_proxy config: %w", l.Name, err)
+				}
+				f.ConfigType = &listener.Filter_TypedConfig{TypedConfig: anyCfg}
+			}
+		}
+	}
+	return copies, nil
+}
+
+// proxyProtocolCluster returns a copy of a cluster sending a PROXY protocol
+// header before the data of its own transport socket
+func proxyProtocolCluster(original *cluster.Cluster, name, version string) (*cluster.Cluster, error) {
+	c := proto.Clone(original).(*cluster.Cluster)
+	c.Name = name
+	if eds := c.GetEdsClusterConfig(); eds != nil && eds.ServiceName == "" {
+		// Share the ClusterLoadAssignment of the original cluster
+		eds.ServiceName = original.Name
+	}
+
+	inner := c.TransportSocket
+	if inner == nil {
+		rawBuffer, err := anypb.New(&raw_buffer.RawBuffer{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal raw_buffer config: %w", err)
+		}
+		inner = &core.TransportSocket{
+			Name:       "envoy.transport_sockets.raw_buffer",
+			ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: rawBuffer},
+		}
+	}
+	upstream, err := anypb.New(&proxy_protocol.ProxyProtocolUpstreamTransport{
+		Config:          &core.ProxyProtocolConfig{Version: core.ProxyProtocolConfig_Version(core.ProxyProtocolConfig_Version_value[version])},
+		TransportSocket: inner,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proxy protocol transport config: %w", err)
+	}
+	c.TransportSocket = &core.TransportSocket{
+		Name:       "envoy.transport_sockets.upstream_proxy_protocol",
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: upstream},
+	}
+	return c, nil
+}
+
+// buildFilterChain builds a filter chain with its match criteria and
+// transport socket
+func (r *XDSControlPlaneReconciler) buildFilterChain(chain api.FilterChainSpec) (*listener.FilterChain, error) {
+	filters := make([]*listener.Filter, 0, len(chain.Filters))
+	for _, f := range chain.Filters {
+		anyCfg, err := r.jsonToAny(f.Name, f.TypedConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert filter config: %w", err)
+		}
+		filters = append(filters, &listener.Filter{
+			Name: f.Name,
+			ConfigType: &listener.Filter_TypedConfig{
+				TypedConfig: anyCfg,
+			},
+		})
+	}
+	filterChain := &listener.FilterChain{Name: chain.Name, Filters: filters}
+	if m := chain.FilterChainMatch; m != nil {
+		match, err := buildFilterChainMatch(m)
+		if err != nil {
+			return nil, err
+		}
+		filterChain.FilterChainMatch = match
+	}
+	if ts := chain.TransportSocket; ts != nil {
+		anyTS, err := r.jsonToAny(ts.Name, ts.TypedConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert filter chain transport socket config: %w", err)
+		}
+		filterChain.TransportSocket = &core.TransportSocket{
+			Name:       ts.Name,
+			ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: anyTS},
+		}
+	}
+	if chain.TLS != nil {
+		if chain.TransportSocket != nil {
+			return nil, fmt.Errorf("tls and transportSocket are mutually exclusive")
+		}
+		ts, err := buildDownstreamTLS(chain.TLS)
+		if err != nil {
+			return nil, err
+		}
+		filterChain.TransportSocket = ts
+	}
+	return filterChain, nil
+}
+
+// buildDownstreamTLS returns a TLS transport socket fetching its certificate
+// over SDS, so that the private key never appears in the listener
+func buildDownstreamTLS(t *api.DownstreamTLSSpec) (*core.TransportSocket, error) {
+	tlsContext, err := anypb.New(&tls.DownstreamTlsContext{
+		CommonTlsContext: &tls.CommonTlsContext{
+			AlpnProtocols: t.ALPNProtocols,
+			TlsCertificateSdsSecretConfigs: []*tls.SdsSecretConfig{{
+				Name: tlsSecretKey(t).String(),
+				SdsConfig: &core.ConfigSource{
+					ResourceApiVersion:    core.ApiVersion_V3,
+					ConfigSourceSpecifier: &core.ConfigSource_Ads{Ads: &core.AggregatedConfigSource{}},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal downstream TLS context: %w", err)
+	}
+	return &core.TransportSocket{
+		Name:       "envoy.transport_sockets.tls",
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: tlsContext},
+	}, nil
+}
+
+var filterChainSourceTypes = map[string]listener.FilterChainMatch_ConnectionSourceType{
+	"Any":              listener.FilterChainMatch_ANY,
+	"SameIPOrLoopback": listener.FilterChainMatch_SAME_IP_OR_LOOPBACK,
+	"External":         listener.FilterChainMatch_EXTERNAL,
+}
+
+func buildFilterChainMatch(m *api.FilterChainMatchSpec) (*listener.FilterChainMatch, error) {
+	match := &listener.FilterChainMatch{
+		ServerNames:          m.ServerNames,
+		TransportProtocol:    m.TransportProtocol,
+		ApplicationProtocols: m.ApplicationProtocols,
+	}
+	if m.DestinationPort > 0 {
+		match.DestinationPort = wrapperspb.UInt32(uint32(m.DestinationPort))
+	}
+	for _, p := range m.SourcePorts {
+		match.SourcePorts = append(match.SourcePorts, uint32(p))
+	}
+	if m.SourceType != "" {
+		sourceType, ok := filterChainSourceTypes[m.SourceType]
+		if !ok {
+			return nil, fmt.Errorf("invalid source type %s", m.SourceType)
+		}
+		match.SourceType = sourceType
+	}
+
+	ranges := []struct {
+		field string
+		cidrs []string
+		set   func([]*core.CidrRange)
+	}{
+		{"prefixRanges", m.PrefixRanges, func(r []*core.CidrRange) { match.PrefixRanges = r }},
+		{"sourcePrefixRanges", m.SourcePrefixRanges, func(r []*core.CidrRange) { match.SourcePrefixRanges = r }},
+		{"directSourcePrefixRanges", m.DirectSourcePrefixRanges, func(r []*core.CidrRange) { match.DirectSourcePrefixRanges = r }},
+	}
+	for _, rng := range ranges {
+		cidrs, err := cidrRanges(rng.cidrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", rng.field, err)
+		}
+		rng.set(cidrs)
+	}
+
+	if err := match.ValidateAll(); err != nil {
+		return nil, fmt.Errorf("invalid filter chain match: %w", err)
+	}
+	return match, nil
+}
+
+// cidrRanges parses CIDRs such as 10.0.0.0/8 or fd00::/8. An address
+// without prefix length matches the address only.
+func cidrRanges(cidrs []string) ([]*core.CidrRange, error) {
+	var ranges []*core.CidrRange
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		ranges = append(ranges, &core.CidrRange{
+			AddressPrefix: prefix.Masked().Addr().String(),
+			PrefixLen:     wrapperspb.UInt32(uint32(prefix.Bits())),
+		})
+	}
+	return ranges, nil
+}
+
+// Socket options of TCP keepalive, with the values of Linux on which the data
+// plane runs
+const (
+	solSocket    = 1
+	soKeepalive  = 9
+	ipprotoTCP   = 6
+	tcpKeepidle  = 4
+	tcpKeepintvl = 5
+	tcpKeepcnt   = 6
+)
+
+// listenerProtocol returns the protocol of the socket of a listener, TCP or
+// UDP. Listeners with a udp section are UDP listeners.
+func listenerProtocol(l api.ListenerSpec) string {
+	if l.Protocol == "UDP" || l.UDP != nil {
+		return "UDP"
+	}
+	return "TCP"
+}
+
+// listenerAddress returns a socket address of a listener with its protocol
+func listenerAddress(address string, port int, protocol string) *core.Address {
+	p := core.SocketAddress_TCP
+	if protocol == "UDP" {
+		p = core.SocketAddress_UDP
+	}
+	return &core.Address{Address: &core.Address_SocketAddress{SocketAddress: &core.SocketAddress{
+		Protocol:      p,
+		Address:       address,
+		PortSpecifier: &core.SocketAddress_PortValue{PortValue: uint32(port)},
+	}}}
+}
+
+// applyListenerSettings sets the socket, connection and addressing settings
+// of a listener. Internal listeners get no address, UDP listeners a
+// udp_listener_config.
+func applyListenerSettings(l api.ListenerSpec, lis *listener.Listener) {
+	protocol := listenerProtocol(l)
+	if l.Internal != nil {
+		lis.ListenerSpecifier = &listener.Listener_InternalListener{
+			InternalListener: &listener.Listener_InternalListenerConfig{},
+		}
+	} else {
+		lis.Address = listenerAddress(l.Address, l.Port, protocol)
+		for _, a := range l.AdditionalAddresses {
+			lis.AdditionalAddresses = append(lis.AdditionalAddresses, &listener.AdditionalAddress{
+				Address: listenerAddress(a.Address, a.Port, protocol),
+			})
+		}
+	}
+
+	if l.PerConnectionBufferLimitBytes != nil {
+		lis.PerConnectionBufferLimitBytes = wrapperspb.UInt32(uint32(*l.PerConnectionBufferLimitBytes))
+	}
+	lis.SocketOptions = listenerSocketOptions(l)
+	if l.EnableReusePort != nil {
+		lis.EnableReusePort = wrapperspb.Bool(*l.EnableReusePort)
+	}
+	if l.ConnectionBalance == "Exact" {
+		lis.ConnectionBalanceConfig = &listener.Listener_ConnectionBalanceConfig{
+			BalanceType: &listener.Listener_ConnectionBalanceConfig_ExactBalance_{
+				ExactBalance: &listener.Listener_ConnectionBalanceConfig_ExactBalance{},
+			},
+		}
+	}
+	if l.BindToPort != nil {
+		lis.BindToPort = wrapperspb.Bool(*l.BindToPort)
+	}
+	if l.Transparent != nil {
+		lis.Transparent = wrapperspb.Bool(*l.Transparent)
+	}
+
+	if protocol == "UDP" || l.UDPListenerConfig != nil {
+		udp := &listener.UdpListenerConfig{}
+		if c := l.UDPListenerConfig; c != nil {
+			socket := &core.UdpSocketConfig{}
+			if c.MaxRxDatagramSize != nil {
+				socket.MaxRxDatagramSize = wrapperspb.UInt64(uint64(*c.MaxRxDatagramSize))
+			}
+			if c.PreferGro != nil {
+				socket.PreferGro = wrapperspb.Bool(*c.PreferGro)
+			}
+			udp.DownstreamSocketConfig = socket
+		}
+		lis.UdpListenerConfig = udp
+	}
+}
+
+// listenerSocketOptions returns the socket options of a listener, followed by
+// the options enabling its TCP keepalive
+func listenerSocketOptions(l api.ListenerSpec) []*core.SocketOption {
+	var options []*core.SocketOption
+	for _, o := range l.SocketOptions {
+		option := &core.SocketOption{
+			Description: o.Description,
+			Level:       o.Level,
+			Name:        o.Name,
+			State:       socketOptionState(o.State),
+		}
+		if o.IntValue != nil {
+			option.Value = &core.SocketOption_IntValue{IntValue: *o.IntValue}
+		} else {
+			option.Value = &core.SocketOption_BufValue{BufValue: o.BufValue}
+		}
+		options = append(options, option)
+	}
+
+	// Accepted connections inherit the keepalive options of the listening socket
+	if k := l.TCPKeepalive; k != nil {
+		keepalive := func(description string, level, name int64, value int64) *core.SocketOption {
+			return &core.SocketOption{
+				Description: description,
+				Level:       level,
+				Name:        name,
+				Value:       &core.SocketOption_IntValue{IntValue: value},
+				State:       core.SocketOption_STATE_LISTENING,
+			}
+		}
+		options = append(options, keepalive("SO_KEEPALIVE", solSocket, soKeepalive, 1))
+		if k.Time != nil {
+			options = append(options, keepalive("TCP_KEEPIDLE", ipprotoTCP, tcpKeepidle, int64(*k.Time)))
+		}
+		if k.Interval != nil {
+			options = append(options, keepalive("TCP_KEEPINTVL", ipprotoTCP, tcpKeepintvl, int64(*k.Interval)))
+		}
+		if k.Probes != nil {
+			options = append(options, keepalive("TCP_KEEPCNT", ipprotoTCP, tcpKeepcnt, int64(*k.Probes)))
+		}
+	}
+	return options
+}
+
+func socketOptionState(state string) core.SocketOption_SocketState {
+	switch state {
+	case "Bound":
+		return core.SocketOption_STATE_BOUND
+	case "Listening":
+		return core.SocketOption_STATE_LISTENING
+	default:
+		return core.SocketOption_STATE_PREBIND
+	}
+}
+
+// internalListenerBufferSizeKb returns whether a control plane has internal
+// listeners, and the largest buffer size they ask for
+func internalListenerBufferSizeKb(crd *api.XDSControlPlane) (bool, int32) {
+	found, size := false, int32(0)
+	for _, l := range crd.Spec.Listeners {
+		if l.Internal == nil {
+			continue
+		}
+		found = true
+		if l.Internal.BufferSizeKb != nil && *l.Internal.BufferSizeKb > size {
+			size = *l.Internal.BufferSizeKb
+		}
+	}
+	return found, size
+}
+
+// internalListenerLoadAssignment returns the load assignment of a cluster
+// connecting to an internal listener of the same Envoy
+func internalListenerLoadAssignment(clusterName, listenerName string) *endpoint.ClusterLoadAssignment {
+	return &endpoint.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints: []*endpoint.LocalityLbEndpoints{{
+			LbEndpoints: []*endpoint.LbEndpoint{{
+				HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+					Endpoint: &endpoint.Endpoint{
+						Address: &core.Address{Address: &core.Address_EnvoyInternalAddress{
+							EnvoyInternalAddress: &core.EnvoyInternalAddress{
+								AddressNameSpecifier: &core.EnvoyInternalAddress_ServerListenerName{
+									ServerListenerName: listenerName,
+								},
+							},
+						}},
+					},
+				},
+			}},
+		}},
+	}
+}
@@ -0,0 +1,571 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	http_connection_manager "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	udp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	proxy_protocol "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/proxy_protocol/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestBuildHTTPListener(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+
+	decodeHCM := func(t *testing.T, l api.ListenerSpec) *http_connection_manager.HttpConnectionManager {
+		t.Helper()
+		built, err := reconciler.buildListener(l)
+		require.NoError(t, err)
+		require.Len(t, built.FilterChains, 1)
+		require.Len(t, built.FilterChains[0].Filters, 1)
+		f := built.FilterChains[0].Filters[0]
+		assert.Equal(t, "envoy.filters.network.http_connection_manager", f.Name)
+		var hcm http_connection_manager.HttpConnectionManager
+		require.NoError(t, f.GetTypedConfig().UnmarshalTo(&hcm))
+		return &hcm
+	}
+
+	t.Run("RDS", func(t *testing.T) {
+		useRemoteAddress := true
+		hcm := decodeHCM(t, api.ListenerSpec{
+			Name: "http", Address: "0.0.0.0", Port: 8080,
+			HTTP: &api.HTTPListenerSpec{
+				RouteConfigName:       "routes",
+				CodecType:             "HTTP2",
+				StreamIdleTimeout:     "5m",
+				RequestTimeout:        "30s",
+				RequestHeadersTimeout: "10s",
+				IdleTimeout:           "1h",
+				DrainTimeout:          "5s",
+				UseRemoteAddress:      &useRemoteAddress,
+				XffNumTrustedHops:     2,
+				MaxRequestHeadersKb:   96,
+				MaxHeadersCount:       50,
+			},
+		})
+
+		assert.Equal(t, "http", hcm.StatPrefix)
+		assert.Equal(t, "routes", hcm.GetRds().RouteConfigName)
+		assert.NotNil(t, hcm.GetRds().GetConfigSource().GetAds())
+		assert.Equal(t, http_connection_manager.HttpConnectionManager_HTTP2, hcm.CodecType)
+		assert.Equal(t, durationpb.New(5*time.Minute), hcm.StreamIdleTimeout)
+		assert.Equal(t, durationpb.New(30*time.Second), hcm.RequestTimeout)
+		assert.Equal(t, durationpb.New(10*time.Second), hcm.RequestHeadersTimeout)
+		assert.Equal(t, durationpb.New(5*time.Second), hcm.DrainTimeout)
+		assert.Equal(t, durationpb.New(time.Hour), hcm.CommonHttpProtocolOptions.IdleTimeout)
+		assert.Equal(t, wrapperspb.UInt32(50), hcm.CommonHttpProtocolOptions.MaxHeadersCount)
+		assert.Equal(t, wrapperspb.Bool(true), hcm.UseRemoteAddress)
+		assert.Equal(t, uint32(2), hcm.XffNumTrustedHops)
+		assert.Equal(t, wrapperspb.UInt32(96), hcm.MaxRequestHeadersKb)
+
+		// The router is added
+		require.Len(t, hcm.HttpFilters, 1)
+		assert.Equal(t, "envoy.filters.http.router", hcm.HttpFilters[0].Name)
+	})
+
+	t.Run("Inline Routes", func(t *testing.T) {
+		random, overall := int32(10), int32(50)
+		hcm := decodeHCM(t, api.ListenerSpec{
+			Name: "http", Address: "0.0.0.0", Port: 8080,
+			HTTP: &api.HTTPListenerSpec{
+				StatPrefix: "ingress_http",
+				VirtualHosts: []api.VirtualHostSpec{{
+					Name:    "backend",
+					Domains: []string{"*"},
+					Routes:  []apiextensionsv1.JSON{{Raw: []byte(`{"match":{"prefix":"/"},"route":{"cluster":"backend"}}`)}},
+				}},
+				HTTPFilters: []api.FilterSpec{
+					{Name: "envoy.filters.http.cors", TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.filters.http.cors.v3.Cors"}`)}},
+					{Name: "envoy.filters.http.router", TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.filters.http.router.v3.Router","suppress_envoy_headers":true}`)}},
+				},
+				Tracing: &api.HTTPTracingSpec{
+					Provider: &api.FilterSpec{
+						Name: "envoy.tracers.zipkin",
+						TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.config.trace.v3.ZipkinConfig",` +
+							`"collector_cluster":"zipkin","collector_endpoint":"/api/v2/spans","collector_endpoint_version":"HTTP_JSON"}`)},
+					},
+					RandomSampling:  &random,
+					OverallSampling: &overall,
+				},
+			},
+		})
+
+		assert.Equal(t, "ingress_http", hcm.StatPrefix)
+		rc := hcm.GetRouteConfig()
+		require.NotNil(t, rc)
+		assert.Equal(t, "http", rc.Name)
+		require.Len(t, rc.VirtualHosts, 1)
+		assert.Equal(t, "backend", rc.VirtualHosts[0].Routes[0].GetRoute().GetCluster())
+
+		// The router is not added twice
+		require.Len(t, hcm.HttpFilters, 2)
+		assert.Equal(t, "envoy.filters.http.cors", hcm.HttpFilters[0].Name)
+		assert.Equal(t, "envoy.filters.http.router", hcm.HttpFilters[1].Name)
+
+		assert.Equal(t, "envoy.tracers.zipkin", hcm.Tracing.Provider.Name)
+		assert.Equal(t, float64(10), hcm.Tracing.RandomSampling.Value)
+		assert.Nil(t, hcm.Tracing.ClientSampling)
+		assert.Equal(t, float64(50), hcm.Tracing.OverallSampling.Value)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := map[string]api.ListenerSpec{
+			"no routes": {Name: "http", HTTP: &api.HTTPListenerSpec{}},
+			"both routes": {Name: "http", HTTP: &api.HTTPListenerSpec{
+				RouteConfigName: "routes",
+				VirtualHosts:    []api.VirtualHostSpec{{Name: "all", Domains: []string{"*"}}},
+			}},
+			"filter chains": {
+				Name:         "http",
+				HTTP:         &api.HTTPListenerSpec{RouteConfigName: "routes"},
+				FilterChains: []api.FilterChainSpec{{}},
+			},
+			"timeout":     {Name: "http", HTTP: &api.HTTPListenerSpec{RouteConfigName: "routes", RequestTimeout: "30"}},
+			"codec":       {Name: "http", HTTP: &api.HTTPListenerSpec{RouteConfigName: "routes", CodecType: "SPDY"}},
+			"filter type": {Name: "http", HTTP: &api.HTTPListenerSpec{RouteConfigName: "routes", HTTPFilters: []api.FilterSpec{{Name: "unknown", TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/unknown.Filter"}`)}}}}},
+		}
+		for name, l := range tests {
+			_, err := reconciler.buildListener(l)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestBuildTCPListener(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+
+	decodeTCPProxy := func(t *testing.T, l api.ListenerSpec) *tcp_proxy.TcpProxy {
+		t.Helper()
+		built, err := reconciler.buildListener(l)
+		require.NoError(t, err)
+		require.Len(t, built.FilterChains, 1)
+		require.Len(t, built.FilterChains[0].Filters, 1)
+		f := built.FilterChains[0].Filters[0]
+		assert.Equal(t, "envoy.filters.network.tcp_proxy", f.Name)
+		var tp tcp_proxy.TcpProxy
+		require.NoError(t, f.GetTypedConfig().UnmarshalTo(&tp))
+		return &tp
+	}
+
+	t.Run("Cluster", func(t *testing.T) {
+		tp := decodeTCPProxy(t, api.ListenerSpec{
+			Name: "nodeport-30080", Address: "0.0.0.0", Port: 80,
+			TCP: &api.TCPListenerSpec{
+				Cluster:            "nodeport-30080",
+				IdleTimeout:        "30m",
+				MaxConnectAttempts: 3,
+				AccessLog: []api.AccessLogSpec{{
+					Name:        "envoy.access_loggers.file",
+					TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.access_loggers.file.v3.FileAccessLog","path":"/dev/stdout"}`)},
+				}},
+				HashPolicy: &api.TCPHashPolicySpec{Type: "FilterState", FilterStateKey: "session"},
+			},
+		})
+
+		assert.Equal(t, "nodeport-30080", tp.StatPrefix)
+		assert.Equal(t, "nodeport-30080", tp.GetCluster())
+		assert.Equal(t, durationpb.New(30*time.Minute), tp.IdleTimeout)
+		assert.Equal(t, wrapperspb.UInt32(3), tp.MaxConnectAttempts)
+		require.Len(t, tp.AccessLog, 1)
+		assert.Equal(t, "envoy.access_loggers.file", tp.AccessLog[0].Name)
+		require.Len(t, tp.HashPolicy, 1)
+		assert.Equal(t, "session", tp.HashPolicy[0].GetFilterState().GetKey())
+	})
+
+	t.Run("Weighted Clusters", func(t *testing.T) {
+		tp := decodeTCPProxy(t, api.ListenerSpec{
+			Name: "tcp", Address: "0.0.0.0", Port: 9000,
+			TCP: &api.TCPListenerSpec{
+				StatPrefix:       "split",
+				HashPolicy:       &api.TCPHashPolicySpec{Type: "SourceIP"},
+				WeightedClusters: []api.WeightedClusterSpec{{Name: "blue", Weight: 90}, {Name: "green", Weight: 10}},
+			},
+		})
+
+		assert.Equal(t, "split", tp.StatPrefix)
+		require.Len(t, tp.HashPolicy, 1)
+		assert.NotNil(t, tp.HashPolicy[0].GetSourceIp())
+		clusters := tp.GetWeightedClusters().GetClusters()
+		require.Len(t, clusters, 2)
+		assert.Equal(t, "blue", clusters[0].Name)
+		assert.Equal(t, uint32(90), clusters[0].Weight)
+		assert.Equal(t, "green", clusters[1].Name)
+		assert.Equal(t, uint32(10), clusters[1].Weight)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := map[string]api.ListenerSpec{
+			"no cluster": {Name: "tcp", TCP: &api.TCPListenerSpec{}},
+			"both clusters": {Name: "tcp", TCP: &api.TCPListenerSpec{
+				Cluster:          "blue",
+				WeightedClusters: []api.WeightedClusterSpec{{Name: "green", Weight: 1}},
+			}},
+			"http": {
+				Name: "tcp",
+				TCP:  &api.TCPListenerSpec{Cluster: "blue"},
+				HTTP: &api.HTTPListenerSpec{RouteConfigName: "routes"},
+			},
+			"timeout":        {Name: "tcp", TCP: &api.TCPListenerSpec{Cluster: "blue", IdleTimeout: "forever"}},
+			"hash policy":    {Name: "tcp", TCP: &api.TCPListenerSpec{Cluster: "blue", HashPolicy: &api.TCPHashPolicySpec{Type: "Cookie"}}},
+			"proxy protocol": {Name: "tcp", TCP: &api.TCPListenerSpec{Cluster: "blue", UpstreamProxyProtocol: "V3"}},
+		}
+		for name, l := range tests {
+			_, err := reconciler.buildListener(l)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestApplyUpstreamProxyProtocol(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+
+	specs := []api.ListenerSpec{
+		{Name: "plain", Address: "0.0.0.0", Port: 80, TCP: &api.TCPListenerSpec{Cluster: "backend"}},
+		{Name: "pp", Address: "0.0.0.0", Port: 443, TCP: &api.TCPListenerSpec{
+			WeightedClusters:      []api.WeightedClusterSpec{{Name: "backend", Weight: 1}, {Name: "eds", Weight: 1}},
+			UpstreamProxyProtocol: "V2",
+		}},
+	}
+	var listeners []types.Resource
+	for _, spec := range specs {
+		l, err := reconciler.buildListener(spec)
+		require.NoError(t, err)
+		listeners = append(listeners, l)
+	}
+	clusters := []types.Resource{
+		&cluster.Cluster{Name: "backend", ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_STRICT_DNS}},
+		&cluster.Cluster{Name: "eds", ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_EDS},
+			EdsClusterConfig: &cluster.Cluster_EdsClusterConfig{EdsConfig: &core.ConfigSource{}}},
+	}
+
+	copies, err := applyUpstreamProxyProtocol(specs, listeners, clusters)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+
+	// The listener without proxy protocol keeps the original cluster
+	tcpProxy := func(l types.Resource) *tcp_proxy.TcpProxy {
+		var tp tcp_proxy.TcpProxy
+		require.NoError(t, l.(*listener.Listener).FilterChains[0].Filters[0].GetTypedConfig().UnmarshalTo(&tp))
+		return &tp
+	}
+	assert.Equal(t, "backend", tcpProxy(listeners[0]).GetCluster())
+	weighted := tcpProxy(listeners[1]).GetWeightedClusters().GetClusters()
+	assert.Equal(t, "backend/proxy_protocol_v2", weighted[0].Name)
+	assert.Equal(t, "eds/proxy_protocol_v2", weighted[1].Name)
+
+	backend := copies[0].(*cluster.Cluster)
+	assert.Equal(t, "backend/proxy_protocol_v2", backend.Name)
+	assert.Equal(t, "envoy.transport_sockets.upstream_proxy_protocol", backend.TransportSocket.Name)
+	var upstream proxy_protocol.ProxyProtocolUpstreamTransport
+	require.NoError(t, backend.TransportSocket.GetTypedConfig().UnmarshalTo(&upstream))
+	assert.Equal(t, core.ProxyProtocolConfig_V2, upstream.Config.Version)
+	assert.Equal(t, "envoy.transport_sockets.raw_buffer", upstream.TransportSocket.Name)
+
+	// The copy of an EDS cluster shares its endpoints
+	eds := copies[1].(*cluster.Cluster)
+	assert.Equal(t, "eds", eds.EdsClusterConfig.ServiceName)
+	assert.Empty(t, clusters[1].(*cluster.Cluster).EdsClusterConfig.ServiceName)
+
+	t.Run("Default filter chain", func(t *testing.T) {
+		specs := []api.ListenerSpec{{
+			Name: "pp", Address: "0.0.0.0", Port: 443,
+			TCP: &api.TCPListenerSpec{Cluster: "backend", UpstreamProxyProtocol: "V1"},
+			DefaultFilterChain: &api.FilterChainSpec{Filters: []api.FilterSpec{{
+				Name: "envoy.filters.network.tcp_proxy",
+				TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",` +
+					`"stat_prefix":"fallback","cluster":"eds"}`)},
+			}}},
+		}}
+		l, err := reconciler.buildListener(specs[0])
+		require.NoError(t, err)
+
+		copies, err := applyUpstreamProxyProtocol(specs, []types.Resource{l}, clusters)
+		require.NoError(t, err)
+		require.Len(t, copies, 2)
+
+		var tp tcp_proxy.TcpProxy
+		require.NoError(t, l.DefaultFilterChain.Filters[0].GetTypedConfig().UnmarshalTo(&tp))
+		assert.Equal(t, "eds/proxy_protocol_v1", tp.GetCluster())
+	})
+}
+
+func TestBuildFilterChainMatch(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+
+	tcpProxy := func(cluster string) []api.FilterSpec {
+		return []api.FilterSpec{{
+			Name: "envoy.filters.network.tcp_proxy",
+			TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",` +
+				`"stat_prefix":"tls","cluster":"` + cluster + `"}`)},
+		}}
+	}
+	l, err := reconciler.buildListener(api.ListenerSpec{
+		Name: "tls", Address: "0.0.0.0", Port: 443,
+		FilterChains: []api.FilterChainSpec{
+			{
+				Name: "internal",
+				FilterChainMatch: &api.FilterChainMatchSpec{
+					DestinationPort:          8443,
+					PrefixRanges:             []string{"10.0.0.1"},
+					SourceType:               "External",
+					SourcePrefixRanges:       []string{"192.168.1.7/16", "fd00::/8"},
+					DirectSourcePrefixRanges: []string{"172.16.0.0/12"},
+					SourcePorts:              []int32{1024},
+					ServerNames:              []string{"*.example.com"},
+					TransportProtocol:        "tls",
+					ApplicationProtocols:     []string{"h2", "http/1.1"},
+				},
+				Filters: tcpProxy("internal"),
+			},
+		},
+		DefaultFilterChain: &api.FilterChainSpec{Name: "fallback", Filters: tcpProxy("fallback")},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, l.FilterChains, 1)
+	chain := l.FilterChains[0]
+	assert.Equal(t, "internal", chain.Name)
+	m := chain.FilterChainMatch
+	assert.Equal(t, wrapperspb.UInt32(8443), m.DestinationPort)
+	require.Len(t, m.PrefixRanges, 1)
+	assert.Equal(t, "10.0.0.1", m.PrefixRanges[0].AddressPrefix)
+	assert.Equal(t, wrapperspb.UInt32(32), m.PrefixRanges[0].PrefixLen)
+	assert.Equal(t, listener.FilterChainMatch_EXTERNAL, m.SourceType)
+	require.Len(t, m.SourcePrefixRanges, 2)
+	assert.Equal(t, "192.168.0.0", m.SourcePrefixRanges[0].AddressPrefix)
+	assert.Equal(t, wrapperspb.UInt32(16), m.SourcePrefixRanges[0].PrefixLen)
+	assert.Equal(t, "fd00::", m.SourcePrefixRanges[1].AddressPrefix)
+	assert.Equal(t, wrapperspb.UInt32(8), m.SourcePrefixRanges[1].PrefixLen)
+	assert.Equal(t, "172.16.0.0", m.DirectSourcePrefixRanges[0].AddressPrefix)
+	assert.Equal(t, []uint32{1024}, m.SourcePorts)
+	assert.Equal(t, []string{"*.example.com"}, m.ServerNames)
+	assert.Equal(t, "tls", m.TransportProtocol)
+	assert.Equal(t, []string{"h2", "http/1.1"}, m.ApplicationProtocols)
+
+	require.NotNil(t, l.DefaultFilterChain)
+	assert.Equal(t, "fallback", l.DefaultFilterChain.Name)
+	assert.Nil(t, l.DefaultFilterChain.FilterChainMatch)
+
+	_, err = reconciler.buildListener(api.ListenerSpec{
+		Name: "tls", Address: "0.0.0.0", Port: 443,
+		FilterChains: []api.FilterChainSpec{{
+			FilterChainMatch: &api.FilterChainMatchSpec{SourcePrefixRanges: []string{"10.0.0.0/33"}},
+			Filters:          tcpProxy("internal"),
+		}},
+	})
+	assert.ErrorContains(t, err, "invalid sourcePrefixRanges")
+}
+
+func TestBuildListenerSettings(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+	int32Ptr := func(v int32) *int32 { return &v }
+	boolPtr := func(v bool) *bool { return &v }
+
+	t.Run("Socket and connections", func(t *testing.T) {
+		reusePort := int64(1)
+		built, err := reconciler.buildListener(api.ListenerSpec{
+			Name: "edge", Address: "0.0.0.0", Port: 443,
+			TCP:                           &api.TCPListenerSpec{Cluster: "backend"},
+			PerConnectionBufferLimitBytes: int32Ptr(32768),
+			SocketOptions: []api.SocketOptionSpec{
+				{Description: "SO_REUSEPORT", Level: 1, Name: 15, IntValue: &reusePort},
+				{Level: 1, Name: 25, BufValue: []byte("eth0"), State: "Bound"},
+			},
+			TCPKeepalive:        &api.TCPKeepaliveSpec{Time: int32Ptr(60), Interval: int32Ptr(10), Probes: int32Ptr(3)},
+			EnableReusePort:     boolPtr(false),
+			ConnectionBalance:   "Exact",
+			Transparent:         boolPtr(true),
+			AdditionalAddresses: []api.ListenerAddressSpec{{Address: "::", Port: 443}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, built.ValidateAll())
+
+		assert.Equal(t, core.SocketAddress_TCP, built.Address.GetSocketAddress().Protocol)
+		require.Len(t, built.AdditionalAddresses, 1)
+		assert.Equal(t, "::", built.AdditionalAddresses[0].Address.GetSocketAddress().Address)
+		assert.Equal(t, uint32(32768), built.PerConnectionBufferLimitBytes.GetValue())
+		assert.False(t, built.EnableReusePort.GetValue())
+		assert.NotNil(t, built.ConnectionBalanceConfig.GetExactBalance())
+		assert.True(t, built.Transparent.GetValue())
+		assert.Nil(t, built.BindToPort)
+		assert.Nil(t, built.UdpListenerConfig)
+
+		require.Len(t, built.SocketOptions, 6)
+		assert.Equal(t, int64(1), built.SocketOptions[0].GetIntValue())
+		assert.Equal(t, core.SocketOption_STATE_PREBIND, built.SocketOptions[0].State)
+		assert.Equal(t, []byte("eth0"), built.SocketOptions[1].GetBufValue())
+		assert.Equal(t, core.SocketOption_STATE_BOUND, built.SocketOptions[1].State)
+		keepalive := map[string]int64{}
+		for _, o := range built.SocketOptions[2:] {
+			assert.Equal(t, core.SocketOption_STATE_LISTENING, o.State)
+			keepalive[o.Description] = o.GetIntValue()
+		}
+		assert.Equal(t, map[string]int64{"SO_KEEPALIVE": 1, "TCP_KEEPIDLE": 60, "TCP_KEEPINTVL": 10, "TCP_KEEPCNT": 3}, keepalive)
+	})
+
+	t.Run("UDP", func(t *testing.T) {
+		maxDatagram := int64(9000)
+		built, err := reconciler.buildListener(api.ListenerSpec{
+			Name: "dns", Address: "0.0.0.0", Port: 53, Protocol: "UDP",
+			UDPListenerConfig: &api.UDPListenerConfigSpec{MaxRxDatagramSize: &maxDatagram, PreferGro: boolPtr(true)},
+		})
+		require.NoError(t, err)
+		require.NoError(t, built.ValidateAll())
+		assert.Equal(t, core.SocketAddress_UDP, built.Address.GetSocketAddress().Protocol)
+		assert.Equal(t, uint64(9000), built.UdpListenerConfig.DownstreamSocketConfig.MaxRxDatagramSize.GetValue())
+		assert.True(t, built.UdpListenerConfig.DownstreamSocketConfig.PreferGro.GetValue())
+
+		built, err = reconciler.buildListener(api.ListenerSpec{Name: "dns", Address: "0.0.0.0", Port: 53, Protocol: "UDP"})
+		require.NoError(t, err)
+		assert.NotNil(t, built.UdpListenerConfig)
+
+		_, err = reconciler.buildListener(api.ListenerSpec{
+			Name: "dns", Address: "0.0.0.0", Port: 53, Protocol: "UDP",
+			TCP: &api.TCPListenerSpec{Cluster: "dns"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Internal", func(t *testing.T) {
+		built, err := reconciler.buildListener(api.ListenerSpec{
+			Name:     "tunnel",
+			Internal: &api.InternalListenerSpec{},
+			TCP:      &api.TCPListenerSpec{Cluster: "backend"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, built.ValidateAll())
+		assert.Nil(t, built.Address)
+		assert.NotNil(t, built.GetInternalListener())
+
+		_, err = reconciler.buildListener(api.ListenerSpec{
+			Name: "tunnel", Address: "0.0.0.0", Port: 8080,
+			Internal: &api.InternalListenerSpec{},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Internal listener cluster", func(t *testing.T) {
+		c, _, err := reconciler.buildCluster(context.Background(), api.ClusterSpec{
+			Name: "tunnel", Type: "static",
+			LoadAssignment: &api.LoadAssignmentSpec{InternalListener: "tunnel"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, c.ValidateAll())
+		address := c.LoadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint().Address
+		assert.Equal(t, "tunnel", address.GetEnvoyInternalAddress().GetServerListenerName())
+		assert.Equal(t, []string{"internal:tunnel"}, endpointAddresses(c.LoadAssignment))
+
+		_, _, err = reconciler.buildCluster(context.Background(), api.ClusterSpec{
+			Name: "tunnel", Type: "strict_dns",
+			LoadAssignment: &api.LoadAssignmentSpec{InternalListener: "tunnel"},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildUDPListener(t *testing.T) {
+	reconciler := &XDSControlPlaneReconciler{}
+
+	decodeUDPProxy := func(t *testing.T, l api.ListenerSpec) (*listener.Listener, *udp_proxy.UdpProxyConfig) {
+		t.Helper()
+		built, err := reconciler.buildListener(l)
+		require.NoError(t, err)
+		require.NoError(t, built.ValidateAll())
+		require.NotEmpty(t, built.ListenerFilters)
+		f := built.ListenerFilters[len(built.ListenerFilters)-1]
+		assert.Equal(t, "envoy.filters.udp_listener.udp_proxy", f.Name)
+		var up udp_proxy.UdpProxyConfig
+		require.NoError(t, f.GetTypedConfig().UnmarshalTo(&up))
+		return built, &up
+	}
+	routeCluster := func(t *testing.T, up *udp_proxy.UdpProxyConfig) string {
+		t.Helper()
+		var route udp_proxy.Route
+		require.NoError(t, up.GetMatcher().GetOnNoMatch().GetAction().GetTypedConfig().UnmarshalTo(&route))
+		return route.Cluster
+	}
+
+	t.Run("Cluster", func(t *testing.T) {
+		built, up := decodeUDPProxy(t, api.ListenerSpec{
+			Name: "dns", Address: "0.0.0.0", Port: 53,
+			UDP: &api.UDPListenerSpec{Cluster: "dns", IdleTimeout: "30s"},
+		})
+		assert.Equal(t, core.SocketAddress_UDP, built.Address.GetSocketAddress().Protocol)
+		assert.NotNil(t, built.UdpListenerConfig)
+		assert.Empty(t, built.FilterChains)
+		assert.Equal(t, "dns", up.StatPrefix)
+		assert.Equal(t, "dns", routeCluster(t, up))
+		assert.Equal(t, 30*time.Second, up.IdleTimeout.AsDuration())
+		assert.Empty(t, up.HashPolicies)
+	})
+
+	t.Run("Hash policy and access log", func(t *testing.T) {
+		_, up := decodeUDPProxy(t, api.ListenerSpec{
+			Name: "syslog", Address: "0.0.0.0", Port: 514, Protocol: "UDP",
+			UDP: &api.UDPListenerSpec{
+				StatPrefix: "syslog_udp",
+				Cluster:    "syslog",
+				HashPolicy: &api.UDPHashPolicySpec{Type: "Key", Key: "syslog"},
+				AccessLog: []api.AccessLogSpec{{
+					Name:        "envoy.access_loggers.file",
+					TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"@type":"type.googleapis.com/envoy.extensions.access_loggers.file.v3.FileAccessLog","path":"/dev/stdout"}`)},
+				}},
+			},
+		})
+		assert.Equal(t, "syslog_udp", up.StatPrefix)
+		require.Len(t, up.HashPolicies, 1)
+		assert.Equal(t, "syslog", up.HashPolicies[0].GetKey())
+		assert.Len(t, up.AccessLog, 1)
+
+		_, up = decodeUDPProxy(t, api.ListenerSpec{
+			Name: "syslog", Address: "0.0.0.0", Port: 514,
+			UDP: &api.UDPListenerSpec{Cluster: "syslog", HashPolicy: &api.UDPHashPolicySpec{Type: "SourceIP"}},
+		})
+		assert.True(t, up.HashPolicies[0].GetSourceIp())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, l := range map[string]api.ListenerSpec{
+			"TCP protocol": {Name: "u", Address: "0.0.0.0", Port: 53, Protocol: "TCP", UDP: &api.UDPListenerSpec{Cluster: "dns"}},
+			"with tcp":     {Name: "u", Address: "0.0.0.0", Port: 53, UDP: &api.UDPListenerSpec{Cluster: "dns"}, TCP: &api.TCPListenerSpec{Cluster: "dns"}},
+			"no cluster":   {Name: "u", Address: "0.0.0.0", Port: 53, UDP: &api.UDPListenerSpec{}},
+			"bad timeout":  {Name: "u", Address: "0.0.0.0", Port: 53, UDP: &api.UDPListenerSpec{Cluster: "dns", IdleTimeout: "soon"}},
+		} {
+			_, err := reconciler.buildListener(l)
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("Raw typed config", func(t *testing.T) {
+		built, err := reconciler.buildListener(api.ListenerSpec{
+			Name: "dns", Address: "0.0.0.0", Port: 53, Protocol: "UDP",
+			ListenerFilters: []api.ListenerFilterSpec{{
+				Name: "envoy.filters.udp_listener.udp_proxy",
+				TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{
+					"@type": "type.googleapis.com/envoy.extensions.filters.udp.udp_proxy.v3.UdpProxyConfig",
+					"stat_prefix": "dns",
+					"matcher": {"on_no_match": {"action": {"name": "route", "typed_config": {
+						"@type": "type.googleapis.com/envoy.extensions.filters.udp.udp_proxy.v3.Route",
+						"cluster": "dns"}}}}
+				}`)},
+			}},
+		})
+		require.NoError(t, err)
+		var up udp_proxy.UdpProxyConfig
+		require.NoError(t, built.ListenerFilters[0].GetTypedConfig().UnmarshalTo(&up))
+		assert.Equal(t, "dns", routeCluster(t, &up))
+	})
+}
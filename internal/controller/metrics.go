@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"sync"
+
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+var (
+	snapshotBuildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xds_snapshot_build_duration_seconds",
+		Help:    "Time taken to build the xDS snapshot of a control plane",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"namespace", "name"})
+
+	snapshotBuildErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xds_snapshot_build_errors_total",
+		Help: "Number of failed xDS snapshot builds of a control plane",
+	}, []string{"namespace", "name"})
+
+	snapshotResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xds_snapshot_resources",
+		Help: "Number of resources per type in the default snapshot of a control plane",
+	}, []string{"namespace", "name", "type"})
+
+	connectedStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xds_connected_streams",
+		Help: "Number of open xDS streams per Envoy node",
+	}, []string{"namespace", "name", "node_id"})
+
+	xdsResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xds_responses_total",
+		Help: "Number of discovery responses pushed to Envoy nodes per type URL",
+	}, []string{"namespace", "name", "type_url"})
+
+	xdsAcks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xds_acks_total",
+		Help: "Number of discovery responses acknowledged by Envoy nodes per type URL",
+	}, []string{"namespace", "name", "type_url"})
+
+	xdsNacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xds_nacks_total",
+		Help: "Number of discovery responses rejected by Envoy nodes per type URL",
+	}, []string{"namespace", "name", "type_url"})
+
+	configPropagation = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xds_config_propagation_seconds",
+		Help:    "Time from a control plane generation change to its acknowledgement by an Envoy node",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"namespace", "name"})
+
+	discoveredEndpoints = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xds_discovered_endpoints",
+		Help: "Number of endpoints in the load assignment of a cluster",
+	}, []string{"namespace", "name", "cluster"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		snapshotBuildDuration,
+		snapshotBuildErrors,
+		snapshotResources,
+		connectedStreams,
+		xdsResponses,
+		xdsAcks,
+		xdsNacks,
+		configPropagation,
+		discoveredEndpoints,
+	)
+}
+
+// connectedStreamsMu serializes the updates of connectedStreams, whose series
+// are shared by a restarted server and the previous one while they overlap
+var connectedStreamsMu sync.Mutex
+
+// streamConnected counts an open stream of a node
+func streamConnected(namespace, name, nodeID string) {
+	connectedStreamsMu.Lock()
+	defer connectedStreamsMu.Unlock()
+	connectedStreams.WithLabelValues(namespace, name, nodeID).Inc()
+}
+
+// streamDisconnected counts a closed stream of a node. The series of the node
+// is removed with its last stream, so that disconnected nodes are not
+// reported forever.
+func streamDisconnected(namespace, name, nodeID string) {
+	connectedStreamsMu.Lock()
+	defer connectedStreamsMu.Unlock()
+
+	gauge := connectedStreams.WithLabelValues(namespace, name, nodeID)
+	gauge.Dec()
+	var m dto.Metric
+	if err := gauge.Write(&m); err == nil && m.GetGauge().GetValue() <= 0 {
+		connectedStreams.DeleteLabelValues(namespace, name, nodeID)
+	}
+}
+
+// recordSnapshotMetrics updates the resource and endpoint gauges of a control plane
+func recordSnapshotMetrics(crd *api.XDSControlPlane, snapshot *cache.Snapshot) {
+	labels := prometheus.Labels{"namespace": crd.Namespace, "name": crd.Name}
+
+	for _, typeURL := range persistedTypes {
+		snapshotResources.WithLabelValues(crd.Namespace, crd.Name, typeURL).Set(float64(len(snapshot.GetResources(typeURL))))
+	}
+
+	// Clusters may have been removed since the last snapshot
+	discoveredEndpoints.DeletePartialMatch(labels)
+	for name, r := range snapshot.GetResources(res.EndpointType) {
+		cla, ok := r.(*endpoint.ClusterLoadAssignment)
+		if !ok {
+			continue
+		}
+		count := 0
+		for _, lle := range cla.GetEndpoints() {
+			count += len(lle.GetLbEndpoints())
+		}
+		discoveredEndpoints.WithLabelValues(crd.Namespace, crd.Name, name).Set(float64(count))
+	}
+}
+
+// deleteControlPlaneMetrics removes all series of a deleted control plane
+func deleteControlPlaneMetrics(namespace, name string) {
+	labels := prometheus.Labels{"namespace": namespace, "name": name}
+	snapshotBuildDuration.DeletePartialMatch(labels)
+	snapshotBuildErrors.DeletePartialMatch(labels)
+	snapshotResources.DeletePartialMatch(labels)
+	connectedStreams.DeletePartialMatch(labels)
+	xdsResponses.DeletePartialMatch(labels)
+	xdsAcks.DeletePartialMatch(labels)
+	xdsNacks.DeletePartialMatch(labels)
+	configPropagation.DeletePartialMatch(labels)
+	discoveredEndpoints.DeletePartialMatch(labels)
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestCallbackMetrics(t *testing.T) {
+	server := newTestServerInstance()
+	server.name = "callback-metrics"
+	callbacks := newXDSCallbacks(server)
+	node := &core.Node{Id: "envoy-1"}
+
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: node, TypeUrl: res.ClusterType}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(connectedStreams.WithLabelValues("default", "callback-metrics", "envoy-1")))
+
+	callbacks.OnStreamResponse(context.Background(), 1, nil, &discovery.DiscoveryResponse{TypeUrl: res.ClusterType})
+	assert.Equal(t, 1.0, testutil.ToFloat64(xdsResponses.WithLabelValues("default", "callback-metrics", res.ClusterType)))
+
+	// ACK
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		TypeUrl: res.ClusterType, VersionInfo: "1", ResponseNonce: "1",
+	}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(xdsAcks.WithLabelValues("default", "callback-metrics", res.ClusterType)))
+
+	// NACK
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		TypeUrl: res.ClusterType, VersionInfo: "1", ResponseNonce: "2",
+		ErrorDetail: &status.Status{Message: "invalid cluster"},
+	}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(xdsNacks.WithLabelValues("default", "callback-metrics", res.ClusterType)))
+
+	// The series of a node is removed with its last stream
+	require.NoError(t, callbacks.OnStreamRequest(2, &discovery.DiscoveryRequest{Node: node, TypeUrl: res.ListenerType}))
+	assert.Equal(t, 2.0, testutil.ToFloat64(connectedStreams.WithLabelValues("default", "callback-metrics", "envoy-1")))
+	callbacks.OnStreamClosed(1, node)
+	assert.Equal(t, 1.0, testutil.ToFloat64(connectedStreams.WithLabelValues("default", "callback-metrics", "envoy-1")))
+	callbacks.OnStreamClosed(2, node)
+	assert.False(t, connectedStreams.DeleteLabelValues("default", "callback-metrics", "envoy-1"))
+}
+
+func TestDeltaCallbackMetrics(t *testing.T) {
+	server := newTestServerInstance()
+	server.name = "delta-metrics"
+	server.tracker.registerVersion("v1", 1)
+	callbacks := newXDSCallbacks(server)
+	node := &core.Node{Id: "envoy-1"}
+
+	require.NoError(t, callbacks.OnStreamDeltaRequest(1, &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: res.ClusterType}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(connectedStreams.WithLabelValues("default", "delta-metrics", "envoy-1")))
+	_, nodes := server.tracker.status()
+	require.Len(t, nodes, 1)
+
+	// ACK, matched to the version of the response by nonce
+	callbacks.OnStreamDeltaResponse(1, nil, &discovery.DeltaDiscoveryResponse{TypeUrl: res.ClusterType, Nonce: "1", SystemVersionInfo: "v1"})
+	require.NoError(t, callbacks.OnStreamDeltaRequest(1, &discovery.DeltaDiscoveryRequest{TypeUrl: res.ClusterType, ResponseNonce: "1"}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(xdsAcks.WithLabelValues("default", "delta-metrics", res.ClusterType)))
+	_, nodes = server.tracker.status()
+	assert.Equal(t, "v1", nodes[0].AckedVersion)
+
+	// NACK
+	callbacks.OnStreamDeltaResponse(1, nil, &discovery.DeltaDiscoveryResponse{TypeUrl: res.ClusterType, Nonce: "2", SystemVersionInfo: "v2"})
+	require.NoError(t, callbacks.OnStreamDeltaRequest(1, &discovery.DeltaDiscoveryRequest{
+		TypeUrl: res.ClusterType, ResponseNonce: "2",
+		ErrorDetail: &status.Status{Message: "invalid cluster"},
+	}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(xdsNacks.WithLabelValues("default", "delta-metrics", res.ClusterType)))
+	_, nodes = server.tracker.status()
+	assert.Equal(t, "invalid cluster", nodes[0].LastNackMessage)
+
+	callbacks.OnDeltaStreamClosed(1, node)
+	assert.False(t, connectedStreams.DeleteLabelValues("default", "delta-metrics", "envoy-1"))
+	_, nodes = server.tracker.status()
+	assert.Empty(t, nodes)
+}
+
+func TestRecordSnapshotMetrics(t *testing.T) {
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "snapshot-metrics", Namespace: "default"}}
+
+	snapshot, err := cache.NewSnapshot("1", map[res.Type][]types.Resource{
+		res.EndpointType: {&endpoint.ClusterLoadAssignment{
+			ClusterName: "backend",
+			Endpoints: []*endpoint.LocalityLbEndpoints{{
+				LbEndpoints: []*endpoint.LbEndpoint{{}, {}, {}},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+
+	recordSnapshotMetrics(crd, snapshot)
+	assert.Equal(t, 3.0, testutil.ToFloat64(discoveredEndpoints.WithLabelValues("default", "snapshot-metrics", "backend")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(snapshotResources.WithLabelValues("default", "snapshot-metrics", res.EndpointType)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(snapshotResources.WithLabelValues("default", "snapshot-metrics", res.ClusterType)))
+
+	deleteControlPlaneMetrics("default", "snapshot-metrics")
+	assert.Equal(t, 0, testutil.CollectAndCount(discoveredEndpoints))
+}
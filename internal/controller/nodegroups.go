@@ -0,0 +1,279 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// nodeGroupKeyPrefix prefixes snapshot cache keys of node groups so they
+// cannot collide with plain node IDs
+const nodeGroupKeyPrefix = "nodegroup/"
+
+// nodeGroupHash implements cache.NodeHash. Nodes matching a node group share
+// the snapshot of that group, all other nodes are keyed by their node ID.
+type nodeGroupHash struct {
+	mu     sync.RWMutex
+	groups []api.NodeGroupSpec
+}
+
+func (h *nodeGroupHash) ID(node *core.Node) string {
+	if node == nil {
+		return ""
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, g := range h.groups {
+		if matchesNodeSelector(node, g.Selector) {
+			return nodeGroupKey(g.Name)
+		}
+	}
+	return node.Id
+}
+
+func (h *nodeGroupHash) setGroups(groups []api.NodeGroupSpec) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.groups = groups
+}
+
+// keys returns the cache keys of the configured node groups
+func (h *nodeGroupHash) keys() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	keys := make([]string, 0, len(h.groups))
+	for _, g := range h.groups {
+		keys = append(keys, nodeGroupKey(g.Name))
+	}
+	return keys
+}
+
+func nodeGroupKey(name string) string {
+	return nodeGroupKeyPrefix + name
+}
+
+// matchesNodeSelector reports whether the node satisfies every criterion of the selector
+func matchesNodeSelector(node *core.Node, sel api.NodeSelectorSpec) bool {
+	if len(sel.NodeIDs) > 0 && !matchesAnyGlob(sel.NodeIDs, node.GetId()) {
+		return false
+	}
+
+	if sel.Cluster != "" && sel.Cluster != node.GetCluster() {
+		return false
+	}
+
+	if l := sel.Locality; l != nil {
+		locality := node.GetLocality()
+		if (l.Region != "" && l.Region != locality.GetRegion()) ||
+			(l.Zone != "" && l.Zone != locality.GetZone()) ||
+			(l.SubZone != "" && l.SubZone != locality.GetSubZone()) {
+			return false
+		}
+	}
+
+	fields := node.GetMetadata().GetFields()
+	for k, v := range sel.Metadata {
+		if fields[k].GetStringValue() != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesAnyGlob(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// buildNodeGroupSnapshots builds a snapshot for every node group of the CR. It
+// returns the snapshots to set, keyed by node group cache key, the keys of the
+// groups to keep serving and the resources skipped by the SkipInvalid failure
+// policy. Nothing is set, so that a group failing to build changes no snapshot.
+func (r *XDSControlPlaneReconciler) buildNodeGroupSnapshots(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance) (map[string]*cache.Snapshot, map[string]bool, []skippedResource, error) {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	skipInvalid := failurePolicy(crd) == FailurePolicySkipInvalid
+	active := make(map[string]bool, len(crd.Spec.NodeGroups))
+	snapshots := make(map[string]*cache.Snapshot, len(crd.Spec.NodeGroups))
+	var skipped []skippedResource
+
+	for _, g := range crd.Spec.NodeGroups {
+		key := nodeGroupKey(g.Name)
+
+		snapshot, groupSkipped, err := r.buildNodeGroupSnapshot(ctx, crd, g)
+		if err != nil {
+			if !skipInvalid {
+				return nil, nil, nil, err
+			}
+			// Keep serving the previous snapshot of the group, if there is one
+			log.Error(err, "Skipping invalid node group", "nodeGroup", g.Name)
+			skipped = append(skipped, skippedResource{Kind: "nodegroup", Name: g.Name, Err: err})
+			if server.nodeGroupKeys[key] {
+				active[key] = true
+			}
+			continue
+		}
+
+		for _, s := range groupSkipped {
+			s.Kind = "nodegroup/" + g.Name + "/" + s.Kind
+			skipped = append(skipped, s)
+		}
+		// Keep the version of the served snapshot if its resources did not change
+		if served, err := server.cache.GetSnapshot(key); err == nil {
+			if served, ok := served.(*cache.Snapshot); ok && sameResources(served, snapshot) {
+				snapshot = served
+			}
+		}
+		active[key] = true
+		snapshots[key] = snapshot
+	}
+	return snapshots, active, skipped, nil
+}
+
+// setNodeGroupSnapshots sets the snapshots built for the node groups of the CR
+// and removes the snapshots of the groups that are no longer active
+func (r *XDSControlPlaneReconciler) setNodeGroupSnapshots(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance, snapshots map[string]*cache.Snapshot, active map[string]bool) error {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	server.nodeHash.setGroups(crd.Spec.NodeGroups)
+
+	for key, snapshot := range snapshots {
+		log.Info("Setting xDS snapshot", "key", key, "version", snapshotVersion(snapshot))
+		if err := server.cache.SetSnapshot(ctx, key, snapshot); err != nil {
+			return fmt.Errorf("failed to set snapshot for %s: %w", key, err)
+		}
+	}
+
+	for key := range server.nodeGroupKeys {
+		if !active[key] {
+			log.Info("Clearing xDS snapshot of removed node group", "key", key)
+			server.cache.ClearSnapshot(key)
+		}
+	}
+	server.nodeGroupKeys = active
+	return nil
+}
+
+func (r *XDSControlPlaneReconciler) buildNodeGroupSnapshot(ctx context.Context, crd *api.XDSControlPlane, g api.NodeGroupSpec) (*cache.Snapshot, []skippedResource, error) {
+	variant, err := applyNodeGroupOverlay(crd, g)
+	if err != nil {
+		return nil, nil, permanent(fmt.Errorf("failed to apply overlay of node group %s: %w", g.Name, err))
+	}
+	if err := r.checkPatchedTLSSecrets(ctx, crd, variant, g); err != nil {
+		return nil, nil, fmt.Errorf("failed to apply overlay of node group %s: %w", g.Name, err)
+	}
+
+	snapshot, skipped, err := r.buildXDSSnapshot(ctx, variant)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build snapshot for node group %s: %w", g.Name, err)
+	}
+	return &snapshot, skipped, nil
+}
+
+// applyNodeGroupOverlay returns a copy of the CR with the patches of the node group applied
+func applyNodeGroupOverlay(crd *api.XDSControlPlane, g api.NodeGroupSpec) (*api.XDSControlPlane, error) {
+	variant := crd.DeepCopy()
+
+	for _, p := range g.Listeners {
+		i := indexByName(len(variant.Spec.Listeners), func(i int) string { return variant.Spec.Listeners[i].Name }, p.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("listener %s not found", p.Name)
+		}
+		patched, err := mergePatch(variant.Spec.Listeners[i], p.Patch.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to patch listener %s: %w", p.Name, err)
+		}
+		variant.Spec.Listeners[i] = patched
+	}
+
+	for _, p := range g.Clusters {
+		i := indexByName(len(variant.Spec.Clusters), func(i int) string { return variant.Spec.Clusters[i].Name }, p.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("cluster %s not found", p.Name)
+		}
+		patched, err := mergePatch(variant.Spec.Clusters[i], p.Patch.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to patch cluster %s: %w", p.Name, err)
+		}
+		variant.Spec.Clusters[i] = patched
+	}
+
+	for _, p := range g.Routes {
+		i := indexByName(len(variant.Spec.Routes), func(i int) string { return variant.Spec.Routes[i].Name }, p.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("route configuration %s not found", p.Name)
+		}
+		patched, err := mergePatch(variant.Spec.Routes[i], p.Patch.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to patch route configuration %s: %w", p.Name, err)
+		}
+		variant.Spec.Routes[i] = patched
+	}
+
+	return variant, nil
+}
+
+// checkPatchedTLSSecrets returns an error for a Secret added to a listener by
+// the patches of a node group that the control plane's namespace, defining
+// the patches, may not terminate TLS with
+func (r *XDSControlPlaneReconciler) checkPatchedTLSSecrets(ctx context.Context, crd, variant *api.XDSControlPlane, g api.NodeGroupSpec) error {
+	for _, p := range g.Listeners {
+		i := indexByName(len(crd.Spec.Listeners), func(i int) string { return crd.Spec.Listeners[i].Name }, p.Name)
+		patched := withTLSSecretNamespace(variant.Spec.Listeners[i], crd.Namespace)
+		for _, t := range listenerTLS(patched) {
+			key := tlsSecretKey(t)
+			if key.Namespace == crd.Namespace || usesTLSSecret(crd.Spec.Listeners[i], crd.Namespace, key) {
+				continue
+			}
+			granted, err := r.tlsSecretGranted(ctx, crd, key)
+			if err != nil {
+				return err
+			}
+			if !granted {
+				return permanent(fmt.Errorf("listener %s: TLS secret %s is not in namespace %s", p.Name, key, crd.Namespace))
+			}
+		}
+	}
+	return nil
+}
+
+func indexByName(n int, name func(int) string, want string) int {
+	for i := 0; i < n; i++ {
+		if name(i) == want {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergePatch applies a JSON merge patch to the JSON representation of obj
+func mergePatch[T any](obj T, patch []byte) (T, error) {
+	var out T
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return out, err
+	}
+	patched, err := jsonpatch.MergePatch(original, patch)
+	if err != nil {
+		return out, err
+	}
+	err = json.Unmarshal(patched, &out)
+	return out, err
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestNodeGroupHash(t *testing.T) {
+	metadata, err := structpb.NewStruct(map[string]interface{}{"datacenter": "dc2"})
+	require.NoError(t, err)
+
+	hash := &nodeGroupHash{}
+	hash.setGroups([]api.NodeGroupSpec{
+		{Name: "dc1", Selector: api.NodeSelectorSpec{NodeIDs: []string{"edge-dc1-*"}}},
+		{Name: "dc2", Selector: api.NodeSelectorSpec{Metadata: map[string]string{"datacenter": "dc2"}}},
+		{Name: "eu", Selector: api.NodeSelectorSpec{
+			Cluster:  "edge",
+			Locality: &api.LocalitySpec{Region: "eu-west-1"},
+		}},
+	})
+
+	assert.Equal(t, "nodegroup/dc1", hash.ID(&core.Node{Id: "edge-dc1-7f9c"}))
+	assert.Equal(t, "nodegroup/dc2", hash.ID(&core.Node{Id: "edge-7f9c", Metadata: metadata}))
+	assert.Equal(t, "nodegroup/eu", hash.ID(&core.Node{
+		Id:       "edge-abc",
+		Cluster:  "edge",
+		Locality: &core.Locality{Region: "eu-west-1", Zone: "eu-west-1a"},
+	}))
+	assert.Equal(t, "edge-abc", hash.ID(&core.Node{
+		Id:       "edge-abc",
+		Cluster:  "other",
+		Locality: &core.Locality{Region: "eu-west-1"},
+	}))
+	assert.Equal(t, "external-envoy", hash.ID(&core.Node{Id: "external-envoy"}))
+	assert.Equal(t, "", hash.ID(nil))
+}
+
+func TestApplyNodeGroupOverlay(t *testing.T) {
+	crd := &api.XDSControlPlane{
+		Spec: api.XDSControlPlaneSpec{
+			Listeners: []api.ListenerSpec{{
+				Name:    "ingress",
+				Address: "0.0.0.0",
+				Port:    80,
+				AccessLog: []api.AccessLogSpec{{
+					Name:        "envoy.access_loggers.file",
+					TypedConfig: apiextensionsv1.JSON{Raw: []byte(`{"path":"/var/log/envoy/access.log"}`)},
+				}},
+			}},
+			Clusters: []api.ClusterSpec{{
+				Name:     "backend",
+				Type:     "static",
+				LbPolicy: "round_robin",
+				LoadAssignment: &api.LoadAssignmentSpec{EndpointsFrom: &api.EndpointSelectorSpec{
+					Type: "Node",
+					Port: 30080,
+				}},
+			}},
+		},
+	}
+
+	variant, err := applyNodeGroupOverlay(crd, api.NodeGroupSpec{
+		Name: "dc1",
+		Listeners: []api.ResourcePatchSpec{{
+			Name: "ingress",
+			Patch: apiextensionsv1.JSON{Raw: []byte(`{
+				"address": "10.1.0.1",
+				"accessLog": [{"name": "envoy.access_loggers.file", "typedConfig": {"path": "/var/log/dc1/access.log"}}]
+			}`)},
+		}},
+		Clusters: []api.ResourcePatchSpec{{
+			Name:  "backend",
+			Patch: apiextensionsv1.JSON{Raw: []byte(`{"loadAssignment": {"endpointsFrom": {"port": 31080}}}`)},
+		}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "10.1.0.1", variant.Spec.Listeners[0].Address)
+	assert.Equal(t, 80, variant.Spec.Listeners[0].Port)
+	assert.JSONEq(t, `{"path":"/var/log/dc1/access.log"}`, string(variant.Spec.Listeners[0].AccessLog[0].TypedConfig.Raw))
+	assert.Equal(t, 31080, variant.Spec.Clusters[0].LoadAssignment.EndpointsFrom.Port)
+	assert.Equal(t, "Node", variant.Spec.Clusters[0].LoadAssignment.EndpointsFrom.Type)
+
+	// The original CR must not be modified
+	assert.Equal(t, "0.0.0.0", crd.Spec.Listeners[0].Address)
+	assert.Equal(t, 30080, crd.Spec.Clusters[0].LoadAssignment.EndpointsFrom.Port)
+
+	t.Run("Unknown resource", func(t *testing.T) {
+		_, err := applyNodeGroupOverlay(crd, api.NodeGroupSpec{
+			Name:     "dc1",
+			Clusters: []api.ResourcePatchSpec{{Name: "missing", Patch: apiextensionsv1.JSON{Raw: []byte(`{}`)}}},
+		})
+		assert.ErrorContains(t, err, "cluster missing not found")
+	})
+}
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const defaultNodeID = "external-envoy"
+
+// nodeMatcher decides which connecting nodes receive the default snapshot of a CR
+type nodeMatcher struct {
+	ids      map[string]bool
+	globs    []string
+	regexes  []*regexp.Regexp
+	clusters map[string]bool
+}
+
+func newNodeMatcher(spec api.XDSControlPlaneSpec) (*nodeMatcher, error) {
+	m := &nodeMatcher{
+		ids:      make(map[string]bool),
+		clusters: make(map[string]bool),
+	}
+
+	for _, id := range spec.NodeIDs {
+		if isGlobPattern(id) {
+			m.globs = append(m.globs, id)
+		} else {
+			m.ids[id] = true
+		}
+	}
+
+	for _, expr := range spec.NodeIDRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node ID regex %q: %w", expr, err)
+		}
+		m.regexes = append(m.regexes, re)
+	}
+
+	for _, c := range spec.NodeClusters {
+		m.clusters[c] = true
+	}
+
+	if len(spec.NodeIDs) == 0 && len(m.regexes) == 0 && len(m.clusters) == 0 {
+		m.ids[defaultNodeID] = true
+	}
+
+	return m, nil
+}
+
+// newControlPlaneMatcher returns the node matcher of a CR, which also matches
+// the proxies of its data plane
+func newControlPlaneMatcher(crd *api.XDSControlPlane) (*nodeMatcher, error) {
+	m, err := newNodeMatcher(crd.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if crd.Spec.DataPlane != nil {
+		m.globs = append(m.globs, dataPlaneNodePattern(crd))
+	}
+	return m, nil
+}
+
+// staticIDs returns the node IDs known in advance
+func (m *nodeMatcher) staticIDs() []string {
+	ids := make([]string, 0, len(m.ids))
+	for id := range m.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (m *nodeMatcher) matches(node *core.Node) bool {
+	id := node.GetId()
+	if m.ids[id] || m.clusters[node.GetCluster()] {
+		return true
+	}
+	if matchesAnyGlob(m.globs, id) {
+		return true
+	}
+	for _, re := range m.regexes {
+		if re.MatchString(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlobPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// setDefaultSnapshots sets the snapshot for all statically configured node IDs
+// and for the nodes matched by a pattern since the server started. It returns
+// the node IDs the snapshot was set for.
+func (r *XDSControlPlaneReconciler) setDefaultSnapshots(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance, snapshot *cache.Snapshot) ([]string, error) {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	matcher, err := newControlPlaneMatcher(crd)
+	if err != nil {
+		return nil, permanent(err)
+	}
+
+	server.nodesMu.Lock()
+	defer server.nodesMu.Unlock()
+
+	server.matcher = matcher
+	server.defaultSnapshot = snapshot
+
+	nodeIDs := matcher.staticIDs()
+	for _, nodeID := range nodeIDs {
+		log.Info("Setting xDS snapshot", "nodeID", nodeID, "version", snapshotVersion(snapshot))
+		if err := server.cache.SetSnapshot(ctx, nodeID, snapshot); err != nil {
+			return nil, fmt.Errorf("failed to set snapshot for node %s: %w", nodeID, err)
+		}
+	}
+
+	// Refresh nodes added on connect, and drop the ones no longer matching or
+	// connected, such as nodes that only fetched their configuration
+	dynamicIDs := make([]string, 0, len(server.dynamicNodes))
+	for nodeID := range server.dynamicNodes {
+		dynamicIDs = append(dynamicIDs, nodeID)
+	}
+	sort.Strings(dynamicIDs)
+	for _, nodeID := range dynamicIDs {
+		node := server.dynamicNodes[nodeID]
+		if matcher.ids[nodeID] {
+			delete(server.dynamicNodes, nodeID)
+			continue
+		}
+		if !matcher.matches(node) || server.nodeStreams[nodeID] == 0 {
+			log.Info("Clearing xDS snapshot of node no longer matching or connected", "nodeID", nodeID)
+			server.cache.ClearSnapshot(nodeID)
+			delete(server.dynamicNodes, nodeID)
+			continue
+		}
+		log.Info("Setting xDS snapshot", "nodeID", nodeID, "version", snapshotVersion(snapshot))
+		if err := server.cache.SetSnapshot(ctx, nodeID, snapshot); err != nil {
+			return nil, fmt.Errorf("failed to set snapshot for node %s: %w", nodeID, err)
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+
+	return nodeIDs, nil
+}
+
+// nodeStreamOpened counts a stream opened by a node
+func (s *XDSServerInstance) nodeStreamOpened(node *core.Node) {
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+	s.nodeStreams[node.GetId()]++
+}
+
+// nodeStreamClosed counts a stream of a node as closed. A node matched by
+// pattern is dropped and its snapshot cleared when its last stream closes, it
+// is matched again when it reconnects.
+func (s *XDSServerInstance) nodeStreamClosed(node *core.Node) {
+	nodeID := node.GetId()
+
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+
+	if s.nodeStreams[nodeID]--; s.nodeStreams[nodeID] > 0 {
+		return
+	}
+	delete(s.nodeStreams, nodeID)
+	if _, ok := s.dynamicNodes[nodeID]; ok {
+		ctrlLog.Log.WithValues("nodeID", nodeID).Info("Clearing xDS snapshot of disconnected node matched by pattern")
+		s.cache.ClearSnapshot(nodeID)
+		delete(s.dynamicNodes, nodeID)
+	}
+}
+
+// ensureNodeSnapshot lazily sets the default snapshot for a node that connects
+// without a snapshot of its own but matches one of the node patterns of the CR
+func (s *XDSServerInstance) ensureNodeSnapshot(node *core.Node) {
+	key := s.nodeHash.ID(node)
+	if key == "" || strings.HasPrefix(key, nodeGroupKeyPrefix) {
+		return
+	}
+
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+
+	if s.matcher == nil || s.defaultSnapshot == nil || s.dynamicNodes[key] != nil || s.matcher.ids[key] {
+		return
+	}
+	if !s.matcher.matches(node) {
+		return
+	}
+
+	log := ctrlLog.Log.WithValues("nodeID", key)
+	if err := s.cache.SetSnapshot(context.Background(), key, s.defaultSnapshot); err != nil {
+		log.Error(err, "failed to set xDS snapshot for matched node")
+		return
+	}
+	log.Info("Set xDS snapshot for node matched by pattern", "version", snapshotVersion(s.defaultSnapshot))
+	s.dynamicNodes[key] = node
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func newTestServerInstance() *XDSServerInstance {
+	nodeHash := &nodeGroupHash{}
+	return &XDSServerInstance{
+		cache:       cache.NewSnapshotCache(false, nodeHash, nil),
+		nodeHash:    nodeHash,
+		namespace:   "default",
+		name:        "test",
+		tracker:     newPropagationTracker(),
+		servedNodes: newServedNodes(),
+	}
+}
+
+func TestNodeMatcher(t *testing.T) {
+	t.Run("Defaults to external-envoy", func(t *testing.T) {
+		m, err := newNodeMatcher(api.XDSControlPlaneSpec{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"external-envoy"}, m.staticIDs())
+	})
+
+	t.Run("Patterns", func(t *testing.T) {
+		m, err := newNodeMatcher(api.XDSControlPlaneSpec{
+			NodeIDs:      []string{"envoy-1", "edge-envoy-*"},
+			NodeIDRegex:  []string{`^ingress-[0-9a-f]{4}-[a-z0-9]{5}$`},
+			NodeClusters: []string{"edge"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"envoy-1"}, m.staticIDs())
+		assert.True(t, m.matches(&core.Node{Id: "envoy-1"}))
+		assert.True(t, m.matches(&core.Node{Id: "edge-envoy-7f9c-abcde"}))
+		assert.True(t, m.matches(&core.Node{Id: "ingress-7f9c-abcde"}))
+		assert.True(t, m.matches(&core.Node{Id: "anything", Cluster: "edge"}))
+		assert.False(t, m.matches(&core.Node{Id: "ingress-zzzz-abcde"}))
+		assert.False(t, m.matches(&core.Node{Id: "other", Cluster: "core"}))
+	})
+
+	t.Run("Invalid regex", func(t *testing.T) {
+		_, err := newNodeMatcher(api.XDSControlPlaneSpec{NodeIDRegex: []string{"("}})
+		assert.ErrorContains(t, err, "invalid node ID regex")
+	})
+}
+
+func TestLazyNodeSnapshot(t *testing.T) {
+	ctx := context.Background()
+	reconciler := &XDSControlPlaneReconciler{}
+	server := newTestServerInstance()
+	callbacks := newXDSCallbacks(server)
+
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "lazy", Namespace: "default"},
+		Spec: api.XDSControlPlaneSpec{
+			NodeIDs: []string{"static-envoy", "edge-envoy-*"},
+		},
+	}
+
+	snapshot, err := cache.NewSnapshot("1", nil)
+	require.NoError(t, err)
+
+	nodeIDs, err := reconciler.setDefaultSnapshots(ctx, crd, server, snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"static-envoy"}, nodeIDs)
+
+	// A matching node gets the snapshot on its first request
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "edge-envoy-7f9c-abcde"},
+	}))
+	_, err = server.cache.GetSnapshot("edge-envoy-7f9c-abcde")
+	assert.NoError(t, err)
+
+	// Later requests on the same stream may omit the node
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{}))
+
+	// A node that does not match gets nothing
+	require.NoError(t, callbacks.OnStreamRequest(2, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "unknown"},
+	}))
+	_, err = server.cache.GetSnapshot("unknown")
+	assert.Error(t, err)
+
+	// Matched nodes are refreshed on the next reconcile, in order
+	require.NoError(t, callbacks.OnStreamRequest(3, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "edge-envoy-0000-aaaaa"},
+	}))
+	require.NoError(t, callbacks.OnStreamRequest(4, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "edge-envoy-0000-aaaaa"},
+	}))
+	next, err := cache.NewSnapshot("2", nil)
+	require.NoError(t, err)
+	nodeIDs, err = reconciler.setDefaultSnapshots(ctx, crd, server, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"static-envoy", "edge-envoy-0000-aaaaa", "edge-envoy-7f9c-abcde"}, nodeIDs)
+
+	// A node is dropped when its last stream closes
+	callbacks.OnStreamClosed(3, nil)
+	_, err = server.cache.GetSnapshot("edge-envoy-0000-aaaaa")
+	assert.NoError(t, err)
+	callbacks.OnStreamClosed(4, nil)
+	_, err = server.cache.GetSnapshot("edge-envoy-0000-aaaaa")
+	assert.Error(t, err)
+	assert.NotContains(t, server.dynamicNodes, "edge-envoy-0000-aaaaa")
+
+	// Nodes without a stream, such as fetching nodes, are dropped on the next reconcile
+	require.NoError(t, callbacks.OnFetchRequest(ctx, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "edge-envoy-1111-bbbbb"},
+	}))
+	_, err = server.cache.GetSnapshot("edge-envoy-1111-bbbbb")
+	assert.NoError(t, err)
+	nodeIDs, err = reconciler.setDefaultSnapshots(ctx, crd, server, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"static-envoy", "edge-envoy-7f9c-abcde"}, nodeIDs)
+
+	// And dropped once the pattern is removed
+	crd.Spec.NodeIDs = []string{"static-envoy"}
+	nodeIDs, err = reconciler.setDefaultSnapshots(ctx, crd, server, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"static-envoy"}, nodeIDs)
+	_, err = server.cache.GetSnapshot("edge-envoy-7f9c-abcde")
+	assert.Error(t, err)
+}
@@ -0,0 +1,359 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	snapshotConfigMapSuffix = "-xds-snapshot"
+	snapshotDataKey         = "snapshots.json.gz"
+
+	// SnapshotHashAnnotation holds the hash of the persisted resources, used to skip unchanged writes
+	SnapshotHashAnnotation = "xds.okassov/snapshot-hash"
+	// ControlPlaneLabel is set on objects owned by an XDSControlPlane
+	ControlPlaneLabel = "xds.okassov/control-plane"
+
+	// ConfigMaps are limited to 1MiB, keep some headroom for metadata
+	maxPersistedSnapshotSize = 900 * 1024
+)
+
+// persistedTypes lists the resource types stored in persisted snapshots
+var persistedTypes = []res.Type{res.ClusterType, res.EndpointType, res.ListenerType, res.RouteType}
+
+// persistedSnapshot is the serialized form of a snapshot. Resources are stored
+// per type URL as binary encoded google.protobuf.Any messages.
+type persistedSnapshot struct {
+	Version   string              `json:"version"`
+	Resources map[string][][]byte `json:"resources"`
+}
+
+// persistedState is the last-known-good configuration of a CR
+type persistedState struct {
+	// Generation is the CR generation the snapshots were built from
+	Generation int64 `json:"generation"`
+	// NodeIDs are the static node IDs the default snapshot was served to,
+	// nodes matched by a pattern are matched again when they reconnect
+	NodeIDs []string `json:"nodeIDs"`
+	// Default is the snapshot served to NodeIDs and nodes matched by a pattern
+	Default persistedSnapshot `json:"default"`
+	// Groups holds the node group snapshots by cache key
+	Groups map[string]persistedSnapshot `json:"groups,omitempty"`
+}
+
+func snapshotConfigMapName(crd *api.XDSControlPlane) string {
+	return crd.Name + snapshotConfigMapSuffix
+}
+
+func encodeSnapshot(snapshot *cache.Snapshot) (persistedSnapshot, error) {
+	out := persistedSnapshot{
+		Version:   snapshotVersion(snapshot),
+		Resources: make(map[string][][]byte),
+	}
+
+	for _, typeURL := range persistedTypes {
+		resources := snapshot.GetResources(typeURL)
+		names := make([]string, 0, len(resources))
+		for name := range resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			anyRes, err := anypb.New(resources[name])
+			if err != nil {
+				return out, fmt.Errorf("failed to encode %s %s: %w", typeURL, name, err)
+			}
+			raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(anyRes)
+			if err != nil {
+				return out, fmt.Errorf("failed to encode %s %s: %w", typeURL, name, err)
+			}
+			out.Resources[typeURL] = append(out.Resources[typeURL], raw)
+		}
+	}
+
+	return out, nil
+}
+
+func decodeSnapshot(in persistedSnapshot) (*cache.Snapshot, error) {
+	resources := make(map[res.Type][]types.Resource, len(in.Resources))
+	for typeURL, items := range in.Resources {
+		for _, raw := range items {
+			var anyRes anypb.Any
+			if err := proto.Unmarshal(raw, &anyRes); err != nil {
+				return nil, fmt.Errorf("failed to decode %s resource: %w", typeURL, err)
+			}
+			msg, err := anyRes.UnmarshalNew()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode %s resource: %w", typeURL, err)
+			}
+			resources[typeURL] = append(resources[typeURL], msg)
+		}
+	}
+	return cache.NewSnapshot(in.Version, resources)
+}
+
+// resourceHash returns a digest of the persisted resources, ignoring snapshot
+// versions and the nodes they were served to
+func (s *persistedState) resourceHash() string {
+	h := sha256.New()
+	write := func(p persistedSnapshot) {
+		for _, typeURL := range persistedTypes {
+			h.Write([]byte(typeURL))
+			for _, raw := range p.Resources[typeURL] {
+				h.Write(raw)
+			}
+		}
+	}
+
+	write(s.Default)
+
+	keys := make([]string, 0, len(s.Groups))
+	for k := range s.Groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(k))
+		write(s.Groups[k])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hash returns a digest of the persisted resources and the sorted static node
+// IDs, which are restored together
+func (s *persistedState) hash() string {
+	h := sha256.New()
+	h.Write([]byte(s.resourceHash()))
+	for _, id := range s.NodeIDs {
+		h.Write([]byte{0})
+		h.Write([]byte(id))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// persistedNodeIDs returns the static node IDs of a CR, which are persisted
+// with its snapshots
+func persistedNodeIDs(crd *api.XDSControlPlane) ([]string, error) {
+	matcher, err := newControlPlaneMatcher(crd)
+	if err != nil {
+		return nil, err
+	}
+	return matcher.staticIDs(), nil
+}
+
+// newPersistedState encodes the snapshots served for a CR
+func newPersistedState(crd *api.XDSControlPlane, snapshot *cache.Snapshot, groups map[string]*cache.Snapshot) (*persistedState, error) {
+	nodeIDs, err := persistedNodeIDs(crd)
+	if err != nil {
+		return nil, err
+	}
+	state := &persistedState{
+		Generation: crd.Generation,
+		NodeIDs:    nodeIDs,
+		Groups:     make(map[string]persistedSnapshot, len(groups)),
+	}
+
+	if state.Default, err = encodeSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	for key, s := range groups {
+		if state.Groups[key], err = encodeSnapshot(s); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// compress returns the gzip compressed JSON form of the state
+func (s *persistedState) compress() ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshots: %w", err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshots: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshots: %w", err)
+	}
+	if buf.Len() > maxPersistedSnapshotSize {
+		return nil, fmt.Errorf("compressed snapshots are %d bytes, exceeding the limit of %d bytes", buf.Len(), maxPersistedSnapshotSize)
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressState(data []byte) (*persistedState, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshots: %w", err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshots: %w", err)
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshots: %w", err)
+	}
+	return &state, nil
+}
+
+// persistSnapshots stores the snapshots just served in a ConfigMap owned by the
+// CR so that they can be served again right after an operator restart
+func (r *XDSControlPlaneReconciler) persistSnapshots(ctx context.Context, crd *api.XDSControlPlane, state *persistedState) error {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	hash := state.hash()
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotConfigMapName(crd),
+			Namespace: crd.Namespace,
+		},
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(cm), cm); err == nil {
+		if cm.Annotations[SnapshotHashAnnotation] == hash {
+			return nil
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get snapshot ConfigMap: %w", err)
+	}
+
+	data, err := state.compress()
+	if err != nil {
+		return err
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		if cm.Labels == nil {
+			cm.Labels = map[string]string{}
+		}
+		cm.Labels[ControlPlaneLabel] = crd.Name
+		cm.Labels["app.kubernetes.io/managed-by"] = "xds-cp-operator"
+		if cm.Annotations == nil {
+			cm.Annotations = map[string]string{}
+		}
+		cm.Annotations[SnapshotHashAnnotation] = hash
+		cm.BinaryData = map[string][]byte{snapshotDataKey: data}
+		return controllerutil.SetControllerReference(crd, cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot ConfigMap: %w", err)
+	}
+
+	log.Info("Persisted last-known-good snapshots", "configMap", cm.Name, "operation", op, "bytes", len(data))
+	return nil
+}
+
+// loadPersistedState reads the last-known-good snapshots of a CR, returning nil if none were persisted
+func (r *XDSControlPlaneReconciler) loadPersistedState(ctx context.Context, crd *api.XDSControlPlane) (*persistedState, error) {
+	cm := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: crd.Namespace, Name: snapshotConfigMapName(crd)}
+	if err := r.Get(ctx, key, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get snapshot ConfigMap: %w", err)
+	}
+
+	return decompressState(cm.BinaryData[snapshotDataKey])
+}
+
+// restoreSnapshots seeds the cache of a freshly started xDS server with the
+// last-known-good snapshots, so reconnecting Envoys are served even if the
+// following reconcile fails
+func (r *XDSControlPlaneReconciler) restoreSnapshots(ctx context.Context, crd *api.XDSControlPlane, server *XDSServerInstance) error {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
+
+	state, err := r.loadPersistedState(ctx, crd)
+	if err != nil || state == nil {
+		return err
+	}
+
+	snapshot, err := decodeSnapshot(state.Default)
+	if err != nil {
+		return err
+	}
+
+	matcher, err := newControlPlaneMatcher(crd)
+	if err != nil {
+		return err
+	}
+	server.nodeHash.setGroups(crd.Spec.NodeGroups)
+
+	server.nodesMu.Lock()
+	server.matcher = matcher
+	server.defaultSnapshot = snapshot
+	server.nodesMu.Unlock()
+	server.tracker.registerVersion(state.Default.Version, state.Generation)
+
+	for _, nodeID := range state.NodeIDs {
+		if err := server.cache.SetSnapshot(ctx, nodeID, snapshot); err != nil {
+			return fmt.Errorf("failed to restore snapshot for node %s: %w", nodeID, err)
+		}
+	}
+
+	if _, err := setPersistedGroupSnapshots(ctx, server, state); err != nil {
+		return err
+	}
+
+	log.Info("Restored last-known-good snapshots", "version", state.Default.Version, "generation", state.Generation, "nodeIDs", state.NodeIDs, "nodeGroups", len(state.Groups))
+	return nil
+}
+
+// setPersistedGroupSnapshots replaces the node group snapshots of a server with
+// the persisted ones and returns them by cache key
+func setPersistedGroupSnapshots(ctx context.Context, server *XDSServerInstance, state *persistedState) (map[string]*cache.Snapshot, error) {
+	snapshots := make(map[string]*cache.Snapshot, len(state.Groups))
+	for key, p := range state.Groups {
+		s, err := decodeSnapshot(p)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[key] = s
+		server.tracker.registerVersion(p.Version, state.Generation)
+	}
+
+	active := make(map[string]bool, len(snapshots))
+	for key, s := range snapshots {
+		if err := server.cache.SetSnapshot(ctx, key, s); err != nil {
+			return nil, fmt.Errorf("failed to set snapshot for %s: %w", key, err)
+		}
+		active[key] = true
+	}
+	for key := range server.nodeGroupKeys {
+		if !active[key] {
+			server.cache.ClearSnapshot(key)
+		}
+	}
+	server.nodeGroupKeys = active
+
+	return snapshots, nil
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestPersistSnapshots(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "persist", Namespace: "default", UID: "uid-1", Generation: 3},
+		Spec: api.XDSControlPlaneSpec{
+			NodeIDs:    []string{"envoy-1", "edge-*"},
+			NodeGroups: []api.NodeGroupSpec{{Name: "dc2", Selector: api.NodeSelectorSpec{NodeIDs: []string{"dc2-*"}}}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	reconciler := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	newSnapshot := func(version, clusterName string) *cache.Snapshot {
+		s, err := cache.NewSnapshot(version, map[res.Type][]types.Resource{
+			res.ClusterType: {&cluster.Cluster{Name: clusterName}},
+		})
+		require.NoError(t, err)
+		return s
+	}
+
+	persist := func(snapshot *cache.Snapshot, groups map[string]*cache.Snapshot) error {
+		state, err := newPersistedState(crd, snapshot, groups)
+		require.NoError(t, err)
+		return reconciler.persistSnapshots(ctx, crd, state)
+	}
+
+	snapshot := newSnapshot("1", "backend")
+	groups := map[string]*cache.Snapshot{"nodegroup/dc2": newSnapshot("1", "backend-dc2")}
+	require.NoError(t, persist(snapshot, groups))
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "persist-xds-snapshot"}, cm))
+	assert.NotEmpty(t, cm.BinaryData[snapshotDataKey])
+	assert.Equal(t, "persist", cm.Labels[ControlPlaneLabel])
+	require.Len(t, cm.OwnerReferences, 1)
+	assert.Equal(t, "persist", cm.OwnerReferences[0].Name)
+
+	t.Run("Unchanged resources are not written again", func(t *testing.T) {
+		rv := cm.ResourceVersion
+		require.NoError(t, persist(newSnapshot("2", "backend"), groups))
+
+		current := &corev1.ConfigMap{}
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cm), current))
+		assert.Equal(t, rv, current.ResourceVersion)
+	})
+
+	t.Run("Only static node IDs are persisted and hashed", func(t *testing.T) {
+		state, err := newPersistedState(crd, newSnapshot("2", "backend"), groups)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"envoy-1"}, state.NodeIDs)
+
+		other := crd.DeepCopy()
+		other.Spec.NodeIDs = []string{"envoy-2", "envoy-1"}
+		changed, err := newPersistedState(other, newSnapshot("3", "backend"), groups)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"envoy-1", "envoy-2"}, changed.NodeIDs)
+		assert.Equal(t, state.resourceHash(), changed.resourceHash())
+		assert.NotEqual(t, state.hash(), changed.hash())
+	})
+
+	t.Run("Restore seeds a new server", func(t *testing.T) {
+		server := newTestServerInstance()
+		require.NoError(t, reconciler.restoreSnapshots(ctx, crd, server))
+
+		restored, err := server.cache.GetSnapshot("envoy-1")
+		require.NoError(t, err)
+		assert.Equal(t, "1", restored.GetVersion(res.ClusterType))
+		assert.Contains(t, restored.GetResources(res.ClusterType), "backend")
+
+		group, err := server.cache.GetSnapshot("nodegroup/dc2")
+		require.NoError(t, err)
+		assert.Contains(t, group.GetResources(res.ClusterType), "backend-dc2")
+		assert.True(t, server.nodeGroupKeys["nodegroup/dc2"])
+
+		// Nodes matched by pattern are served the restored snapshot on connect
+		server.ensureNodeSnapshot(&core.Node{Id: "edge-7f9c"})
+		_, err = server.cache.GetSnapshot("edge-7f9c")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Nothing to restore", func(t *testing.T) {
+		other := crd.DeepCopy()
+		other.Name = "other"
+		server := newTestServerInstance()
+		require.NoError(t, reconciler.restoreSnapshots(ctx, other, server))
+		assert.Empty(t, server.cache.GetStatusKeys())
+	})
+}
+
+func TestSameResources(t *testing.T) {
+	snapshot := func(version string, resources ...types.Resource) *cache.Snapshot {
+		s, err := cache.NewSnapshot(version, map[res.Type][]types.Resource{res.ClusterType: resources})
+		require.NoError(t, err)
+		return s
+	}
+
+	served := snapshot("1", &cluster.Cluster{Name: "a"})
+	assert.True(t, sameResources(served, snapshot("2", &cluster.Cluster{Name: "a"})))
+	assert.False(t, sameResources(served, snapshot("2", &cluster.Cluster{Name: "a", AltStatName: "a"})))
+	assert.False(t, sameResources(served, snapshot("2", &cluster.Cluster{Name: "a"}, &cluster.Cluster{Name: "b"})))
+	assert.False(t, sameResources(nil, served))
+}
@@ -0,0 +1,291 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const (
+	// maxTrackedVersions bounds the snapshot versions mapped to their generation
+	maxTrackedVersions = 64
+	// maxNodeStatuses bounds the number of nodes listed in status
+	maxNodeStatuses = 256
+	// nodeStatusInterval is the minimum interval between node status updates
+	nodeStatusInterval = 2 * time.Second
+)
+
+// propagationTracker follows a spec generation from its observation by the
+// reconciler to the snapshot being set and acknowledged by each node
+type propagationTracker struct {
+	mu sync.Mutex
+
+	generation int64
+	observedAt time.Time
+	// measured is false for the generation found when the server started,
+	// which was not changed while the operator was watching
+	measured bool
+
+	snapshotVersion string
+	snapshotAt      time.Time
+
+	// versions maps snapshot versions to the generation they were built from
+	versions     map[string]int64
+	versionOrder []string
+
+	// measuredNodes holds the nodes whose latency was recorded for the current generation
+	measuredNodes map[string]bool
+	nodes         map[string]*nodeAckState
+
+	// dirty is set when the node state changed since the last status update
+	dirty bool
+}
+
+// nodeAckState is the acknowledgement state of a connected node
+type nodeAckState struct {
+	streams      int
+	ackedVersion string
+	lastAck      time.Time
+	lastNack     time.Time
+	nackMessage  string
+}
+
+func newPropagationTracker() *propagationTracker {
+	return &propagationTracker{
+		versions:      make(map[string]int64),
+		measuredNodes: make(map[string]bool),
+		nodes:         make(map[string]*nodeAckState),
+	}
+}
+
+// observeGeneration records the generation seen by a reconcile
+func (t *propagationTracker) observeGeneration(generation int64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if generation == t.generation {
+		return
+	}
+	t.measured = t.generation != 0
+	t.generation = generation
+	t.observedAt = now
+	t.snapshotVersion = ""
+	t.snapshotAt = time.Time{}
+	t.measuredNodes = make(map[string]bool)
+	t.dirty = true
+}
+
+// snapshotSet records the default snapshot version set for the current
+// generation. Setting the same version again keeps the time it was first set.
+func (t *propagationTracker) snapshotSet(version string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if version == t.snapshotVersion {
+		return
+	}
+	t.snapshotVersion = version
+	t.snapshotAt = now
+	t.registerVersionLocked(version, t.generation)
+	t.dirty = true
+}
+
+// registerVersion maps a snapshot version to the generation it was built from
+func (t *propagationTracker) registerVersion(version string, generation int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.registerVersionLocked(version, generation)
+}
+
+func (t *propagationTracker) registerVersionLocked(version string, generation int64) {
+	if _, ok := t.versions[version]; !ok {
+		t.versionOrder = append(t.versionOrder, version)
+	}
+	t.versions[version] = generation
+
+	for len(t.versionOrder) > maxTrackedVersions {
+		delete(t.versions, t.versionOrder[0])
+		t.versionOrder = t.versionOrder[1:]
+	}
+}
+
+func (t *propagationTracker) streamOpened(nodeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.nodes[nodeID]
+	if !ok {
+		state = &nodeAckState{}
+		t.nodes[nodeID] = state
+	}
+	state.streams++
+	t.dirty = true
+}
+
+func (t *propagationTracker) streamClosed(nodeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.nodes[nodeID]
+	if !ok {
+		return
+	}
+	state.streams--
+	if state.streams <= 0 {
+		delete(t.nodes, nodeID)
+	}
+	t.dirty = true
+}
+
+// ack records an acknowledged version. It returns the propagation latency the
+// first time a node acknowledges a version built from the current generation.
+func (t *propagationTracker) ack(nodeID, version string, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.nodes[nodeID]; ok {
+		if state.ackedVersion != version {
+			t.dirty = true
+		}
+		state.ackedVersion = version
+		state.lastAck = now
+	}
+
+	generation, known := t.versions[version]
+	if !known || generation != t.generation || !t.measured || t.measuredNodes[nodeID] {
+		return 0, false
+	}
+	t.measuredNodes[nodeID] = true
+	return now.Sub(t.observedAt), true
+}
+
+// nack records a rejected response
+func (t *propagationTracker) nack(nodeID, message string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.nodes[nodeID]; ok {
+		state.lastNack = now
+		state.nackMessage = message
+		t.dirty = true
+	}
+}
+
+func (t *propagationTracker) markDirty() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirty = true
+}
+
+// takeDirty reports whether the state changed since the last call
+func (t *propagationTracker) takeDirty() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dirty := t.dirty
+	t.dirty = false
+	return dirty
+}
+
+// status returns the propagation status and the status of connected nodes
+func (t *propagationTracker) status() (*api.PropagationStatus, []api.NodeStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]string, 0, len(t.nodes))
+	for id := range t.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var updated int32
+	nodes := make([]api.NodeStatus, 0, len(ids))
+	for _, id := range ids {
+		state := t.nodes[id]
+		ns := api.NodeStatus{
+			NodeID:          id,
+			AckedVersion:    state.ackedVersion,
+			LastNackMessage: state.nackMessage,
+		}
+		if generation, ok := t.versions[state.ackedVersion]; ok {
+			ns.ObservedGeneration = generation
+			if generation == t.generation {
+				updated++
+			}
+		}
+		if !state.lastAck.IsZero() {
+			ns.LastAckTime = &metav1.Time{Time: state.lastAck}
+		}
+		if !state.lastNack.IsZero() {
+			ns.LastNackTime = &metav1.Time{Time: state.lastNack}
+		}
+		if len(nodes) < maxNodeStatuses {
+			nodes = append(nodes, ns)
+		}
+	}
+
+	if t.generation == 0 {
+		return nil, nodes
+	}
+
+	propagation := &api.PropagationStatus{
+		Generation:      t.generation,
+		ObservedTime:    metav1.Time{Time: t.observedAt},
+		SnapshotVersion: t.snapshotVersion,
+		UpdatedNodes:    updated,
+		ConnectedNodes:  int32(len(ids)),
+	}
+	if !t.snapshotAt.IsZero() {
+		propagation.SnapshotTime = &metav1.Time{Time: t.snapshotAt}
+	}
+	return propagation, nodes
+}
+
+// runNodeStatusUpdates writes node acknowledgements to the CR status as they
+// arrive, without waiting for the next reconcile
+func (r *XDSControlPlaneReconciler) runNodeStatusUpdates(ctx context.Context, server *XDSServerInstance) {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", server.name)
+
+	ticker := time.NewTicker(nodeStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// A replaced server shares the tracker with its successor, which
+			// reports the node status
+			if !server.isCurrent() || !server.tracker.takeDirty() {
+				continue
+			}
+			if err := r.updateNodeStatus(ctx, server); err != nil {
+				log.Error(err, "failed to update node status")
+				server.tracker.markDirty()
+			}
+		}
+	}
+}
+
+func (r *XDSControlPlaneReconciler) updateNodeStatus(ctx context.Context, server *XDSServerInstance) error {
+	crd := &api.XDSControlPlane{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: server.namespace, Name: server.name}, crd); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	base := crd.DeepCopy()
+	crd.Status.Propagation, crd.Status.Nodes = server.tracker.status()
+	setAcceptedCondition(crd)
+	if equality.Semantic.DeepEqual(base.Status, crd.Status) {
+		return nil
+	}
+	return r.Status().Patch(ctx, crd, client.MergeFrom(base))
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func TestPropagationTracker(t *testing.T) {
+	start := time.Now()
+	tracker := newPropagationTracker()
+	tracker.streamOpened("envoy-1")
+	tracker.streamOpened("envoy-2")
+
+	// The generation found at startup is not measured
+	tracker.observeGeneration(1, start)
+	tracker.snapshotSet("v1", start)
+	_, ok := tracker.ack("envoy-1", "v1", start.Add(time.Second))
+	assert.False(t, ok)
+
+	tracker.observeGeneration(2, start)
+	tracker.snapshotSet("v2", start)
+	tracker.snapshotSet("v3", start.Add(time.Second))
+
+	latency, ok := tracker.ack("envoy-1", "v3", start.Add(2*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, latency)
+
+	// Only the first ACK of a node counts
+	_, ok = tracker.ack("envoy-1", "v2", start.Add(3*time.Second))
+	assert.False(t, ok)
+
+	// Versions of previous generations are ignored
+	_, ok = tracker.ack("envoy-2", "v1", start.Add(3*time.Second))
+	assert.False(t, ok)
+	tracker.nack("envoy-2", "invalid listener", start.Add(3*time.Second))
+
+	propagation, nodes := tracker.status()
+	require.NotNil(t, propagation)
+	assert.Equal(t, int64(2), propagation.Generation)
+	assert.Equal(t, "v3", propagation.SnapshotVersion)
+	assert.Equal(t, int32(1), propagation.UpdatedNodes)
+	assert.Equal(t, int32(2), propagation.ConnectedNodes)
+
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "envoy-1", nodes[0].NodeID)
+	assert.Equal(t, "v2", nodes[0].AckedVersion)
+	assert.Equal(t, int64(2), nodes[0].ObservedGeneration)
+	assert.Equal(t, "v1", nodes[1].AckedVersion)
+	assert.Equal(t, int64(1), nodes[1].ObservedGeneration)
+	assert.Equal(t, "invalid listener", nodes[1].LastNackMessage)
+	assert.NotNil(t, nodes[1].LastNackTime)
+
+	// Disconnected nodes are removed
+	tracker.streamClosed("envoy-2")
+	_, nodes = tracker.status()
+	assert.Len(t, nodes, 1)
+}
+
+func TestNodeStatusUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, api.AddToScheme(scheme))
+
+	crd := &api.XDSControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 3}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).WithStatusSubresource(crd).Build()
+	reconciler := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	server := newTestServerInstance()
+	callbacks := newXDSCallbacks(server)
+	server.tracker.observeGeneration(3, time.Now())
+	server.tracker.snapshotSet("100", time.Now())
+
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "envoy-1"}, TypeUrl: res.ClusterType,
+	}))
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		TypeUrl: res.ClusterType, VersionInfo: "100", ResponseNonce: "1",
+	}))
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		TypeUrl: res.ListenerType, VersionInfo: "100", ResponseNonce: "2",
+		ErrorDetail: &status.Status{Message: "duplicate listener"},
+	}))
+	assert.True(t, server.tracker.takeDirty())
+
+	require.NoError(t, reconciler.updateNodeStatus(ctx, server))
+
+	updated := &api.XDSControlPlane{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(crd), updated))
+	require.Len(t, updated.Status.Nodes, 1)
+	assert.Equal(t, "envoy-1", updated.Status.Nodes[0].NodeID)
+	assert.Equal(t, "100", updated.Status.Nodes[0].AckedVersion)
+	assert.Equal(t, int64(3), updated.Status.Nodes[0].ObservedGeneration)
+	assert.Equal(t, "duplicate listener", updated.Status.Nodes[0].LastNackMessage)
+	require.NotNil(t, updated.Status.Propagation)
+	assert.Equal(t, int32(1), updated.Status.Propagation.UpdatedNodes)
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+const defaultGracefulStopTimeout = 10 * time.Second
+
+func gracefulStopTimeout(crd *api.XDSControlPlane) time.Duration {
+	if crd.Spec.GracefulStopTimeout == "" {
+		return defaultGracefulStopTimeout
+	}
+	timeout, err := time.ParseDuration(crd.Spec.GracefulStopTimeout)
+	if err != nil || timeout <= 0 {
+		return defaultGracefulStopTimeout
+	}
+	return timeout
+}
+
+func portChangeOverlap(crd *api.XDSControlPlane) time.Duration {
+	if crd.Spec.PortChangeOverlap == "" {
+		return 0
+	}
+	overlap, err := time.ParseDuration(crd.Spec.PortChangeOverlap)
+	if err != nil || overlap < 0 {
+		return 0
+	}
+	return overlap
+}
+
+// inheritServer makes a new server of a CR serve the state of the previous one.
+// Both servers share the snapshot cache, the propagation tracker and the served
+// nodes, so the new server serves the current snapshots as soon as it accepts
+// connections, the previous server keeps receiving updates until it is stopped,
+// and a node matched by pattern is only dropped once its streams to both
+// servers are closed.
+func inheritServer(instance, previous *XDSServerInstance) {
+	instance.cache = previous.cache
+	instance.nodeHash = previous.nodeHash
+	instance.tracker = previous.tracker
+	instance.nodeGroupKeys = maps.Clone(previous.nodeGroupKeys)
+	instance.pushedHash = previous.pushedHash
+	instance.endpointSets = maps.Clone(previous.endpointSets)
+	instance.servedNodes = previous.servedNodes
+}
+
+// isCurrent reports whether the server is the one registered for its CR
+func (s *XDSServerInstance) isCurrent() bool {
+	serverManager.RLock()
+	defer serverManager.RUnlock()
+	return serverManager.servers[s.namespace+"/"+s.name] == s
+}
+
+// retireServer stops a replaced server once the overlap has elapsed
+func (r *XDSControlPlaneReconciler) retireServer(ctx context.Context, server *XDSServerInstance, overlap, timeout time.Duration) {
+	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", server.name)
+
+	if overlap > 0 {
+		log.Info("Previous xDS server keeps serving during the port change overlap", "port", server.port, "overlap", overlap)
+		timer := time.NewTimer(overlap)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	if !r.stopServer(server, timeout) {
+		log.Info("xDS server did not stop gracefully, closed remaining streams", "port", server.port, "timeout", timeout)
+	}
+	log.Info("Previous xDS server stopped", "port", server.port)
+}
+
+// stopServer stops a server, streams still open after the timeout are closed
+// forcefully. It reports whether the server stopped gracefully.
+func (r *XDSControlPlaneReconciler) stopServer(server *XDSServerInstance, timeout time.Duration) bool {
+	if server.cancel != nil {
+		server.cancel()
+	}
+
+	graceful := true
+	if server.server != nil {
+		stopped := make(chan struct{})
+		go func() {
+			server.server.GracefulStop()
+			close(stopped)
+		}()
+
+		timer := time.NewTimer(timeout)
+		select {
+		case <-stopped:
+			timer.Stop()
+		case <-timer.C:
+			graceful = false
+			server.server.Stop()
+			<-stopped
+		}
+	}
+
+	if server.listener != nil {
+		server.listener.Close()
+	}
+	return graceful
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+func freePort(t *testing.T) int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestRestartDurations(t *testing.T) {
+	crd := &api.XDSControlPlane{}
+	assert.Equal(t, defaultGracefulStopTimeout, gracefulStopTimeout(crd))
+	assert.Equal(t, time.Duration(0), portChangeOverlap(crd))
+
+	crd.Spec.GracefulStopTimeout = "invalid"
+	crd.Spec.PortChangeOverlap = "-1m"
+	assert.Equal(t, defaultGracefulStopTimeout, gracefulStopTimeout(crd))
+	assert.Equal(t, time.Duration(0), portChangeOverlap(crd))
+
+	crd.Spec.GracefulStopTimeout = "30s"
+	crd.Spec.PortChangeOverlap = "2m"
+	assert.Equal(t, 30*time.Second, gracefulStopTimeout(crd))
+	assert.Equal(t, 2*time.Minute, portChangeOverlap(crd))
+}
+
+func TestStopServerTimeout(t *testing.T) {
+	// A stream that never finishes blocks the graceful stop
+	started, block := make(chan struct{}), make(chan struct{})
+	defer close(block)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(any, grpc.ServerStream) error {
+		close(started)
+		<-block
+		return nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/test.Blocking/Stream")
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(&discovery.DiscoveryRequest{}))
+	<-started
+
+	r := &XDSControlPlaneReconciler{}
+	start := time.Now()
+	graceful := r.stopServer(&XDSServerInstance{server: srv, listener: lis}, 200*time.Millisecond)
+	assert.False(t, graceful)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestPortChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	oldPort, newPort := freePort(t), freePort(t)
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "restart", Namespace: "default"},
+		Spec:       api.XDSControlPlaneSpec{XdsPort: oldPort, NodeIDs: []string{"envoy-1"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+	serverKey := "default/restart"
+	defer r.cleanupServer(serverKey, time.Second)
+
+	previous, err := r.ensureXDSServer(ctx, crd, serverKey)
+	require.NoError(t, err)
+
+	snapshot, err := cache.NewSnapshot("1", map[res.Type][]types.Resource{
+		res.ClusterType: {&cluster.Cluster{Name: "backend"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, previous.cache.SetSnapshot(ctx, "envoy-1", snapshot))
+
+	crd.Spec.XdsPort = newPort
+	server, err := r.ensureXDSServer(ctx, crd, serverKey)
+	require.NoError(t, err)
+	require.NotSame(t, previous, server)
+	assert.Equal(t, newPort, server.port)
+	assert.True(t, server.isCurrent())
+	assert.False(t, previous.isCurrent())
+
+	// The new port serves the current snapshot right away
+	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", newPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	streamCtx, streamCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer streamCancel()
+	stream, err := discovery.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(streamCtx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&discovery.DiscoveryRequest{Node: &core.Node{Id: "envoy-1"}, TypeUrl: res.ClusterType}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "1", resp.VersionInfo)
+	assert.Len(t, resp.Resources, 1)
+
+	// The previous port is closed without overlap
+	require.Eventually(t, func() bool {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", oldPort), 100*time.Millisecond)
+		if err != nil {
+			return true
+		}
+		conn.Close()
+		return false
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestInheritServerSharesNodes(t *testing.T) {
+	ctx := context.Background()
+	reconciler := &XDSControlPlaneReconciler{}
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "overlap", Namespace: "default"},
+		Spec:       api.XDSControlPlaneSpec{NodeIDs: []string{"edge-envoy-*"}},
+	}
+	snapshot, err := cache.NewSnapshot("1", nil)
+	require.NoError(t, err)
+
+	previous := newTestServerInstance()
+	_, err = reconciler.setDefaultSnapshots(ctx, crd, previous, snapshot)
+	require.NoError(t, err)
+	node := &core.Node{Id: "edge-envoy-7f9c-abcde"}
+	previousCallbacks := newXDSCallbacks(previous)
+	require.NoError(t, previousCallbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: node}))
+
+	instance := &XDSServerInstance{namespace: "default", name: "overlap"}
+	inheritServer(instance, previous)
+	assert.Same(t, previous.servedNodes, instance.servedNodes)
+
+	// The node reconnects to the new server before its stream to the previous
+	// one is closed, and keeps its snapshot
+	callbacks := newXDSCallbacks(instance)
+	require.NoError(t, callbacks.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: node}))
+	previousCallbacks.OnStreamClosed(1, nil)
+	_, err = instance.cache.GetSnapshot(node.Id)
+	assert.NoError(t, err)
+
+	// Nodes matched by either server are set on the next reconcile
+	nodeIDs, err := reconciler.setDefaultSnapshots(ctx, crd, instance, snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, []string{node.Id}, nodeIDs)
+
+	callbacks.OnStreamClosed(1, nil)
+	_, err = instance.cache.GetSnapshot(node.Id)
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlLog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// tlsSecretKey returns the Secret referenced by a filter chain, which also
+// names the certificate served over SDS
+func tlsSecretKey(t *api.DownstreamTLSSpec) client.ObjectKey {
+	return client.ObjectKey{Namespace: t.SecretNamespace, Name: t.SecretName}
+}
+
+// listenerTLS returns the TLS references of the filter chains of a listener
+func listenerTLS(l api.ListenerSpec) []*api.DownstreamTLSSpec {
+	var refs []*api.DownstreamTLSSpec
+	for _, chain := range l.FilterChains {
+		if chain.TLS != nil {
+			refs = append(refs, chain.TLS)
+		}
+	}
+	if l.DefaultFilterChain != nil && l.DefaultFilterChain.TLS != nil {
+		refs = append(refs, l.DefaultFilterChain.TLS)
+	}
+	return refs
+}
+
+// withTLSSecretNamespace returns a listener whose TLS references without a
+// namespace reference Secrets of namespace. The listener is copied before
+// it is defaulted.
+func withTLSSecretNamespace(l api.ListenerSpec, namespace string) api.ListenerSpec {
+	defaulted := false
+	for _, t := range listenerTLS(l) {
+		if t.SecretNamespace == "" {
+			defaulted = true
+		}
+	}
+	if !defaulted {
+		return l
+	}
+	copied := *l.DeepCopy()
+	for _, t := range listenerTLS(copied) {
+		if t.SecretNamespace == "" {
+			t.SecretNamespace = namespace
+		}
+	}
+	return copied
+}
+
+// usesTLSSecret reports whether a listener terminates TLS with a Secret,
+// references without a namespace resolving to namespace
+func usesTLSSecret(l api.ListenerSpec, namespace string, key client.ObjectKey) bool {
+	for _, t := range listenerTLS(withTLSSecretNamespace(l, namespace)) {
+		if tlsSecretKey(t) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// disallowedTLSSecret returns a Secret a listener defined in namespace may
+// not terminate TLS with, its references already defaulted. A listener may
+// only use the Secrets of its own namespace, unless they are granted to the
+// control plane (see tlsSecretGranted).
+func (r *XDSControlPlaneReconciler) disallowedTLSSecret(ctx context.Context, crd *api.XDSControlPlane, l api.ListenerSpec, namespace string) (client.ObjectKey, bool, error) {
+	for _, t := range listenerTLS(l) {
+		key := tlsSecretKey(t)
+		if key.Namespace == namespace {
+			continue
+		}
+		granted, err := r.tlsSecretGranted(ctx, crd, key)
+		if err != nil {
+			return client.ObjectKey{}, false, err
+		}
+		if !granted {
+			return key, true, nil
+		}
+	}
+	return client.ObjectKey{}, false, nil
+}
+
+// tlsSecretGranted reports whether a Secret is granted to a control plane
+// outside of its namespace. Only the control plane translating Ingresses is
+// granted Secrets, by an Ingress of the class listing the Secret in spec.tls.
+func (r *XDSControlPlaneReconciler) tlsSecretGranted(ctx context.Context, crd *api.XDSControlPlane, key client.ObjectKey) (bool, error) {
+	if r.IngressClassName == "" || client.ObjectKeyFromObject(crd) != r.IngressControlPlane {
+		return false, nil
+	}
+	var ingresses networkingv1.IngressList
+	if err := r.List(ctx, &ingresses, client.InNamespace(key.Namespace)); err != nil {
+		return false, fmt.Errorf("failed to list ingresses of namespace %s: %w", key.Namespace, err)
+	}
+	for i := range ingresses.Items {
+		ing := &ingresses.Items[i]
+		if ingressClassOf(ing) != r.IngressClassName {
+			continue
+		}
+		for _, tls := range ing.Spec.TLS {
+			if tls.SecretName == key.Name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// buildTLSSecrets reads the Secrets referenced by a listener into SDS
+// secrets. The certificates only live in the snapshot served to Envoy: they
+// are not persisted, recorded in the history or shown by the debug endpoint.
+func (r *XDSControlPlaneReconciler) buildTLSSecrets(ctx context.Context, l api.ListenerSpec) ([]types.Resource, error) {
+	var secrets []types.Resource
+	seen := map[client.ObjectKey]bool{}
+	for _, t := range listenerTLS(l) {
+		key := tlsSecretKey(t)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, key, secret); err != nil {
+			return nil, fmt.Errorf("failed to get TLS secret %s: %w", key, err)
+		}
+		cert, privateKey := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+		if len(cert) == 0 || len(privateKey) == 0 {
+			return nil, fmt.Errorf("secret %s has no %s or %s", key, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		}
+		secrets = append(secrets, &tls.Secret{
+			Name: key.String(),
+			Type: &tls.Secret_TlsCertificate{TlsCertificate: &tls.TlsCertificate{
+				CertificateChain: &core.DataSource{Specifier: &core.DataSource_InlineBytes{InlineBytes: cert}},
+				PrivateKey:       &core.DataSource{Specifier: &core.DataSource_InlineBytes{InlineBytes: privateKey}},
+			}},
+		})
+	}
+	return secrets, nil
+}
+
+// appendSecrets appends the secrets not already in a list, listeners sharing
+// a certificate
+func appendSecrets(secrets, more []types.Resource) []types.Resource {
+	for _, s := range more {
+		if !slices.ContainsFunc(secrets, func(existing types.Resource) bool {
+			return existing.(*tls.Secret).Name == s.(*tls.Secret).Name
+		}) {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
+
+// controlPlanesForSecret returns the control planes with a listener
+// terminating TLS with a Secret, inline or attached through an XDSListener
+func (r *XDSControlPlaneReconciler) controlPlanesForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	log := ctrlLog.FromContext(ctx)
+	key := client.ObjectKeyFromObject(obj)
+
+	var list api.XDSControlPlaneList
+	if err := r.List(ctx, &list); err != nil {
+		log.Error(err, "failed to list XDSControlPlanes for secret", "secret", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, crd := range list.Items {
+		for _, l := range crd.Spec.Listeners {
+			if usesTLSSecret(l, crd.Namespace, key) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&crd)})
+				break
+			}
+		}
+	}
+
+	var listeners api.XDSListenerList
+	if err := r.List(ctx, &listeners); err != nil {
+		log.Error(err, "failed to list XDSListeners for secret", "secret", obj.GetName())
+		return requests
+	}
+	for i := range listeners.Items {
+		l := &listeners.Items[i]
+		if usesTLSSecret(l.Spec.ListenerSpec, l.Namespace, key) {
+			requests = append(requests, r.controlPlanesForResource(ctx, l)...)
+		}
+	}
+	return requests
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+var tcpProxy = api.FilterSpec{
+	Name: "envoy.filters.network.tcp_proxy",
+	TypedConfig: rawJSON(map[string]interface{}{
+		"@type":       "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
+		"stat_prefix": "backend",
+		"cluster":     "backend",
+	}),
+}
+
+func TestTLSSecretsOverSDS(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("private-key")},
+	}
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+		Spec: api.XDSControlPlaneSpec{
+			Listeners: []api.ListenerSpec{{
+				Name: "https", Address: "0.0.0.0", Port: 8443,
+				FilterChains: []api.FilterChainSpec{{
+					Filters: []api.FilterSpec{tcpProxy},
+					TLS:     &api.DownstreamTLSSpec{SecretName: "example", ALPNProtocols: []string{"h2"}},
+				}},
+				DefaultFilterChain: &api.FilterChainSpec{
+					Filters: []api.FilterSpec{tcpProxy},
+					TLS:     &api.DownstreamTLSSpec{SecretName: "example", SecretNamespace: "default"},
+				},
+			}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd, secret).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	snapshot, skipped, err := r.buildXDSSnapshot(ctx, crd)
+	require.NoError(t, err)
+	assert.Empty(t, skipped)
+	assert.Empty(t, crd.Spec.Listeners[0].FilterChains[0].TLS.SecretNamespace, "the spec is not defaulted in place")
+
+	// The listener references the certificate, which is served once over SDS
+	l := snapshot.GetResources(res.ListenerType)["https"].(*listener.Listener)
+	var tlsContext tls.DownstreamTlsContext
+	require.NoError(t, l.FilterChains[0].GetTransportSocket().GetTypedConfig().UnmarshalTo(&tlsContext))
+	assert.Equal(t, []string{"h2"}, tlsContext.CommonTlsContext.AlpnProtocols)
+	require.Len(t, tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs, 1)
+	sds := tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs[0]
+	assert.Equal(t, "default/example", sds.Name)
+	assert.NotNil(t, sds.SdsConfig.GetAds())
+	assert.Equal(t, core.ApiVersion_V3, sds.SdsConfig.ResourceApiVersion)
+
+	secrets := snapshot.GetResources(res.SecretType)
+	require.Len(t, secrets, 1)
+	served := secrets["default/example"].(*tls.Secret)
+	assert.Equal(t, []byte("private-key"), served.GetTlsCertificate().GetPrivateKey().GetInlineBytes())
+
+	// The key is never persisted
+	persisted, err := encodeSnapshot(&snapshot)
+	require.NoError(t, err)
+	encoded, err := json.Marshal(persisted)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "private-key")
+	assert.NotContains(t, persisted.Resources, res.SecretType)
+
+	// A rotated certificate is a new snapshot
+	secret.Data[corev1.TLSPrivateKeyKey] = []byte("rotated-key")
+	require.NoError(t, c.Update(ctx, secret))
+	rotated, _, err := r.buildXDSSnapshot(ctx, crd)
+	require.NoError(t, err)
+	assert.False(t, sameResources(&snapshot, &rotated))
+
+	// The Secret triggers the control plane
+	assert.Equal(t, []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(crd)}},
+		r.controlPlanesForSecret(ctx, secret))
+	assert.Empty(t, r.controlPlanesForSecret(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "other"}}))
+
+	// A missing Secret is retried, or skips the listener
+	require.NoError(t, c.Delete(ctx, secret))
+	_, _, err = r.buildXDSSnapshot(ctx, crd)
+	require.Error(t, err)
+	assert.False(t, isPermanent(err))
+
+	crd.Spec.FailurePolicy = FailurePolicySkipInvalid
+	snapshot, skipped, err = r.buildXDSSnapshot(ctx, crd)
+	require.NoError(t, err)
+	require.Len(t, skipped, 1)
+	assert.Equal(t, "https", skipped[0].Name)
+	assert.Empty(t, snapshot.GetResources(res.ListenerType))
+	assert.Empty(t, snapshot.GetResources(res.SecretType))
+}
+
+func TestAttachedListenerTLSSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	ref := &api.ControlPlaneReference{Name: "edge", Namespace: "default"}
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+		Spec: api.XDSControlPlaneSpec{
+			AllowedNamespaces: &api.AllowedNamespacesSpec{From: "All"},
+		},
+	}
+	tlsListener := func(name, namespace string, port int, secretNamespace string) *api.XDSListener {
+		return &api.XDSListener{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: api.XDSListenerSpec{
+				AttachmentSpec: api.AttachmentSpec{ControlPlaneRef: ref},
+				ListenerSpec: api.ListenerSpec{
+					Name: name, Address: "0.0.0.0", Port: port,
+					FilterChains: []api.FilterChainSpec{{
+						Filters: []api.FilterSpec{tcpProxy},
+						TLS:     &api.DownstreamTLSSpec{SecretName: "cert", SecretNamespace: secretNamespace},
+					}},
+				},
+			},
+		}
+	}
+	own := tlsListener("own", "apps", 8443, "")
+	foreign := tlsListener("foreign", "apps", 9443, "default")
+	// The namespace of the control plane may not read other namespaces either
+	fromControlPlane := tlsListener("from-control-plane", "default", 10443, "other")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd, own, foreign, fromControlPlane).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	merged := crd.DeepCopy()
+	resources, err := r.resolveAttachments(ctx, merged)
+	require.NoError(t, err)
+
+	// Secrets default to the namespace of the listener, other namespaces are
+	// not readable from it
+	require.Len(t, merged.Spec.Listeners, 1)
+	assert.Equal(t, "apps", merged.Spec.Listeners[0].FilterChains[0].TLS.SecretNamespace)
+	outcomes := map[string]*api.AttachmentStatus{}
+	for _, res := range resources {
+		outcomes[res.obj.GetName()] = res.outcome
+	}
+	assert.Equal(t, ReasonAccepted, outcomes["own"].Reason)
+	assert.Equal(t, ReasonNotAllowed, outcomes["foreign"].Reason)
+	assert.Equal(t, ReasonNotAllowed, outcomes["from-control-plane"].Reason)
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "edge"}}},
+		r.controlPlanesForSecret(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cert", Namespace: "apps"}}))
+}
+
+func TestInlineListenerTLSSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, networkingv1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	newCRD := func(secretNamespace string) *api.XDSControlPlane {
+		return &api.XDSControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+			Spec: api.XDSControlPlaneSpec{
+				Listeners: []api.ListenerSpec{{
+					Name: "https", Address: "0.0.0.0", Port: 8443,
+					FilterChains: []api.FilterChainSpec{{
+						Filters: []api.FilterSpec{tcpProxy},
+						TLS:     &api.DownstreamTLSSpec{SecretName: "cert", SecretNamespace: secretNamespace},
+					}},
+				}},
+			},
+		}
+	}
+	class := "xds"
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &class,
+			TLS:              []networkingv1.IngressTLS{{SecretName: "cert"}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ingress).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+
+	// An inline listener may use the Secrets of the control plane's namespace
+	_, err := r.resolveAttachments(ctx, newCRD(""))
+	require.NoError(t, err)
+	_, err = r.resolveAttachments(ctx, newCRD("default"))
+	require.NoError(t, err)
+
+	// A Secret of another namespace is rejected
+	_, err = r.resolveAttachments(ctx, newCRD("apps"))
+	require.Error(t, err)
+	assert.True(t, isPermanent(err))
+	assert.Contains(t, err.Error(), "TLS secret apps/cert is not in namespace default")
+
+	// So is a Secret added by the patch of a node group
+	crd := newCRD("")
+	crd.Spec.NodeGroups = []api.NodeGroupSpec{{
+		Name: "canary",
+		Listeners: []api.ResourcePatchSpec{{
+			Name:  "https",
+			Patch: rawJSON(map[string]interface{}{"filterChains": []interface{}{map[string]interface{}{"tls": map[string]interface{}{"secretName": "cert", "secretNamespace": "apps"}}}}),
+		}},
+	}}
+	_, _, err = r.buildNodeGroupSnapshot(ctx, crd, crd.Spec.NodeGroups[0])
+	require.Error(t, err)
+	assert.True(t, isPermanent(err))
+
+	// The Ingresses of the class grant their Secrets to the control plane of the class only
+	r.IngressClassName = "xds"
+	r.IngressControlPlane = client.ObjectKey{Namespace: "default", Name: "other"}
+	_, err = r.resolveAttachments(ctx, newCRD("apps"))
+	require.Error(t, err)
+
+	r.IngressControlPlane = client.ObjectKey{Namespace: "default", Name: "edge"}
+	_, err = r.resolveAttachments(ctx, newCRD("apps"))
+	require.NoError(t, err)
+
+	ingress.Spec.TLS = nil
+	require.NoError(t, c.Update(ctx, ingress))
+	_, err = r.resolveAttachments(ctx, newCRD("apps"))
+	require.Error(t, err)
+}
@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// translatedResources are the Envoy resources translated from other APIs,
+// such as Gateways or Ingresses, and written as attached resources
+type translatedResources struct {
+	listeners []api.ListenerSpec
+	clusters  []api.ClusterSpec
+	routes    []api.RouteConfigSpec
+}
+
+func (t *translatedResources) addCluster(c api.ClusterSpec) {
+	for _, existing := range t.clusters {
+		if existing.Name == c.Name {
+			return
+		}
+	}
+	t.clusters = append(t.clusters, c)
+}
+
+// translatedObjectName returns the name of the resource holding a translated
+// Envoy resource. The name is suffixed with a hash of the Envoy name, which
+// may contain characters that are not valid in a name.
+func translatedObjectName(base, envoyName string) string {
+	name := base + "-" + sanitizeName(envoyName)
+	sum := sha256.Sum256([]byte(envoyName))
+	if len(name) > 244 {
+		name = strings.TrimRight(name[:244], "-.")
+	}
+	return fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:4]))
+}
+
+// translatedObjects describes where the translated resources of an owner are written
+type translatedObjects struct {
+	owner     client.Object
+	namespace string
+	// labels select the resources of the owner, stale ones are deleted
+	labels map[string]string
+	ref    *api.ControlPlaneReference
+	name   func(envoyName string) string
+}
+
+// writeTranslatedResources writes translated resources owned by an object and
+// deletes the ones no longer translated. It returns the written resources.
+func writeTranslatedResources(ctx context.Context, c client.Client, scheme *runtime.Scheme, to translatedObjects, t *translatedResources) ([]client.Object, error) {
+	var written []client.Object
+	write := func(obj client.Object, mutate func()) error {
+		_, err := controllerutil.CreateOrUpdate(ctx, c, obj, func() error {
+			obj.SetLabels(to.labels)
+			mutate()
+			return controllerutil.SetControllerReference(to.owner, obj, scheme)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %T %s: %w", obj, obj.GetName(), err)
+		}
+		written = append(written, obj)
+		return nil
+	}
+	objectMeta := func(envoyName string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: to.name(envoyName), Namespace: to.namespace}
+	}
+	attachment := api.AttachmentSpec{ControlPlaneRef: to.ref}
+
+	for _, l := range t.listeners {
+		l := l
+		obj := &api.XDSListener{ObjectMeta: objectMeta(l.Name)}
+		if err := write(obj, func() {
+			obj.Spec = api.XDSListenerSpec{AttachmentSpec: attachment, ListenerSpec: l}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, cl := range t.clusters {
+		cl := cl
+		obj := &api.XDSCluster{ObjectMeta: objectMeta(cl.Name)}
+		if err := write(obj, func() {
+			obj.Spec = api.XDSClusterSpec{AttachmentSpec: attachment, ClusterSpec: cl}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, rc := range t.routes {
+		rc := rc
+		obj := &api.XDSRouteConfig{ObjectMeta: objectMeta(rc.Name)}
+		if err := write(obj, func() {
+			obj.Spec = api.XDSRouteConfigSpec{AttachmentSpec: attachment, RouteConfigSpec: rc}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	desired := make(map[string]bool)
+	for _, obj := range written {
+		desired[fmt.Sprintf("%T/%s", obj, obj.GetName())] = true
+	}
+	lists := []client.ObjectList{&api.XDSListenerList{}, &api.XDSClusterList{}, &api.XDSRouteConfigList{}}
+	for _, list := range lists {
+		if err := c.List(ctx, list, client.InNamespace(to.namespace), client.MatchingLabels(to.labels)); err != nil {
+			return nil, fmt.Errorf("failed to list translated resources: %w", err)
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			obj := item.(client.Object)
+			if desired[fmt.Sprintf("%T/%s", obj, obj.GetName())] || !metav1.IsControlledBy(obj, to.owner) {
+				continue
+			}
+			if err := c.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to delete %T %s: %w", obj, obj.GetName(), err)
+			}
+		}
+	}
+	return written, nil
+}
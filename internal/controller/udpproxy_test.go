@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	udp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	res "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/okassov/xds-cp-operator/api/v1alpha1"
+)
+
+// udpEchoServer starts a UDP server on the loopback sending every datagram
+// back to its sender, and returns its port
+func udpEchoServer(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// udpExchange sends a datagram to address and returns the response
+func udpExchange(address, payload string) (string, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// envoyBinary returns the Envoy to send traffic through. ENVOY names a binary
+// that must exist, the pinned release of make test-envoy, otherwise the test
+// is skipped without an envoy in PATH.
+func envoyBinary(t *testing.T) string {
+	t.Helper()
+	if path := os.Getenv("ENVOY"); path != "" {
+		_, err := os.Stat(path)
+		require.NoError(t, err, "ENVOY")
+		return path
+	}
+	path, err := exec.LookPath("envoy")
+	if err != nil {
+		t.Skip("envoy not found in PATH, run make test-envoy")
+	}
+	return path
+}
+
+// TestUDPProxy serves a UDP listener proxying to a local echo server over
+// ADS, and sends datagrams through it with a real Envoy
+func TestUDPProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echoPort := udpEchoServer(t)
+	reply, err := udpExchange(fmt.Sprintf("127.0.0.1:%d", echoPort), "ping")
+	require.NoError(t, err)
+	require.Equal(t, "ping", reply)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, api.AddToScheme(scheme))
+
+	listenPort := freeUDPPort(t)
+	crd := &api.XDSControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "udp", Namespace: "default"},
+		Spec: api.XDSControlPlaneSpec{
+			XdsPort:   freePort(t),
+			NodeIDs:   []string{"envoy-1"},
+			Bootstrap: &api.BootstrapSpec{AdminPort: int32(freePort(t))},
+			Listeners: []api.ListenerSpec{{
+				Name: "echo", Address: "127.0.0.1", Port: listenPort,
+				UDP: &api.UDPListenerSpec{
+					Cluster:     "echo",
+					IdleTimeout: "10s",
+					HashPolicy:  &api.UDPHashPolicySpec{Type: "SourceIP"},
+				},
+			}},
+			Clusters: []api.ClusterSpec{{
+				Name: "echo", Type: "static", LbPolicy: "round_robin",
+				LoadAssignment: &api.LoadAssignmentSpec{EndpointsFrom: &api.EndpointSelectorSpec{
+					Type: "Service", Name: "echo", Namespace: "default", Port: echoPort,
+				}},
+			}},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "127.0.0.1",
+			Ports:     []corev1.ServicePort{{Port: int32(echoPort), Protocol: corev1.ProtocolUDP}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd, svc).Build()
+	r := &XDSControlPlaneReconciler{Client: c, Scheme: scheme}
+	serverKey := "default/udp"
+	defer r.cleanupServer(serverKey, time.Second)
+
+	server, err := r.ensureXDSServer(ctx, crd, serverKey)
+	require.NoError(t, err)
+	snapshot, _, err := r.buildXDSSnapshot(ctx, crd)
+	require.NoError(t, err)
+	require.NoError(t, server.cache.SetSnapshot(ctx, "envoy-1", &snapshot))
+
+	t.Run("Served over ADS", func(t *testing.T) {
+		conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", crd.Spec.XdsPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		streamCtx, streamCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer streamCancel()
+		stream, err := discovery.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(streamCtx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&discovery.DiscoveryRequest{Node: &core.Node{Id: "envoy-1"}, TypeUrl: res.ListenerType}))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		require.Len(t, resp.Resources, 1)
+
+		var l listener.Listener
+		require.NoError(t, resp.Resources[0].UnmarshalTo(&l))
+		assert.Equal(t, core.SocketAddress_UDP, l.Address.GetSocketAddress().Protocol)
+		assert.Equal(t, uint32(listenPort), l.Address.GetSocketAddress().GetPortValue())
+		assert.NotNil(t, l.UdpListenerConfig)
+		require.Len(t, l.ListenerFilters, 1)
+		assert.Equal(t, "envoy.filters.udp_listener.udp_proxy", l.ListenerFilters[0].Name)
+
+		var up udp_proxy.UdpProxyConfig
+		require.NoError(t, l.ListenerFilters[0].GetTypedConfig().UnmarshalTo(&up))
+		var route udp_proxy.Route
+		require.NoError(t, up.GetMatcher().GetOnNoMatch().GetAction().GetTypedConfig().UnmarshalTo(&route))
+		assert.Equal(t, "echo", route.Cluster)
+		assert.Equal(t, 10*time.Second, up.IdleTimeout.AsDuration())
+	})
+
+	t.Run("Datagrams through Envoy", func(t *testing.T) {
+		envoyPath := envoyBinary(t)
+
+		bootstrap, err := renderBootstrap(crd, "envoy-1", fmt.Sprintf("127.0.0.1:%d", crd.Spec.XdsPort))
+		require.NoError(t, err)
+		bootstrapPath := filepath.Join(t.TempDir(), "bootstrap.yaml")
+		require.NoError(t, os.WriteFile(bootstrapPath, bootstrap, 0o600))
+
+		envoyCtx, envoyCancel := context.WithCancel(ctx)
+		defer envoyCancel()
+		envoy := exec.CommandContext(envoyCtx, envoyPath, "-c", bootstrapPath,
+			"--base-id", fmt.Sprint(os.Getpid()), "--log-level", "warn")
+		envoy.Stdout, envoy.Stderr = os.Stdout, os.Stderr
+		require.NoError(t, envoy.Start())
+		defer func() { _ = envoy.Wait() }()
+
+		address := fmt.Sprintf("127.0.0.1:%d", listenPort)
+		require.Eventually(t, func() bool {
+			reply, err := udpExchange(address, "ping")
+			return err == nil && reply == "ping"
+		}, 30*time.Second, 250*time.Millisecond)
+
+		reply, err := udpExchange(address, "syslog message")
+		require.NoError(t, err)
+		assert.Equal(t, "syslog message", reply)
+	})
+}
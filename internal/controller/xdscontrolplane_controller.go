@@ -3,8 +3,10 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"time"
 
@@ -27,6 +29,7 @@ import (
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	envoytype "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 
 	// Transport sockets
@@ -37,32 +40,57 @@ import (
 	// Network filters
 	http_connection_manager "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	udp_proxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+
+	// HTTP filters, resolved by protojson inside HttpConnectionManager configs
+	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
 
 	// Access loggers
-	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
 	file_access_log "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
 	listener_proxy_protocol "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/proxy_protocol/v3"
+	tls_inspector "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"sync"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type XDSControlPlaneReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// PodIP and ServiceHost are the IP of the operator pod and the host name of
+	// its xDS Service, used to advertise the address of the xDS servers
+	PodIP       string
+	ServiceHost string
+
+	// IngressClassName and IngressControlPlane are the Ingress class and the
+	// control plane it is translated for, whose listeners may terminate TLS
+	// with the Secrets listed by the Ingresses of the class
+	IngressClassName    string
+	IngressControlPlane client.ObjectKey
 }
 
 // XDSServerManager manages the lifecycle of xDS servers
@@ -74,9 +102,53 @@ type XDSServerManager struct {
 type XDSServerInstance struct {
 	server   *grpc.Server
 	cache    cache.SnapshotCache
+	nodeHash *nodeGroupHash
 	listener net.Listener
 	cancel   context.CancelFunc
 	port     int
+
+	// network and address are the listen address, address is the socket path
+	// of a unix domain socket
+	network string
+	address string
+
+	// namespace and name identify the served CR in metrics
+	namespace string
+	name      string
+
+	// tracker measures the propagation of generation changes to the nodes
+	tracker *propagationTracker
+	// callbacks holds the state of the open streams
+	callbacks *xdsCallbacks
+
+	// nodeGroupKeys holds the cache keys of the node group snapshots currently set
+	nodeGroupKeys map[string]bool
+
+	// pushedHash and endpointSets describe the last pushed snapshots, to emit
+	// events only when they change
+	pushedHash   string
+	endpointSets map[string][]string
+
+	// servedNodes is shared with the previous server of the CR while they
+	// overlap, so that nodes are tracked across both
+	*servedNodes
+}
+
+// servedNodes holds the state used to serve nodes matched by a pattern on
+// connect, guarded by nodesMu
+type servedNodes struct {
+	nodesMu         sync.Mutex
+	matcher         *nodeMatcher
+	defaultSnapshot *cache.Snapshot
+	dynamicNodes    map[string]*core.Node
+	nodeStreams     map[string]int
+}
+
+func newServedNodes() *servedNodes {
+	return &servedNodes{
+		dynamicNodes: make(map[string]*core.Node),
+		nodeStreams:  make(map[string]int),
+	}
 }
 
 var (
@@ -90,19 +162,57 @@ const (
 	XDSControlPlaneFinalizer = "xds.okassov/finalizer"
 
 	// Condition types
-	ConditionTypeReady    = "Ready"
-	ConditionTypeServerUp = "ServerUp"
-	ConditionTypeSnapshot = "SnapshotReady"
+	ConditionTypeReady     = "Ready"
+	ConditionTypeServerUp  = "ServerUp"
+	ConditionTypeSnapshot  = "SnapshotReady"
+	ConditionTypeEndpoints = "EndpointsResolved"
+	ConditionTypeAccepted  = "ConfigAccepted"
+	ConditionTypeDataPlane = "DataPlaneReady"
 
 	// Phase values
-	PhasePending = "Pending"
-	PhaseReady   = "Ready"
-	PhaseError   = "Error"
+	PhasePending  = "Pending"
+	PhaseReady    = "Ready"
+	PhaseDegraded = "Degraded"
+	PhaseError    = "Error"
 )
 
+// SetupWithManager watches XDSControlPlanes for spec changes only, status
+// updates do not trigger reconciles. Node changes trigger the control planes
+// discovering endpoints from nodes, and changes to XDSListener, XDSCluster and
+// XDSRouteConfig resources the control planes they may attach to. Namespace
+// label changes trigger the control planes allowing namespaces by selector,
+// and TLS Secret changes the control planes serving them over SDS.
 func (r *XDSControlPlaneReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&api.XDSControlPlane{}).
+		For(&api.XDSControlPlane{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// Restore data plane objects and bootstraps that are deleted or whose
+		// spec is changed. Services do not bump their generation on spec
+		// changes, so all their events are watched.
+		Owns(&appsv1.Deployment{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&appsv1.DaemonSet{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}, builder.WithPredicates(bootstrapConfigMapChanged())).
+		Watches(&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlanesForNode),
+			builder.WithPredicates(nodeEndpointsChanged())).
+		Watches(&discoveryv1.EndpointSlice{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlanesForEndpointSlice),
+			builder.WithPredicates(endpointSliceChanged())).
+		Watches(&api.XDSListener{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlanesForResource),
+			builder.WithPredicates(attachedResourceChanged())).
+		Watches(&api.XDSCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlanesForResource),
+			builder.WithPredicates(attachedResourceChanged())).
+		Watches(&api.XDSRouteConfig{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlanesForResource),
+			builder.WithPredicates(attachedResourceChanged())).
+		Watches(&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlanesForNamespace),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlanesForSecret),
+			builder.WithPredicates(tlsSecretChanged())).
 		Complete(r)
 }
 
@@ -115,7 +225,7 @@ func (r *XDSControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	if err := r.Get(ctx, req.NamespacedName, &xdsCRD); err != nil {
 		if apierrors.IsNotFound(err) {
 			log.Info("XDSControlPlane not found, cleaning up server")
-			r.cleanupServer(req.NamespacedName.String())
+			r.cleanupServer(req.NamespacedName.String(), defaultGracefulStopTimeout)
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch XDSControlPlane")
@@ -127,15 +237,20 @@ func (r *XDSControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		return r.handleDeletion(ctx, &xdsCRD)
 	}
 
-	// Add finalizer if not present
+	// Add finalizer if not present. Metadata updates do not trigger a
+	// reconcile, so carry on with the updated object.
 	if !controllerutil.ContainsFinalizer(&xdsCRD, XDSControlPlaneFinalizer) {
 		controllerutil.AddFinalizer(&xdsCRD, XDSControlPlaneFinalizer)
-		return ctrl.Result{}, r.Update(ctx, &xdsCRD)
+		if err := r.Update(ctx, &xdsCRD); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	// Update status to Pending initially
 	if xdsCRD.Status.Phase == "" {
-		return r.updateStatus(ctx, &xdsCRD, PhasePending, "Initializing xDS control plane")
+		if _, err := r.updateStatus(ctx, &xdsCRD); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	// Ensure xDS server is running
@@ -143,92 +258,236 @@ func (r *XDSControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	server, err := r.ensureXDSServer(ctx, &xdsCRD, serverKey)
 	if err != nil {
 		log.Error(err, "Failed to ensure xDS server")
-		r.updateStatus(ctx, &xdsCRD, PhaseError, fmt.Sprintf("Failed to start xDS server: %v", err))
-		return ctrl.Result{RequeueAfter: time.Second * 30}, err
+		r.eventf(&xdsCRD, corev1.EventTypeWarning, EventReasonServerFailed, "Failed to start xDS server: %v", err)
+		r.updateStatusFailed(ctx, &xdsCRD, ConditionTypeServerUp, ReasonServerFailed, fmt.Sprintf("Failed to start xDS server: %v", err))
+		return errorResult(err)
 	}
+	setCondition(&xdsCRD, ConditionTypeServerUp, metav1.ConditionTrue, ReasonServerRunning, fmt.Sprintf("xDS server is running on %s", server.describeAddress()))
+
+	// Merge the attached XDSListener, XDSCluster and XDSRouteConfig resources
+	// into the in-memory spec, which is never written back
+	attached, err := r.resolveAttachments(ctx, &xdsCRD)
+	if err == nil {
+		err = r.updateAttachmentStatus(ctx, &xdsCRD, attached)
+	}
+	if err != nil {
+		log.Error(err, "failed to resolve attached resources")
+		r.updateStatusFailed(ctx, &xdsCRD, ConditionTypeSnapshot, ReasonAttachmentFailed, err.Error())
+		return errorResult(err)
+	}
+
+	// Bootstraps only depend on the spec and the server address, a failure
+	// does not affect the configuration served to connected nodes
+	dataPlaneRequeue := r.reconcileDataPlaneObjects(ctx, &xdsCRD, server)
+
+	// Serve a revision from the history instead of the spec while pinned
+	if xdsCRD.Spec.PinnedRevision != nil {
+		result, err := r.reconcilePinnedRevision(ctx, &xdsCRD, server)
+		return requeueSooner(result, dataPlaneRequeue), err
+	}
+
+	// Advance the canary rollout before rendering the weights into the snapshot
+	canaryRequeue := r.progressCanary(ctx, &xdsCRD)
 
 	// Build and set snapshot
-	snapshot, err := r.buildXDSSnapshot(ctx, &xdsCRD)
+	server.tracker.observeGeneration(xdsCRD.Generation, time.Now())
+	buildStart := time.Now()
+	snapshot, skipped, err := r.buildXDSSnapshot(ctx, &xdsCRD)
+	snapshotBuildDuration.WithLabelValues(xdsCRD.Namespace, xdsCRD.Name).Observe(time.Since(buildStart).Seconds())
 	if err != nil {
-		log.Error(err, "Error building xDS snapshot")
-		r.updateStatus(ctx, &xdsCRD, PhaseError, fmt.Sprintf("Failed to build snapshot: %v", err))
-		return ctrl.Result{RequeueAfter: time.Second * 30}, err
+		snapshotBuildErrors.WithLabelValues(xdsCRD.Namespace, xdsCRD.Name).Inc()
+		r.eventf(&xdsCRD, corev1.EventTypeWarning, EventReasonBuildFailed, "Failed to build snapshot: %v", err)
+		result, err := r.handleSnapshotFailure(ctx, &xdsCRD, server, err)
+		return requeueSooner(result, dataPlaneRequeue), err
 	}
 
-	// Get nodeIDs to use
-	nodeIDs := xdsCRD.Spec.NodeIDs
-	if len(nodeIDs) == 0 {
-		nodeIDs = []string{"external-envoy"}
+	// Build the snapshots of the node groups before setting any snapshot, so
+	// that a group failing to build leaves every node on its served snapshot
+	groupSnapshots, activeGroups, groupSkipped, err := r.buildNodeGroupSnapshots(ctx, &xdsCRD, server)
+	if err != nil {
+		r.eventf(&xdsCRD, corev1.EventTypeWarning, EventReasonBuildFailed, "Failed to build node group snapshots: %v", err)
+		result, err := r.handleSnapshotFailure(ctx, &xdsCRD, server, err)
+		return requeueSooner(result, dataPlaneRequeue), err
 	}
 
-	// Set snapshot for all nodeIDs
-	version := strconv.FormatInt(time.Now().Unix(), 10)
-	for _, nodeID := range nodeIDs {
-		log.Info("Setting xDS snapshot", "nodeID", nodeID, "version", version)
-		if err := server.cache.SetSnapshot(ctx, nodeID, &snapshot); err != nil {
-			log.Error(err, "failed to set xDS snapshot", "nodeID", nodeID)
-			r.updateStatus(ctx, &xdsCRD, PhaseError, fmt.Sprintf("Failed to set snapshot for node %s: %v", nodeID, err))
-			return ctrl.Result{RequeueAfter: time.Second * 30}, err
-		}
+	// Keep the version of the served snapshot if its resources did not change,
+	// so that unchanged reconciles neither push to Envoy nor update the status
+	server.nodesMu.Lock()
+	served := server.defaultSnapshot
+	server.nodesMu.Unlock()
+	if sameResources(served, &snapshot) {
+		snapshot = *served
+	}
+
+	// Set snapshot for all nodes receiving the default configuration
+	version := snapshotVersion(&snapshot)
+	nodeIDs, err := r.setDefaultSnapshots(ctx, &xdsCRD, server, &snapshot)
+	if err != nil {
+		log.Error(err, "failed to set xDS snapshot")
+		r.updateStatusFailed(ctx, &xdsCRD, ConditionTypeSnapshot, ReasonSetFailed, err.Error())
+		return errorResult(err)
+	}
+
+	// Set snapshots for node groups
+	if err := r.setNodeGroupSnapshots(ctx, &xdsCRD, server, groupSnapshots, activeGroups); err != nil {
+		log.Error(err, "failed to set node group snapshots")
+		r.updateStatusFailed(ctx, &xdsCRD, ConditionTypeSnapshot, ReasonSetFailed, err.Error())
+		return errorResult(err)
+	}
+	skipped = append(skipped, groupSkipped...)
+	fallback := skippedFallback(skipped, version)
+	if fallback != nil && !sameFallback(xdsCRD.Status.Fallback, fallback) {
+		r.eventf(&xdsCRD, corev1.EventTypeWarning, EventReasonResourcesSkipped, "Skipped invalid resources: %s", fallback.Reason)
 	}
+	xdsCRD.Status.Fallback = keepFallbackTime(xdsCRD.Status.Fallback, fallback)
 
-	log.Info("Successfully set xDS snapshots", "nodeIDs", nodeIDs, "version", version)
+	log.Info("Successfully set xDS snapshots", "nodeIDs", nodeIDs, "nodeGroups", len(xdsCRD.Spec.NodeGroups), "version", version)
+
+	server.tracker.snapshotSet(version, time.Now())
+	for _, s := range groupSnapshots {
+		server.tracker.registerVersion(snapshotVersion(s), xdsCRD.Generation)
+	}
+	recordSnapshotMetrics(&xdsCRD, &snapshot)
+
+	// Persist the served configuration so it survives operator restarts, and record it in the history
+	if state, err := newPersistedState(&xdsCRD, &snapshot, groupSnapshots); err != nil {
+		log.Error(err, "failed to encode xDS snapshots")
+	} else {
+		r.recordSnapshotEvents(&xdsCRD, server, &snapshot, state.resourceHash(), nodeIDs)
+		if err := r.persistSnapshots(ctx, &xdsCRD, state); err != nil {
+			log.Error(err, "failed to persist xDS snapshots")
+		}
+		if err := r.recordRevision(ctx, &xdsCRD, state); err != nil {
+			log.Error(err, "failed to record snapshot revision")
+		}
+	}
 
 	// Update status to Ready
-	return r.updateStatusReady(ctx, &xdsCRD, nodeIDs, server.port, version)
+	xdsCRD.Status.Propagation, xdsCRD.Status.Nodes = server.tracker.status()
+	setEndpointsCondition(&xdsCRD, &snapshot, skipped)
+	result, err := r.updateStatusReady(ctx, &xdsCRD, nodeIDs, server, version)
+	if err == nil {
+		result = requeueSooner(requeueSooner(result, canaryRequeue), dataPlaneRequeue)
+	}
+	return result, err
 }
 
 func (r *XDSControlPlaneReconciler) ensureXDSServer(ctx context.Context, crd *api.XDSControlPlane, serverKey string) (*XDSServerInstance, error) {
 	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
 
+	network, address, err := xdsListenAddress(crd)
+	if err != nil {
+		return nil, err
+	}
+
 	serverManager.Lock()
 	defer serverManager.Unlock()
 
+	// The socket file of another server would be replaced by the new listener
+	if owner, ok := socketOwner(serverKey, network, address); ok {
+		return nil, permanent(fmt.Errorf("invalid xdsBindAddress %q: socket is used by XDSControlPlane %s", crd.Spec.XdsBindAddress, owner))
+	}
+
 	// Check if server already exists
-	if server, exists := serverManager.servers[serverKey]; exists {
-		if server.port == crd.Spec.XdsPort {
-			log.Info("xDS server already running", "port", server.port)
-			return server, nil
+	previous, exists := serverManager.servers[serverKey]
+	retire := previous
+	if exists {
+		if previous.network == network && previous.address == address {
+			log.Info("xDS server already running", "address", previous.address)
+			return previous, nil
+		}
+
+		if port := xdsPort(crd); previous.port != port {
+			// Port changed, the previous server keeps serving until the new one is up
+			log.Info("xDS server port changed, restarting", "oldPort", previous.port, "newPort", port)
+			r.eventf(crd, corev1.EventTypeNormal, EventReasonPortChanged, "xDS server port changed from %d to %d, restarting", previous.port, port)
+		} else {
+			log.Info("xDS server bind address changed, restarting", "oldAddress", previous.address, "newAddress", address)
+			r.eventf(crd, corev1.EventTypeNormal, EventReasonAddressChanged, "xDS server address changed from %s to %s, restarting", previous.address, address)
+		}
+
+		// The port of a TCP listener is only free once the previous server is
+		// stopped, which is done without holding the server manager lock
+		if network == "tcp" && previous.network == "tcp" && previous.port == xdsPort(crd) {
+			delete(serverManager.servers, serverKey)
+			serverManager.Unlock()
+			r.stopServer(previous, gracefulStopTimeout(crd))
+			serverManager.Lock()
+			retire = nil
 		}
-		// Port changed, need to restart server
-		log.Info("xDS server port changed, restarting", "oldPort", server.port, "newPort", crd.Spec.XdsPort)
-		r.stopServer(server)
-		delete(serverManager.servers, serverKey)
 	}
 
 	// Start new server
-	server, err := r.startXDSServer(ctx, crd)
+	server, err := r.startXDSServer(ctx, crd, network, address, previous)
 	if err != nil {
+		if exists && retire == nil {
+			// Serve on the previous address again rather than not at all
+			restored, restoreErr := r.startXDSServer(ctx, crd, previous.network, previous.address, previous)
+			if restoreErr != nil {
+				log.Error(restoreErr, "failed to restore previous xDS server", "address", previous.address)
+				return nil, err
+			}
+			serverManager.servers[serverKey] = restored
+			log.Info("Restored previous xDS server", "address", restored.address)
+		}
 		return nil, err
 	}
 
 	serverManager.servers[serverKey] = server
-	log.Info("xDS server started successfully", "port", server.port)
+	log.Info("xDS server started successfully", "network", server.network, "address", server.address)
+	r.eventf(crd, corev1.EventTypeNormal, EventReasonServerStarted, "xDS server listening on %s", server.describeAddress())
+
+	// Stop the previous server without holding the server manager lock
+	if retire != nil {
+		go r.retireServer(ctx, retire, portChangeOverlap(crd), gracefulStopTimeout(crd))
+	}
 	return server, nil
 }
 
-func (r *XDSControlPlaneReconciler) startXDSServer(ctx context.Context, crd *api.XDSControlPlane) (*XDSServerInstance, error) {
+// startXDSServer starts the xDS server of a CR on an address. The server
+// inherits the state of the previous server of the CR if any, otherwise it
+// restores the persisted snapshots, so that it serves the current
+// configuration from the first connection.
+func (r *XDSControlPlaneReconciler) startXDSServer(ctx context.Context, crd *api.XDSControlPlane, network, address string, previous *XDSServerInstance) (*XDSServerInstance, error) {
 	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
 
-	port := crd.Spec.XdsPort
-	if port == 0 {
-		port = 18000
-	}
-
-	addr := fmt.Sprintf(":%d", port)
-	lis, err := net.Listen("tcp", addr)
+	lis, err := listenXDS(network, address)
 	if err != nil {
-		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		return nil, err
 	}
 
-	log.Info("xDS gRPC server listening", "addr", addr)
+	log.Info("xDS gRPC server listening", "network", network, "address", address)
 
-	// Create snapshot cache and server
-	snapCache := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
 	srv := grpc.NewServer()
+	instance := &XDSServerInstance{
+		server:    srv,
+		listener:  lis,
+		port:      xdsPort(crd),
+		network:   network,
+		address:   address,
+		namespace: crd.Namespace,
+		name:      crd.Name,
+	}
+
+	if previous != nil {
+		inheritServer(instance, previous)
+	} else {
+		// Create snapshot cache and serve the last-known-good configuration
+		// until the snapshot is rebuilt
+		instance.nodeHash = &nodeGroupHash{}
+		instance.cache = cache.NewSnapshotCache(false, instance.nodeHash, nil)
+		instance.tracker = newPropagationTracker()
+		instance.servedNodes = newServedNodes()
+		if err := r.restoreSnapshots(ctx, crd, instance); err != nil {
+			log.Error(err, "failed to restore persisted xDS snapshots")
+		}
+	}
 
 	serverCtx, cancel := context.WithCancel(ctx)
-	xdsServer := serverv3.NewServer(serverCtx, snapCache, nil)
+	instance.cancel = cancel
+	instance.callbacks = newXDSCallbacks(instance)
+	xdsServer := serverv3.NewServer(serverCtx, instance.cache, instance.callbacks)
+	go r.runNodeStatusUpdates(serverCtx, instance)
 
 	// Register all xDS services
 	log.Info("Registering xDS gRPC services")
@@ -246,13 +505,7 @@ func (r *XDSControlPlaneReconciler) startXDSServer(ctx context.Context, crd *api
 		}
 	}()
 
-	return &XDSServerInstance{
-		server:   srv,
-		cache:    snapCache,
-		listener: lis,
-		cancel:   cancel,
-		port:     port,
-	}, nil
+	return instance, nil
 }
 
 func (r *XDSControlPlaneReconciler) handleDeletion(ctx context.Context, crd *api.XDSControlPlane) (ctrl.Result, error) {
@@ -261,94 +514,79 @@ func (r *XDSControlPlaneReconciler) handleDeletion(ctx context.Context, crd *api
 
 	// Clean up server
 	serverKey := fmt.Sprintf("%s/%s", crd.Namespace, crd.Name)
-	r.cleanupServer(serverKey)
+	if r.cleanupServer(serverKey, gracefulStopTimeout(crd)) {
+		r.eventf(crd, corev1.EventTypeNormal, EventReasonServerStopped, "xDS server stopped")
+	}
+	deleteControlPlaneMetrics(crd.Namespace, crd.Name)
+
+	if err := r.releaseAttachments(ctx, crd); err != nil {
+		return ctrl.Result{}, err
+	}
 
 	// Remove finalizer
 	controllerutil.RemoveFinalizer(crd, XDSControlPlaneFinalizer)
 	return ctrl.Result{}, r.Update(ctx, crd)
 }
 
-func (r *XDSControlPlaneReconciler) cleanupServer(serverKey string) {
+// cleanupServer stops the server of a CR, it reports whether a server was running
+func (r *XDSControlPlaneReconciler) cleanupServer(serverKey string, timeout time.Duration) bool {
 	serverManager.Lock()
-	defer serverManager.Unlock()
+	server, exists := serverManager.servers[serverKey]
+	delete(serverManager.servers, serverKey)
+	serverManager.Unlock()
 
-	if server, exists := serverManager.servers[serverKey]; exists {
-		r.stopServer(server)
-		delete(serverManager.servers, serverKey)
+	if exists {
+		r.stopServer(server, timeout)
 	}
+	return exists
 }
 
-func (r *XDSControlPlaneReconciler) stopServer(server *XDSServerInstance) {
-	if server.cancel != nil {
-		server.cancel()
-	}
-	if server.server != nil {
-		server.server.GracefulStop()
-	}
-	if server.listener != nil {
-		server.listener.Close()
-	}
+// updateStatus derives the Ready condition and the phase from the other
+// conditions and writes the status for the current generation
+func (r *XDSControlPlaneReconciler) updateStatus(ctx context.Context, crd *api.XDSControlPlane) (ctrl.Result, error) {
+	setReadyCondition(crd)
+	crd.Status.ObservedGeneration = crd.Generation
+	return ctrl.Result{}, r.patchStatus(ctx, crd)
 }
 
-func (r *XDSControlPlaneReconciler) updateStatus(ctx context.Context, crd *api.XDSControlPlane, phase, message string) (ctrl.Result, error) {
-	crd.Status.Phase = phase
-
-	condition := metav1.Condition{
-		Type:               ConditionTypeReady,
-		Status:             metav1.ConditionFalse,
-		LastTransitionTime: metav1.NewTime(time.Now()),
-		Reason:             phase,
-		Message:            message,
+// patchStatus writes the status of the CR as a merge patch against the latest
+// known object, the write is skipped if the status did not change
+func (r *XDSControlPlaneReconciler) patchStatus(ctx context.Context, crd *api.XDSControlPlane) error {
+	latest := &api.XDSControlPlane{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(crd), latest); err != nil {
+		return err
 	}
-
-	if phase == PhaseReady {
-		condition.Status = metav1.ConditionTrue
+	if equality.Semantic.DeepEqual(latest.Status, crd.Status) {
+		return nil
 	}
 
-	meta.SetStatusCondition(&crd.Status.Conditions, condition)
+	base := latest.DeepCopy()
+	latest.Status = *crd.Status.DeepCopy()
+	return r.Status().Patch(ctx, latest, client.MergeFrom(base))
+}
 
-	return ctrl.Result{}, r.Status().Update(ctx, crd)
+// updateStatusFailed marks a condition as failed and writes the status
+func (r *XDSControlPlaneReconciler) updateStatusFailed(ctx context.Context, crd *api.XDSControlPlane, conditionType, reason, message string) (ctrl.Result, error) {
+	setCondition(crd, conditionType, metav1.ConditionFalse, reason, message)
+	return r.updateStatus(ctx, crd)
 }
 
-func (r *XDSControlPlaneReconciler) updateStatusReady(ctx context.Context, crd *api.XDSControlPlane, nodeIDs []string, port int, version string) (ctrl.Result, error) {
-	crd.Status.Phase = PhaseReady
+func (r *XDSControlPlaneReconciler) updateStatusReady(ctx context.Context, crd *api.XDSControlPlane, nodeIDs []string, server *XDSServerInstance, version string) (ctrl.Result, error) {
 	crd.Status.ConnectedNodeIDs = nodeIDs
-	crd.Status.XdsServerAddress = fmt.Sprintf(":%d", port)
+	crd.Status.XdsServerAddress = r.advertisedAddress(server)
 	crd.Status.LastSnapshotVersion = version
 
-	// Set conditions
-	readyCondition := metav1.Condition{
-		Type:               ConditionTypeReady,
-		Status:             metav1.ConditionTrue,
-		LastTransitionTime: metav1.NewTime(time.Now()),
-		Reason:             "Ready",
-		Message:            "XDS control plane is ready and serving configuration",
-	}
-
-	serverCondition := metav1.Condition{
-		Type:               ConditionTypeServerUp,
-		Status:             metav1.ConditionTrue,
-		LastTransitionTime: metav1.NewTime(time.Now()),
-		Reason:             "ServerRunning",
-		Message:            fmt.Sprintf("xDS server is running on port %d", port),
-	}
+	setCondition(crd, ConditionTypeServerUp, metav1.ConditionTrue, ReasonServerRunning, fmt.Sprintf("xDS server is running on %s", server.describeAddress()))
+	setCondition(crd, ConditionTypeSnapshot, metav1.ConditionTrue, ReasonSnapshotSet,
+		fmt.Sprintf("Snapshot version %s set for %d node(s)", version, len(nodeIDs)))
+	setAcceptedCondition(crd)
 
-	snapshotCondition := metav1.Condition{
-		Type:               ConditionTypeSnapshot,
-		Status:             metav1.ConditionTrue,
-		LastTransitionTime: metav1.NewTime(time.Now()),
-		Reason:             "SnapshotReady",
-		Message:            fmt.Sprintf("Snapshot version %s set for %d node(s)", version, len(nodeIDs)),
-	}
-
-	meta.SetStatusCondition(&crd.Status.Conditions, readyCondition)
-	meta.SetStatusCondition(&crd.Status.Conditions, serverCondition)
-	meta.SetStatusCondition(&crd.Status.Conditions, snapshotCondition)
-
-	return ctrl.Result{}, r.Status().Update(ctx, crd)
+	return r.updateStatus(ctx, crd)
 }
 
-func (r *XDSControlPlaneReconciler) buildXDSSnapshot(ctx context.Context, crd *api.XDSControlPlane) (cache.Snapshot, error) {
+// buildXDSSnapshot renders the snapshot of a CR. With the SkipInvalid failure
+// policy, resources that fail to build are left out and returned as skipped.
+func (r *XDSControlPlaneReconciler) buildXDSSnapshot(ctx context.Context, crd *api.XDSControlPlane) (cache.Snapshot, []skippedResource, error) {
 	log := ctrlLog.FromContext(ctx).WithValues("xdscontrolplane", crd.Name)
 	log.Info("Building xDS snapshot", "crd", crd.Name)
 	defer log.Info("Finished building xDS snapshot", "crd", crd.Name)
@@ -356,6 +594,11 @@ func (r *XDSControlPlaneReconciler) buildXDSSnapshot(ctx context.Context, crd *a
 	var endpoints []types.Resource
 	var clusters []types.Resource
 	var listeners []types.Resource
+	var routes []types.Resource
+	var secrets []types.Resource
+	var skipped []skippedResource
+
+	skipInvalid := failurePolicy(crd) == FailurePolicySkipInvalid
 
 	// Build clusters and endpoints
 	for _, c := range crd.Spec.Clusters {
@@ -364,7 +607,17 @@ func (r *XDSControlPlaneReconciler) buildXDSSnapshot(ctx context.Context, crd *a
 
 		clusterObj, cla, err := r.buildCluster(ctx, c)
 		if err != nil {
-			return cache.Snapshot{}, fmt.Errorf("failed to build cluster %s: %w", c.Name, err)
+			if skipInvalid {
+				log.Error(err, "Skipping invalid cluster")
+				skipped = append(skipped, skippedResource{Kind: "cluster", Name: c.Name, Err: err})
+				continue
+			}
+			err = fmt.Errorf("failed to build cluster %s: %w", c.Name, err)
+			var discoveryErr *endpointDiscoveryError
+			if !errors.As(err, &discoveryErr) {
+				err = permanent(err)
+			}
+			return cache.Snapshot{}, nil, err
 		}
 
 		clusters = append(clusters, clusterObj)
@@ -378,30 +631,111 @@ func (r *XDSControlPlaneReconciler) buildXDSSnapshot(ctx context.Context, crd *a
 		log := log.WithValues("listener", l.Name)
 		log.Info("Processing listener", "spec", l)
 
+		l = withTLSSecretNamespace(l, crd.Namespace)
 		listenerObj, err := r.buildListener(l)
 		if err != nil {
-			return cache.Snapshot{}, fmt.Errorf("failed to build listener %s: %w", l.Name, err)
+			if skipInvalid {
+				log.Error(err, "Skipping invalid listener")
+				skipped = append(skipped, skippedResource{Kind: "listener", Name: l.Name, Err: err})
+				continue
+			}
+			return cache.Snapshot{}, nil, permanent(fmt.Errorf("failed to build listener %s: %w", l.Name, err))
+		}
+		listenerSecrets, err := r.buildTLSSecrets(ctx, l)
+		if err != nil {
+			if skipInvalid {
+				log.Error(err, "Skipping listener with invalid TLS secret")
+				skipped = append(skipped, skippedResource{Kind: "listener", Name: l.Name, Err: err})
+				continue
+			}
+			// The Secret may be created or fixed later, retry with backoff
+			return cache.Snapshot{}, nil, fmt.Errorf("failed to build TLS secrets of listener %s: %w", l.Name, err)
 		}
 
 		listeners = append(listeners, listenerObj)
+		secrets = appendSecrets(secrets, listenerSecrets)
+	}
+
+	// Build route configurations
+	for _, rc := range crd.Spec.Routes {
+		log := log.WithValues("routeConfig", rc.Name)
+		log.Info("Processing route configuration", "spec", rc)
+
+		routeObj, err := r.buildRouteConfiguration(rc)
+		if err != nil {
+			if skipInvalid {
+				log.Error(err, "Skipping invalid route configuration")
+				skipped = append(skipped, skippedResource{Kind: "route", Name: rc.Name, Err: err})
+				continue
+			}
+			return cache.Snapshot{}, nil, permanent(fmt.Errorf("failed to build route configuration %s: %w", rc.Name, err))
+		}
+
+		routes = append(routes, routeObj)
 	}
 
+	// Shift traffic towards the canary cluster if a rollout is in progress
+	if err := applyCanaryWeights(crd, clusters, listeners, routes); err != nil {
+		if !skipInvalid {
+			return cache.Snapshot{}, nil, permanent(fmt.Errorf("failed to apply canary weights: %w", err))
+		}
+		log.Error(err, "Skipping canary weights")
+		skipped = append(skipped, skippedResource{Kind: "canary", Name: crd.Spec.Canary.CanaryCluster, Err: err})
+	}
+
+	proxyProtocolClusters, err := applyUpstreamProxyProtocol(crd.Spec.Listeners, listeners, clusters)
+	if err != nil {
+		return cache.Snapshot{}, nil, permanent(fmt.Errorf("failed to apply upstream proxy protocol: %w", err))
+	}
+	clusters = append(clusters, proxyProtocolClusters...)
+
 	version := strconv.FormatInt(time.Now().Unix(), 10)
 	snapshot, err := cache.NewSnapshot(version,
 		map[res.Type][]types.Resource{
 			res.EndpointType: endpoints,
 			res.ClusterType:  clusters,
 			res.ListenerType: listeners,
+			res.RouteType:    routes,
+			res.SecretType:   secrets,
 		},
 	)
 
 	if err != nil {
 		log.Error(err, "failed to create xDS snapshot")
-		return cache.Snapshot{}, err
+		return cache.Snapshot{}, nil, permanent(err)
 	}
 
-	log.Info("xDS snapshot created", "version", version)
-	return *snapshot, nil
+	log.Info("xDS snapshot created", "version", version, "skipped", len(skipped))
+	return *snapshot, skipped, nil
+}
+
+// sameResources reports whether two snapshots hold the same resources, ignoring their versions
+func sameResources(a, b *cache.Snapshot) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	for _, typeURL := range servedTypes {
+		ra, rb := a.GetResources(typeURL), b.GetResources(typeURL)
+		if len(ra) != len(rb) {
+			return false
+		}
+		for name, r := range ra {
+			other, ok := rb[name]
+			if !ok || !proto.Equal(r, other) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// servedTypes are the resource types served to Envoy: the persisted types and
+// the SDS secrets, which are never persisted
+var servedTypes = append([]res.Type{res.SecretType}, persistedTypes...)
+
+// snapshotVersion returns the version shared by all resource types of a snapshot
+func snapshotVersion(snapshot *cache.Snapshot) string {
+	return snapshot.GetVersion(res.ClusterType)
 }
 
 func (r *XDSControlPlaneReconciler) buildCluster(ctx context.Context, c api.ClusterSpec) (*cluster.Cluster, *endpoint.ClusterLoadAssignment, error) {
@@ -412,23 +746,21 @@ func (r *XDSControlPlaneReconciler) buildCluster(ctx context.Context, c api.Clus
 	if c.LoadAssignment != nil && c.LoadAssignment.EndpointsFrom != nil {
 		addrs, err := r.discoverEndpoints(ctx, c.LoadAssignment.EndpointsFrom)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to discover endpoints: %w", err)
+			return nil, nil, &endpointDiscoveryError{err: err}
 		}
 
-		log.Info("Discovered node addresses", "addresses", addrs)
+		log.Info("Discovered endpoints", "endpoints", addrs)
 
 		lbs := make([]*endpoint.LbEndpoint, 0, len(addrs))
-		port := int32(c.LoadAssignment.EndpointsFrom.Port)
-
-		for _, ip := range addrs {
+		for _, addr := range addrs {
 			lbs = append(lbs, &endpoint.LbEndpoint{
 				HostIdentifier: &endpoint.LbEndpoint_Endpoint{
 					Endpoint: &endpoint.Endpoint{
 						Address: &core.Address{
 							Address: &core.Address_SocketAddress{
 								SocketAddress: &core.SocketAddress{
-									Address:       ip,
-									PortSpecifier: &core.SocketAddress_PortValue{PortValue: uint32(port)},
+									Address:       addr.IP,
+									PortSpecifier: &core.SocketAddress_PortValue{PortValue: uint32(addr.Port)},
 								},
 							},
 						},
@@ -444,6 +776,12 @@ func (r *XDSControlPlaneReconciler) buildCluster(ctx context.Context, c api.Clus
 			}},
 		}
 	}
+	if c.LoadAssignment != nil && c.LoadAssignment.InternalListener != "" {
+		if c.Type != "static" {
+			return nil, nil, fmt.Errorf("clusters of an internal listener must be static")
+		}
+		cla = internalListenerLoadAssignment(c.Name, c.LoadAssignment.InternalListener)
+	}
 
 	// Build cluster
 	clusterObj := &cluster.Cluster{
@@ -453,16 +791,24 @@ func (r *XDSControlPlaneReconciler) buildCluster(ctx context.Context, c api.Clus
 		LbPolicy:             cluster.Cluster_ROUND_ROBIN,
 	}
 
-	if cla != nil {
-		clusterObj.LoadAssignment = cla
-	}
-
-	// Set cluster type
+	// Set cluster type, the endpoints of EDS clusters are only served as EDS resources
 	switch c.Type {
 	case "static":
 		clusterObj.ClusterDiscoveryType = &cluster.Cluster_Type{Type: cluster.Cluster_STATIC}
 	case "strict_dns":
 		clusterObj.ClusterDiscoveryType = &cluster.Cluster_Type{Type: cluster.Cluster_STRICT_DNS}
+	case "eds":
+		clusterObj.ClusterDiscoveryType = &cluster.Cluster_Type{Type: cluster.Cluster_EDS}
+		clusterObj.EdsClusterConfig = &cluster.Cluster_EdsClusterConfig{
+			EdsConfig: &core.ConfigSource{
+				ConfigSourceSpecifier: &core.ConfigSource_Ads{Ads: &core.AggregatedConfigSource{}},
+				ResourceApiVersion:    core.ApiVersion_V3,
+			},
+		}
+	}
+
+	if cla != nil && c.Type != "eds" {
+		clusterObj.LoadAssignment = cla
 	}
 
 	// Set load balancing policy
@@ -674,55 +1020,109 @@ func (r *XDSControlPlaneReconciler) buildListener(l api.ListenerSpec) (*listener
 	}
 
 	fc := make([]*listener.FilterChain, 0, len(l.FilterChains))
-	for _, chain := range l.FilterChains {
-		filters := make([]*listener.Filter, 0, len(chain.Filters))
-		for _, f := range chain.Filters {
-			anyCfg, err := r.jsonToAny(f.Name, f.TypedConfig)
-			if err != nil {
-				return nil, fmt.Errorf("failed to convert filter config: %w", err)
-			}
-			filters = append(filters, &listener.Filter{
-				Name: f.Name,
-				ConfigType: &listener.Filter_TypedConfig{
-					TypedConfig: anyCfg,
-				},
-			})
+	for i, chain := range l.FilterChains {
+		filterChain, err := r.buildFilterChain(chain)
+		if err != nil {
+			return nil, fmt.Errorf("filter chain %d: %w", i, err)
 		}
-		fc = append(fc, &listener.FilterChain{Filters: filters})
+		fc = append(fc, filterChain)
 	}
-
-	// Process access logs
-	var accessLogs []*accesslog.AccessLog
-	for _, al := range l.AccessLog {
-		anyConfig, err := r.jsonToAny(al.Name, al.TypedConfig)
+	if typedListenerSections(l) > 1 {
+		return nil, fmt.Errorf("only one of http, tcp, udp and filterChains may be set")
+	}
+	if listenerProtocol(l) == "UDP" && l.UDP == nil && typedListenerSections(l) > 0 {
+		return nil, fmt.Errorf("UDP listeners have no http, tcp or filterChains")
+	}
+	if l.UDP != nil && l.Protocol == "TCP" {
+		return nil, fmt.Errorf("listeners with udp have protocol UDP")
+	}
+	if (l.Internal != nil) == (l.Address != "" && l.Port > 0) {
+		return nil, fmt.Errorf("exactly one of address with port and internal is required")
+	}
+	if l.HTTP != nil {
+		filterChain, err := r.buildHTTPFilterChain(l)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert access log config: %w", err)
+			return nil, fmt.Errorf("failed to build http filter chain: %w", err)
 		}
-		accessLogs = append(accessLogs, &accesslog.AccessLog{
-			Name: al.Name,
-			ConfigType: &accesslog.AccessLog_TypedConfig{
-				TypedConfig: anyConfig,
-			},
+		fc = append(fc, filterChain)
+	}
+	if l.TCP != nil {
+		filterChain, err := r.buildTCPFilterChain(l)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build tcp filter chain: %w", err)
+		}
+		fc = append(fc, filterChain)
+	}
+	if l.UDP != nil {
+		udpProxy, err := r.buildUDPProxyFilter(l)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build udp_proxy filter: %w", err)
+		}
+		lf = append(lf, udpProxy)
+	}
+
+	var defaultChain *listener.FilterChain
+	if l.DefaultFilterChain != nil {
+		var err error
+		if defaultChain, err = r.buildFilterChain(*l.DefaultFilterChain); err != nil {
+			return nil, fmt.Errorf("default filter chain: %w", err)
+		}
+	}
+
+	accessLogs, err := r.buildAccessLogs(l.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+	lis := &listener.Listener{
+		Name:               l.Name,
+		ListenerFilters:    lf,
+		FilterChains:       fc,
+		DefaultFilterChain: defaultChain,
+		AccessLog:          accessLogs,
+	}
+	applyListenerSettings(l, lis)
+	return lis, nil
+}
+
+func (r *XDSControlPlaneReconciler) buildRouteConfiguration(rc api.RouteConfigSpec) (*route.RouteConfiguration, error) {
+	vhosts := make([]*route.VirtualHost, 0, len(rc.VirtualHosts))
+	for _, vh := range rc.VirtualHosts {
+		routes := make([]*route.Route, 0, len(vh.Routes))
+		for i, raw := range vh.Routes {
+			var rt route.Route
+			if err := protojson.Unmarshal(raw.Raw, &rt); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal route %d of virtual host %s: %w", i, vh.Name, err)
+			}
+			routes = append(routes, &rt)
+		}
+		vhosts = append(vhosts, &route.VirtualHost{
+			Name:    vh.Name,
+			Domains: vh.Domains,
+			Routes:  routes,
 		})
 	}
-	return &listener.Listener{
-		Name: l.Name,
-		Address: &core.Address{
-			Address: &core.Address_SocketAddress{
-				SocketAddress: &core.SocketAddress{
-					Address:       l.Address,
-					PortSpecifier: &core.SocketAddress_PortValue{PortValue: uint32(l.Port)},
-				},
-			},
-		},
-		ListenerFilters: lf,
-		FilterChains:    fc,
-		AccessLog:       accessLogs,
+
+	return &route.RouteConfiguration{
+		Name:         rc.Name,
+		VirtualHosts: vhosts,
 	}, nil
 }
 
-func (r *XDSControlPlaneReconciler) discoverEndpoints(ctx context.Context, selector *api.EndpointSelectorSpec) ([]string, error) {
-	var addrs []string
+// discoveredEndpoint is the address of an endpoint of a cluster
+type discoveredEndpoint struct {
+	IP   string
+	Port int32
+}
+
+func (r *XDSControlPlaneReconciler) discoverEndpoints(ctx context.Context, selector *api.EndpointSelectorSpec) ([]discoveredEndpoint, error) {
+	var addrs []discoveredEndpoint
+
+	switch selector.Type {
+	case "Service":
+		return r.discoverServiceEndpoints(ctx, selector)
+	case "EndpointSlice":
+		return r.discoverEndpointSliceEndpoints(ctx, selector)
+	}
 
 	if selector.Type == "Node" && selector.Selector != nil {
 		labelSelector, err := metav1.LabelSelectorAsSelector(selector.Selector)
@@ -738,7 +1138,7 @@ func (r *XDSControlPlaneReconciler) discoverEndpoints(ctx context.Context, selec
 		for _, node := range nodeList.Items {
 			for _, addr := range node.Status.Addresses {
 				if addr.Type == corev1.NodeInternalIP {
-					addrs = append(addrs, addr.Address)
+					addrs = append(addrs, discoveredEndpoint{IP: addr.Address, Port: int32(selector.Port)})
 					break
 				}
 			}
@@ -748,6 +1148,208 @@ func (r *XDSControlPlaneReconciler) discoverEndpoints(ctx context.Context, selec
 	return addrs, nil
 }
 
+// discoverServiceEndpoints returns the cluster IPs of a Service, the port of
+// the selector is the port of the Service
+func (r *XDSControlPlaneReconciler) discoverServiceEndpoints(ctx context.Context, selector *api.EndpointSelectorSpec) ([]discoveredEndpoint, error) {
+	var svc corev1.Service
+	if err := r.Get(ctx, client.ObjectKey{Namespace: selector.Namespace, Name: selector.Name}, &svc); err != nil {
+		return nil, fmt.Errorf("failed to get service %s/%s: %w", selector.Namespace, selector.Name, err)
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return nil, fmt.Errorf("service %s/%s has no cluster IP", selector.Namespace, selector.Name)
+	}
+	ips := svc.Spec.ClusterIPs
+	if len(ips) == 0 {
+		ips = []string{svc.Spec.ClusterIP}
+	}
+	addrs := make([]discoveredEndpoint, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, discoveredEndpoint{IP: ip, Port: int32(selector.Port)})
+	}
+	return addrs, nil
+}
+
+// discoverEndpointSliceEndpoints returns the ready endpoints of a Service
+// from its EndpointSlices. The port of the selector is the port of the
+// Service, the endpoints use the matching port of each slice, which is the
+// target port of the pods.
+func (r *XDSControlPlaneReconciler) discoverEndpointSliceEndpoints(ctx context.Context, selector *api.EndpointSelectorSpec) ([]discoveredEndpoint, error) {
+	var svc corev1.Service
+	if err := r.Get(ctx, client.ObjectKey{Namespace: selector.Namespace, Name: selector.Name}, &svc); err != nil {
+		return nil, fmt.Errorf("failed to get service %s/%s: %w", selector.Namespace, selector.Name, err)
+	}
+	portName, found := "", false
+	for _, p := range svc.Spec.Ports {
+		if p.Port == int32(selector.Port) {
+			portName, found = p.Name, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("service %s/%s has no port %d", selector.Namespace, selector.Name, selector.Port)
+	}
+
+	var slices discoveryv1.EndpointSliceList
+	if err := r.List(ctx, &slices, client.InNamespace(selector.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: selector.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list endpoint slices of service %s/%s: %w", selector.Namespace, selector.Name, err)
+	}
+
+	var addrs []discoveredEndpoint
+	seen := make(map[discoveredEndpoint]bool)
+	for _, slice := range slices.Items {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+		var port *int32
+		for _, p := range slice.Ports {
+			if (p.Name != nil && *p.Name == portName) || (p.Name == nil && portName == "") {
+				port = p.Port
+				break
+			}
+		}
+		if port == nil {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, ip := range ep.Addresses {
+				addr := discoveredEndpoint{IP: ip, Port: *port}
+				if !seen[addr] {
+					seen[addr] = true
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+	}
+	// Slices are listed in no particular order, keep the snapshot stable
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].IP != addrs[j].IP {
+			return addrs[i].IP < addrs[j].IP
+		}
+		return addrs[i].Port < addrs[j].Port
+	})
+	return addrs, nil
+}
+
+// controlPlanesForNode returns the control planes that discover endpoints from
+// nodes. Node groups may add such clusters through their overlay, control
+// planes with node groups are included as well.
+func (r *XDSControlPlaneReconciler) controlPlanesForNode(ctx context.Context, obj client.Object) []reconcile.Request {
+	var list api.XDSControlPlaneList
+	if err := r.List(ctx, &list); err != nil {
+		ctrlLog.FromContext(ctx).Error(err, "failed to list XDSControlPlanes for node", "node", obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, crd := range list.Items {
+		if usesNodeEndpoints(&crd) || len(crd.Spec.NodeGroups) > 0 {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&crd)})
+		}
+	}
+	return requests
+}
+
+func usesNodeEndpoints(crd *api.XDSControlPlane) bool {
+	for _, c := range crd.Spec.Clusters {
+		if c.LoadAssignment != nil && c.LoadAssignment.EndpointsFrom != nil && c.LoadAssignment.EndpointsFrom.Type == "Node" {
+			return true
+		}
+	}
+	return false
+}
+
+// usesEndpointSlices reports whether a cluster discovers its endpoints from
+// the EndpointSlices of a Service
+func usesEndpointSlices(c api.ClusterSpec, namespace, service string) bool {
+	if c.LoadAssignment == nil || c.LoadAssignment.EndpointsFrom == nil {
+		return false
+	}
+	selector := c.LoadAssignment.EndpointsFrom
+	return selector.Type == "EndpointSlice" && selector.Namespace == namespace && selector.Name == service
+}
+
+// controlPlanesForEndpointSlice returns the control planes with a cluster
+// discovering its endpoints from the Service of an EndpointSlice, inline or
+// attached through an XDSCluster
+func (r *XDSControlPlaneReconciler) controlPlanesForEndpointSlice(ctx context.Context, obj client.Object) []reconcile.Request {
+	log := ctrlLog.FromContext(ctx)
+	service := obj.GetLabels()[discoveryv1.LabelServiceName]
+	if service == "" {
+		return nil
+	}
+
+	var list api.XDSControlPlaneList
+	if err := r.List(ctx, &list); err != nil {
+		log.Error(err, "failed to list XDSControlPlanes for endpoint slice", "endpointSlice", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, crd := range list.Items {
+		for _, c := range crd.Spec.Clusters {
+			if usesEndpointSlices(c, obj.GetNamespace(), service) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&crd)})
+				break
+			}
+		}
+	}
+
+	var clusters api.XDSClusterList
+	if err := r.List(ctx, &clusters); err != nil {
+		log.Error(err, "failed to list XDSClusters for endpoint slice", "endpointSlice", obj.GetName())
+		return requests
+	}
+	for i := range clusters.Items {
+		if usesEndpointSlices(clusters.Items[i].Spec.ClusterSpec, obj.GetNamespace(), service) {
+			requests = append(requests, r.controlPlanesForResource(ctx, &clusters.Items[i])...)
+		}
+	}
+	return requests
+}
+
+// endpointSliceChanged filters EndpointSlice updates down to the changes of
+// endpoints and ports
+func endpointSliceChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSlice, ok := e.ObjectOld.(*discoveryv1.EndpointSlice)
+			if !ok {
+				return false
+			}
+			newSlice, ok := e.ObjectNew.(*discoveryv1.EndpointSlice)
+			if !ok {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(oldSlice.Endpoints, newSlice.Endpoints) ||
+				!equality.Semantic.DeepEqual(oldSlice.Ports, newSlice.Ports)
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// nodeEndpointsChanged filters node updates down to the changes of labels and
+// addresses, which may change the discovered endpoints
+func nodeEndpointsChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels) ||
+				!equality.Semantic.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses)
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
 func (r *XDSControlPlaneReconciler) jsonToAny(typeURL string, in apiextensionsv1.JSON) (*anypb.Any, error) {
 	log := ctrlLog.Log.WithValues("typeURL", typeURL)
 	log.Info("Converting JSON to protobuf Any with proper protobuf marshaling")
@@ -792,6 +1394,14 @@ func (r *XDSControlPlaneReconciler) jsonToAny(typeURL string, in apiextensionsv1
 		}
 		return anypb.New(&msg)
 
+	case "type.googleapis.com/envoy.extensions.filters.udp.udp_proxy.v3.UdpProxyConfig":
+		var msg udp_proxy.UdpProxyConfig
+		if err := protojson.Unmarshal(filteredJSON, &msg); err != nil {
+			log.Error(err, "failed to unmarshal udp_proxy config")
+			return nil, fmt.Errorf("failed to unmarshal udp_proxy config: %w", err)
+		}
+		return anypb.New(&msg)
+
 	case "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager":
 		var msg http_connection_manager.HttpConnectionManager
 		if err := protojson.Unmarshal(filteredJSON, &msg); err != nil {
@@ -855,6 +1465,14 @@ func (r *XDSControlPlaneReconciler) jsonToAny(typeURL string, in apiextensionsv1
 			return nil, fmt.Errorf("failed to unmarshal listener_proxy_protocol config: %w", err)
 		}
 		return anypb.New(&msg)
+
+	case "type.googleapis.com/envoy.extensions.filters.listener.tls_inspector.v3.TlsInspector":
+		var msg tls_inspector.TlsInspector
+		if err := protojson.Unmarshal(filteredJSON, &msg); err != nil {
+			log.Error(err, "failed to unmarshal tls_inspector config")
+			return nil, fmt.Errorf("failed to unmarshal tls_inspector config: %w", err)
+		}
+		return anypb.New(&msg)
 	default:
 		log.Info("Unknown typeURL, trying generic protobuf conversion", "typeURL", typeURL)
 		// For unknown types, create Any with the JSON data as value